@@ -0,0 +1,181 @@
+package api
+
+import (
+	"context"
+	"strings"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/YaleSpinup/minion/common"
+	"github.com/YaleSpinup/minion/jobs"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// cacheKey returns the key of a job in the jobs cache
+func cacheKey(group, id string) string {
+	if group == "" {
+		return id
+	}
+	return group + "/" + id
+}
+
+// put caches a copy of an enabled job of the named account with the account defaults applied.  disabled jobs are
+// removed from the cache.
+func (c *jobsCache) put(key, name string, account common.Account, job *jobs.Job) {
+	c.Mux.Lock()
+	defer c.Mux.Unlock()
+
+	if !job.Enabled {
+		log.Debugf("job '%s' is disabled, removing from the cache", key)
+		delete(c.Cache, key)
+		return
+	}
+
+	j := *job
+	j.Account = name
+	if j.Timezone == "" {
+		j.Timezone = account.Timezone
+	}
+
+	log.Debugf("caching job id %s with details: %+v", key, j)
+	c.Cache[key] = &j
+}
+
+// remove removes a job of the account from the cache.  if the id is empty, all of the jobs in the group are removed.
+// the cache keys don't include the account, so only the cached jobs of the account are removed, not the jobs of
+// another account's group with the same name.  the keys of the removed jobs are returned.
+func (c *jobsCache) remove(account, group, id string) []string {
+	c.Mux.Lock()
+	defer c.Mux.Unlock()
+
+	if id != "" {
+		key := cacheKey(group, id)
+		if job, ok := c.Cache[key]; ok && job.Account != account {
+			return nil
+		}
+
+		delete(c.Cache, key)
+		return []string{key}
+	}
+
+	removed := []string{}
+	for k, job := range c.Cache {
+		if strings.HasPrefix(k, group+"/") && job.Account == account {
+			delete(c.Cache, k)
+			removed = append(removed, k)
+		}
+	}
+
+	return removed
+}
+
+// cacheJob updates the local jobs cache with a created or updated job and invalidates it on the other nodes.  the
+// last scheduled time of a disabled job is forgotten, it's not considered for missed runs when it's enabled again.
+func (s *server) cacheJob(account, group string, job *jobs.Job) {
+	if s.jobsCache == nil {
+		return
+	}
+
+	key := cacheKey(group, job.ID)
+	s.jobsCache.put(key, account, s.accounts[account], job)
+	s.invalidate(account, group, job.ID)
+
+	if !job.Enabled {
+		s.untrack(key)
+	}
+}
+
+// uncacheJob removes a deleted job, or group of jobs if the id is empty, from the local jobs cache and
+// invalidates it on the other nodes
+func (s *server) uncacheJob(account, group, id string) {
+	if s.jobsCache == nil {
+		return
+	}
+
+	removed := s.jobsCache.remove(account, group, id)
+	s.invalidate(account, group, id)
+	s.untrack(removed...)
+}
+
+// untrack forgets the last scheduled time of removed or disabled jobs.  failures are logged, a job that's
+// still tracked when it's enabled again is only scheduled for the runs missed since it was modified.
+func (s *server) untrack(keys ...string) {
+	if s.tracker == nil || len(keys) == 0 {
+		return
+	}
+
+	if err := s.tracker.Forget(keys...); err != nil {
+		log.Errorf("failed to forget last scheduled time of %d jobs: %s", len(keys), err)
+	}
+}
+
+// invalidate broadcasts a job invalidation to the other nodes.  failures are logged, the other
+// nodes will pick up the change the next time the loader runs.
+func (s *server) invalidate(account, group, id string) {
+	if s.invalidator == nil {
+		return
+	}
+
+	if err := s.invalidator.Invalidate(&jobs.Invalidation{
+		Account: account,
+		Group:   group,
+		ID:      id,
+		Origin:  s.id,
+	}); err != nil {
+		log.Errorf("failed to invalidate job %s/%s/%s: %s", account, group, id, err)
+	}
+}
+
+// listen updates the cache from the job invalidations broadcast by the other nodes until the context is cancelled
+func (l *loader) listen(ctx context.Context, invalidator jobs.Invalidator) error {
+	invalidations, err := invalidator.Invalidations(ctx)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for inv := range invalidations {
+			if inv.Origin == l.id {
+				continue
+			}
+
+			log.Debugf("%s: received invalidation %+v", l.id, inv)
+
+			if err := l.reload(ctx, inv); err != nil {
+				log.Errorf("%s: failed to reload invalidated job %s/%s/%s: %s", l.id, inv.Account, inv.Group, inv.ID, err)
+			}
+		}
+
+		log.Debugf("%s: stopped listening for invalidations", l.id)
+	}()
+
+	log.Infof("%s: listening for job invalidations", l.id)
+
+	return nil
+}
+
+// reload updates the cache with the invalidated job from the jobs repository
+func (l *loader) reload(ctx context.Context, inv *jobs.Invalidation) error {
+	account, ok := l.accounts[inv.Account]
+	if !ok {
+		return nil
+	}
+
+	if inv.ID == "" {
+		l.jobsCache.remove(inv.Account, inv.Group, "")
+		return nil
+	}
+
+	job, err := l.jobsRepository.Get(ctx, inv.Account, inv.Group, inv.ID)
+	if err != nil {
+		if aerr, ok := errors.Cause(err).(apierror.Error); ok && aerr.Code == apierror.ErrNotFound {
+			l.jobsCache.remove(inv.Account, inv.Group, inv.ID)
+			return nil
+		}
+		return err
+	}
+
+	l.jobsCache.put(cacheKey(inv.Group, inv.ID), inv.Account, account, job)
+
+	return nil
+}
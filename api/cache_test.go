@@ -0,0 +1,281 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/YaleSpinup/minion/common"
+	"github.com/YaleSpinup/minion/jobs"
+)
+
+type mockCacheRepository struct {
+	jobs.Repository
+	jobs map[string]*jobs.Job
+	err  error
+}
+
+func (m *mockCacheRepository) Get(ctx context.Context, account, group, id string) (*jobs.Job, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	j, ok := m.jobs[account+"/"+group+"/"+id]
+	if !ok {
+		return nil, apierror.New(apierror.ErrNotFound, "not found", nil)
+	}
+	return j, nil
+}
+
+type mockInvalidator struct {
+	invalidations chan *jobs.Invalidation
+	published     []jobs.Invalidation
+	err           error
+}
+
+func (m *mockInvalidator) Invalidate(inv *jobs.Invalidation) error {
+	if m.err != nil {
+		return m.err
+	}
+
+	m.published = append(m.published, *inv)
+	return nil
+}
+
+func (m *mockInvalidator) Invalidations(ctx context.Context) (<-chan *jobs.Invalidation, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	return m.invalidations, nil
+}
+
+func cachedKeys(c *jobsCache) []string {
+	c.Mux.Lock()
+	defer c.Mux.Unlock()
+
+	keys := []string{}
+	for k := range c.Cache {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func TestJobsCachePutRemove(t *testing.T) {
+	c := &jobsCache{Cache: map[string]*jobs.Job{}}
+	account := common.Account{Timezone: "America/New_York"}
+
+	job := &jobs.Job{ID: "job1", Group: "space-1", Enabled: true}
+	c.put("space-1/job1", "acct1", account, job)
+	c.put("space-1/job2", "acct1", account, &jobs.Job{ID: "job2", Group: "space-1", Enabled: true, Timezone: "UTC"})
+	c.put("space-2/job3", "acct1", account, &jobs.Job{ID: "job3", Group: "space-2", Enabled: true})
+	c.put("space-2/job4", "acct1", account, &jobs.Job{ID: "job4", Group: "space-2", Enabled: false})
+
+	if keys, expected := cachedKeys(c), []string{"space-1/job1", "space-1/job2", "space-2/job3"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected cached jobs %v, got %v", expected, keys)
+	}
+
+	if tz := c.Cache["space-1/job1"].Timezone; tz != "America/New_York" {
+		t.Errorf("expected account default timezone America/New_York, got %s", tz)
+	}
+
+	if job.Timezone != "" {
+		t.Error("expected cached job to be a copy")
+	}
+
+	if tz := c.Cache["space-1/job2"].Timezone; tz != "UTC" {
+		t.Errorf("expected job timezone UTC, got %s", tz)
+	}
+
+	// disabling a job removes it
+	c.put("space-2/job3", "acct1", account, &jobs.Job{ID: "job3", Group: "space-2", Enabled: false})
+	if keys, expected := cachedKeys(c), []string{"space-1/job1", "space-1/job2"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected cached jobs %v, got %v", expected, keys)
+	}
+
+	c.remove("acct1", "space-1", "job2")
+	if keys, expected := cachedKeys(c), []string{"space-1/job1"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected cached jobs %v, got %v", expected, keys)
+	}
+
+	c.put("space-10/job5", "acct1", account, &jobs.Job{ID: "job5", Group: "space-10", Enabled: true})
+	c.remove("acct1", "space-1", "")
+	if keys, expected := cachedKeys(c), []string{"space-10/job5"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected cached jobs %v, got %v", expected, keys)
+	}
+}
+
+func TestJobsCacheRemoveAccount(t *testing.T) {
+	c := &jobsCache{Cache: map[string]*jobs.Job{}}
+
+	c.put("space-1/job1", "acct1", common.Account{}, &jobs.Job{ID: "job1", Group: "space-1", Enabled: true})
+	c.put("space-1/job2", "acct2", common.Account{}, &jobs.Job{ID: "job2", Group: "space-1", Enabled: true})
+
+	if account := c.Cache["space-1/job2"].Account; account != "acct2" {
+		t.Errorf("expected cached job account acct2, got %s", account)
+	}
+
+	// removing the group of one account keeps the jobs of the same group in another account
+	if removed, expected := c.remove("acct1", "space-1", ""), []string{"space-1/job1"}; !reflect.DeepEqual(removed, expected) {
+		t.Errorf("expected removed jobs %v, got %v", expected, removed)
+	}
+
+	if keys, expected := cachedKeys(c), []string{"space-1/job2"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected cached jobs %v, got %v", expected, keys)
+	}
+
+	// removing a job of another account is a no-op
+	if removed := c.remove("acct1", "space-1", "job2"); len(removed) != 0 {
+		t.Errorf("expected no removed jobs, got %v", removed)
+	}
+
+	if keys, expected := cachedKeys(c), []string{"space-1/job2"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected cached jobs %v, got %v", expected, keys)
+	}
+
+	c.remove("acct2", "space-1", "job2")
+	if keys := cachedKeys(c); len(keys) != 0 {
+		t.Errorf("expected no cached jobs, got %v", keys)
+	}
+}
+
+func TestServerCacheJob(t *testing.T) {
+	inv := &mockInvalidator{}
+	s := server{
+		accounts:    map[string]common.Account{"acct1": {}},
+		id:          "node1",
+		invalidator: inv,
+		jobsCache:   &jobsCache{Cache: map[string]*jobs.Job{}},
+	}
+
+	s.cacheJob("acct1", "space-1", &jobs.Job{ID: "job1", Enabled: true})
+	if keys, expected := cachedKeys(s.jobsCache), []string{"space-1/job1"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected cached jobs %v, got %v", expected, keys)
+	}
+
+	s.uncacheJob("acct1", "space-1", "")
+	if keys := cachedKeys(s.jobsCache); len(keys) != 0 {
+		t.Errorf("expected empty cache, got %v", keys)
+	}
+
+	expected := []jobs.Invalidation{
+		{Account: "acct1", Group: "space-1", ID: "job1", Origin: "node1"},
+		{Account: "acct1", Group: "space-1", Origin: "node1"},
+	}
+	if !reflect.DeepEqual(inv.published, expected) {
+		t.Errorf("expected invalidations %+v, got %+v", expected, inv.published)
+	}
+
+	// failing to invalidate still updates the local cache
+	inv.err = errors.New("boom")
+	s.cacheJob("acct1", "space-1", &jobs.Job{ID: "job1", Enabled: true})
+	if keys, expected := cachedKeys(s.jobsCache), []string{"space-1/job1"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected cached jobs %v, got %v", expected, keys)
+	}
+}
+
+func TestServerCacheJobUntrack(t *testing.T) {
+	tracker := &mockSchedTracker{t, map[string]time.Time{}}
+	s := server{
+		accounts:  map[string]common.Account{"acct1": {}},
+		id:        "node1",
+		jobsCache: &jobsCache{Cache: map[string]*jobs.Job{}},
+		tracker:   tracker,
+	}
+
+	now := time.Now().UTC().Truncate(time.Minute)
+	for _, id := range []string{"job1", "job2", "job3"} {
+		s.cacheJob("acct1", "space-1", &jobs.Job{ID: id, Enabled: true})
+		tracker.last["space-1/"+id] = now
+	}
+	tracker.last["space-2/job4"] = now
+
+	// a disabled job is forgotten
+	s.cacheJob("acct1", "space-1", &jobs.Job{ID: "job1", Enabled: false})
+	if _, ok := tracker.last["space-1/job1"]; ok {
+		t.Error("expected disabled job to be forgotten")
+	}
+
+	// a deleted job is forgotten
+	s.uncacheJob("acct1", "space-1", "job2")
+	if _, ok := tracker.last["space-1/job2"]; ok {
+		t.Error("expected deleted job to be forgotten")
+	}
+
+	// the jobs of a deleted group are forgotten
+	s.uncacheJob("acct1", "space-1", "")
+	if expected := map[string]time.Time{"space-2/job4": now}; !reflect.DeepEqual(tracker.last, expected) {
+		t.Errorf("expected tracked jobs %v, got %v", expected, tracker.last)
+	}
+}
+
+func TestLoaderListen(t *testing.T) {
+	repo := &mockCacheRepository{
+		jobs: map[string]*jobs.Job{
+			"acct1/space-1/job1": {ID: "job1", Group: "space-1", Enabled: true},
+			"acct1/space-1/job2": {ID: "job2", Group: "space-1", Enabled: false},
+		},
+	}
+
+	inv := &mockInvalidator{invalidations: make(chan *jobs.Invalidation)}
+	l := loader{
+		accounts:       map[string]common.Account{"acct1": {}},
+		id:             "node1",
+		jobsCache:      &jobsCache{Cache: map[string]*jobs.Job{"space-1/job2": {ID: "job2", Account: "acct1"}, "space-1/job3": {ID: "job3", Account: "acct1"}, "space-2/job4": {ID: "job4", Account: "acct1"}}},
+		jobsRepository: repo,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := l.listen(ctx, inv); err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	for _, i := range []*jobs.Invalidation{
+		// created job is cached
+		{Account: "acct1", Group: "space-1", ID: "job1", Origin: "node2"},
+		// disabled job is removed
+		{Account: "acct1", Group: "space-1", ID: "job2", Origin: "node2"},
+		// deleted job is removed
+		{Account: "acct1", Group: "space-1", ID: "job3", Origin: "node2"},
+		// own invalidations are ignored
+		{Account: "acct1", Group: "space-2", Origin: "node1"},
+		// unknown accounts are ignored
+		{Account: "acct2", Group: "space-2", Origin: "node2"},
+	} {
+		inv.invalidations <- i
+	}
+
+	// wait for the last invalidation to be processed
+	deadline := time.Now().Add(5 * time.Second)
+	expected := []string{"space-1/job1", "space-2/job4"}
+	for !reflect.DeepEqual(cachedKeys(l.jobsCache), expected) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected cached jobs %v, got %v", expected, cachedKeys(l.jobsCache))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// deleted group is removed
+	inv.invalidations <- &jobs.Invalidation{Account: "acct1", Group: "space-2", Origin: "node2"}
+	expected = []string{"space-1/job1"}
+	for !reflect.DeepEqual(cachedKeys(l.jobsCache), expected) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected cached jobs %v, got %v", expected, cachedKeys(l.jobsCache))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(inv.invalidations)
+
+	if err := l.listen(ctx, &mockInvalidator{err: errors.New("boom")}); err == nil {
+		t.Error("expected error subscribing to invalidations, got nil")
+	}
+}
@@ -2,80 +2,182 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	report "github.com/YaleSpinup/eventreporter"
+	"github.com/YaleSpinup/minion/common"
 	"github.com/YaleSpinup/minion/jobs"
 	log "github.com/sirupsen/logrus"
 )
 
-func (e *executer) start(ctx context.Context, interval time.Duration) {
+// maxLimitedFetches bounds the jobs returned to the queue in one tick because their runner or account is at its
+// limit, so the executer doesn't keep fetching the same limited jobs until the next tick
+const maxLimitedFetches = 10
+
+// fetchResult is the result of fetching the next job from the queue
+type fetchResult int
+
+const (
+	// fetchedNone means there are no jobs to fetch right now
+	fetchedNone fetchResult = iota
+	// fetchedJob means a job was fetched and run, or dropped if it couldn't be run
+	fetchedJob
+	// fetchedLimited means a job was fetched and returned to the queue because its runner or account is at its limit
+	fetchedLimited
+)
+
+// start the executer loop.  jobs are fetched from the queue until ctx is cancelled and run with runCtx, so
+// in-flight jobs can keep running while the executer is drained.
+func (e *executer) start(ctx, runCtx context.Context, interval time.Duration) {
 	log.Infof("%s: executer starting", e.id)
-	go e.loop(ctx, interval)
+	e.done = make(chan struct{})
+	go e.loop(ctx, runCtx, interval)
 	log.Infof("%s: executer started", e.id)
 }
 
-func (e *executer) loop(ctx context.Context, interval time.Duration) {
+func (e *executer) loop(ctx, runCtx context.Context, interval time.Duration) {
+	defer close(e.done)
+
 	ticker := time.NewTicker(interval)
 	for {
 		log.Debugf("%s: starting executer loop (%s)", e.id, time.Now().String())
 		select {
 		case <-ticker.C:
-			q := jobs.QueuedJob{}
-			if err := e.jobQueue.Fetch(&q); err != nil {
-				qErr, ok := err.(jobs.QueueError)
-				if ok && qErr.Code == jobs.ErrQueueIsEmpty {
-					log.Debugf("%s: no jobs", e.id)
-				} else {
-					log.Errorf("%s: error fetching jobs from the queue: %s", e.id, err)
-				}
-				continue
-			}
+			e.fetch(ctx, runCtx)
+		case <-ctx.Done():
+			log.Debugf("%s: shutting down executer ticker", e.id)
+			ticker.Stop()
+			return
+		}
+	}
+}
 
-			if q.ID == "" {
-				continue
-			}
+// fetch runs the due jobs from the queue.  jobs are only pulled off of the queue while there's room to run them,
+// otherwise they're left in the queue for other executers.  a job that's returned to the queue because of a limit
+// doesn't stop fetching the jobs behind it, up to maxLimitedFetches.
+func (e *executer) fetch(ctx, runCtx context.Context) {
+	limited := 0
+	for ctx.Err() == nil && e.pool.free() && limited < maxLimitedFetches {
+		switch e.next(runCtx) {
+		case fetchedNone:
+			return
+		case fetchedLimited:
+			limited++
+		}
+	}
+}
 
-			log.Debugf("%s: about to execute queued job %+v", e.id, q)
+// drain waits for the executer loop to stop fetching jobs and for the in-flight jobs to finish.  if
+// they don't finish before the timeout, cancelRuns is called to interrupt them and the interrupted jobs
+// are queued again.  drain returns false if the jobs didn't finish in time.
+func (e *executer) drain(timeout time.Duration, cancelRuns context.CancelFunc) bool {
+	log.Infof("%s: draining executer", e.id)
 
-			e.jobsCache.Mux.Lock()
-			job, ok := e.jobsCache.Cache[q.ID]
-			e.jobsCache.Mux.Unlock()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
 
-			if !ok {
-				log.Warnf("%s: job %s not found in the job cache", e.id, q.ID)
-				continue
-			}
+	select {
+	case <-e.done:
+	case <-deadline.C:
+		log.Warnf("%s: timeout waiting for the executer loop to stop", e.id)
+		cancelRuns()
+		return false
+	}
 
-			// if the job from the repository has a runner configured
-			runner, ok := job.Details["runner"]
-			if !ok {
-				log.Warnf("%s: runner not found in the job details for %s", e.id, job.ID)
-				continue
-			}
+	if e.pool.wait(deadline.C) {
+		log.Infof("%s: executer drained", e.id)
+		return true
+	}
 
-			log.Debugf("%s: found requested runner '%s' in job details", e.id, runner)
+	log.Warnf("%s: timeout draining executer, interrupting in-flight jobs", e.id)
+	cancelRuns()
 
-			// look for that runner in the list of available runners
-			jr, ok := e.jobRunners[runner]
-			if !ok {
-				log.Warnf("%s: jobRunner not defined for requested runner '%s'", e.id, runner)
-				continue
-			}
+	// give the interrupted jobs a chance to be queued again
+	e.pool.wait(time.After(10 * time.Second))
 
-			log.Debugf("%s: jobRunner defined for requested runner '%s': %+v", e.id, runner, jr)
+	return false
+}
 
-			go e.run(ctx, jr, job)
-		case <-ctx.Done():
-			log.Debugf("%s: shutting down executer ticker", e.id)
-			ticker.Stop()
-			return
+// next fetches the next job from the queue and runs it in the pool
+func (e *executer) next(ctx context.Context) fetchResult {
+	q := jobs.QueuedJob{}
+	if err := e.jobQueue.Fetch(&q); err != nil {
+		qErr, ok := err.(jobs.QueueError)
+		if ok && qErr.Code == jobs.ErrQueueIsEmpty {
+			log.Debugf("%s: no jobs", e.id)
+		} else if ok && qErr.Code == jobs.ErrQueuedJobNotDue {
+			log.Debugf("%s: no jobs due", e.id)
+		} else {
+			log.Errorf("%s: error fetching jobs from the queue: %s", e.id, err)
 		}
+		return fetchedNone
 	}
+
+	if q.ID == "" {
+		return fetchedNone
+	}
+
+	log.Debugf("%s: about to execute queued job %+v", e.id, q)
+
+	e.jobsCache.Mux.Lock()
+	job, ok := e.jobsCache.Cache[q.ID]
+	e.jobsCache.Mux.Unlock()
+
+	if !ok {
+		log.Warnf("%s: job %s not found in the job cache", e.id, q.ID)
+		return fetchedJob
+	}
+
+	// if the job from the repository has a runner configured
+	runner, ok := job.Details["runner"]
+	if !ok {
+		log.Warnf("%s: runner not found in the job details for %s", e.id, job.ID)
+		return fetchedJob
+	}
+
+	log.Debugf("%s: found requested runner '%s' in job details", e.id, runner)
+
+	// look for that runner in the list of available runners
+	jr, ok := e.jobRunners[runner]
+	if !ok {
+		log.Warnf("%s: jobRunner not defined for requested runner '%s'", e.id, runner)
+		return fetchedJob
+	}
+
+	log.Debugf("%s: jobRunner defined for requested runner '%s': %+v", e.id, runner, jr)
+
+	// refuse to run jobs with a runner the account isn't allowed to use, ie. if the runner was
+	// removed from the account after the job was saved
+	if !allowedRunner(e.accounts[job.Account], runner) {
+		e.forbid(job, &q, runner)
+		return fetchedJob
+	}
+
+	// if the runner or the account is at its limit, put the job back at the end of the currently
+	// due jobs so it doesn't block the jobs queued behind it
+	if !e.pool.acquire(runner, job.Account) {
+		log.Infof("%s: concurrency limit reached for runner '%s' or account '%s', returning job %s to the queue", e.id, runner, job.Account, q.ID)
+
+		q.Score = float64(time.Now().Add(time.Second).Unix())
+		if err := e.jobQueue.Enqueue(&q); err != nil {
+			log.Errorf("%s: failed to return job %s to the queue: %s", e.id, q.ID, err)
+		}
+		return fetchedLimited
+	}
+
+	go func() {
+		defer e.pool.release(runner, job.Account)
+		e.run(ctx, jr, job, &q)
+	}()
+
+	return fetchedJob
 }
 
-func (e *executer) run(ctx context.Context, runner jobs.Runner, j *jobs.Job) {
+// run runs a job once.  if the job fails and its retry policy allows it, the next attempt is queued
+// to run after the backoff delay.
+func (e *executer) run(ctx context.Context, runner jobs.Runner, j *jobs.Job, q *jobs.QueuedJob) {
 	defer timeTrack("executer.run()", time.Now())
 
 	logctx, closeLog := context.WithCancel(context.Background())
@@ -87,39 +189,308 @@ func (e *executer) run(ctx context.Context, runner jobs.Runner, j *jobs.Job) {
 	}
 	logStream := e.logger.log(logctx, logGroup, j.ID)
 
-	// defer finalizing the job until we return (success or failure)
+	r := e.newRun(j, q)
+
+	// the next attempt, if the job failed and should be retried
+	var retry *jobs.QueuedJob
+
+	// defer finalizing the job until we return (success or failure).  if the run was interrupted
+	// before it finished (ie. minion is shutting down), the job is queued again to run elsewhere.
 	defer func() {
-		if err := e.jobQueue.Finalize(j.ID); err != nil {
+		if err := e.jobQueue.Finalize(q); err != nil {
 			log.Errorf("%s: error finalizing job %s: %s", e.id, j.ID, err)
 		}
+
+		if r.Status == jobs.RunStatusInterrupted {
+			e.requeue(q)
+		} else if retry != nil {
+			e.retry(retry)
+		}
 	}()
 
-	for i := 1; i <= 3; i++ {
-		log.Debugf("running (%d) job executer for %+v", i, j)
+	// keep the lease on the job while it's running so the requeuer doesn't recover it
+	defer e.renew(q)()
 
-		// run the configured runner
-		out, err := runner.Run(ctx, j.Account, j.Details)
-		if err == nil {
-			logStream <- out
-			log.Debugf("got output from running job: %s", out)
-			return
+	// record the run in the history and defer recording the result
+	e.putRun(r)
+	defer func() {
+		if ctx.Err() != nil && r.Status != jobs.RunStatusSucceeded {
+			r.interrupted()
+		}
+
+		now := time.Now().UTC()
+		r.EndedAt = &now
+		e.putRun(r)
+		e.pruneRuns(r)
+	}()
+
+	policy := e.retryPolicy(j)
+	attempt := q.Attempt + 1
+
+	log.Debugf("running (%d) job executer for %+v", attempt, j)
+
+	// run the configured runner, limited to the failed targets of the run being retried
+	runCtx := jobs.WithRunID(ctx, r.ID)
+	if len(q.Targets) > 0 {
+		runCtx = jobs.WithTargets(runCtx, q.Targets)
+	}
+
+	result, err := jobs.RunWithResult(runCtx, runner, j.Account, j.Details)
+	if err == nil {
+		logStream <- result.JSON()
+		log.Debugf("got result from running job: %s", result.JSON())
+		r.succeeded(result)
+		return
+	}
+
+	msg := fmt.Sprintf("failed running job (attempt %d of %d) %s: %s", attempt, policy.MaxAttempts, j.ID, err)
+	log.Error(msg)
+	logStream <- msg
+	logStream <- result.JSON()
+	reportEvent(msg, report.ERROR)
+	r.failed(err)
+	r.Result = result
+
+	if ctx.Err() != nil || !policy.Retryable(attempt, err) {
+		return
+	}
+
+	delay := policy.NextDelay(attempt)
+	retry = &jobs.QueuedJob{
+		ID:      q.ID,
+		RunID:   jobs.NewRunID(),
+		Attempt: attempt,
+		Targets: q.Targets,
+		Score:   float64(time.Now().Add(delay).Unix()),
+	}
+
+	// only retry the targets that failed
+	var rErr jobs.RunnerError
+	if errors.As(err, &rErr) && len(rErr.Targets) > 0 {
+		retry.Targets = rErr.Targets
+	}
+
+	msg = fmt.Sprintf("retrying job %s in %s", j.ID, delay)
+	logStream <- msg
+	log.Info(msg)
+}
+
+// renew renews the lease on a running job every heartbeat until the returned func is called, which waits for
+// the renewals to stop.  it's a noop if the queue doesn't support renewing leases.
+func (e *executer) renew(q *jobs.QueuedJob) func() {
+	renewer, ok := e.jobQueue.(jobs.Renewer)
+	if !ok || e.heartbeat <= 0 {
+		return func() {}
+	}
+
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(e.heartbeat)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := renewer.Renew(q); err != nil {
+					log.Warnf("%s: failed to renew lease on job %s: %s", e.id, q.ID, err)
+				}
+			case <-done:
+				return
+			}
 		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// forbid records a failed run for a queued job with a runner that isn't allowed for the job's account, reports
+// it and removes the job from the queue without running it
+func (e *executer) forbid(j *jobs.Job, q *jobs.QueuedJob, runner string) {
+	msg := fmt.Sprintf("%s: runner '%s' is not allowed for account %s, refusing to run job %s", e.id, runner, j.Account, q.ID)
+	log.Error(msg)
+	reportEvent(msg, report.ERROR)
+
+	r := e.newRun(j, q)
+	r.failed(errors.New(msg))
+	r.EndedAt = r.StartedAt
+	e.putRun(r)
+
+	if err := e.jobQueue.Finalize(q); err != nil {
+		log.Errorf("%s: error finalizing job %s: %s", e.id, j.ID, err)
+	}
+}
+
+// retryPolicy returns the retry policy for a job.  unset fields in the job's retry policy are
+// taken from the runner's retry policy, then the default retry policy.
+func (e *executer) retryPolicy(j *jobs.Job) jobs.RetryPolicy {
+	policy := e.defaultRetry
+	if policy.MaxAttempts == 0 {
+		policy = jobs.DefaultRetryPolicy
+	}
+
+	if p, ok := e.retryPolicies[j.Details["runner"]]; ok {
+		policy = p
+	}
 
-		msg := fmt.Sprintf("failed running job (%d tries) %s: %s", i, j.ID, err)
+	if j.Retry != nil {
+		policy = j.Retry.WithDefaults(policy)
+	}
+
+	return policy
+}
+
+// retry queues the next attempt of a failed job
+func (e *executer) retry(q *jobs.QueuedJob) {
+	log.Infof("%s: queueing attempt %d of job %s", e.id, q.Attempt+1, q.ID)
+
+	if err := e.jobQueue.Enqueue(q); err != nil {
+		msg := fmt.Sprintf("%s: failed to queue attempt %d of job %s: %s", e.id, q.Attempt+1, q.ID, err)
 		log.Error(msg)
-		logStream <- msg
 		reportEvent(msg, report.ERROR)
+	}
+}
 
-		timer := time.NewTimer(5 * time.Second)
-		select {
-		case <-ctx.Done():
-			msg := fmt.Sprintf("cancelling retrying of job %s", j.ID)
-			logStream <- msg
-			log.Warn(msg)
-			timer.Stop()
-			return
-		case <-timer.C:
-			log.Infof("retrying job (%d) %s", i, j.ID)
+// runRecord wraps a run record for the executer
+type runRecord struct {
+	*jobs.Run
+}
+
+// newRun returns a new running run record for the queued job
+func (e *executer) newRun(j *jobs.Job, q *jobs.QueuedJob) *runRecord {
+	id := q.RunID
+	if id == "" {
+		id = jobs.NewRunID()
+	}
+
+	now := time.Now().UTC()
+	r := &jobs.Run{
+		ID:        id,
+		JobID:     j.ID,
+		Account:   j.Account,
+		Group:     j.Group,
+		StartedAt: &now,
+		Attempt:   q.Attempt + 1,
+		NodeID:    e.id,
+		Runner:    j.Details["runner"],
+		Status:    jobs.RunStatusRunning,
+	}
+
+	if q.Score > 0 {
+		scheduled := time.Unix(int64(q.Score), 0).UTC()
+		r.ScheduledAt = &scheduled
+	}
+
+	return &runRecord{r}
+}
+
+// succeeded sets the run record status, output and result for a successful run
+func (r *runRecord) succeeded(result *jobs.Result) {
+	r.Status = jobs.RunStatusSucceeded
+	r.Output = result.Output()
+	r.Result = result
+	r.ErrorCode = ""
+	r.Error = ""
+}
+
+// failed sets the run record status and error for a failed run
+func (r *runRecord) failed(err error) {
+	r.Status = jobs.RunStatusFailed
+	r.Error = err.Error()
+
+	var rErr jobs.RunnerError
+	if errors.As(err, &rErr) {
+		r.ErrorCode = rErr.Code
+	}
+}
+
+// interrupted sets the run record status for a run that was interrupted before it finished
+func (r *runRecord) interrupted() {
+	r.Status = jobs.RunStatusInterrupted
+	r.ErrorCode = ""
+	r.Error = "run was interrupted before it finished"
+}
+
+// requeue queues a job that was interrupted again to run as soon as possible
+func (e *executer) requeue(q *jobs.QueuedJob) {
+	requeued := &jobs.QueuedJob{
+		ID:      q.ID,
+		RunID:   q.RunID,
+		Attempt: q.Attempt,
+		Targets: q.Targets,
+		Score:   float64(time.Now().Unix()),
+	}
+
+	log.Infof("%s: queueing interrupted job %s again", e.id, q.ID)
+
+	if err := e.jobQueue.Enqueue(requeued); err != nil {
+		msg := fmt.Sprintf("%s: failed to queue interrupted job %s again: %s", e.id, q.ID, err)
+		log.Error(msg)
+		reportEvent(msg, report.ERROR)
+	}
+}
+
+// configureRetention sets how many runs of each job, and for how long, are kept in the runs repository.  By default
+// the 1000 most recent runs of a job are kept, a negative MaxRuns keeps all of them.
+func (e *executer) configureRetention(c common.RunsRepository) error {
+	e.maxRuns = 1000
+	if c.MaxRuns < 0 {
+		e.maxRuns = 0
+	} else if c.MaxRuns > 0 {
+		e.maxRuns = c.MaxRuns
+	}
+
+	if c.MaxAge != "" {
+		age, err := time.ParseDuration(c.MaxAge)
+		if err != nil {
+			return fmt.Errorf("invalid runs repository maxAge '%s': %s", c.MaxAge, err)
 		}
+
+		if age <= 0 {
+			return errors.New("runs repository maxAge must be greater than 0")
+		}
+		e.maxRunAge = age
+	}
+
+	log.Infof("%s: keeping %d runs per job for %s (0 is unlimited)", e.id, e.maxRuns, e.maxRunAge)
+
+	return nil
+}
+
+// pruneRuns removes the runs of the job beyond the retention from the runs repository after a run ended.  failures
+// are logged, the runs are pruned again when the job runs next.
+func (e *executer) pruneRuns(r *runRecord) {
+	if e.runsRepository == nil || (e.maxRuns <= 0 && e.maxRunAge <= 0) {
+		return
+	}
+
+	var before time.Time
+	if e.maxRunAge > 0 {
+		before = time.Now().Add(-e.maxRunAge)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := e.runsRepository.Prune(ctx, r.Account, r.Group, r.JobID, e.maxRuns, before); err != nil {
+		log.Errorf("%s: failed to prune runs of job %s: %s", e.id, r.JobID, err)
+	}
+}
+
+// putRun saves the run record in the runs repository.  failures are logged but don't affect the run.
+func (e *executer) putRun(r *runRecord) {
+	if e.runsRepository == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := e.runsRepository.Put(ctx, r.Run); err != nil {
+		log.Errorf("%s: failed to save run %s of job %s: %s", e.id, r.ID, r.JobID, err)
 	}
 }
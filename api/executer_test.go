@@ -3,18 +3,25 @@ package api
 import (
 	"context"
 	"errors"
+	"fmt"
+	"reflect"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/YaleSpinup/minion/cloudwatchlogs"
+	"github.com/YaleSpinup/minion/common"
 	"github.com/YaleSpinup/minion/jobs"
 	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
 )
 
 type mockExecQueuer struct {
 	t         *testing.T
+	enqueued  []jobs.QueuedJob
 	finalize  bool
 	finalized bool
+	mux       sync.Mutex
 }
 
 func newMockExecQueuer(t *testing.T, finalize bool) *mockExecQueuer {
@@ -31,20 +38,28 @@ func (m *mockExecQueuer) Close() error {
 }
 func (m *mockExecQueuer) Enqueue(queued *jobs.QueuedJob) error {
 	m.t.Logf("executer enqueing job %+v", queued)
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.enqueued = append(m.enqueued, *queued)
 	return nil
 }
 func (m *mockExecQueuer) Fetch(queued *jobs.QueuedJob) error {
 	m.t.Log("executer fetching jobs")
 	return nil
 }
-func (m *mockExecQueuer) Finalize(id string) error {
-	m.t.Logf("executer finalizing job %s", id)
+func (m *mockExecQueuer) Finalize(queued *jobs.QueuedJob) error {
+	m.t.Logf("executer finalizing job %+v", queued)
 
 	if !m.finalize {
 		m.t.Logf("'finalize' set to false, not finalizing")
 		return errors.New("boom!")
 	}
 
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
 	m.finalized = true
 	return nil
 }
@@ -65,6 +80,10 @@ func newMockRunner(t *testing.T, succeedAfter int) *mockRunner {
 	}
 }
 
+func (m *mockRunner) Validate(parameters interface{}) error {
+	return nil
+}
+
 func (m *mockRunner) Run(ctx context.Context, account string, parameters interface{}) (string, error) {
 	m.count += 1
 
@@ -78,13 +97,13 @@ func (m *mockRunner) Run(ctx context.Context, account string, parameters interfa
 	return "", errors.New("boom")
 }
 
-type mockExecCWLclient struct {
-	t *testing.T
-}
+// mockExecCWLclient doesn't log with the test since the events are sent from the log batching go routine,
+// which can still be running after the test returned
+type mockExecCWLclient struct{}
 
 func (m *mockExecCWLclient) LogEvent(ctx context.Context, group, stream string, events []*cloudwatchlogs.Event) error {
 	for _, e := range events {
-		m.t.Logf("logging event to %s/%s: %d %s", group, stream, e.Timestamp, e.Message)
+		log.Debugf("logging event to %s/%s: %d %s", group, stream, e.Timestamp, e.Message)
 	}
 	return nil
 }
@@ -131,8 +150,8 @@ func TestExecuterRun(t *testing.T) {
 	// test early success.  always finalize, test runner ran
 	q := newMockExecQueuer(t, true)
 	r := newMockRunner(t, 0)
-	l := &logger{client: &mockExecCWLclient{t: t}}
-	newMockExecuter(t, q, l).run(context.TODO(), r, &jobs.Job{ID: "job1", Group: "space-1"})
+	l := &logger{client: &mockExecCWLclient{}}
+	newMockExecuter(t, q, l).run(context.TODO(), r, &jobs.Job{ID: "job1", Group: "space-1"}, &jobs.QueuedJob{ID: "space-1/job1"})
 	if !q.finalized {
 		t.Error("queue was not finalized")
 	}
@@ -141,11 +160,11 @@ func TestExecuterRun(t *testing.T) {
 		t.Error("runner didn't run, expected runner to run")
 	}
 
-	// test no success (retry is 3).  always finalize, test runner didn't run
+	// test no success.  always finalize, test runner didn't run
 	q = newMockExecQueuer(t, true)
 	r = newMockRunner(t, 5)
-	l = &logger{client: &mockExecCWLclient{t: t}}
-	newMockExecuter(t, q, l).run(context.TODO(), r, &jobs.Job{ID: "job2", Group: "space-1"})
+	l = &logger{client: &mockExecCWLclient{}}
+	newMockExecuter(t, q, l).run(context.TODO(), r, &jobs.Job{ID: "job2", Group: "space-1"}, &jobs.QueuedJob{ID: "space-1/job2"})
 	if !q.finalized {
 		t.Error("queue was not finalized")
 	}
@@ -157,9 +176,9 @@ func TestExecuterRun(t *testing.T) {
 	// test early success.  failed finalize
 	q = newMockExecQueuer(t, false)
 	r = newMockRunner(t, 0)
-	l = &logger{client: &mockExecCWLclient{t: t}}
+	l = &logger{client: &mockExecCWLclient{}}
 	q.finalize = false
-	newMockExecuter(t, q, l).run(context.TODO(), r, &jobs.Job{ID: "job1", Group: "space-1"})
+	newMockExecuter(t, q, l).run(context.TODO(), r, &jobs.Job{ID: "job1", Group: "space-1"}, &jobs.QueuedJob{ID: "space-1/job1"})
 	if q.finalized {
 		t.Error("queue was finalized, expected failed finalize")
 	}
@@ -168,19 +187,726 @@ func TestExecuterRun(t *testing.T) {
 		t.Error("runner didn't run, expected runner to run")
 	}
 
-	// test cancel before retries complete, successful finalize
+	// test cancelled run, successful finalize, queued again
 	q = newMockExecQueuer(t, true)
 	r = newMockRunner(t, 2)
-	l = &logger{client: &mockExecCWLclient{t: t}}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	l = &logger{client: &mockExecCWLclient{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
 
-	newMockExecuter(t, q, l).run(ctx, r, &jobs.Job{ID: "job4", Group: "space-1"})
+	newMockExecuter(t, q, l).run(ctx, r, &jobs.Job{ID: "job4", Group: "space-1"}, &jobs.QueuedJob{ID: "space-1/job4", RunID: "run4"})
 	if !q.finalized {
 		t.Error("queue was not finalized")
 	}
 
 	if r.ran {
-		t.Error("runner didn't run, expected runner to run")
+		t.Error("runner ran, expected no run for cancelled context")
+	}
+
+	if len(q.enqueued) != 1 || q.enqueued[0].RunID != "run4" || q.enqueued[0].Attempt != 0 {
+		t.Errorf("expected cancelled job to be queued again, got %+v", q.enqueued)
+	}
+}
+
+func TestExecuterRunRetry(t *testing.T) {
+	type test struct {
+		name         string
+		policy       jobs.RetryPolicy
+		job          *jobs.Job
+		queued       *jobs.QueuedJob
+		runner       jobs.Runner
+		wantRetry    bool
+		wantAttempt  int
+		wantMinDelay time.Duration
+		wantTargets  []string
+	}
+
+	batchErr := jobs.NewRunnerStatusError(jobs.ErrExecFailure, "2 of 3 targets failed", 503, nil)
+	batchErr.Targets = []string{"i-2", "i-3"}
+
+	tests := []test{
+		{
+			name:         "default policy retries",
+			policy:       jobs.DefaultRetryPolicy,
+			job:          &jobs.Job{ID: "job1", Group: "space-1"},
+			queued:       &jobs.QueuedJob{ID: "space-1/job1"},
+			runner:       newMockRunner(t, 5),
+			wantRetry:    true,
+			wantAttempt:  1,
+			wantMinDelay: 4 * time.Second,
+		},
+		{
+			name:      "default policy attempts exhausted",
+			policy:    jobs.DefaultRetryPolicy,
+			job:       &jobs.Job{ID: "job1", Group: "space-1"},
+			queued:    &jobs.QueuedJob{ID: "space-1/job1", Attempt: 2},
+			runner:    newMockRunner(t, 5),
+			wantRetry: false,
+		},
+		{
+			name:      "missing details are not retried",
+			policy:    jobs.DefaultRetryPolicy,
+			job:       &jobs.Job{ID: "job1", Group: "space-1"},
+			queued:    &jobs.QueuedJob{ID: "space-1/job1"},
+			runner:    &mockErrRunner{err: jobs.NewRunnerError(jobs.ErrMissingDetails, "missing instance_id", nil)},
+			wantRetry: false,
+		},
+		{
+			name:   "job policy retries status class",
+			policy: jobs.DefaultRetryPolicy,
+			job: &jobs.Job{ID: "job1", Group: "space-1", Retry: &jobs.RetryPolicy{
+				MaxAttempts: 5,
+				Backoff:     jobs.BackoffExponential,
+				Delay:       1 * time.Minute,
+				RetryOn:     []string{"5xx"},
+			}},
+			queued:       &jobs.QueuedJob{ID: "space-1/job1", Attempt: 3},
+			runner:       &mockErrRunner{err: jobs.NewRunnerStatusError(jobs.ErrExecFailure, "unexpected http response", 503, nil)},
+			wantRetry:    true,
+			wantAttempt:  4,
+			wantMinDelay: 7 * time.Minute,
+		},
+		{
+			name:   "job policy doesn't retry other statuses",
+			policy: jobs.DefaultRetryPolicy,
+			job: &jobs.Job{ID: "job1", Group: "space-1", Retry: &jobs.RetryPolicy{
+				RetryOn: []string{"5xx"},
+			}},
+			queued:    &jobs.QueuedJob{ID: "space-1/job1"},
+			runner:    &mockErrRunner{err: jobs.NewRunnerStatusError(jobs.ErrExecFailure, "unexpected http response", 400, nil)},
+			wantRetry: false,
+		},
+		{
+			name:         "failed targets are retried",
+			policy:       jobs.DefaultRetryPolicy,
+			job:          &jobs.Job{ID: "job1", Group: "space-1"},
+			queued:       &jobs.QueuedJob{ID: "space-1/job1"},
+			runner:       &mockErrRunner{err: batchErr},
+			wantRetry:    true,
+			wantAttempt:  1,
+			wantMinDelay: 4 * time.Second,
+			wantTargets:  []string{"i-2", "i-3"},
+		},
+		{
+			name:         "targets are kept on retry",
+			policy:       jobs.DefaultRetryPolicy,
+			job:          &jobs.Job{ID: "job1", Group: "space-1"},
+			queued:       &jobs.QueuedJob{ID: "space-1/job1", Attempt: 1, Targets: []string{"i-3"}},
+			runner:       newMockRunner(t, 5),
+			wantRetry:    true,
+			wantAttempt:  2,
+			wantMinDelay: 4 * time.Second,
+			wantTargets:  []string{"i-3"},
+		},
+		{
+			name:      "retries disabled",
+			policy:    jobs.RetryPolicy{MaxAttempts: 1},
+			job:       &jobs.Job{ID: "job1", Group: "space-1"},
+			queued:    &jobs.QueuedJob{ID: "space-1/job1"},
+			runner:    newMockRunner(t, 5),
+			wantRetry: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newMockExecQueuer(t, true)
+			e := newMockExecuter(t, q, &logger{client: &mockExecCWLclient{}})
+			e.defaultRetry = tt.policy
+
+			start := time.Now()
+			e.run(context.TODO(), tt.runner, tt.job, tt.queued)
+
+			if !q.finalized {
+				t.Error("queue was not finalized")
+			}
+
+			if !tt.wantRetry {
+				if len(q.enqueued) != 0 {
+					t.Errorf("expected no retry, got %+v", q.enqueued)
+				}
+				return
+			}
+
+			if len(q.enqueued) != 1 {
+				t.Fatalf("expected retry, got %+v", q.enqueued)
+			}
+
+			retry := q.enqueued[0]
+			if retry.ID != tt.queued.ID || retry.Attempt != tt.wantAttempt || retry.RunID == "" {
+				t.Errorf("unexpected retry %+v", retry)
+			}
+
+			if min := float64(start.Add(tt.wantMinDelay).Unix()); retry.Score < min {
+				t.Errorf("expected retry score to be at least %f, got %f", min, retry.Score)
+			}
+
+			if !reflect.DeepEqual(retry.Targets, tt.wantTargets) {
+				t.Errorf("expected retry targets %v, got %v", tt.wantTargets, retry.Targets)
+			}
+		})
+	}
+}
+
+type mockErrRunner struct {
+	err error
+}
+
+func (m *mockErrRunner) Validate(parameters interface{}) error {
+	return nil
+}
+
+func (m *mockErrRunner) Run(ctx context.Context, account string, parameters interface{}) (string, error) {
+	return "", m.err
+}
+
+type mockRunsRepository struct {
+	t       *testing.T
+	runs    []jobs.Run
+	pruned  []string
+	deleted []string
+	err     error
+}
+
+func (m *mockRunsRepository) Get(ctx context.Context, account, group, jobID, id string) (*jobs.Run, error) {
+	return nil, nil
+}
+
+func (m *mockRunsRepository) List(ctx context.Context, account, group, jobID string, limit int) ([]*jobs.Run, error) {
+	return nil, nil
+}
+
+func (m *mockRunsRepository) Prune(ctx context.Context, account, group, jobID string, keep int, before time.Time) error {
+	m.t.Logf("pruning runs of %s/%s/%s, keeping %d since %s", account, group, jobID, keep, before)
+	if m.err != nil {
+		return m.err
+	}
+
+	m.pruned = append(m.pruned, fmt.Sprintf("%s/%s/%s:%d:%t", account, group, jobID, keep, before.IsZero()))
+	return nil
+}
+
+func (m *mockRunsRepository) Delete(ctx context.Context, account, group, jobID string) error {
+	m.t.Logf("deleting runs of %s/%s/%s", account, group, jobID)
+	if m.err != nil {
+		return m.err
+	}
+
+	m.deleted = append(m.deleted, account+"/"+group+"/"+jobID)
+	return nil
+}
+
+func (m *mockRunsRepository) Put(ctx context.Context, run *jobs.Run) error {
+	m.t.Logf("putting run %+v", run)
+	if m.err != nil {
+		return m.err
+	}
+
+	m.runs = append(m.runs, *run)
+	return nil
+}
+
+func TestExecuterRunRecords(t *testing.T) {
+	scheduled := time.Unix(1600000000, 0).UTC()
+
+	type test struct {
+		name         string
+		succeedAfter int
+		wantStatus   string
+		wantOutput   string
+		wantError    string
+	}
+
+	tests := []test{
+		{
+			name:         "success",
+			succeedAfter: 0,
+			wantStatus:   jobs.RunStatusSucceeded,
+			wantOutput:   "success",
+		},
+		{
+			name:         "failure",
+			succeedAfter: 5,
+			wantStatus:   jobs.RunStatusFailed,
+			wantError:    "boom",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := &mockRunsRepository{t: t}
+			e := newMockExecuter(t, newMockExecQueuer(t, true), &logger{client: &mockExecCWLclient{}})
+			e.runsRepository = rr
+			e.maxRuns = 5
+
+			j := &jobs.Job{ID: "job1", Account: "acct", Group: "space-1", Details: map[string]string{"runner": "dummy"}}
+			q := &jobs.QueuedJob{ID: "space-1/job1", RunID: "run1", Score: float64(scheduled.Unix()), Attempt: 1}
+			e.run(context.TODO(), newMockRunner(t, tt.succeedAfter), j, q)
+
+			if len(rr.runs) != 2 {
+				t.Fatalf("expected 2 run records, got %d", len(rr.runs))
+			}
+
+			start, end := rr.runs[0], rr.runs[1]
+			if start.Status != jobs.RunStatusRunning {
+				t.Errorf("expected first record status %s, got %s", jobs.RunStatusRunning, start.Status)
+			}
+
+			if start.StartedAt == nil || start.EndedAt != nil {
+				t.Errorf("expected first record to be started and not ended, got %+v", start)
+			}
+
+			if end.ID != "run1" || end.JobID != "job1" || end.Account != "acct" || end.Group != "space-1" || end.Runner != "dummy" || end.NodeID != e.id {
+				t.Errorf("unexpected run record identity %+v", end)
+			}
+
+			if end.Attempt != 2 {
+				t.Errorf("expected attempt 2, got %d", end.Attempt)
+			}
+
+			if end.ScheduledAt == nil || !end.ScheduledAt.Equal(scheduled) {
+				t.Errorf("expected scheduled at %s, got %v", scheduled, end.ScheduledAt)
+			}
+
+			if end.EndedAt == nil {
+				t.Error("expected last record to be ended")
+			}
+
+			if end.Status != tt.wantStatus {
+				t.Errorf("expected status %s, got %s", tt.wantStatus, end.Status)
+			}
+
+			if end.Output != tt.wantOutput {
+				t.Errorf("expected output %q, got %q", tt.wantOutput, end.Output)
+			}
+
+			if end.Error != tt.wantError {
+				t.Errorf("expected error %q, got %q", tt.wantError, end.Error)
+			}
+
+			if end.Result == nil || end.Result.Status != tt.wantStatus || end.Result.StartedAt == nil {
+				t.Errorf("expected result with status %s, got %+v", tt.wantStatus, end.Result)
+			}
+
+			if expected := []string{"acct/space-1/job1:5:true"}; !reflect.DeepEqual(rr.pruned, expected) {
+				t.Errorf("expected runs to be pruned %v, got %v", expected, rr.pruned)
+			}
+		})
+	}
+
+	// failing to save the run record doesn't fail the run
+	q := newMockExecQueuer(t, true)
+	r := newMockRunner(t, 0)
+	e := newMockExecuter(t, q, &logger{client: &mockExecCWLclient{}})
+	e.runsRepository = &mockRunsRepository{t: t, err: errors.New("boom")}
+	e.run(context.TODO(), r, &jobs.Job{ID: "job1", Group: "space-1"}, &jobs.QueuedJob{ID: "space-1/job1"})
+	if !r.ran || !q.finalized {
+		t.Error("expected job to run and be finalized when saving the run record fails")
+	}
+}
+
+func TestExecuterConfigureRetention(t *testing.T) {
+	type test struct {
+		config    common.RunsRepository
+		maxRuns   int
+		maxRunAge time.Duration
+		err       bool
+	}
+
+	tests := []test{
+		{config: common.RunsRepository{}, maxRuns: 1000},
+		{config: common.RunsRepository{MaxRuns: 50, MaxAge: "720h"}, maxRuns: 50, maxRunAge: 720 * time.Hour},
+		{config: common.RunsRepository{MaxRuns: -1}, maxRuns: 0},
+		{config: common.RunsRepository{MaxAge: "forever"}, err: true},
+		{config: common.RunsRepository{MaxAge: "-1h"}, err: true},
+	}
+
+	for _, tst := range tests {
+		e := &executer{}
+		err := e.configureRetention(tst.config)
+		if tst.err {
+			if err == nil {
+				t.Errorf("expected error for %+v, got nil", tst.config)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("expected nil error for %+v, got %s", tst.config, err)
+		}
+
+		if e.maxRuns != tst.maxRuns || e.maxRunAge != tst.maxRunAge {
+			t.Errorf("expected retention %d, %s for %+v, got %d, %s", tst.maxRuns, tst.maxRunAge, tst.config, e.maxRuns, e.maxRunAge)
+		}
+	}
+}
+
+type mockRenewQueuer struct {
+	*mockExecQueuer
+	renewed int
+}
+
+func (m *mockRenewQueuer) Renew(queued *jobs.QueuedJob) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.renewed++
+	return nil
+}
+
+func (m *mockRenewQueuer) renewals() int {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	return m.renewed
+}
+
+func TestExecuterRunRenew(t *testing.T) {
+	q := &mockRenewQueuer{mockExecQueuer: newMockExecQueuer(t, true)}
+	e := newMockExecuter(t, q.mockExecQueuer, &logger{client: &mockExecCWLclient{}})
+	e.jobQueue = q
+	e.heartbeat = 10 * time.Millisecond
+
+	runner := &mockBlockingRunner{done: make(chan struct{})}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(runner.done)
+	}()
+
+	e.run(context.TODO(), runner, &jobs.Job{ID: "job1", Group: "space-1"}, &jobs.QueuedJob{ID: "space-1/job1", RunID: "run1"})
+
+	renewed := q.renewals()
+	if renewed == 0 {
+		t.Error("expected the lease to be renewed while the job was running")
+	}
+
+	if !q.finalized {
+		t.Error("queue was not finalized")
+	}
+
+	// the lease isn't renewed after the run
+	time.Sleep(50 * time.Millisecond)
+	if q.renewals() != renewed {
+		t.Errorf("expected no renewals after the run, got %d more", q.renewals()-renewed)
+	}
+}
+
+type mockPoolQueuer struct {
+	mockExecQueuer
+	queue []jobs.QueuedJob
+}
+
+func (m *mockPoolQueuer) Fetch(queued *jobs.QueuedJob) error {
+	if len(m.queue) == 0 {
+		return jobs.NewQueueError(jobs.ErrQueueIsEmpty, "queue is empty", nil)
+	}
+
+	*queued = m.queue[0]
+	m.queue = m.queue[1:]
+	return nil
+}
+
+type mockBlockingRunner struct {
+	done chan struct{}
+}
+
+func (m *mockBlockingRunner) Validate(parameters interface{}) error {
+	return nil
+}
+
+func (m *mockBlockingRunner) Run(ctx context.Context, account string, parameters interface{}) (string, error) {
+	select {
+	case <-m.done:
+		return "success", nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func TestExecuterNext(t *testing.T) {
+	runner := &mockBlockingRunner{done: make(chan struct{})}
+	q := &mockPoolQueuer{
+		mockExecQueuer: mockExecQueuer{t: t, finalize: true},
+		queue: []jobs.QueuedJob{
+			{ID: "space-1/job1", RunID: "run1"},
+			{ID: "space-1/job2", RunID: "run2"},
+			{ID: "space-1/job3", RunID: "run3"},
+		},
+	}
+
+	e := &executer{
+		accounts: map[string]common.Account{
+			"acct1": {Runners: []string{"block"}},
+			"acct2": {Runners: []string{"block"}},
+		},
+		id:       "test",
+		jobQueue: q,
+		jobsCache: &jobsCache{
+			Cache: map[string]*jobs.Job{
+				"space-1/job1": {ID: "job1", Account: "acct1", Group: "space-1", Details: map[string]string{"runner": "block"}},
+				"space-1/job2": {ID: "job2", Account: "acct1", Group: "space-1", Details: map[string]string{"runner": "block"}},
+				"space-1/job3": {ID: "job3", Account: "acct2", Group: "space-1", Details: map[string]string{"runner": "block"}},
+			},
+		},
+		jobRunners: map[string]jobs.Runner{"block": runner},
+		logger:     &logger{client: &mockExecCWLclient{}},
+		pool:       newPool(2, nil, map[string]int{"acct1": 1}),
+	}
+
+	// first job runs
+	if e.next(context.TODO()) != fetchedJob {
+		t.Error("expected next to return fetchedJob after running a job")
+	}
+
+	// second job is over the account limit and goes back to the queue
+	if e.next(context.TODO()) != fetchedLimited {
+		t.Error("expected next to return fetchedLimited after returning a job to the queue")
+	}
+
+	if len(q.enqueued) != 1 || q.enqueued[0].RunID != "run2" {
+		t.Errorf("expected run2 to be returned to the queue, got %+v", q.enqueued)
+	}
+
+	if q.enqueued[0].Score < float64(time.Now().Unix()) {
+		t.Errorf("expected returned job to be scored after now, got %f", q.enqueued[0].Score)
+	}
+
+	// third job is in another account and runs
+	if e.next(context.TODO()) != fetchedJob {
+		t.Error("expected next to return fetchedJob after running a job")
+	}
+
+	if e.pool.free() {
+		t.Error("expected pool to be full")
+	}
+
+	// empty queue
+	if e.next(context.TODO()) != fetchedNone {
+		t.Error("expected next to return fetchedNone for an empty queue")
+	}
+
+	close(runner.done)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		e.pool.mux.Lock()
+		running := e.pool.running
+		e.pool.mux.Unlock()
+
+		if running == 0 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the pool to be released")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// mockRequeuingQueuer puts the jobs returned to the queue back at the end of the queue
+type mockRequeuingQueuer struct {
+	mockPoolQueuer
+}
+
+func (m *mockRequeuingQueuer) Enqueue(queued *jobs.QueuedJob) error {
+	m.mockPoolQueuer.Enqueue(queued)
+	m.queue = append(m.queue, *queued)
+	return nil
+}
+
+func TestExecuterFetch(t *testing.T) {
+	runner := &mockBlockingRunner{done: make(chan struct{})}
+	q := &mockRequeuingQueuer{mockPoolQueuer{
+		mockExecQueuer: mockExecQueuer{t: t, finalize: true},
+		queue: []jobs.QueuedJob{
+			{ID: "space-1/job1", RunID: "run1"},
+			{ID: "space-1/job2", RunID: "run2"},
+			{ID: "space-1/job3", RunID: "run3"},
+		},
+	}}
+
+	e := &executer{
+		accounts: map[string]common.Account{
+			"acct1": {Runners: []string{"block"}},
+			"acct2": {Runners: []string{"block"}},
+		},
+		id:       "test",
+		jobQueue: q,
+		jobsCache: &jobsCache{
+			Cache: map[string]*jobs.Job{
+				"space-1/job1": {ID: "job1", Account: "acct1", Group: "space-1", Details: map[string]string{"runner": "block"}},
+				"space-1/job2": {ID: "job2", Account: "acct1", Group: "space-1", Details: map[string]string{"runner": "block"}},
+				"space-1/job3": {ID: "job3", Account: "acct2", Group: "space-1", Details: map[string]string{"runner": "block"}},
+			},
+		},
+		jobRunners: map[string]jobs.Runner{"block": runner},
+		logger:     &logger{client: &mockExecCWLclient{}},
+		pool:       newPool(3, nil, map[string]int{"acct1": 1}),
+	}
+
+	// the limited job doesn't keep the job behind it from running, and returning it to the queue over
+	// and over again is bounded
+	e.fetch(context.TODO(), context.TODO())
+
+	e.pool.mux.Lock()
+	running := e.pool.running
+	e.pool.mux.Unlock()
+
+	if running != 2 {
+		t.Errorf("expected job1 and job3 to be running, got %d running jobs", running)
+	}
+
+	if len(q.enqueued) != maxLimitedFetches {
+		t.Errorf("expected the limited job to be returned to the queue %d times, got %d", maxLimitedFetches, len(q.enqueued))
+	}
+
+	for _, queued := range q.enqueued {
+		if queued.RunID != "run2" {
+			t.Errorf("expected only run2 to be returned to the queue, got %+v", queued)
+		}
+	}
+
+	close(runner.done)
+	if !e.pool.wait(time.After(5 * time.Second)) {
+		t.Fatal("timed out waiting for the pool to be released")
+	}
+}
+
+func TestExecuterNextForbidden(t *testing.T) {
+	runner := &mockBlockingRunner{done: make(chan struct{})}
+	close(runner.done)
+
+	q := &mockPoolQueuer{
+		mockExecQueuer: mockExecQueuer{t: t, finalize: true},
+		queue:          []jobs.QueuedJob{{ID: "space-1/job1", RunID: "run1"}},
+	}
+	runs := &mockRunsRepository{t: t}
+
+	e := &executer{
+		accounts: map[string]common.Account{"acct1": {Runners: []string{"other"}}},
+		id:       "test",
+		jobQueue: q,
+		jobsCache: &jobsCache{
+			Cache: map[string]*jobs.Job{
+				"space-1/job1": {ID: "job1", Account: "acct1", Group: "space-1", Details: map[string]string{"runner": "block"}},
+			},
+		},
+		jobRunners:     map[string]jobs.Runner{"block": runner},
+		logger:         &logger{client: &mockExecCWLclient{}},
+		pool:           newPool(2, nil, nil),
+		runsRepository: runs,
+	}
+
+	if e.next(context.TODO()) != fetchedJob {
+		t.Error("expected next to return fetchedJob after refusing a job")
+	}
+
+	if !q.finalized {
+		t.Error("expected refused job to be finalized")
+	}
+
+	if e.pool.running != 0 {
+		t.Errorf("expected refused job not to run, got %d running", e.pool.running)
+	}
+
+	if len(runs.runs) != 1 || runs.runs[0].Status != jobs.RunStatusFailed {
+		t.Errorf("expected a failed run record, got %+v", runs.runs)
+	}
+}
+
+func TestExecuterDrain(t *testing.T) {
+	newDrainExecuter := func(q *mockPoolQueuer, runner jobs.Runner) *executer {
+		e := &executer{
+			accounts: map[string]common.Account{"acct1": {Runners: []string{"block"}}},
+			done:     make(chan struct{}),
+			id:       "test",
+			jobQueue: q,
+			jobsCache: &jobsCache{
+				Cache: map[string]*jobs.Job{
+					"space-1/job1": {ID: "job1", Account: "acct1", Group: "space-1", Details: map[string]string{"runner": "block"}},
+				},
+			},
+			jobRunners: map[string]jobs.Runner{"block": runner},
+			logger:     &logger{client: &mockExecCWLclient{}},
+			pool:       newPool(2, nil, nil),
+		}
+		close(e.done)
+		return e
+	}
+
+	// in-flight job finishes before the timeout
+	runner := &mockBlockingRunner{done: make(chan struct{})}
+	q := &mockPoolQueuer{
+		mockExecQueuer: mockExecQueuer{t: t, finalize: true},
+		queue:          []jobs.QueuedJob{{ID: "space-1/job1", RunID: "run1"}},
+	}
+	e := newDrainExecuter(q, runner)
+	runCtx, cancelRuns := context.WithCancel(context.Background())
+	defer cancelRuns()
+
+	if e.next(runCtx) != fetchedJob {
+		t.Fatal("expected next to run the job")
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(runner.done)
+	}()
+
+	if !e.drain(5*time.Second, cancelRuns) {
+		t.Error("expected drain to succeed")
+	}
+
+	if runCtx.Err() != nil {
+		t.Error("expected in-flight jobs not to be cancelled")
+	}
+
+	if !q.finalized {
+		t.Error("expected job to be finalized")
+	}
+
+	if len(q.enqueued) != 0 {
+		t.Errorf("expected no jobs to be queued again, got %+v", q.enqueued)
+	}
+
+	// in-flight job doesn't finish before the timeout and is queued again
+	runner = &mockBlockingRunner{done: make(chan struct{})}
+	q = &mockPoolQueuer{
+		mockExecQueuer: mockExecQueuer{t: t, finalize: true},
+		queue:          []jobs.QueuedJob{{ID: "space-1/job1", RunID: "run1", Attempt: 1}},
+	}
+	e = newDrainExecuter(q, runner)
+	rr := &mockRunsRepository{t: t}
+	e.runsRepository = rr
+	runCtx, cancelRuns = context.WithCancel(context.Background())
+	defer cancelRuns()
+
+	if e.next(runCtx) != fetchedJob {
+		t.Fatal("expected next to run the job")
+	}
+
+	if e.drain(100*time.Millisecond, cancelRuns) {
+		t.Error("expected drain to time out")
+	}
+
+	if runCtx.Err() == nil {
+		t.Error("expected in-flight jobs to be cancelled")
+	}
+
+	if !q.finalized {
+		t.Error("expected job to be finalized")
+	}
+
+	if len(q.enqueued) != 1 {
+		t.Fatalf("expected job to be queued again, got %+v", q.enqueued)
+	}
+
+	if requeued := q.enqueued[0]; requeued.ID != "space-1/job1" || requeued.RunID != "run1" || requeued.Attempt != 1 {
+		t.Errorf("unexpected queued job %+v", requeued)
+	}
+
+	if last := rr.runs[len(rr.runs)-1]; last.Status != jobs.RunStatusInterrupted {
+		t.Errorf("expected run status %s, got %s", jobs.RunStatusInterrupted, last.Status)
 	}
 }
@@ -35,6 +35,9 @@ func (s *server) VersionHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
+// ErrPreconditionFailed is the error code of a conditional request whose precondition, ie. If-Match, failed
+const ErrPreconditionFailed = "PreconditionFailed"
+
 // handleError handles standard apierror return codes
 func handleError(w http.ResponseWriter, err error) {
 	log.Error(err.Error())
@@ -46,6 +49,8 @@ func handleError(w http.ResponseWriter, err error) {
 			w.WriteHeader(http.StatusNotFound)
 		case apierror.ErrConflict:
 			w.WriteHeader(http.StatusConflict)
+		case ErrPreconditionFailed:
+			w.WriteHeader(http.StatusPreconditionFailed)
 		case apierror.ErrBadRequest:
 			w.WriteHeader(http.StatusBadRequest)
 		case apierror.ErrLimitExceeded:
@@ -4,9 +4,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/YaleSpinup/apierror"
+	report "github.com/YaleSpinup/eventreporter"
+	"github.com/YaleSpinup/minion/common"
 	"github.com/YaleSpinup/minion/jobs"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
@@ -48,6 +51,11 @@ func (s *server) JobsCreateHandler(w http.ResponseWriter, r *http.Request) {
 
 	log.Debugf("decoded request body into job input %+v", input)
 
+	if err := s.validateJob(account, input.Job); err != nil {
+		handleError(w, err)
+		return
+	}
+
 	// setup rollback function list and defer execution, note that we depend on the err variable defined above this
 	var rollBackTasks []func() error
 	defer func() {
@@ -73,7 +81,7 @@ func (s *server) JobsCreateHandler(w http.ResponseWriter, r *http.Request) {
 		}()
 	})
 
-	next, err := job.NextRun(time.Now())
+	next, err := nextRun(job, s.accounts[account], time.Now())
 	if err != nil {
 		handleError(w, err)
 		return
@@ -84,10 +92,13 @@ func (s *server) JobsCreateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// cache the new job so it's scheduled right away
+	s.cacheJob(account, group, job)
+
 	out := JobsResponse{
 		Job:  job,
 		Tags: input.Tags,
-		Next: next.UTC().Truncate(time.Second).Format(time.RFC3339),
+		Next: next,
 	}
 
 	j, err := json.Marshal(&out)
@@ -98,6 +109,7 @@ func (s *server) JobsCreateHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", jobETag(job))
 	w.WriteHeader(http.StatusOK)
 	w.Write(j)
 }
@@ -156,7 +168,7 @@ func (s *server) JobsShowHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	next, err := job.NextRun(time.Now())
+	next, err := nextRun(job, s.accounts[account], time.Now())
 	if err != nil {
 		handleError(w, err)
 		return
@@ -172,7 +184,7 @@ func (s *server) JobsShowHandler(w http.ResponseWriter, r *http.Request) {
 		Job:  job,
 		Tags: tags,
 		Log:  lg,
-		Next: next.UTC().Truncate(time.Second).Format(time.RFC3339),
+		Next: next,
 	}
 
 	j, err := json.Marshal(&out)
@@ -183,6 +195,7 @@ func (s *server) JobsShowHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", jobETag(job))
 	w.WriteHeader(http.StatusOK)
 	w.Write(j)
 }
@@ -225,19 +238,40 @@ func (s *server) JobsUpdateHandler(w http.ResponseWriter, r *http.Request) {
 
 	log.Debugf("decoded request body into job input %+v", input)
 
+	if err := s.validateJob(account, input.Job); err != nil {
+		handleError(w, err)
+		return
+	}
+
 	// get the job to be sure it exists
-	if _, err := s.jobsRepository.Get(r.Context(), account, group, id); err != nil {
+	current, err := s.jobsRepository.Get(r.Context(), account, group, id)
+	if err != nil {
 		handleError(w, err)
 		return
 	}
 
+	// a conditional update only applies to the version of the job the client has, the repository rejects
+	// the update if the job is modified before it's written.  otherwise the update is unconditional.
+	input.Job.Version = 0
+	if match := r.Header.Get("If-Match"); match != "" {
+		if !etagMatches(match, current) {
+			msg := fmt.Sprintf("job %s was modified, it is at version %s", id, jobETag(current))
+			handleError(w, apierror.New(ErrPreconditionFailed, msg, nil))
+			return
+		}
+		input.Job.Version = current.Version
+	}
+
 	job, err := s.jobsRepository.Update(r.Context(), account, group, id, input.Job)
 	if err != nil {
 		handleError(w, err)
 		return
 	}
 
-	next, err := job.NextRun(time.Now())
+	// update the cached job so the changes are scheduled right away
+	s.cacheJob(account, group, job)
+
+	next, err := nextRun(job, s.accounts[account], time.Now())
 	if err != nil {
 		handleError(w, err)
 		return
@@ -258,7 +292,7 @@ func (s *server) JobsUpdateHandler(w http.ResponseWriter, r *http.Request) {
 		Job:  job,
 		Tags: tags,
 		Log:  lg,
-		Next: next.UTC().Truncate(time.Second).Format(time.RFC3339),
+		Next: next,
 	}
 
 	j, err := json.Marshal(&out)
@@ -269,11 +303,13 @@ func (s *server) JobsUpdateHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", jobETag(job))
 	w.WriteHeader(http.StatusAccepted)
 	w.Write(j)
 }
 
-// JobsDeleteHandler removes a job from the respository
+// JobsDeleteHandler removes a job, or all of the jobs in a group, from the respository.  Repositories that keep a
+// trash move the jobs to the trash, see the trash handlers.
 func (s *server) JobsDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	w = LogWriter{w}
 	vars := mux.Vars(r)
@@ -289,12 +325,14 @@ func (s *server) JobsDeleteHandler(w http.ResponseWriter, r *http.Request) {
 
 	log.Infof("deleting job %s/%s/%s from repository", account, group, id)
 
-	err := s.jobsRepository.Delete(r.Context(), account, group, id)
-	if err != nil {
+	if err := s.deleteJob(r, account, group, id); err != nil {
 		handleError(w, err)
 		return
 	}
 
+	// remove the deleted job (or group of jobs) from the cache so it's no longer scheduled
+	s.uncacheJob(account, group, id)
+
 	// TODO archive cloudwatchlog log stream
 
 	w.Header().Set("Content-Type", "application/json")
@@ -336,21 +374,22 @@ func (s *server) JobsRunHandler(w http.ResponseWriter, r *http.Request) {
 		if jobRunner, ok := s.jobRunners[runner]; ok {
 			log.Debugf("jobRunner is defined for requested runner '%s': %+v", runner, jobRunner)
 
-			// check if the runner is configured for the account
-			allowed := false
-			for _, r := range account.Runners {
-				if r == runner {
-					allowed = true
-					break
-				}
+			// check if the runner is allowed for the account
+			if !allowedRunner(account, runner) {
+				msg := fmt.Sprintf("runner '%s' is not allowed for account %s, refusing to run job %s/%s", runner, acct, group, id)
+				log.Warn(msg)
+				reportEvent(msg, report.ERROR)
+				handleError(w, apierror.New(apierror.ErrForbidden, msg, nil))
+				return
 			}
 
-			if !allowed {
-				w.WriteHeader(http.StatusBadRequest)
-				w.Write([]byte("runner not found for account"))
+			queued := &jobs.QueuedJob{
+				ID:    group + "/" + id,
+				RunID: jobs.NewRunID(),
+				Score: float64(time.Now().Unix()),
 			}
 
-			if err := s.jobQueue.Enqueue(&jobs.QueuedJob{ID: group + "/" + id}); err != nil {
+			if err := s.jobQueue.Enqueue(queued); err != nil {
 				w.WriteHeader(http.StatusBadRequest)
 				w.Write([]byte("failed queuing job " + err.Error()))
 				return
@@ -366,3 +405,105 @@ func (s *server) JobsRunHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusBadRequest)
 	w.Write([]byte("runner not found in job"))
 }
+
+// deleteJob deletes a job, or a group of jobs if the id is empty, from the repository.  If the repository keeps a
+// trash, the jobs are moved to the trash until they are restored or purged.  If the request has an If-Match header,
+// the job is only deleted if it's still at a matching version.
+func (s *server) deleteJob(r *http.Request, account, group, id string) error {
+	var version int64
+	if match := r.Header.Get("If-Match"); match != "" {
+		if id == "" {
+			return apierror.New(apierror.ErrBadRequest, "If-Match is not supported when deleting a group of jobs", nil)
+		}
+
+		current, err := s.jobsRepository.Get(r.Context(), account, group, id)
+		if err != nil {
+			return err
+		}
+
+		if !etagMatches(match, current) {
+			msg := fmt.Sprintf("job %s was modified, it is at version %s", id, jobETag(current))
+			return apierror.New(ErrPreconditionFailed, msg, nil)
+		}
+		version = current.Version
+	}
+
+	if t, ok := s.jobsRepository.(jobs.Trasher); ok {
+		return t.Trash(r.Context(), account, group, id, version)
+	}
+
+	if vd, ok := s.jobsRepository.(jobs.VersionedDeleter); ok && version != 0 {
+		return vd.DeleteVersion(r.Context(), account, group, id, version)
+	}
+
+	return s.jobsRepository.Delete(r.Context(), account, group, id)
+}
+
+// jobETag returns the entity tag of the version of a job
+func jobETag(job *jobs.Job) string {
+	return fmt.Sprintf(`"%d"`, job.Version)
+}
+
+// etagMatches returns true if the value of an If-Match header matches the entity tag of the job.  The value is
+// either '*' or a list of entity tags, weak entity tags never match.
+func etagMatches(match string, job *jobs.Job) bool {
+	etag := jobETag(job)
+	for _, m := range strings.Split(match, ",") {
+		if m = strings.TrimSpace(m); m == "*" || m == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// nextRun returns the next run of the job after t formatted in the job's timezone.  If the job
+// doesn't set a timezone, the account default timezone is used.
+func nextRun(job *jobs.Job, account common.Account, t time.Time) (string, error) {
+	j := *job
+	if j.Timezone == "" {
+		j.Timezone = account.Timezone
+	}
+
+	next, err := j.NextRun(t)
+	if err != nil {
+		return "", apierror.New(apierror.ErrBadRequest, "failed to determine next run for job", err)
+	}
+
+	return next.Truncate(time.Second).Format(time.RFC3339), nil
+}
+
+// validateJob checks that the job has a configured runner that is allowed for the account and validates the job
+// details with that runner.  The returned bad request error lists every problem with the details.
+func (s *server) validateJob(account string, job *jobs.Job) error {
+	runner, ok := job.Details["runner"]
+	if !ok || runner == "" {
+		return apierror.New(apierror.ErrBadRequest, "invalid job details: missing runner", nil)
+	}
+
+	jobRunner, ok := s.jobRunners[runner]
+	if !ok {
+		msg := fmt.Sprintf("invalid job details: runner '%s' is not configured", runner)
+		return apierror.New(apierror.ErrBadRequest, msg, nil)
+	}
+
+	if !allowedRunner(s.accounts[account], runner) {
+		msg := fmt.Sprintf("runner '%s' is not allowed for account %s", runner, account)
+		return apierror.New(apierror.ErrForbidden, msg, nil)
+	}
+
+	if err := jobRunner.Validate(job.Details); err != nil {
+		return apierror.New(apierror.ErrBadRequest, err.Error(), err)
+	}
+
+	return nil
+}
+
+// allowedRunner returns true if the runner is in the account's list of runners
+func allowedRunner(account common.Account, runner string) bool {
+	for _, r := range account.Runners {
+		if r == runner {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,240 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/YaleSpinup/minion/jobs"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// RevisionsListHandler lists the revision history of a job, most recent first.  Each revision has the changes
+// from the revision before it.
+func (s *server) RevisionsListHandler(w http.ResponseWriter, r *http.Request) {
+	w = LogWriter{w}
+	vars := mux.Vars(r)
+	account := vars["account"]
+	group := vars["group"]
+	id := vars["id"]
+
+	if _, ok := s.accounts[account]; !ok {
+		msg := fmt.Sprintf("account not found: %s", account)
+		handleError(w, apierror.New(apierror.ErrNotFound, msg, nil))
+		return
+	}
+
+	log.Infof("listing revisions of job %s for account %s, group %s", id, account, group)
+
+	revisions, err := s.revisions(r, account, group, id)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	j, err := json.Marshal(&revisions)
+	if err != nil {
+		msg := fmt.Sprintf("cannot encode revision listing into json: %s", err)
+		handleError(w, apierror.New(apierror.ErrBadRequest, msg, err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(j)
+}
+
+// RevisionsShowHandler gets an individual revision of a job
+func (s *server) RevisionsShowHandler(w http.ResponseWriter, r *http.Request) {
+	w = LogWriter{w}
+	vars := mux.Vars(r)
+	account := vars["account"]
+	group := vars["group"]
+	id := vars["id"]
+	rev := vars["rev"]
+
+	if _, ok := s.accounts[account]; !ok {
+		msg := fmt.Sprintf("account not found: %s", account)
+		handleError(w, apierror.New(apierror.ErrNotFound, msg, nil))
+		return
+	}
+
+	log.Infof("showing revision %s of job %s for account %s, group %s", rev, id, account, group)
+
+	revision, err := s.revision(r, account, group, id, rev)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	j, err := json.Marshal(revision)
+	if err != nil {
+		msg := fmt.Sprintf("cannot encode revision into json: %s", err)
+		handleError(w, apierror.New(apierror.ErrBadRequest, msg, err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(j)
+}
+
+// RevisionsRestoreHandler re-applies an earlier revision of a job as a new update.  The optional body sets who
+// restored the revision, ie. {"modified_by": "someone"}, like the body of an update it's empty otherwise.  Like an update, the restore is conditional if the
+// request has an If-Match header.
+func (s *server) RevisionsRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	w = LogWriter{w}
+	vars := mux.Vars(r)
+	account := vars["account"]
+	group := vars["group"]
+	id := vars["id"]
+	rev := vars["rev"]
+
+	if _, ok := s.accounts[account]; !ok {
+		msg := fmt.Sprintf("account not found: %s", account)
+		handleError(w, apierror.New(apierror.ErrNotFound, msg, nil))
+		return
+	}
+
+	log.Infof("restoring revision %s of job %s for account %s, group %s", rev, id, account, group)
+
+	input := struct {
+		ModifiedBy string `json:"modified_by"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil && err != io.EOF {
+		msg := fmt.Sprintf("cannot decode body into restore revision input: %s", err)
+		handleError(w, apierror.New(apierror.ErrBadRequest, msg, err))
+		return
+	}
+
+	revision, err := s.revision(r, account, group, id, rev)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	current, err := s.jobsRepository.Get(r.Context(), account, group, id)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	restored := *revision.Job
+	restored.ID = id
+	restored.Account = account
+	restored.Group = group
+	restored.Version = 0
+	// the restore is a new update, it isn't attributed to the author of the revision
+	restored.ModifiedBy = input.ModifiedBy
+
+	if match := r.Header.Get("If-Match"); match != "" {
+		if !etagMatches(match, current) {
+			msg := fmt.Sprintf("job %s was modified, it is at version %s", id, jobETag(current))
+			handleError(w, apierror.New(ErrPreconditionFailed, msg, nil))
+			return
+		}
+		restored.Version = current.Version
+	}
+
+	// the runner of the revision may no longer be configured or allowed for the account
+	if err := s.validateJob(account, &restored); err != nil {
+		handleError(w, err)
+		return
+	}
+
+	job, err := s.jobsRepository.Update(r.Context(), account, group, id, &restored)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	// update the cached job so the restored revision is scheduled right away
+	s.cacheJob(account, group, job)
+
+	next, err := nextRun(job, s.accounts[account], time.Now())
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	out := JobsResponse{
+		Job:  job,
+		Next: next,
+	}
+
+	j, err := json.Marshal(&out)
+	if err != nil {
+		msg := fmt.Sprintf("cannot encode job into json: %s", err)
+		handleError(w, apierror.New(apierror.ErrBadRequest, msg, err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", jobETag(job))
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(j)
+}
+
+// revisions returns the revision history of a job with the changes from the revision before each revision
+func (s *server) revisions(r *http.Request, account, group, id string) ([]*jobs.Revision, error) {
+	rl, ok := s.jobsRepository.(jobs.RevisionLister)
+	if !ok {
+		return nil, apierror.New(apierror.ErrBadRequest, "the jobs repository doesn't keep the revision history of jobs", nil)
+	}
+
+	revisions, err := rl.Revisions(r.Context(), account, group, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(revisions) == 0 {
+		return nil, apierror.New(apierror.ErrNotFound, "no revisions found for job "+id, nil)
+	}
+
+	for i, rev := range revisions {
+		var previous *jobs.Job
+		if i+1 < len(revisions) {
+			previous = revisions[i+1].Job
+		}
+		rev.Diff = jobs.Diff(previous, rev.Job)
+	}
+
+	return revisions, nil
+}
+
+// revision returns a revision of a job with the changes from the revision before it.  The revision is fetched on
+// its own if the jobs repository supports it, otherwise it's found in the revision history.
+func (s *server) revision(r *http.Request, account, group, id, rev string) (*jobs.Revision, error) {
+	if rg, ok := s.jobsRepository.(jobs.RevisionGetter); ok {
+		revision, previous, err := rg.Revision(r.Context(), account, group, id, rev)
+		if err != nil {
+			return nil, err
+		}
+
+		var from *jobs.Job
+		if previous != nil {
+			from = previous.Job
+		}
+		revision.Diff = jobs.Diff(from, revision.Job)
+
+		return revision, nil
+	}
+
+	revisions, err := s.revisions(r, account, group, id)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, revision := range revisions {
+		if revision.ID == rev {
+			return revision, nil
+		}
+	}
+
+	return nil, apierror.New(apierror.ErrNotFound, fmt.Sprintf("revision %s of job %s not found", rev, id), nil)
+}
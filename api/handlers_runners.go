@@ -0,0 +1,40 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/YaleSpinup/minion/jobs"
+	log "github.com/sirupsen/logrus"
+)
+
+// RunnersListHandler lists the configured job runners and the job details they accept
+func (s *server) RunnersListHandler(w http.ResponseWriter, r *http.Request) {
+	w = LogWriter{w}
+
+	log.Info("listing job runners")
+
+	list := make([]RunnerResponse, 0, len(s.jobRunners))
+	for name, runner := range s.jobRunners {
+		list = append(list, RunnerResponse{
+			Name:              name,
+			RunnerDescription: jobs.Describe(s.jobRunnerTypes[name], runner),
+		})
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	j, err := json.Marshal(&list)
+	if err != nil {
+		msg := fmt.Sprintf("cannot encode runner listing into json: %s", err)
+		handleError(w, apierror.New(apierror.ErrBadRequest, msg, err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(j)
+}
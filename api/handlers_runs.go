@@ -0,0 +1,124 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/YaleSpinup/minion/jobs"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// RunsListHandler lists the run history of a job, most recent first.  The optional
+// limit query parameter limits the number of runs returned.
+func (s *server) RunsListHandler(w http.ResponseWriter, r *http.Request) {
+	w = LogWriter{w}
+	vars := mux.Vars(r)
+	account := vars["account"]
+	group := vars["group"]
+	id := vars["id"]
+
+	if _, ok := s.accounts[account]; !ok {
+		msg := fmt.Sprintf("account not found: %s", account)
+		handleError(w, apierror.New(apierror.ErrNotFound, msg, nil))
+		return
+	}
+
+	if s.runsRepository == nil {
+		handleError(w, apierror.New(apierror.ErrNotFound, "run history is not configured", nil))
+		return
+	}
+
+	limit := 0
+	if l := r.URL.Query().Get("limit"); l != "" {
+		i, err := strconv.Atoi(l)
+		if err != nil || i < 0 {
+			msg := fmt.Sprintf("invalid limit: %s", l)
+			handleError(w, apierror.New(apierror.ErrBadRequest, msg, err))
+			return
+		}
+		limit = i
+	}
+
+	log.Infof("listing runs of job %s for account %s, group %s", id, account, group)
+
+	if _, err := s.jobsRepository.Get(r.Context(), account, group, id); err != nil {
+		handleError(w, err)
+		return
+	}
+
+	list, err := s.runsRepository.List(r.Context(), account, group, id, limit)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	j, err := json.Marshal(&list)
+	if err != nil {
+		msg := fmt.Sprintf("cannot encode run listing into json: %s", err)
+		handleError(w, apierror.New(apierror.ErrBadRequest, msg, err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(j)
+}
+
+// RunsShowHandler gets the details about an individual run of a job
+func (s *server) RunsShowHandler(w http.ResponseWriter, r *http.Request) {
+	w = LogWriter{w}
+	vars := mux.Vars(r)
+	account := vars["account"]
+	group := vars["group"]
+	id := vars["id"]
+	runID := vars["runId"]
+
+	if _, ok := s.accounts[account]; !ok {
+		msg := fmt.Sprintf("account not found: %s", account)
+		handleError(w, apierror.New(apierror.ErrNotFound, msg, nil))
+		return
+	}
+
+	if s.runsRepository == nil {
+		handleError(w, apierror.New(apierror.ErrNotFound, "run history is not configured", nil))
+		return
+	}
+
+	log.Infof("showing run %s of job %s for account %s, group %s", runID, id, account, group)
+
+	run, err := s.runsRepository.Get(r.Context(), account, group, id, runID)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	j, err := json.Marshal(run)
+	if err != nil {
+		msg := fmt.Sprintf("cannot encode run into json: %s", err)
+		handleError(w, apierror.New(apierror.ErrBadRequest, msg, err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(j)
+}
+
+// deleteRuns removes the run history of purged jobs.  failures are logged, the runs are left behind in the runs
+// repository.
+func deleteRuns(ctx context.Context, runsRepository jobs.RunsRepository, account, group string, ids ...string) {
+	if runsRepository == nil {
+		return
+	}
+
+	for _, id := range ids {
+		if err := runsRepository.Delete(ctx, account, group, id); err != nil {
+			log.Errorf("failed to delete the runs of purged job %s/%s/%s: %s", account, group, id, err)
+		}
+	}
+}
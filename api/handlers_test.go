@@ -1,9 +1,20 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/YaleSpinup/minion/common"
+	"github.com/YaleSpinup/minion/jobs"
+	"github.com/gorilla/mux"
 )
 
 func TestPingHandler(t *testing.T) {
@@ -57,3 +68,557 @@ func TestVersionHandler(t *testing.T) {
 			rr.Body.String(), expected)
 	}
 }
+
+func TestRunnersListHandler(t *testing.T) {
+	req, err := http.NewRequest("GET", "/v1/minion/runners", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	s := server{
+		jobRunners: map[string]jobs.Runner{
+			"instanceRunner": &jobs.InstanceRunner{},
+			"dummyRunner":    &jobs.DummyRunner{Template: "ok"},
+		},
+		jobRunnerTypes: map[string]string{
+			"instanceRunner": "instance",
+			"dummyRunner":    "dummy",
+		},
+	}
+	handler := http.HandlerFunc(s.RunnersListHandler)
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	expected := `[{"name":"dummyRunner","type":"dummy","description":"executes a template with the account name and returns the result"},` +
+		`{"name":"instanceRunner","type":"instance","description":"changes the power state of an instance or a batch of instances","actions":["reboot","stop","start"],"required_details":["instance_action","instance_id|instance_ids|instance_selector"]}]`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestValidateJob(t *testing.T) {
+	s := server{
+		accounts: map[string]common.Account{
+			"acct1": {Runners: []string{"dummy", "instance"}},
+			"acct2": {Runners: []string{"dummy"}},
+		},
+		jobRunners: map[string]jobs.Runner{
+			"dummy":    &jobs.DummyRunner{Template: "ok"},
+			"instance": &jobs.InstanceRunner{},
+		},
+	}
+
+	tests := []struct {
+		account string
+		details map[string]string
+		code    string
+		message string
+	}{
+		{
+			account: "acct1",
+			details: map[string]string{"runner": "dummy"},
+		},
+		{
+			account: "acct1",
+			details: map[string]string{"runner": "instance", "instance_id": "i-123", "instance_action": "stop"},
+		},
+		{
+			account: "acct1",
+			details: map[string]string{},
+			code:    apierror.ErrBadRequest,
+			message: "invalid job details: missing runner",
+		},
+		{
+			account: "acct1",
+			details: map[string]string{"runner": "foobar"},
+			code:    apierror.ErrBadRequest,
+			message: "invalid job details: runner 'foobar' is not configured",
+		},
+		{
+			account: "acct1",
+			details: map[string]string{"runner": "instance", "instance_action": "explode"},
+			code:    apierror.ErrBadRequest,
+			message: "invalid job details: missing instance_id, instance_ids or instance_selector, unexpected instance_action 'explode', must be one of 'reboot', 'stop', 'start'",
+		},
+		{
+			account: "acct2",
+			details: map[string]string{"runner": "instance", "instance_id": "i-123", "instance_action": "stop"},
+			code:    apierror.ErrForbidden,
+			message: "runner 'instance' is not allowed for account acct2",
+		},
+	}
+
+	for _, test := range tests {
+		err := s.validateJob(test.account, &jobs.Job{Details: test.details})
+		if test.message == "" {
+			if err != nil {
+				t.Errorf("expected nil error for %+v, got %s", test.details, err)
+			}
+			continue
+		}
+
+		aerr, ok := err.(apierror.Error)
+		if !ok {
+			t.Errorf("expected apierror.Error for %+v, got %v", test.details, err)
+			continue
+		}
+
+		if aerr.Code != test.code {
+			t.Errorf("expected code %s, got %s", test.code, aerr.Code)
+		}
+
+		if aerr.Message != test.message {
+			t.Errorf("expected message '%s', got '%s'", test.message, aerr.Message)
+		}
+	}
+}
+
+type mockVersionedRepository struct {
+	mockCacheRepository
+	deleted []string
+}
+
+func (m *mockVersionedRepository) Delete(ctx context.Context, account, group, id string) error {
+	m.deleted = append(m.deleted, account+"/"+group+"/"+id)
+	return nil
+}
+
+func (m *mockVersionedRepository) DeleteVersion(ctx context.Context, account, group, id string, version int64) error {
+	if j := m.jobs[account+"/"+group+"/"+id]; j.Version != version {
+		return apierror.New(apierror.ErrConflict, "stale version", nil)
+	}
+	m.deleted = append(m.deleted, fmt.Sprintf("%s/%s/%s@%d", account, group, id, version))
+	return nil
+}
+
+func TestEtagMatches(t *testing.T) {
+	job := &jobs.Job{Version: 3}
+
+	tests := []struct {
+		match string
+		want  bool
+	}{
+		{match: `"3"`, want: true},
+		{match: `*`, want: true},
+		{match: `"1", "3"`, want: true},
+		{match: `"2"`, want: false},
+		{match: `W/"3"`, want: false},
+		{match: `3`, want: false},
+	}
+
+	for _, tt := range tests {
+		if got := etagMatches(tt.match, job); got != tt.want {
+			t.Errorf("expected etagMatches(%s) to be %t, got %t", tt.match, tt.want, got)
+		}
+	}
+
+	if etag := jobETag(job); etag != `"3"` {
+		t.Errorf(`expected etag "3", got %s`, etag)
+	}
+}
+
+func TestJobsDeleteHandlerIfMatch(t *testing.T) {
+	tests := []struct {
+		path    string
+		match   string
+		status  int
+		deleted string
+	}{
+		{path: "/metal/jobs/metallica/job1", status: http.StatusAccepted, deleted: "metal/metallica/job1"},
+		{path: "/metal/jobs/metallica/job1", match: `"3"`, status: http.StatusAccepted, deleted: "metal/metallica/job1@3"},
+		{path: "/metal/jobs/metallica/job1", match: `*`, status: http.StatusAccepted, deleted: "metal/metallica/job1@3"},
+		{path: "/metal/jobs/metallica/job1", match: `"2"`, status: http.StatusPreconditionFailed},
+		{path: "/metal/jobs/metallica/job2", match: `"3"`, status: http.StatusNotFound},
+		{path: "/metal/jobs/metallica", match: `"3"`, status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		repo := &mockVersionedRepository{mockCacheRepository: mockCacheRepository{jobs: map[string]*jobs.Job{
+			"metal/metallica/job1": {ID: "job1", Version: 3},
+		}}}
+
+		s := &server{
+			accounts:       map[string]common.Account{"metal": {}},
+			jobsRepository: repo,
+		}
+
+		router := mux.NewRouter()
+		router.HandleFunc("/{account}/jobs/{group}", s.JobsDeleteHandler).Methods(http.MethodDelete)
+		router.HandleFunc("/{account}/jobs/{group}/{id}", s.JobsDeleteHandler).Methods(http.MethodDelete)
+
+		req := httptest.NewRequest(http.MethodDelete, tt.path, nil)
+		if tt.match != "" {
+			req.Header.Set("If-Match", tt.match)
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != tt.status {
+			t.Errorf("expected status %d deleting %s with If-Match '%s', got %d", tt.status, tt.path, tt.match, rr.Code)
+		}
+
+		if tt.deleted == "" && len(repo.deleted) != 0 {
+			t.Errorf("expected nothing to be deleted, got %v", repo.deleted)
+		} else if tt.deleted != "" && (len(repo.deleted) != 1 || repo.deleted[0] != tt.deleted) {
+			t.Errorf("expected %s to be deleted, got %v", tt.deleted, repo.deleted)
+		}
+	}
+}
+
+type mockRevisionsRepository struct {
+	mockCacheRepository
+	revisions []*jobs.Revision
+	updated   []*jobs.Job
+	listed    bool
+}
+
+func (m *mockRevisionsRepository) Revisions(ctx context.Context, account, group, id string) ([]*jobs.Revision, error) {
+	m.listed = true
+	if _, ok := m.jobs[account+"/"+group+"/"+id]; !ok {
+		return []*jobs.Revision{}, nil
+	}
+	return m.revisions, nil
+}
+
+func (m *mockRevisionsRepository) Update(ctx context.Context, account, group, id string, job *jobs.Job) (*jobs.Job, error) {
+	current := m.jobs[account+"/"+group+"/"+id]
+	if job.Version != 0 && job.Version != current.Version {
+		return nil, apierror.New(apierror.ErrConflict, "stale version", nil)
+	}
+
+	updated := *job
+	updated.Version = current.Version + 1
+	m.updated = append(m.updated, &updated)
+	return &updated, nil
+}
+
+// mockRevisionGetterRepository also gets a single revision without listing the revision history
+type mockRevisionGetterRepository struct {
+	*mockRevisionsRepository
+}
+
+func (m *mockRevisionGetterRepository) Revision(ctx context.Context, account, group, id, rev string) (*jobs.Revision, *jobs.Revision, error) {
+	for i, r := range m.revisions {
+		if _, ok := m.jobs[account+"/"+group+"/"+id]; ok && r.ID == rev {
+			var previous *jobs.Revision
+			if i+1 < len(m.revisions) {
+				previous = m.revisions[i+1]
+			}
+			return r, previous, nil
+		}
+	}
+	return nil, nil, apierror.New(apierror.ErrNotFound, "not found", nil)
+}
+
+func TestRevisionsHandlers(t *testing.T) {
+	newServer := func(getter bool) (*server, *mockRevisionsRepository) {
+		repo := &mockRevisionsRepository{
+			mockCacheRepository: mockCacheRepository{jobs: map[string]*jobs.Job{
+				"metal/metallica/job1": {ID: "job1", Description: "second", Details: map[string]string{"runner": "dummy"}, ScheduleExpression: "00 00 25 07 *", Version: 2},
+			}},
+			revisions: []*jobs.Revision{
+				{ID: "2", Version: 2, Job: &jobs.Job{ID: "job1", Description: "second", Details: map[string]string{"runner": "dummy"}, ScheduleExpression: "00 00 25 07 *", Version: 2}},
+				{ID: "1", Version: 1, Job: &jobs.Job{ID: "job1", Description: "first", ModifiedBy: "mustaine", Details: map[string]string{"runner": "dummy"}, ScheduleExpression: "00 00 25 07 *", Version: 1}},
+			},
+		}
+
+		s := &server{
+			accounts:       map[string]common.Account{"metal": {Runners: []string{"dummy"}}},
+			jobRunners:     map[string]jobs.Runner{"dummy": &jobs.DummyRunner{Template: "ok"}},
+			jobsRepository: repo,
+		}
+
+		if getter {
+			s.jobsRepository = &mockRevisionGetterRepository{repo}
+		}
+
+		return s, repo
+	}
+
+	newRouter := func(s *server) *mux.Router {
+		router := mux.NewRouter()
+		router.HandleFunc("/{account}/jobs/{group}/{id}/revisions", s.RevisionsListHandler).Methods(http.MethodGet)
+		router.HandleFunc("/{account}/jobs/{group}/{id}/revisions/{rev}", s.RevisionsShowHandler).Methods(http.MethodGet)
+		router.HandleFunc("/{account}/jobs/{group}/{id}/revisions/{rev}/restore", s.RevisionsRestoreHandler).Methods(http.MethodPost)
+		return router
+	}
+
+	// list the revisions with the changes from the revision before
+	s, _ := newServer(false)
+	rr := httptest.NewRecorder()
+	newRouter(s).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metal/jobs/metallica/job1/revisions", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d listing revisions, got %d", http.StatusOK, rr.Code)
+	}
+
+	revisions := []*jobs.Revision{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &revisions); err != nil {
+		t.Fatalf("expected nil error decoding revisions, got %s", err)
+	}
+
+	if len(revisions) != 2 || len(revisions[0].Diff) != 1 || revisions[0].Diff[0].Field != "description" {
+		t.Errorf("expected 2 revisions with a description change in the most recent, got %+v", revisions)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		match  string
+		status int
+		want   string
+		by     string
+	}{
+		{method: http.MethodGet, path: "/metal/jobs/metallica/job1/revisions/1", status: http.StatusOK},
+		{method: http.MethodGet, path: "/metal/jobs/metallica/job1/revisions/3", status: http.StatusNotFound},
+		{method: http.MethodGet, path: "/metal/jobs/metallica/job2/revisions", status: http.StatusNotFound},
+		{method: http.MethodGet, path: "/thrash/jobs/metallica/job1/revisions", status: http.StatusNotFound},
+		{method: http.MethodPost, path: "/metal/jobs/metallica/job1/revisions/1/restore", status: http.StatusAccepted, want: "first"},
+		{method: http.MethodPost, path: "/metal/jobs/metallica/job1/revisions/1/restore", body: `{"modified_by": "hammett"}`, match: `"2"`, status: http.StatusAccepted, want: "first", by: "hammett"},
+		{method: http.MethodPost, path: "/metal/jobs/metallica/job1/revisions/1/restore", match: `"1"`, status: http.StatusPreconditionFailed},
+		{method: http.MethodPost, path: "/metal/jobs/metallica/job1/revisions/1/restore", body: `{`, status: http.StatusBadRequest},
+		{method: http.MethodPost, path: "/metal/jobs/metallica/job1/revisions/3/restore", status: http.StatusNotFound},
+	}
+
+	// the handlers either get the single revision or find it in the listed revisions
+	for _, getter := range []bool{false, true} {
+		for _, tt := range tests {
+			s, repo := newServer(getter)
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.match != "" {
+				req.Header.Set("If-Match", tt.match)
+			}
+
+			rr := httptest.NewRecorder()
+			newRouter(s).ServeHTTP(rr, req)
+
+			if rr.Code != tt.status {
+				t.Errorf("expected status %d for %s %s, got %d", tt.status, tt.method, tt.path, rr.Code)
+			}
+
+			if getter && repo.listed && strings.Contains(tt.path, "/revisions/") {
+				t.Errorf("expected the revision to be fetched without listing the revisions for %s %s", tt.method, tt.path)
+			}
+
+			if tt.want == "" {
+				if len(repo.updated) != 0 {
+					t.Errorf("expected no update for %s %s, got %+v", tt.method, tt.path, repo.updated)
+				}
+				continue
+			}
+
+			if len(repo.updated) != 1 || repo.updated[0].Description != tt.want || repo.updated[0].Version != 3 {
+				t.Errorf("expected the restored revision '%s' at version 3, got %+v", tt.want, repo.updated)
+			}
+
+			if len(repo.updated) == 1 && repo.updated[0].ModifiedBy != tt.by {
+				t.Errorf("expected the restored revision modified by '%s', got '%s'", tt.by, repo.updated[0].ModifiedBy)
+			}
+
+			if etag := rr.Header().Get("ETag"); etag != `"3"` {
+				t.Errorf(`expected etag "3" for the restored revision, got %s`, etag)
+			}
+		}
+	}
+}
+
+type mockTrashRepository struct {
+	mockCacheRepository
+	trash   map[string]*jobs.TrashedJob
+	trashed []string
+	purged  []string
+}
+
+func (m *mockTrashRepository) Trash(ctx context.Context, account, group, id string, version int64) error {
+	m.trashed = append(m.trashed, fmt.Sprintf("%s/%s/%s@%d", account, group, id, version))
+	return nil
+}
+
+func (m *mockTrashRepository) ListTrash(ctx context.Context, account, group string) ([]*jobs.TrashedJob, error) {
+	trashed := []*jobs.TrashedJob{}
+	for _, t := range m.trash {
+		if group == "" || t.Group == group {
+			trashed = append(trashed, t)
+		}
+	}
+	return trashed, nil
+}
+
+func (m *mockTrashRepository) RestoreTrash(ctx context.Context, account, group, id string) ([]*jobs.Job, error) {
+	restored := []*jobs.Job{}
+	for _, t := range m.trash {
+		if t.Group == group && (id == "" || t.Job.ID == id) {
+			restored = append(restored, t.Job)
+		}
+	}
+
+	if id != "" && len(restored) == 0 {
+		return nil, apierror.New(apierror.ErrNotFound, "not found", nil)
+	}
+	return restored, nil
+}
+
+func (m *mockTrashRepository) PurgeTrash(ctx context.Context, account, group, id string) error {
+	m.purged = append(m.purged, account+"/"+group+"/"+id)
+	return nil
+}
+
+func TestTrashHandlers(t *testing.T) {
+	deletedAt := time.Date(2020, 2, 28, 16, 22, 9, 0, time.UTC)
+
+	newServer := func() (*server, *mockTrashRepository) {
+		repo := &mockTrashRepository{
+			mockCacheRepository: mockCacheRepository{jobs: map[string]*jobs.Job{
+				"metal/metallica/job1": {ID: "job1", Enabled: true, ScheduleExpression: "00 00 25 07 *", Version: 3},
+			}},
+			trash: map[string]*jobs.TrashedJob{
+				"job2": {Group: "megadeth", DeletedAt: deletedAt, Job: &jobs.Job{ID: "job2", Details: map[string]string{"runner": "dummy"}, Enabled: true, ScheduleExpression: "00 00 25 07 *", Version: 2}},
+			},
+		}
+
+		s := &server{
+			accounts:       map[string]common.Account{"metal": {Runners: []string{"dummy"}}},
+			jobsCache:      &jobsCache{Cache: map[string]*jobs.Job{"metallica/job1": {ID: "job1", Account: "metal"}}},
+			jobsRepository: repo,
+			jobRunners:     map[string]jobs.Runner{"dummy": &jobs.DummyRunner{Template: "ok"}},
+			trashRetention: 24 * time.Hour,
+		}
+
+		return s, repo
+	}
+
+	newRouter := func(s *server) *mux.Router {
+		router := mux.NewRouter()
+		router.HandleFunc("/{account}/jobs/{group}", s.JobsDeleteHandler).Methods(http.MethodDelete)
+		router.HandleFunc("/{account}/jobs/{group}/{id}", s.JobsDeleteHandler).Methods(http.MethodDelete)
+		router.HandleFunc("/{account}/trash", s.TrashListHandler).Methods(http.MethodGet)
+		router.HandleFunc("/{account}/trash/{group}", s.TrashListHandler).Methods(http.MethodGet)
+		router.HandleFunc("/{account}/trash/{group}/restore", s.TrashRestoreHandler).Methods(http.MethodPost)
+		router.HandleFunc("/{account}/trash/{group}/{id}/restore", s.TrashRestoreHandler).Methods(http.MethodPost)
+		router.HandleFunc("/{account}/trash/{group}", s.TrashPurgeHandler).Methods(http.MethodDelete)
+		router.HandleFunc("/{account}/trash/{group}/{id}", s.TrashPurgeHandler).Methods(http.MethodDelete)
+		return router
+	}
+
+	serve := func(s *server, method, path, match string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if match != "" {
+			req.Header.Set("If-Match", match)
+		}
+
+		rr := httptest.NewRecorder()
+		newRouter(s).ServeHTTP(rr, req)
+		return rr
+	}
+
+	// deleting moves the job to the trash, at the version of the If-Match header
+	s, repo := newServer()
+	if rr := serve(s, http.MethodDelete, "/metal/jobs/metallica/job1", `"3"`); rr.Code != http.StatusAccepted {
+		t.Errorf("expected status %d deleting job, got %d", http.StatusAccepted, rr.Code)
+	}
+
+	if rr := serve(s, http.MethodDelete, "/metal/jobs/metallica", ""); rr.Code != http.StatusAccepted {
+		t.Errorf("expected status %d deleting group, got %d", http.StatusAccepted, rr.Code)
+	}
+
+	if expected := []string{"metal/metallica/job1@3", "metal/metallica/@0"}; !reflect.DeepEqual(repo.trashed, expected) {
+		t.Errorf("expected trashed jobs %v, got %v", expected, repo.trashed)
+	}
+
+	if _, ok := s.jobsCache.Cache["metallica/job1"]; ok {
+		t.Error("expected trashed job to be removed from the cache")
+	}
+
+	// list the trash with the time the jobs are purged
+	rr := serve(s, http.MethodGet, "/metal/trash", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d listing the trash, got %d", http.StatusOK, rr.Code)
+	}
+
+	trashed := []struct {
+		Group     string    `json:"group"`
+		DeletedAt time.Time `json:"deleted_at"`
+		Expires   string    `json:"expires"`
+		Job       *jobs.Job `json:"job"`
+	}{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &trashed); err != nil {
+		t.Fatalf("expected nil error decoding the trash, got %s", err)
+	}
+
+	if len(trashed) != 1 || trashed[0].Group != "megadeth" || !trashed[0].DeletedAt.Equal(deletedAt) || trashed[0].Expires != "2020-02-29T16:22:09Z" || trashed[0].Job.ID != "job2" {
+		t.Errorf("expected job2 in the trash expiring a day after it was deleted, got %s", rr.Body.String())
+	}
+
+	// restore caches the restored jobs so they are scheduled
+	for _, path := range []string{"/metal/trash/megadeth/job2/restore", "/metal/trash/megadeth/restore"} {
+		s, _ := newServer()
+		if rr := serve(s, http.MethodPost, path, ""); rr.Code != http.StatusAccepted {
+			t.Errorf("expected status %d restoring %s, got %d", http.StatusAccepted, path, rr.Code)
+		}
+
+		if job, ok := s.jobsCache.Cache["megadeth/job2"]; !ok || job.Version != 2 {
+			t.Errorf("expected restored job to be cached for %s, got %+v", path, s.jobsCache.Cache)
+		}
+	}
+
+	// jobs with a runner that's no longer allowed for the account aren't restored
+	for _, path := range []string{"/metal/trash/megadeth/job2/restore", "/metal/trash/megadeth/restore"} {
+		s, _ := newServer()
+		s.accounts["metal"] = common.Account{}
+		if rr := serve(s, http.MethodPost, path, ""); rr.Code != http.StatusForbidden {
+			t.Errorf("expected status %d restoring %s with a runner that isn't allowed, got %d", http.StatusForbidden, path, rr.Code)
+		}
+
+		if _, ok := s.jobsCache.Cache["megadeth/job2"]; ok {
+			t.Errorf("expected invalid job not to be restored for %s", path)
+		}
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{method: http.MethodPost, path: "/metal/trash/megadeth/job3/restore", status: http.StatusNotFound},
+		{method: http.MethodPost, path: "/metal/trash/metallica/restore", status: http.StatusNotFound},
+		{method: http.MethodGet, path: "/thrash/trash", status: http.StatusNotFound},
+		{method: http.MethodDelete, path: "/metal/trash/megadeth/job2", status: http.StatusAccepted},
+		{method: http.MethodDelete, path: "/metal/trash/megadeth", status: http.StatusAccepted},
+	}
+
+	for _, tt := range tests {
+		s, _ := newServer()
+		if rr := serve(s, tt.method, tt.path, ""); rr.Code != tt.status {
+			t.Errorf("expected status %d for %s %s, got %d", tt.status, tt.method, tt.path, rr.Code)
+		}
+	}
+
+	s, repo = newServer()
+	runs := &mockRunsRepository{t: t}
+	s.runsRepository = runs
+	serve(s, http.MethodDelete, "/metal/trash/megadeth/job2", "")
+	serve(s, http.MethodDelete, "/metal/trash/megadeth", "")
+	serve(s, http.MethodDelete, "/metal/trash/megadeth/job3", "")
+	if expected := []string{"metal/megadeth/job2", "metal/megadeth/", "metal/megadeth/job3"}; !reflect.DeepEqual(repo.purged, expected) {
+		t.Errorf("expected purged jobs %v, got %v", expected, repo.purged)
+	}
+
+	// the runs of the purged jobs are deleted
+	if expected := []string{"metal/megadeth/job2", "metal/megadeth/job2"}; !reflect.DeepEqual(runs.deleted, expected) {
+		t.Errorf("expected the runs of the purged jobs %v to be deleted, got %v", expected, runs.deleted)
+	}
+
+	// repositories without a trash
+	s.jobsRepository = &mockCacheRepository{}
+	if rr := serve(s, http.MethodGet, "/metal/trash", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d listing the trash of a repository without one, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/YaleSpinup/minion/jobs"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// TrashListHandler lists the deleted jobs in the trash of an account, or of a group in the account, with the
+// time they expire and are purged
+func (s *server) TrashListHandler(w http.ResponseWriter, r *http.Request) {
+	w = LogWriter{w}
+	vars := mux.Vars(r)
+	account := vars["account"]
+	group := vars["group"]
+
+	if _, ok := s.accounts[account]; !ok {
+		msg := fmt.Sprintf("account not found: %s", account)
+		handleError(w, apierror.New(apierror.ErrNotFound, msg, nil))
+		return
+	}
+
+	log.Infof("listing trashed jobs for account %s, group %s", account, group)
+
+	t, err := s.trasher()
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	trashed, err := t.ListTrash(r.Context(), account, group)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	out := make([]TrashResponse, len(trashed))
+	for i, tj := range trashed {
+		out[i] = TrashResponse{
+			TrashedJob: tj,
+			Expires:    tj.DeletedAt.Add(s.trashRetention).Format(time.RFC3339),
+		}
+	}
+
+	j, err := json.Marshal(&out)
+	if err != nil {
+		msg := fmt.Sprintf("cannot encode trash listing into json: %s", err)
+		handleError(w, apierror.New(apierror.ErrBadRequest, msg, err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(j)
+}
+
+// TrashRestoreHandler restores a deleted job, or all of the deleted jobs in a group, from the trash.  The jobs are
+// validated like new jobs before they are restored, ie. the runner of a job may have been removed from the account
+// since it was deleted, and the restored jobs are scheduled again right away.
+func (s *server) TrashRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	w = LogWriter{w}
+	vars := mux.Vars(r)
+	account := vars["account"]
+	group := vars["group"]
+	id := vars["id"]
+
+	if _, ok := s.accounts[account]; !ok {
+		msg := fmt.Sprintf("account not found: %s", account)
+		handleError(w, apierror.New(apierror.ErrNotFound, msg, nil))
+		return
+	}
+
+	log.Infof("restoring trashed job %s/%s/%s", account, group, id)
+
+	t, err := s.trasher()
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	trashed, err := t.ListTrash(r.Context(), account, group)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	for _, tj := range trashed {
+		if tj.Job == nil || tj.Group != group || (id != "" && tj.Job.ID != id) {
+			continue
+		}
+
+		if err := s.validateJob(account, tj.Job); err != nil {
+			log.Warnf("not restoring invalid job %s/%s/%s from the trash: %s", account, group, tj.Job.ID, err)
+			handleError(w, err)
+			return
+		}
+	}
+
+	restored, err := t.RestoreTrash(r.Context(), account, group, id)
+
+	// a group is restored job by job, cache the jobs restored before a failure
+	for _, job := range restored {
+		s.cacheJob(account, group, job)
+	}
+
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	if len(restored) == 0 {
+		handleError(w, apierror.New(apierror.ErrNotFound, "no trashed jobs found in group "+group, nil))
+		return
+	}
+
+	out := make([]JobsResponse, len(restored))
+	for i, job := range restored {
+		next, err := nextRun(job, s.accounts[account], time.Now())
+		if err != nil {
+			handleError(w, err)
+			return
+		}
+
+		out[i] = JobsResponse{
+			Job:  job,
+			Next: next,
+		}
+	}
+
+	j, err := json.Marshal(&out)
+	if err != nil {
+		msg := fmt.Sprintf("cannot encode restored jobs into json: %s", err)
+		handleError(w, apierror.New(apierror.ErrBadRequest, msg, err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(j)
+}
+
+// TrashPurgeHandler permanently removes a deleted job, or all of the deleted jobs in a group, from the trash along
+// with their run history
+func (s *server) TrashPurgeHandler(w http.ResponseWriter, r *http.Request) {
+	w = LogWriter{w}
+	vars := mux.Vars(r)
+	account := vars["account"]
+	group := vars["group"]
+	id := vars["id"]
+
+	if _, ok := s.accounts[account]; !ok {
+		msg := fmt.Sprintf("account not found: %s", account)
+		handleError(w, apierror.New(apierror.ErrNotFound, msg, nil))
+		return
+	}
+
+	log.Infof("purging trashed job %s/%s/%s", account, group, id)
+
+	t, err := s.trasher()
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	// the ids of the purged jobs are needed to delete their runs
+	trashed, err := t.ListTrash(r.Context(), account, group)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	if err := t.PurgeTrash(r.Context(), account, group, id); err != nil {
+		handleError(w, err)
+		return
+	}
+
+	for _, tj := range trashed {
+		if tj.Job != nil && tj.Group == group && (id == "" || tj.Job.ID == id) {
+			deleteRuns(r.Context(), s.runsRepository, account, group, tj.Job.ID)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("OK"))
+}
+
+// trasher returns the trash of the jobs repository
+func (s *server) trasher() (jobs.Trasher, error) {
+	t, ok := s.jobsRepository.(jobs.Trasher)
+	if !ok {
+		return nil, apierror.New(apierror.ErrBadRequest, "the jobs repository doesn't keep deleted jobs in a trash", nil)
+	}
+
+	return t, nil
+}
@@ -53,32 +53,17 @@ func (l *loader) run(ctx context.Context) error {
 	defer l.jobsCache.Mux.Unlock()
 
 	cache := make(map[string]*jobs.Job)
-	for name := range l.accounts {
-		jobs, err := l.jobsRepository.List(ctx, name, "")
+	for name, account := range l.accounts {
+		loaded, err := l.load(ctx, name)
 		if err != nil {
 			return err
 		}
 
-		log.Debugf("list of jobs: %+v", jobs)
-
-		for _, j := range jobs {
-			var id, group string
-			if split := strings.SplitN(j, "/", 2); len(split) == 1 {
-				id = split[0]
-			} else {
-				group = split[0]
-				id = split[1]
-			}
-
-			job, err := l.jobsRepository.Get(ctx, name, group, id)
-			if err != nil {
-				log.Errorf("error getting details about job '%s': %s", j, err)
-				continue
-			}
-
-			if !job.Enabled {
-				log.Infof("job '%s' is disabled, not caching", id)
-				continue
+		for j, job := range loaded {
+			// the cache keys don't include the account, the cached job carries it
+			job.Account = name
+			if job.Timezone == "" {
+				job.Timezone = account.Timezone
 			}
 
 			log.Debugf("caching job id %s with details: %+v", j, job)
@@ -97,3 +82,45 @@ func (l *loader) run(ctx context.Context) error {
 
 	return nil
 }
+
+// load returns the enabled jobs of the account keyed by <group>/<id>.  Repositories implementing
+// jobs.BulkLister return them in a single call, otherwise each listed job is fetched separately.  Jobs in
+// the trash aren't listed by the repositories, so deleted jobs are never loaded.
+func (l *loader) load(ctx context.Context, account string) (map[string]*jobs.Job, error) {
+	if bl, ok := l.jobsRepository.(jobs.BulkLister); ok {
+		return bl.ListJobs(ctx, account, true)
+	}
+
+	list, err := l.jobsRepository.List(ctx, account, "")
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugf("list of jobs: %+v", list)
+
+	out := make(map[string]*jobs.Job)
+	for _, j := range list {
+		var id, group string
+		if split := strings.SplitN(j, "/", 2); len(split) == 1 {
+			id = split[0]
+		} else {
+			group = split[0]
+			id = split[1]
+		}
+
+		job, err := l.jobsRepository.Get(ctx, account, group, id)
+		if err != nil {
+			log.Errorf("error getting details about job '%s': %s", j, err)
+			continue
+		}
+
+		if !job.Enabled {
+			log.Infof("job '%s' is disabled, not caching", id)
+			continue
+		}
+
+		out[j] = job
+	}
+
+	return out, nil
+}
@@ -1,7 +1,123 @@
 package api
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/YaleSpinup/minion/common"
+	"github.com/YaleSpinup/minion/jobs"
+)
+
+type mockLoaderRepository struct {
+	mockCacheRepository
+	gets int
+}
+
+func (m *mockLoaderRepository) Get(ctx context.Context, account, group, id string) (*jobs.Job, error) {
+	m.gets++
+	return m.mockCacheRepository.Get(ctx, account, group, id)
+}
+
+func (m *mockLoaderRepository) List(ctx context.Context, account, group string) ([]string, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	list := []string{}
+	for k := range m.jobs {
+		if strings.HasPrefix(k, account+"/") {
+			list = append(list, strings.TrimPrefix(k, account+"/"))
+		}
+	}
+	return list, nil
+}
+
+type mockBulkLoaderRepository struct {
+	mockLoaderRepository
+}
+
+func (m *mockBulkLoaderRepository) ListJobs(ctx context.Context, account string, enabled bool) (map[string]*jobs.Job, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	out := make(map[string]*jobs.Job)
+	for k, j := range m.jobs {
+		if strings.HasPrefix(k, account+"/") && (j.Enabled || !enabled) {
+			out[strings.TrimPrefix(k, account+"/")] = j
+		}
+	}
+	return out, nil
+}
+
+func loaderTestJobs() map[string]*jobs.Job {
+	return map[string]*jobs.Job{
+		"metal/metallica/job1": {ID: "job1", Enabled: true},
+		"metal/metallica/job2": {ID: "job2", Enabled: false},
+		"metal/megadeth/job3":  {ID: "job3", Enabled: true, Timezone: "UTC"},
+		"punk/ramones/job4":    {ID: "job4", Enabled: true},
+	}
+}
 
 func TestLoaderRun(t *testing.T) {
-	t.Log("todo")
+	accounts := map[string]common.Account{
+		"metal": {Timezone: "America/New_York"},
+		"punk":  {Timezone: "Europe/London"},
+	}
+
+	list := &mockLoaderRepository{mockCacheRepository: mockCacheRepository{jobs: loaderTestJobs()}}
+	bulk := &mockBulkLoaderRepository{mockLoaderRepository: mockLoaderRepository{mockCacheRepository: mockCacheRepository{jobs: loaderTestJobs()}}}
+
+	tests := []struct {
+		name string
+		repo jobs.Repository
+		gets func() int
+		want int
+	}{
+		{name: "list and get", repo: list, gets: func() int { return list.gets }, want: 4},
+		{name: "bulk list", repo: bulk, gets: func() int { return bulk.gets }, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &jobsCache{Cache: map[string]*jobs.Job{"metal/metallica/stale": {ID: "stale"}}}
+			l := &loader{id: "test", accounts: accounts, jobsCache: c, jobsRepository: tt.repo}
+
+			if err := l.run(context.TODO()); err != nil {
+				t.Fatalf("expected nil error, got %s", err)
+			}
+
+			keys := cachedKeys(c)
+			expected := []string{"megadeth/job3", "metallica/job1", "ramones/job4"}
+			if !reflect.DeepEqual(keys, expected) {
+				t.Errorf("expected cached jobs %v, got %v", expected, keys)
+			}
+
+			if tz := c.Cache["metallica/job1"].Timezone; tz != "America/New_York" {
+				t.Errorf("expected account timezone America/New_York, got '%s'", tz)
+			}
+
+			if tz := c.Cache["megadeth/job3"].Timezone; tz != "UTC" {
+				t.Errorf("expected job timezone UTC to be kept, got '%s'", tz)
+			}
+
+			if gets := tt.gets(); gets != tt.want {
+				t.Errorf("expected %d gets, got %d", tt.want, gets)
+			}
+		})
+	}
+
+	l := &loader{
+		id:             "test",
+		accounts:       accounts,
+		jobsCache:      &jobsCache{Cache: map[string]*jobs.Job{}},
+		jobsRepository: &mockBulkLoaderRepository{mockLoaderRepository: mockLoaderRepository{mockCacheRepository: mockCacheRepository{err: errors.New("boom")}}},
+	}
+
+	if err := l.run(context.TODO()); err == nil {
+		t.Error("expected error from the repository, got nil")
+	}
 }
@@ -1,15 +1,105 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/YaleSpinup/minion/common"
+	"github.com/YaleSpinup/minion/jobs"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// TokenMiddleware checks the tokens for non-public URLs
-func TokenMiddleware(psk []byte, public map[string]string, h http.Handler) http.Handler {
+// DefaultSignatureMaxSkew is the default maximum distance of the timestamp of a signed request from the server time
+const DefaultSignatureMaxSkew = 5 * time.Minute
+
+// SignatureVerifier verifies HMAC signed requests for the token middleware.  The nonce of each signed request is
+// remembered until its timestamp expires, so a signed request can't be replayed.  If Nonces is set, the nonces are
+// locked in it so they're shared by all of the nodes, otherwise they're only remembered by this node.
+type SignatureVerifier struct {
+	MaxSkew  time.Duration
+	Nonces   jobs.Locker
+	Required bool
+
+	mux  sync.Mutex
+	seen map[string]time.Time
+}
+
+// NewSignatureVerifier returns a signature verifier from the signing configuration, or nil if signing isn't configured
+func NewSignatureVerifier(config *common.Signing) (*SignatureVerifier, error) {
+	if config == nil {
+		return nil, nil
+	}
+
+	v := &SignatureVerifier{
+		MaxSkew:  DefaultSignatureMaxSkew,
+		Required: config.Required,
+		seen:     make(map[string]time.Time),
+	}
+
+	if config.MaxSkew != "" {
+		d, err := time.ParseDuration(config.MaxSkew)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid signing maxSkew '%s'", config.MaxSkew)
+		}
+		v.MaxSkew = d
+	}
+
+	return v, nil
+}
+
+// Verify checks the signature of the request with the pre-shared key and rejects nonces that were already used
+func (v *SignatureVerifier) Verify(psk []byte, r *http.Request) error {
+	if err := jobs.VerifyRequestSignature(psk, r, v.MaxSkew); err != nil {
+		return err
+	}
+
+	// the timestamp was checked with the signature
+	ts, _ := strconv.ParseInt(r.Header.Get(jobs.SignatureTimestampHeader), 10, 64)
+	nonce := r.Header.Get(jobs.SignatureNonceHeader)
+
+	if v.Nonces != nil {
+		if err := v.Nonces.Lock("nonce-"+nonce, strconv.FormatInt(ts, 10)); err != nil {
+			return fmt.Errorf("nonce %s was already used or couldn't be checked: %s", nonce, err)
+		}
+		return nil
+	}
+
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	now := time.Now()
+	for n, expires := range v.seen {
+		if now.After(expires) {
+			delete(v.seen, n)
+		}
+	}
+
+	if _, ok := v.seen[nonce]; ok {
+		return fmt.Errorf("nonce %s was already used", nonce)
+	}
+
+	if v.seen == nil {
+		v.seen = make(map[string]time.Time)
+	}
+	v.seen[nonce] = time.Unix(ts, 0).Add(v.MaxSkew)
+
+	return nil
+}
+
+// TokenMiddleware checks the tokens for non-public URLs.  If a signature verifier is passed, signed requests are
+// accepted and, if signatures are required, requests with the hashed token are rejected.
+func TokenMiddleware(psk []byte, public map[string]string, signatures *SignatureVerifier, h http.Handler) http.Handler {
+	allowHeaders := "X-Auth-Token, If-Match"
+	if signatures != nil {
+		allowHeaders = strings.Join([]string{allowHeaders, jobs.SignatureHeader, jobs.SignatureTimestampHeader, jobs.SignatureNonceHeader}, ", ")
+	}
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log.Debug("Processing token middleware for protected URLs")
 
@@ -17,7 +107,7 @@ func TokenMiddleware(psk []byte, public map[string]string, h http.Handler) http.
 		if r.Method == "OPTIONS" {
 			log.Info("Setting CORS preflight options and returning")
 			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Headers", "X-Auth-Token")
+			w.Header().Set("Access-Control-Allow-Headers", allowHeaders)
 			w.WriteHeader(http.StatusOK)
 			w.Write([]byte{})
 			return
@@ -32,6 +122,20 @@ func TokenMiddleware(psk []byte, public map[string]string, h http.Handler) http.
 
 		if _, ok := public[uri.Path]; ok {
 			log.Debugf("Not authenticating for '%s'", uri.Path)
+		} else if signatures != nil && r.Header.Get(jobs.SignatureHeader) != "" {
+			log.Debugf("Authenticating signature for protected URL '%s'", r.URL)
+
+			if err := signatures.Verify(psk, r); err != nil {
+				log.Warnf("Unable to authenticate signed request for '%s': %s", r.URL, err)
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			log.Infof("Successfully authenticated signature for URL '%s'", r.URL)
+		} else if signatures != nil && signatures.Required {
+			log.Warnf("Unable to authenticate session for '%s', a signature is required", r.URL)
+			w.WriteHeader(http.StatusForbidden)
+			return
 		} else {
 			log.Debugf("Authenticating token for protected URL '%s'", r.URL)
 
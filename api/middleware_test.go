@@ -1,11 +1,17 @@
 package api
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 
+	"github.com/YaleSpinup/minion/common"
+	"github.com/YaleSpinup/minion/jobs"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -27,7 +33,7 @@ func TestTokenMiddleware(t *testing.T) {
 	}
 
 	// Start a new server with our token middleware and test handler
-	server := httptest.NewServer(TokenMiddleware(psk, pubUrls, okHandler))
+	server := httptest.NewServer(TokenMiddleware(psk, pubUrls, nil, okHandler))
 	defer server.Close()
 
 	// Test some public urls
@@ -83,7 +89,7 @@ func TestTokenMiddleware(t *testing.T) {
 
 	testHeaders := map[string]string{
 		"Access-Control-Allow-Origin":  "*",
-		"Access-Control-Allow-Headers": "X-Auth-Token",
+		"Access-Control-Allow-Headers": "X-Auth-Token, If-Match",
 	}
 
 	for k, v := range testHeaders {
@@ -110,7 +116,7 @@ func BenchmarkTokenMiddlewarePub(b *testing.B) {
 	}
 
 	// Start a new server with our token middleware and test handler
-	server := httptest.NewServer(TokenMiddleware(psk, pubUrls, okHandler))
+	server := httptest.NewServer(TokenMiddleware(psk, pubUrls, nil, okHandler))
 	defer server.Close()
 
 	for n := 0; n < b.N; n++ {
@@ -142,7 +148,7 @@ func BenchmarkTokenMiddlewarePrivMinCost(b *testing.B) {
 	}
 
 	// Start a new server with our token middleware and test handler
-	server := httptest.NewServer(TokenMiddleware(psk, pubUrls, okHandler))
+	server := httptest.NewServer(TokenMiddleware(psk, pubUrls, nil, okHandler))
 	defer server.Close()
 
 	for n := 0; n < b.N; n++ {
@@ -175,7 +181,7 @@ func BenchmarkTokenMiddlewarePrivDefCost(b *testing.B) {
 	}
 
 	// Start a new server with our token middleware and test handler
-	server := httptest.NewServer(TokenMiddleware(psk, pubUrls, okHandler))
+	server := httptest.NewServer(TokenMiddleware(psk, pubUrls, nil, okHandler))
 	defer server.Close()
 
 	for n := 0; n < b.N; n++ {
@@ -208,7 +214,7 @@ func BenchmarkTokenMiddlewarePrivCostSix(b *testing.B) {
 	}
 
 	// Start a new server with our token middleware and test handler
-	server := httptest.NewServer(TokenMiddleware(psk, pubUrls, okHandler))
+	server := httptest.NewServer(TokenMiddleware(psk, pubUrls, nil, okHandler))
 	defer server.Close()
 
 	for n := 0; n < b.N; n++ {
@@ -222,3 +228,187 @@ func BenchmarkTokenMiddlewarePrivCostSix(b *testing.B) {
 		}
 	}
 }
+
+func TestTokenMiddlewareSignatures(t *testing.T) {
+	psk := []byte("sometesttoken")
+	tokenHeader, _ := bcrypt.GenerateFromPassword(psk, bcrypt.MinCost)
+
+	okHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+	})
+
+	signatures, err := NewSignatureVerifier(&common.Signing{MaxSkew: "1m"})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	server := httptest.NewServer(TokenMiddleware(psk, map[string]string{}, signatures, okHandler))
+	defer server.Close()
+
+	signer := &jobs.Signer{Secret: psk}
+	client := &http.Client{}
+
+	signed := func() *http.Request {
+		req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/private?x=1", server.URL), bytes.NewReader([]byte(`{"foo":"bar"}`)))
+		if err := signer.Sign(req); err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+
+	// a signed request is accepted and the handler can read the body
+	req := signed()
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != `{"foo":"bar"}` {
+		t.Errorf("expected %d with the request body for a signed request, got %d '%s'", http.StatusOK, resp.StatusCode, body)
+	}
+
+	// replaying the same signed request is rejected
+	replay, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/private?x=1", server.URL), bytes.NewReader([]byte(`{"foo":"bar"}`)))
+	replay.Header = req.Header.Clone()
+	if resp, err = client.Do(replay); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected %d for a replayed request, got %d", http.StatusForbidden, resp.StatusCode)
+	}
+
+	// a signed request with the wrong secret is rejected
+	req, _ = http.NewRequest(http.MethodGet, fmt.Sprintf("%s/private", server.URL), nil)
+	(&jobs.Signer{Secret: []byte("wrong")}).Sign(req)
+	if resp, err = client.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected %d for a request signed with the wrong secret, got %d", http.StatusForbidden, resp.StatusCode)
+	}
+
+	// the hashed token is still accepted unless signatures are required
+	req, _ = http.NewRequest(http.MethodGet, fmt.Sprintf("%s/private", server.URL), nil)
+	req.Header.Add("X-Auth-Token", string(tokenHeader))
+	if resp, err = client.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected %d for a request with the token, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	signatures.Required = true
+	req, _ = http.NewRequest(http.MethodGet, fmt.Sprintf("%s/private", server.URL), nil)
+	req.Header.Add("X-Auth-Token", string(tokenHeader))
+	if resp, err = client.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected %d for a request with the token when signatures are required, got %d", http.StatusForbidden, resp.StatusCode)
+	}
+
+	if resp, err = client.Do(signed()); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected %d for a signed request when signatures are required, got %d", http.StatusOK, resp.StatusCode)
+	}
+}
+
+// mockNonceLocker is an in memory locker shared by signature verifiers
+type mockNonceLocker struct {
+	mux   sync.Mutex
+	locks map[string]string
+}
+
+func (m *mockNonceLocker) Lock(key, id string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if _, ok := m.locks[key]; ok {
+		return errors.New("didn't aquire lock")
+	}
+
+	if m.locks == nil {
+		m.locks = make(map[string]string)
+	}
+	m.locks[key] = id
+	return nil
+}
+
+func TestSignatureVerifierSharedNonces(t *testing.T) {
+	psk := []byte("sometesttoken")
+	nonces := &mockNonceLocker{}
+
+	node1, _ := NewSignatureVerifier(&common.Signing{})
+	node2, _ := NewSignatureVerifier(&common.Signing{})
+	node1.Nonces, node2.Nonces = nonces, nonces
+
+	req, _ := http.NewRequest(http.MethodGet, "http://minion/v1/minion/ping", nil)
+	if err := (&jobs.Signer{Secret: psk}).Sign(req); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := node1.Verify(psk, req); err != nil {
+		t.Fatalf("expected nil error verifying a signed request, got %s", err)
+	}
+
+	if err := node2.Verify(psk, req); err == nil {
+		t.Error("expected error replaying a signed request against another node, got nil")
+	}
+
+	if len(nonces.locks) != 1 {
+		t.Errorf("expected the nonce to be locked, got %+v", nonces.locks)
+	}
+}
+
+func TestNewSignatureVerifier(t *testing.T) {
+	if v, err := NewSignatureVerifier(nil); err != nil || v != nil {
+		t.Errorf("expected nil verifier without signing configuration, got %+v, %v", v, err)
+	}
+
+	if _, err := NewSignatureVerifier(&common.Signing{MaxSkew: "soon"}); err == nil {
+		t.Error("expected error for invalid maxSkew, got nil")
+	}
+
+	v, err := NewSignatureVerifier(&common.Signing{Required: true})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if v.MaxSkew != DefaultSignatureMaxSkew || !v.Required {
+		t.Errorf("unexpected signature verifier %+v", v)
+	}
+}
+
+func BenchmarkTokenMiddlewarePrivSigned(b *testing.B) {
+	log.SetLevel(log.ErrorLevel)
+
+	psk := []byte("0232ecdb-8ce2-4125-808b-8056b24d3a49")
+	signer := &jobs.Signer{Secret: psk}
+
+	// Test handler that just returns 200 OK
+	okHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	signatures, _ := NewSignatureVerifier(&common.Signing{})
+
+	// Start a new server with our token middleware and test handler
+	server := httptest.NewServer(TokenMiddleware(psk, map[string]string{}, signatures, okHandler))
+	defer server.Close()
+
+	for n := 0; n < b.N; n++ {
+		// Test a private URL with a signed request
+		client := &http.Client{}
+		req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/private", server.URL), nil)
+		signer.Sign(req)
+		_, err := client.Do(req)
+		if err != nil {
+			log.Errorf("unexpected error: %s", err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"sync"
+	"time"
+)
+
+// pool limits the number of jobs running at once in the executer.  The size is the total number of
+// jobs that can run at once, the runner and account limits are the number of jobs that can run at
+// once for a runner name or an account.  A missing or zero runner or account limit means the runner
+// or account is only limited by the size of the pool.
+type pool struct {
+	accountLimits map[string]int
+	accounts      map[string]int
+	mux           sync.Mutex
+	runnerLimits  map[string]int
+	runners       map[string]int
+	running       int
+	size          int
+	wg            sync.WaitGroup
+}
+
+// newPool returns a new pool with the given size and runner and account limits
+func newPool(size int, runnerLimits, accountLimits map[string]int) *pool {
+	if runnerLimits == nil {
+		runnerLimits = map[string]int{}
+	}
+
+	if accountLimits == nil {
+		accountLimits = map[string]int{}
+	}
+
+	return &pool{
+		accountLimits: accountLimits,
+		accounts:      make(map[string]int),
+		runnerLimits:  runnerLimits,
+		runners:       make(map[string]int),
+		size:          size,
+	}
+}
+
+// free returns true if the pool has room for another job
+func (p *pool) free() bool {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	return p.running < p.size
+}
+
+// acquire takes a slot in the pool for a job with the given runner and account.  If the pool, the runner
+// or the account is at its limit, no slot is taken and false is returned.
+func (p *pool) acquire(runner, account string) bool {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if p.running >= p.size {
+		return false
+	}
+
+	if limit := p.runnerLimits[runner]; limit > 0 && p.runners[runner] >= limit {
+		return false
+	}
+
+	if limit := p.accountLimits[account]; limit > 0 && p.accounts[account] >= limit {
+		return false
+	}
+
+	p.running++
+	p.runners[runner]++
+	p.accounts[account]++
+	p.wg.Add(1)
+
+	return true
+}
+
+// release gives back the slot taken for a job with the given runner and account
+func (p *pool) release(runner, account string) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.running--
+
+	p.runners[runner]--
+	if p.runners[runner] <= 0 {
+		delete(p.runners, runner)
+	}
+
+	p.accounts[account]--
+	if p.accounts[account] <= 0 {
+		delete(p.accounts, account)
+	}
+
+	p.wg.Done()
+}
+
+// wait blocks until all of the running jobs have released their slot or the timeout fires.  it
+// returns false if the timeout fired first.
+func (p *pool) wait(timeout <-chan time.Time) bool {
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-timeout:
+		return false
+	}
+}
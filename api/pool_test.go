@@ -0,0 +1,51 @@
+package api
+
+import "testing"
+
+func TestPool(t *testing.T) {
+	p := newPool(3, map[string]int{"instance": 2}, map[string]int{"acct1": 1})
+
+	type acquire struct {
+		runner  string
+		account string
+		want    bool
+	}
+
+	for _, a := range []acquire{
+		{runner: "instance", account: "acct1", want: true},
+		{runner: "instance", account: "acct1", want: false},
+		{runner: "instance", account: "acct2", want: true},
+		{runner: "instance", account: "acct3", want: false},
+		{runner: "service", account: "acct3", want: true},
+		{runner: "service", account: "acct4", want: false},
+	} {
+		if got := p.acquire(a.runner, a.account); got != a.want {
+			t.Errorf("expected acquire(%s, %s) to be %t, got %t", a.runner, a.account, a.want, got)
+		}
+	}
+
+	if p.free() {
+		t.Error("expected full pool not to be free")
+	}
+
+	p.release("instance", "acct1")
+	if !p.free() {
+		t.Error("expected pool to be free after release")
+	}
+
+	if !p.acquire("instance", "acct1") {
+		t.Error("expected acquire to succeed after release")
+	}
+
+	if p.running != 3 || p.runners["instance"] != 2 || p.accounts["acct1"] != 1 {
+		t.Errorf("unexpected pool state %+v", p)
+	}
+
+	p.release("instance", "acct1")
+	p.release("instance", "acct2")
+	p.release("service", "acct3")
+
+	if p.running != 0 || len(p.runners) != 0 || len(p.accounts) != 0 {
+		t.Errorf("expected empty pool, got %+v", p)
+	}
+}
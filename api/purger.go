@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	report "github.com/YaleSpinup/eventreporter"
+	"github.com/YaleSpinup/minion/common"
+	log "github.com/sirupsen/logrus"
+)
+
+// configure sets up the purger from the jobs repository configuration.  By default, deleted jobs are kept in the
+// trash for 30 days and the trash is checked for expired jobs every hour.
+func (p *purger) configure(c common.JobsRepository) error {
+	p.interval = 1 * time.Hour
+	p.retention = 30 * 24 * time.Hour
+
+	if c.TrashRetention != "" {
+		v, err := time.ParseDuration(c.TrashRetention)
+		if err != nil {
+			return fmt.Errorf("invalid trashRetention '%s': %s", c.TrashRetention, err)
+		}
+
+		if v <= 0 {
+			return fmt.Errorf("trashRetention must be greater than 0")
+		}
+		p.retention = v
+	}
+
+	// check at least as often as the retention, so a short retention isn't exceeded by much
+	if p.retention < p.interval {
+		p.interval = p.retention
+	}
+
+	log.Infof("%s: purger interval %s, trash retention %s", p.id, p.interval, p.retention)
+
+	return nil
+}
+
+// start the purger loop
+func (p *purger) start(ctx context.Context) {
+	log.Infof("%s: purger starting", p.id)
+	go p.loop(ctx)
+	log.Infof("%s: purger started", p.id)
+}
+
+// loop runs the purger every interval
+func (p *purger) loop(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	for {
+		log.Debugf("%s: starting purger loop (%s)", p.id, time.Now().String())
+		select {
+		case <-ticker.C:
+			p.run(ctx, time.Now().UTC().Truncate(p.interval))
+		case <-ctx.Done():
+			log.Debugf("%s: shutting down purger ticker", p.id)
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+// run permanently removes the jobs that were deleted longer than the retention ago from the trash.  only the
+// node that aquires the lock for the interval (the leader) runs the purger.
+func (p *purger) run(ctx context.Context, now time.Time) {
+	defer timeTrack("purger.run()", time.Now())
+
+	if err := p.locker.Lock("purger-"+strconv.FormatInt(now.Unix(), 10), p.id); err != nil {
+		log.Debugf("%s: not the purger leader, moving on...", p.id)
+		return
+	}
+
+	for account := range p.accounts {
+		trashed, err := p.trasher.ListTrash(ctx, account, "")
+		if err != nil {
+			log.Errorf("%s: failed to list the trash of account %s: %s", p.id, account, err)
+			continue
+		}
+
+		for _, t := range trashed {
+			if ctx.Err() != nil {
+				return
+			}
+
+			if now.Sub(t.DeletedAt) < p.retention {
+				continue
+			}
+
+			// without the job there's no id to purge it by
+			if t.Job == nil {
+				log.Warnf("%s: skipping trashed job without a job in %s/%s deleted at %s", p.id, account, t.Group, t.DeletedAt.String())
+				continue
+			}
+
+			if err := p.trasher.PurgeTrash(ctx, account, t.Group, t.Job.ID); err != nil {
+				msg := fmt.Sprintf("%s: failed to purge job %s/%s/%s from the trash: %s", p.id, account, t.Group, t.Job.ID, err)
+				log.Error(msg)
+				reportEvent(msg, report.ERROR)
+				continue
+			}
+
+			log.Infof("%s: purged job %s/%s/%s deleted at %s", p.id, account, t.Group, t.Job.ID, t.DeletedAt.String())
+
+			deleteRuns(ctx, p.runsRepository, account, t.Group, t.Job.ID)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/YaleSpinup/minion/common"
+	"github.com/YaleSpinup/minion/jobs"
+	"github.com/google/uuid"
+)
+
+type mockTrasher struct {
+	jobs.Trasher
+	trash  map[string][]*jobs.TrashedJob
+	purged []string
+}
+
+func (m *mockTrasher) ListTrash(ctx context.Context, account, group string) ([]*jobs.TrashedJob, error) {
+	if account == "broken" {
+		return nil, errors.New("boom")
+	}
+	return m.trash[account], nil
+}
+
+func (m *mockTrasher) PurgeTrash(ctx context.Context, account, group, id string) error {
+	if id == "fails" {
+		return errors.New("boom")
+	}
+	m.purged = append(m.purged, account+"/"+group+"/"+id)
+	return nil
+}
+
+func TestPurgerRun(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Hour)
+
+	trasher := &mockTrasher{
+		trash: map[string][]*jobs.TrashedJob{
+			"metal": {
+				{Group: "metallica", DeletedAt: now.Add(-31 * 24 * time.Hour), Job: &jobs.Job{ID: "expired"}},
+				{Group: "metallica", DeletedAt: now.Add(-30 * 24 * time.Hour), Job: &jobs.Job{ID: "just-expired"}},
+				{Group: "metallica", DeletedAt: now.Add(-29 * 24 * time.Hour), Job: &jobs.Job{ID: "recent"}},
+				{Group: "metallica", DeletedAt: now.Add(-31 * 24 * time.Hour), Job: &jobs.Job{ID: "fails"}},
+				{Group: "metallica", DeletedAt: now.Add(-31 * 24 * time.Hour)},
+			},
+			"thrash": {
+				{Group: "slayer", DeletedAt: now.Add(-40 * 24 * time.Hour), Job: &jobs.Job{ID: "expired"}},
+			},
+		},
+	}
+
+	runs := &mockRunsRepository{t: t}
+	p := &purger{
+		accounts:       map[string]common.Account{"metal": {}, "thrash": {}, "broken": {}},
+		id:             uuid.New().String(),
+		locker:         &mockSchedLocker{t, true},
+		runsRepository: runs,
+		trasher:        trasher,
+	}
+
+	if err := p.configure(common.JobsRepository{}); err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	p.run(context.TODO(), now)
+
+	sort.Strings(trasher.purged)
+	if expected := []string{"metal/metallica/expired", "metal/metallica/just-expired", "thrash/slayer/expired"}; !reflect.DeepEqual(trasher.purged, expected) {
+		t.Errorf("expected purged jobs %v, got %v", expected, trasher.purged)
+	}
+
+	sort.Strings(runs.deleted)
+	if expected := []string{"metal/metallica/expired", "metal/metallica/just-expired", "thrash/slayer/expired"}; !reflect.DeepEqual(runs.deleted, expected) {
+		t.Errorf("expected the runs of the purged jobs %v to be deleted, got %v", expected, runs.deleted)
+	}
+
+	// not the leader
+	trasher.purged = nil
+	p.locker = &mockSchedLocker{t, false}
+	p.run(context.TODO(), now)
+
+	if len(trasher.purged) != 0 {
+		t.Errorf("expected no jobs to be purged without the lock, got %v", trasher.purged)
+	}
+}
+
+func TestPurgerConfigure(t *testing.T) {
+	p := &purger{}
+	if err := p.configure(common.JobsRepository{}); err != nil {
+		t.Errorf("expected nil error, got %s", err)
+	}
+
+	if p.interval != time.Hour || p.retention != 30*24*time.Hour {
+		t.Errorf("unexpected purger defaults %+v", p)
+	}
+
+	if err := p.configure(common.JobsRepository{TrashRetention: "168h"}); err != nil {
+		t.Errorf("expected nil error, got %s", err)
+	} else if p.interval != time.Hour || p.retention != 168*time.Hour {
+		t.Errorf("expected interval 1h and retention 168h, got %s and %s", p.interval, p.retention)
+	}
+
+	if err := p.configure(common.JobsRepository{TrashRetention: "10m"}); err != nil {
+		t.Errorf("expected nil error, got %s", err)
+	} else if p.interval != 10*time.Minute {
+		t.Errorf("expected the interval to be the short retention, got %s", p.interval)
+	}
+
+	if err := p.configure(common.JobsRepository{TrashRetention: "forever"}); err == nil {
+		t.Error("expected error for bad retention, got nil")
+	}
+
+	if err := p.configure(common.JobsRepository{TrashRetention: "0s"}); err == nil {
+		t.Error("expected error for zero retention, got nil")
+	}
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	report "github.com/YaleSpinup/eventreporter"
+	"github.com/YaleSpinup/minion/common"
+	"github.com/YaleSpinup/minion/jobs"
+	log "github.com/sirupsen/logrus"
+)
+
+// configure sets up the requeuer from the configuration.  By default, expired jobs are checked for every minute,
+// jobs have 15 minutes to finish (or renew their lease) before they are recovered, runs are recovered twice and
+// jobs whose lease expired more than an hour ago are dead lettered instead of requeued.
+func (r *requeuer) configure(c common.Requeuer) error {
+	r.interval = 1 * time.Minute
+	r.lease = 15 * time.Minute
+	r.maxAge = 1 * time.Hour
+	r.maxAttempts = 3
+
+	durations := []struct {
+		name  string
+		value string
+		field *time.Duration
+	}{
+		{"interval", c.Interval, &r.interval},
+		{"lease", c.Lease, &r.lease},
+		{"maxAge", c.MaxAge, &r.maxAge},
+	}
+
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return fmt.Errorf("invalid requeuer %s '%s': %s", d.name, d.value, err)
+		}
+
+		if v <= 0 {
+			return fmt.Errorf("requeuer %s must be greater than 0", d.name)
+		}
+		*d.field = v
+	}
+
+	if c.MaxAttempts < 0 {
+		return errors.New("requeuer maxAttempts cannot be negative")
+	} else if c.MaxAttempts > 0 {
+		r.maxAttempts = c.MaxAttempts
+	}
+
+	log.Infof("%s: requeuer interval %s, lease %s, max age %s, max attempts %d", r.id, r.interval, r.lease, r.maxAge, r.maxAttempts)
+
+	return nil
+}
+
+// start the requeuer loop
+func (r *requeuer) start(ctx context.Context) {
+	log.Infof("%s: requeuer starting", r.id)
+	go r.loop(ctx)
+	log.Infof("%s: requeuer started", r.id)
+}
+
+// loop runs the requeuer every interval
+func (r *requeuer) loop(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	for {
+		log.Debugf("%s: starting requeuer loop (%s)", r.id, time.Now().String())
+		select {
+		case <-ticker.C:
+			r.run(ctx, time.Now().UTC().Truncate(r.interval))
+		case <-ctx.Done():
+			log.Debugf("%s: shutting down requeuer ticker", r.id)
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+// run recovers expired jobs.  only the node that aquires the lock for the interval (the leader) runs the
+// requeuer.  jobs that haven't used all of their attempts and expired recently are requeued, the others are
+// moved to the dead letter set.
+func (r *requeuer) run(ctx context.Context, now time.Time) {
+	defer timeTrack("requeuer.run()", time.Now())
+
+	if err := r.locker.Lock("requeuer-"+strconv.FormatInt(now.Unix(), 10), r.id); err != nil {
+		log.Debugf("%s: not the requeuer leader, moving on...", r.id)
+		return
+	}
+
+	expired, err := r.recoverer.Expired(r.lease)
+	if err != nil {
+		log.Errorf("%s: failed to get expired jobs: %s", r.id, err)
+		return
+	}
+
+	log.Infof("%s: requeuer found %d expired jobs", r.id, len(expired))
+
+	for _, q := range expired {
+		if ctx.Err() != nil {
+			return
+		}
+
+		leaseStart := time.Unix(int64(q.Score), 0)
+		// the attempts to finish the run are counted apart from the retries of failed runs
+		attempts := q.Recovered + 1
+
+		var reason string
+		if attempts >= r.maxAttempts {
+			reason = fmt.Sprintf("failed to finish after %d attempts", attempts)
+		} else if expiredAt := leaseStart.Add(r.lease); now.Sub(expiredAt) > r.maxAge {
+			reason = fmt.Sprintf("lease expired at %s, more than %s ago", expiredAt.UTC().String(), r.maxAge)
+		}
+
+		if reason != "" {
+			if err := r.recoverer.DeadLetter(q); err != nil {
+				r.logRecoverError("dead letter", q, err)
+				continue
+			}
+
+			msg := fmt.Sprintf("%s: dead lettered job %s (run %s), %s", r.id, q.ID, q.RunID, reason)
+			log.Error(msg)
+			reportEvent(msg, report.ERROR)
+			continue
+		}
+
+		if err := r.recoverer.Requeue(q); err != nil {
+			r.logRecoverError("requeue", q, err)
+			continue
+		}
+
+		log.Warnf("%s: requeued job %s (run %s), lease started at %s, attempt %d", r.id, q.ID, q.RunID, leaseStart.UTC().String(), attempts+1)
+	}
+}
+
+// logRecoverError logs the failure to recover a job.  if the job was no longer in the backup set with the expired
+// lease, it was finalized or its lease was renewed while it was being recovered which isn't an error.
+func (r *requeuer) logRecoverError(action string, q *jobs.QueuedJob, err error) {
+	if qErr, ok := err.(jobs.QueueError); ok && qErr.Code == jobs.ErrQueuedJobNotFound {
+		log.Infof("%s: job %s (run %s) was finalized or renewed before it could %s", r.id, q.ID, q.RunID, action)
+		return
+	}
+
+	log.Errorf("%s: failed to %s job %s (run %s): %s", r.id, action, q.ID, q.RunID, err)
+}
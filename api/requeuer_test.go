@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/YaleSpinup/minion/common"
+	"github.com/YaleSpinup/minion/jobs"
+	"github.com/google/uuid"
+)
+
+type mockRecoverer struct {
+	t            *testing.T
+	expired      []*jobs.QueuedJob
+	requeued     []*jobs.QueuedJob
+	deadLettered []*jobs.QueuedJob
+	finalized    map[string]bool
+}
+
+func (m *mockRecoverer) Expired(lease time.Duration) ([]*jobs.QueuedJob, error) {
+	m.t.Logf("getting jobs expired for more than %s", lease)
+	return m.expired, nil
+}
+
+func (m *mockRecoverer) Requeue(queued *jobs.QueuedJob) error {
+	m.t.Logf("requeuing job %+v", queued)
+	if m.finalized[queued.RunID] {
+		return jobs.NewQueueError(jobs.ErrQueuedJobNotFound, "not found", nil)
+	}
+	m.requeued = append(m.requeued, queued)
+	return nil
+}
+
+func (m *mockRecoverer) DeadLetter(queued *jobs.QueuedJob) error {
+	m.t.Logf("dead lettering job %+v", queued)
+	m.deadLettered = append(m.deadLettered, queued)
+	return nil
+}
+
+func TestRequeuerRun(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Minute)
+	recent := float64(now.Add(-20 * time.Minute).Unix())
+	old := float64(now.Add(-2 * time.Hour).Unix())
+
+	recoverer := &mockRecoverer{
+		t: t,
+		expired: []*jobs.QueuedJob{
+			{ID: "group/requeue", RunID: "run1", Score: recent},
+			{ID: "group/requeue-again", RunID: "run2", Recovered: 1, Score: recent},
+			{ID: "group/too-many-attempts", RunID: "run3", Recovered: 2, Score: recent},
+			{ID: "group/retried", RunID: "run6", Attempt: 4, Score: recent},
+			{ID: "group/too-old", RunID: "run4", Score: old},
+			{ID: "group/finalized", RunID: "run5", Score: recent},
+		},
+		finalized: map[string]bool{"run5": true},
+	}
+
+	r := &requeuer{
+		id:        uuid.New().String(),
+		locker:    &mockSchedLocker{t, true},
+		recoverer: recoverer,
+	}
+
+	if err := r.configure(common.Requeuer{}); err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	r.run(context.TODO(), now)
+
+	if len(recoverer.requeued) != 3 {
+		t.Fatalf("expected 3 requeued jobs, got %+v", recoverer.requeued)
+	}
+
+	// retries of failed runs don't count towards the recovery attempts
+	for i, id := range []string{"group/requeue", "group/requeue-again", "group/retried"} {
+		if recoverer.requeued[i].ID != id {
+			t.Errorf("expected requeued job %s, got %s", id, recoverer.requeued[i].ID)
+		}
+	}
+
+	if len(recoverer.deadLettered) != 2 {
+		t.Fatalf("expected 2 dead lettered jobs, got %+v", recoverer.deadLettered)
+	}
+
+	for i, id := range []string{"group/too-many-attempts", "group/too-old"} {
+		if recoverer.deadLettered[i].ID != id {
+			t.Errorf("expected dead lettered job %s, got %s", id, recoverer.deadLettered[i].ID)
+		}
+	}
+
+	// not the leader
+	recoverer.requeued, recoverer.deadLettered = nil, nil
+	r.locker = &mockSchedLocker{t, false}
+	r.run(context.TODO(), now)
+
+	if len(recoverer.requeued) != 0 || len(recoverer.deadLettered) != 0 {
+		t.Errorf("expected no jobs to be recovered without the lock, got %+v, %+v", recoverer.requeued, recoverer.deadLettered)
+	}
+}
+
+func TestRequeuerConfigure(t *testing.T) {
+	r := &requeuer{}
+	if err := r.configure(common.Requeuer{}); err != nil {
+		t.Errorf("expected nil error, got %s", err)
+	}
+
+	if r.interval != time.Minute || r.lease != 15*time.Minute || r.maxAge != time.Hour || r.maxAttempts != 3 {
+		t.Errorf("unexpected requeuer defaults %+v", r)
+	}
+
+	if err := r.configure(common.Requeuer{Lease: "5m", MaxAttempts: 5}); err != nil {
+		t.Errorf("expected nil error, got %s", err)
+	} else if r.lease != 5*time.Minute || r.maxAttempts != 5 {
+		t.Errorf("expected lease 5m and 5 max attempts, got %s and %d", r.lease, r.maxAttempts)
+	}
+
+	if err := r.configure(common.Requeuer{Interval: "often"}); err == nil {
+		t.Error("expected error for bad interval, got nil")
+	}
+
+	if err := r.configure(common.Requeuer{Lease: "0s"}); err == nil {
+		t.Error("expected error for zero lease, got nil")
+	}
+
+	if err := r.configure(common.Requeuer{MaxAttempts: -1}); err == nil {
+		t.Error("expected error for bad max attempts, got nil")
+	}
+}
@@ -12,6 +12,8 @@ func (s *server) routes() {
 	api.HandleFunc("/version", s.VersionHandler).Methods(http.MethodGet)
 	api.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet)
 
+	api.HandleFunc("/runners", s.RunnersListHandler).Methods(http.MethodGet)
+
 	api.HandleFunc("/{account}/jobs", s.JobsListHandler).Methods(http.MethodGet)
 
 	api.HandleFunc("/{account}/jobs/{group}", s.JobsListHandler).Methods(http.MethodGet)
@@ -24,4 +26,18 @@ func (s *server) routes() {
 	api.HandleFunc("/{account}/jobs/{group}/{id}", s.JobsDeleteHandler).Methods(http.MethodDelete)
 
 	api.HandleFunc("/{account}/jobs/{group}/{id}", s.JobsRunHandler).Methods(http.MethodPatch)
+
+	api.HandleFunc("/{account}/jobs/{group}/{id}/runs", s.RunsListHandler).Methods(http.MethodGet)
+	api.HandleFunc("/{account}/jobs/{group}/{id}/runs/{runId}", s.RunsShowHandler).Methods(http.MethodGet)
+
+	api.HandleFunc("/{account}/jobs/{group}/{id}/revisions", s.RevisionsListHandler).Methods(http.MethodGet)
+	api.HandleFunc("/{account}/jobs/{group}/{id}/revisions/{rev}", s.RevisionsShowHandler).Methods(http.MethodGet)
+	api.HandleFunc("/{account}/jobs/{group}/{id}/revisions/{rev}/restore", s.RevisionsRestoreHandler).Methods(http.MethodPost)
+
+	api.HandleFunc("/{account}/trash", s.TrashListHandler).Methods(http.MethodGet)
+	api.HandleFunc("/{account}/trash/{group}", s.TrashListHandler).Methods(http.MethodGet)
+	api.HandleFunc("/{account}/trash/{group}/restore", s.TrashRestoreHandler).Methods(http.MethodPost)
+	api.HandleFunc("/{account}/trash/{group}/{id}/restore", s.TrashRestoreHandler).Methods(http.MethodPost)
+	api.HandleFunc("/{account}/trash/{group}", s.TrashPurgeHandler).Methods(http.MethodDelete)
+	api.HandleFunc("/{account}/trash/{group}/{id}", s.TrashPurgeHandler).Methods(http.MethodDelete)
 }
@@ -2,13 +2,54 @@ package api
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"strconv"
 	"time"
 
+	"github.com/YaleSpinup/minion/common"
 	"github.com/YaleSpinup/minion/jobs"
 	log "github.com/sirupsen/logrus"
 )
 
+// configureMisfire sets the default handling of missed job runs from the scheduler configuration.  By default
+// missed runs are skipped, runs are considered missed for up to an hour and run_all enqueues at most 10 runs.
+func (s *scheduler) configureMisfire(c common.Scheduler) error {
+	s.misfirePolicy = jobs.MisfireSkip
+	s.misfireGrace = 1 * time.Hour
+	s.misfireLimit = 10
+
+	switch c.MisfirePolicy {
+	case "":
+	case jobs.MisfireSkip, jobs.MisfireRunOnce, jobs.MisfireRunAll:
+		s.misfirePolicy = c.MisfirePolicy
+	default:
+		return fmt.Errorf("invalid scheduler misfire policy '%s'", c.MisfirePolicy)
+	}
+
+	if c.MisfireGrace != "" {
+		grace, err := time.ParseDuration(c.MisfireGrace)
+		if err != nil {
+			return fmt.Errorf("invalid scheduler misfire grace '%s': %s", c.MisfireGrace, err)
+		}
+
+		if grace < 0 {
+			return errors.New("scheduler misfire grace cannot be negative")
+		}
+		s.misfireGrace = grace
+	}
+
+	if c.MisfireLimit < 0 {
+		return errors.New("scheduler misfire limit cannot be negative")
+	} else if c.MisfireLimit > 0 {
+		s.misfireLimit = c.MisfireLimit
+	}
+
+	log.Infof("%s: scheduler misfire policy %s, grace %s, limit %d", s.id, s.misfirePolicy, s.misfireGrace, s.misfireLimit)
+
+	return nil
+}
+
 // start the scheduler loop
 func (s *scheduler) start(ctx context.Context) error {
 	log.Infof("%s: scheduler starting", s.id)
@@ -37,7 +78,9 @@ func (s *scheduler) loop(ctx context.Context) {
 }
 
 // run does the scheduling of jobs.  first we aquire a central lock, then determines the minute we are in
-// and looks for any enabled job in the cache which should be scheduled now.  if any are found, they are enqueued.
+// and looks for any enabled job in the cache which should be scheduled now or missed a run since it was
+// last scheduled.  if any are found, they are enqueued.  the cache is only locked while the enabled jobs
+// are copied out, and the last scheduled times are read and written for all of the jobs at once.
 func (s *scheduler) run(ctx context.Context, now time.Time) {
 	defer timeTrack("scheduler.run()", time.Now())
 
@@ -48,36 +91,169 @@ func (s *scheduler) run(ctx context.Context, now time.Time) {
 	}
 	log.Debugf("%s acquired lock", s.id)
 
-	basis := now.Add(time.Duration(-1) * time.Minute).UTC().Truncate(time.Minute)
+	log.Infof("%s running jobs scheduler %s", s.id, now.String())
 
-	log.Infof("%s running jobs scheduler %s with basis time %s", s.id, now.String(), basis.String())
+	enabled := s.enabledJobs()
+
+	ids := make([]string, 0, len(enabled))
+	for id := range enabled {
+		ids = append(ids, id)
+	}
+
+	var last map[string]time.Time
+	if s.tracker != nil {
+		var err error
+		if last, err = s.tracker.LastScheduled(ids...); err != nil {
+			log.Warnf("%s failed to get last scheduled times, not checking for missed runs: %s", s.id, err)
+		}
+	}
 
+	scheduled := make([]string, 0, len(enabled))
+	for id, job := range enabled {
+		log.Debugf("processing job %s schedule %s", id, job.ScheduleExpression)
+
+		runs, err := s.due(id, job, last[id], now)
+		if err != nil {
+			log.Errorf("failed to get next run for job id '%s' with expression '%s': %s", id, job.ScheduleExpression, err)
+			continue
+		}
+
+		for _, r := range runs {
+			q := &jobs.QueuedJob{
+				ID:    id,
+				RunID: jobs.NewRunID(),
+				Score: float64(r.Unix()),
+			}
+
+			log.Infof("%s enqueing job %s (run %s) scheduled for %s", s.id, id, q.RunID, r.String())
+			if err := s.jobQueue.Enqueue(q); err != nil {
+				log.Errorf("failed enqueing job %s: %s", id, err)
+			}
+		}
+
+		scheduled = append(scheduled, id)
+	}
+
+	if s.tracker != nil {
+		if err := s.tracker.SetLastScheduled(now, scheduled...); err != nil {
+			log.Errorf("failed to set last scheduled time for %d jobs: %s", len(scheduled), err)
+		}
+	}
+
+	log.Infof("%s done scheduling jobs", s.id)
+}
+
+// enabledJobs returns the enabled jobs in the cache.  cached jobs are replaced rather than modified, so
+// the jobs can be scheduled after the cache is unlocked.
+func (s *scheduler) enabledJobs() map[string]*jobs.Job {
 	s.jobsCache.Mux.Lock()
 	defer s.jobsCache.Mux.Unlock()
 
+	enabled := make(map[string]*jobs.Job, len(s.jobsCache.Cache))
 	for id, job := range s.jobsCache.Cache {
-		log.Debugf("processing job %s schedule %s", id, job.ScheduleExpression)
-
 		if !job.Enabled {
 			log.Debugf("job %s is disabled", id)
 			continue
 		}
+		enabled[id] = job
+	}
 
-		next, err := job.NextRun(basis)
+	return enabled
+}
+
+// due returns the times a job should be enqueued for in the minute now.  This is now if the job is
+// scheduled to run now, plus any runs missed since the job was last scheduled, according to the
+// job's misfire policy.
+func (s *scheduler) due(id string, job *jobs.Job, last, now time.Time) ([]time.Time, error) {
+	policy, grace, limit := s.misfire(job)
+
+	previous := now.Add(time.Duration(-1) * time.Minute).UTC().Truncate(time.Minute)
+	from := previous
+	if !last.IsZero() && last.Before(from) {
+		from = last
+
+		// don't consider runs from before the job was last modified
+		if job.ModifiedAt != nil && job.ModifiedAt.After(from) {
+			from = *job.ModifiedAt
+		}
+
+		// a grace window shorter than a minute still schedules the run due now
+		earliest := now.Add(-grace)
+		if earliest.After(previous) {
+			earliest = previous
+		}
+
+		if from.Before(earliest) {
+			log.Warnf("%s job %s was last scheduled %s, ignoring runs missed before the grace window (%s)", s.id, id, last.String(), earliest.String())
+			from = earliest
+		}
+	}
+
+	var missed []time.Time
+	var scheduled bool
+	for t := from; ; {
+		next, err := job.NextRun(t)
 		if err != nil {
-			log.Errorf("failed to get next run for job id '%s' with expression '%s': %s", id, job.ScheduleExpression, err)
-			continue
+			return nil, err
 		}
 
 		log.Debugf("%s next execution is %s", id, next.String())
 
+		if next.IsZero() || next.After(now) {
+			break
+		}
+
 		if next.Equal(now) {
-			log.Infof("%s enqueing job %s", s.id, id)
-			if err := s.jobQueue.Enqueue(&jobs.QueuedJob{ID: id}); err != nil {
-				log.Errorf("failed enqueing job %s: %s", id, err)
+			scheduled = true
+			break
+		}
+
+		missed = append(missed, *next)
+		t = *next
+	}
+
+	runs := []time.Time{}
+	if n := len(missed); n > 0 {
+		first, last := missed[0].String(), missed[n-1].String()
+
+		switch policy {
+		case jobs.MisfireRunOnce:
+			log.Infof("%s job %s missed %d run(s) between %s and %s, running once (%s)", s.id, id, n, first, last, policy)
+			runs = append(runs, missed[n-1])
+		case jobs.MisfireRunAll:
+			if limit > 0 && n > limit {
+				missed = missed[n-limit:]
 			}
+			log.Infof("%s job %s missed %d run(s) between %s and %s, running %d (%s)", s.id, id, n, first, last, len(missed), policy)
+			runs = append(runs, missed...)
+		default:
+			log.Infof("%s job %s missed %d run(s) between %s and %s, skipping (%s)", s.id, id, n, first, last, policy)
 		}
 	}
 
-	log.Infof("%s done scheduling jobs", s.id)
+	if scheduled {
+		runs = append(runs, now)
+	}
+
+	return runs, nil
+}
+
+// misfire returns the misfire policy, grace window and limit for a job, falling back to the scheduler defaults
+func (s *scheduler) misfire(job *jobs.Job) (string, time.Duration, int) {
+	policy, grace, limit := s.misfirePolicy, s.misfireGrace, s.misfireLimit
+
+	if job.MisfirePolicy != "" {
+		policy = job.MisfirePolicy
+	}
+
+	// an explicit grace of 0 overrides the default and ignores all missed runs
+	if job.MisfireGrace != nil {
+		grace = *job.MisfireGrace
+	}
+
+	if job.MisfireLimit > 0 {
+		limit = job.MisfireLimit
+	}
+
+	return policy, grace, limit
 }
@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/YaleSpinup/minion/common"
 	"github.com/YaleSpinup/minion/jobs"
 	"github.com/google/uuid"
 )
@@ -79,8 +80,8 @@ func (m *mockSchedQueuer) Fetch(queued *jobs.QueuedJob) error {
 	m.t.Log("fetching jobs")
 	return nil
 }
-func (m *mockSchedQueuer) Finalize(id string) error {
-	m.t.Logf("finalizing job %s", id)
+func (m *mockSchedQueuer) Finalize(queued *jobs.QueuedJob) error {
+	m.t.Logf("finalizing job %+v", queued)
 	return nil
 }
 
@@ -112,3 +113,258 @@ func TestSchedulerRun(t *testing.T) {
 	sched.run(context.TODO(), hourBasis)
 	sched.run(context.TODO(), fiveMinutesBasis)
 }
+
+type mockUnlockedQueuer struct {
+	mockSchedQueuer
+	cache  *jobsCache
+	locked bool
+}
+
+func (m *mockUnlockedQueuer) Enqueue(queued *jobs.QueuedJob) error {
+	if !m.cache.Mux.TryLock() {
+		m.locked = true
+		return nil
+	}
+	m.cache.Mux.Unlock()
+	return nil
+}
+
+func TestSchedulerRunUnlocked(t *testing.T) {
+	cache := &jobsCache{
+		Cache: map[string]*jobs.Job{
+			"group/job": {ScheduleExpression: "* * * * *", Enabled: true},
+		},
+	}
+
+	q := &mockUnlockedQueuer{mockSchedQueuer: mockSchedQueuer{t, true}, cache: cache}
+	tracker := &mockSchedTracker{t, map[string]time.Time{}}
+	sched := &scheduler{
+		id:        uuid.New().String(),
+		jobsCache: cache,
+		jobQueue:  q,
+		locker:    &mockSchedLocker{t, true},
+		tracker:   tracker,
+	}
+
+	now := time.Now().UTC().Truncate(time.Minute)
+	sched.run(context.TODO(), now)
+
+	if q.locked {
+		t.Error("expected the jobs cache to be unlocked while enqueuing jobs")
+	}
+
+	if !tracker.last["group/job"].Equal(now) {
+		t.Errorf("expected last scheduled to be %s, got %s", now, tracker.last["group/job"])
+	}
+}
+
+type mockSchedTracker struct {
+	t    *testing.T
+	last map[string]time.Time
+}
+
+func (m *mockSchedTracker) LastScheduled(ids ...string) (map[string]time.Time, error) {
+	m.t.Logf("getting last scheduled time for %v", ids)
+
+	last := map[string]time.Time{}
+	for _, id := range ids {
+		if t, ok := m.last[id]; ok {
+			last[id] = t
+		}
+	}
+	return last, nil
+}
+
+func (m *mockSchedTracker) SetLastScheduled(t time.Time, ids ...string) error {
+	m.t.Logf("setting last scheduled time for %v to %s", ids, t)
+	for _, id := range ids {
+		m.last[id] = t
+	}
+	return nil
+}
+
+func (m *mockSchedTracker) Forget(ids ...string) error {
+	m.t.Logf("forgetting last scheduled time for %v", ids)
+	for _, id := range ids {
+		delete(m.last, id)
+	}
+	return nil
+}
+
+type mockMisfireQueuer struct {
+	mockSchedQueuer
+	queued []*jobs.QueuedJob
+}
+
+func (m *mockMisfireQueuer) Enqueue(queued *jobs.QueuedJob) error {
+	m.t.Logf("enqueing job %+v", queued)
+	m.queued = append(m.queued, queued)
+	return nil
+}
+
+func TestSchedulerMisfire(t *testing.T) {
+	now, _ := time.Parse(time.RFC3339, "2020-03-13T08:00:00Z")
+	modified, _ := time.Parse(time.RFC3339, "2020-03-13T07:45:30Z")
+	grace, noGrace := 20*time.Minute, time.Duration(0)
+
+	type test struct {
+		name   string
+		job    *jobs.Job
+		last   time.Time
+		expect []time.Time
+	}
+
+	tests := []test{
+		{
+			name:   "never scheduled",
+			job:    &jobs.Job{ScheduleExpression: "*/15 * * * *", MisfirePolicy: jobs.MisfireRunAll},
+			expect: []time.Time{now},
+		},
+		{
+			name:   "nothing missed",
+			job:    &jobs.Job{ScheduleExpression: "*/15 * * * *", MisfirePolicy: jobs.MisfireRunAll},
+			last:   now.Add(-1 * time.Minute),
+			expect: []time.Time{now},
+		},
+		{
+			name:   "skip missed runs",
+			job:    &jobs.Job{ScheduleExpression: "*/15 * * * *", MisfirePolicy: jobs.MisfireSkip},
+			last:   now.Add(-40 * time.Minute),
+			expect: []time.Time{now},
+		},
+		{
+			name:   "run once",
+			job:    &jobs.Job{ScheduleExpression: "5,35 * * * *", MisfirePolicy: jobs.MisfireRunOnce},
+			last:   now.Add(-40 * time.Minute),
+			expect: []time.Time{now.Add(-25 * time.Minute)},
+		},
+		{
+			name: "run all",
+			job:  &jobs.Job{ScheduleExpression: "*/15 * * * *", MisfirePolicy: jobs.MisfireRunAll},
+			last: now.Add(-40 * time.Minute),
+			expect: []time.Time{
+				now.Add(-30 * time.Minute),
+				now.Add(-15 * time.Minute),
+				now,
+			},
+		},
+		{
+			name: "run all up to the limit",
+			job:  &jobs.Job{ScheduleExpression: "*/15 * * * *", MisfirePolicy: jobs.MisfireRunAll, MisfireLimit: 1},
+			last: now.Add(-40 * time.Minute),
+			expect: []time.Time{
+				now.Add(-15 * time.Minute),
+				now,
+			},
+		},
+		{
+			name: "run all within the grace window",
+			job:  &jobs.Job{ScheduleExpression: "*/15 * * * *", MisfirePolicy: jobs.MisfireRunAll, MisfireGrace: &grace},
+			last: now.Add(-40 * time.Minute),
+			expect: []time.Time{
+				now.Add(-15 * time.Minute),
+				now,
+			},
+		},
+		{
+			name: "run all without a grace window",
+			job:  &jobs.Job{ScheduleExpression: "*/15 * * * *", MisfirePolicy: jobs.MisfireRunAll, MisfireGrace: &noGrace},
+			last: now.Add(-40 * time.Minute),
+			expect: []time.Time{
+				now,
+			},
+		},
+		{
+			name: "run all since modified",
+			job:  &jobs.Job{ScheduleExpression: "*/15 * * * *", MisfirePolicy: jobs.MisfireRunAll, ModifiedAt: &modified},
+			last: now.Add(-40 * time.Minute),
+			expect: []time.Time{
+				now,
+			},
+		},
+		{
+			name: "default policy",
+			job:  &jobs.Job{ScheduleExpression: "*/15 * * * *"},
+			last: now.Add(-40 * time.Minute),
+			expect: []time.Time{
+				now.Add(-15 * time.Minute),
+				now,
+			},
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			tst.job.Enabled = true
+
+			q := &mockMisfireQueuer{mockSchedQueuer: mockSchedQueuer{t, true}}
+			tracker := &mockSchedTracker{t, map[string]time.Time{}}
+			if !tst.last.IsZero() {
+				tracker.last["group/job"] = tst.last
+			}
+
+			sched := &scheduler{
+				id: uuid.New().String(),
+				jobsCache: &jobsCache{
+					Cache: map[string]*jobs.Job{"group/job": tst.job},
+				},
+				jobQueue: q,
+				locker:   &mockSchedLocker{t, true},
+				tracker:  tracker,
+			}
+
+			if err := sched.configureMisfire(common.Scheduler{MisfirePolicy: jobs.MisfireRunOnce}); err != nil {
+				t.Fatalf("expected nil error, got %s", err)
+			}
+
+			sched.run(context.TODO(), now)
+
+			if len(q.queued) != len(tst.expect) {
+				t.Fatalf("expected %d queued jobs, got %d", len(tst.expect), len(q.queued))
+			}
+
+			runIDs := map[string]bool{}
+			for i, e := range tst.expect {
+				if q.queued[i].ID != "group/job" {
+					t.Errorf("expected queued job id group/job, got %s", q.queued[i].ID)
+				}
+
+				if q.queued[i].Score != float64(e.Unix()) {
+					t.Errorf("expected queued job score %d, got %f", e.Unix(), q.queued[i].Score)
+				}
+
+				if runIDs[q.queued[i].RunID] {
+					t.Errorf("expected unique run id, got duplicate %s", q.queued[i].RunID)
+				}
+				runIDs[q.queued[i].RunID] = true
+			}
+
+			if !tracker.last["group/job"].Equal(now) {
+				t.Errorf("expected last scheduled to be %s, got %s", now, tracker.last["group/job"])
+			}
+		})
+	}
+}
+
+func TestSchedulerConfigureMisfire(t *testing.T) {
+	s := &scheduler{}
+	if err := s.configureMisfire(common.Scheduler{}); err != nil {
+		t.Errorf("expected nil error, got %s", err)
+	}
+
+	if s.misfirePolicy != jobs.MisfireSkip || s.misfireGrace != time.Hour || s.misfireLimit != 10 {
+		t.Errorf("unexpected misfire defaults %s, %s, %d", s.misfirePolicy, s.misfireGrace, s.misfireLimit)
+	}
+
+	if err := s.configureMisfire(common.Scheduler{MisfirePolicy: "sometimes"}); err == nil {
+		t.Error("expected error for bad policy, got nil")
+	}
+
+	if err := s.configureMisfire(common.Scheduler{MisfireGrace: "forever"}); err == nil {
+		t.Error("expected error for bad grace, got nil")
+	}
+
+	if err := s.configureMisfire(common.Scheduler{MisfireLimit: -1}); err == nil {
+		t.Error("expected error for bad limit, got nil")
+	}
+}
@@ -7,8 +7,10 @@ import (
 	"math/rand"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/YaleSpinup/minion/common"
@@ -52,11 +54,18 @@ type jobsCache struct {
 // and dependencies that are necessary in the http handlers.
 type server struct {
 	accounts       map[string]common.Account
+	id             string
+	invalidator    jobs.Invalidator
+	jobsCache      *jobsCache
 	jobQueue       jobs.Queuer
 	jobsRepository jobs.Repository
 	jobRunners     map[string]jobs.Runner
+	jobRunnerTypes map[string]string
 	logger         *logger
 	router         *mux.Router
+	runsRepository jobs.RunsRepository
+	tracker        jobs.ScheduleTracker
+	trashRetention time.Duration
 	version        *apiVersion
 }
 
@@ -71,20 +80,54 @@ type loader struct {
 
 // scheduler searches through the locally cached jobs and adds them to the queue
 type scheduler struct {
-	id        string
-	jobsCache *jobsCache
-	locker    jobs.Locker
-	jobQueue  jobs.Queuer
+	id            string
+	jobsCache     *jobsCache
+	locker        jobs.Locker
+	jobQueue      jobs.Queuer
+	misfireGrace  time.Duration
+	misfireLimit  int
+	misfirePolicy string
+	tracker       jobs.ScheduleTracker
+}
+
+// requeuer recovers jobs that were pulled off of the queue but never finalized
+type requeuer struct {
+	id          string
+	interval    time.Duration
+	lease       time.Duration
+	locker      jobs.Locker
+	maxAge      time.Duration
+	maxAttempts int
+	recoverer   jobs.Recoverer
+}
+
+// purger permanently removes the jobs that were in the trash of the jobs repository longer than the retention
+type purger struct {
+	accounts       map[string]common.Account
+	id             string
+	interval       time.Duration
+	locker         jobs.Locker
+	retention      time.Duration
+	runsRepository jobs.RunsRepository
+	trasher        jobs.Trasher
 }
 
 // executer pulls jobs off of the queue and runs then
 type executer struct {
-	accounts   map[string]common.Account
-	id         string
-	jobsCache  *jobsCache
-	jobQueue   jobs.Queuer
-	jobRunners map[string]jobs.Runner
-	logger     *logger
+	accounts       map[string]common.Account
+	defaultRetry   jobs.RetryPolicy
+	done           chan struct{}
+	heartbeat      time.Duration
+	id             string
+	jobsCache      *jobsCache
+	jobQueue       jobs.Queuer
+	jobRunners     map[string]jobs.Runner
+	logger         *logger
+	maxRunAge      time.Duration
+	maxRuns        int
+	pool           *pool
+	retryPolicies  map[string]jobs.RetryPolicy
+	runsRepository jobs.RunsRepository
 }
 
 var (
@@ -115,12 +158,27 @@ func NewServer(config common.Config) error {
 
 	reportEvent(fmt.Sprintf("Starting minion (id: %s, org: %s)", id, Org), report.INFO)
 
-	// setup server context with cancellation
+	// setup server context with cancellation, cancelling it stops the background loops
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// in-flight jobs run with their own context so they can finish while shutting down
+	runCtx, cancelRuns := context.WithCancel(context.Background())
+	defer cancelRuns()
+
+	shutdownTimeout := 30 * time.Second
+	if config.ShutdownTimeout != "" {
+		t, err := time.ParseDuration(config.ShutdownTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid shutdownTimeout '%s': %s", config.ShutdownTimeout, err)
+		}
+		shutdownTimeout = t
+	}
+
 	s := server{
 		accounts:   make(map[string]common.Account),
+		id:         id,
+		jobsCache:  jobsCache,
 		jobRunners: make(map[string]jobs.Runner),
 		logger:     newLogger(Org, config.LogProvider),
 		router:     mux.NewRouter(),
@@ -153,6 +211,13 @@ func NewServer(config common.Config) error {
 
 	for name, c := range config.Accounts {
 		log.Debugf("configuring account %s with %+v", name, c)
+
+		if c.Timezone != "" {
+			if _, err := time.LoadLocation(c.Timezone); err != nil {
+				return fmt.Errorf("invalid timezone '%s' for account %s: %s", c.Timezone, name, err)
+			}
+		}
+
 		s.accounts[name] = c
 		l.accounts[name] = c
 		e.accounts[name] = c
@@ -174,6 +239,28 @@ func NewServer(config common.Config) error {
 	s.jobRunners = jobRunners
 	e.jobRunners = jobRunners
 
+	s.jobRunnerTypes = make(map[string]string)
+	for name, c := range config.JobRunners {
+		s.jobRunnerTypes[name] = c.Type
+	}
+	e.pool = newExecuterPool(config.Executer, config.Accounts, config.JobRunners)
+
+	// configure the default retry policy and the retry policies of the runners
+	if e.defaultRetry, err = newRetryPolicy(config.Executer.Retry, jobs.DefaultRetryPolicy); err != nil {
+		return err
+	}
+
+	e.retryPolicies = make(map[string]jobs.RetryPolicy)
+	for name, c := range config.JobRunners {
+		if c.Retry == nil {
+			continue
+		}
+
+		if e.retryPolicies[name], err = newRetryPolicy(c.Retry, e.defaultRetry); err != nil {
+			return fmt.Errorf("invalid retry policy for runner %s: %s", name, err)
+		}
+	}
+
 	jobsRepository, err := newJobsRepository(Org, config.JobsRepository)
 	if err != nil {
 		return err
@@ -181,6 +268,17 @@ func NewServer(config common.Config) error {
 	s.jobsRepository = jobsRepository
 	l.jobsRepository = jobsRepository
 
+	runsRepository, err := newRunsRepository(Org, config.RunsRepository, config.JobsRepository)
+	if err != nil {
+		return err
+	}
+	s.runsRepository = runsRepository
+	e.runsRepository = runsRepository
+
+	if err := e.configureRetention(config.RunsRepository); err != nil {
+		return err
+	}
+
 	refreshInterval, err := time.ParseDuration(config.JobsRepository.RefreshInterval)
 	if err != nil {
 		return err
@@ -194,22 +292,94 @@ func NewServer(config common.Config) error {
 	}
 	d.locker = locker
 
+	// configure the tracking of scheduled jobs and the defaults for handling missed runs
+	tracker, err := newScheduleTracker(Org, config.LockProvider)
+	if err != nil {
+		return err
+	}
+	d.tracker = tracker
+	s.tracker = tracker
+
+	// configure the state runners keep between runs, ie. the desired count of a scaled down service
+	stateStore, err := newStateStore(Org, config.LockProvider)
+	if err != nil {
+		return err
+	}
+
+	for name, r := range jobRunners {
+		if stateful, ok := r.(jobs.Stateful); ok {
+			log.Debugf("setting state store for runner %s", name)
+			stateful.SetStateStore(stateStore)
+		}
+	}
+
+	if err := d.configureMisfire(config.Scheduler); err != nil {
+		return err
+	}
+
 	// load jobs from durable storage into the local cache
 	err = l.start(ctx)
 	if err != nil {
 		return err
 	}
 
+	// keep the local cache up to date with the changes made on the other nodes, if the queue supports it
+	if invalidator, ok := jobQueue.(jobs.Invalidator); ok {
+		if err := l.listen(ctx, invalidator); err != nil {
+			return err
+		}
+		s.invalidator = invalidator
+	} else {
+		log.Warnf("%s: job queue doesn't support invalidating jobs, changes are only loaded every %s", id, refreshInterval)
+	}
+
+	// start the requeuer if the queue supports recovering jobs
+	if recoverer, ok := jobQueue.(jobs.Recoverer); ok {
+		rq := requeuer{
+			id:        id,
+			locker:    locker,
+			recoverer: recoverer,
+		}
+
+		if err := rq.configure(config.Requeuer); err != nil {
+			return err
+		}
+
+		// running jobs renew their lease a few times before it expires
+		e.heartbeat = rq.lease / 3
+
+		rq.start(ctx)
+	} else {
+		log.Warnf("%s: job queue doesn't support recovering jobs, not starting requeuer", id)
+	}
+
 	// pop and execute jobs from the queue
-	e.start(ctx, time.Second)
+	e.start(ctx, runCtx, time.Second)
 
 	// start the job scheduler
 	if err := d.start(ctx); err != nil {
 		return err
 	}
 
-	// TODO build up and start requeuer that
-	// * checks the backup queue for jobs older than XX minutes and requeues them (assuming the runner died)
+	// start the purger if the jobs repository keeps deleted jobs in a trash
+	if trasher, ok := jobsRepository.(jobs.Trasher); ok {
+		p := purger{
+			accounts:       s.accounts,
+			id:             id,
+			locker:         locker,
+			runsRepository: runsRepository,
+			trasher:        trasher,
+		}
+
+		if err := p.configure(config.JobsRepository); err != nil {
+			return err
+		}
+		s.trashRetention = p.retention
+
+		p.start(ctx)
+	} else {
+		log.Warnf("%s: jobs repository doesn't support a trash, deleted jobs are removed permanently", id)
+	}
 
 	// load routes
 	s.routes()
@@ -218,7 +388,19 @@ func NewServer(config common.Config) error {
 		config.ListenAddress = ":8080"
 	}
 
-	handler := handlers.RecoveryHandler()(handlers.LoggingHandler(os.Stdout, TokenMiddleware([]byte(config.Token), publicURLs, s.router)))
+	signatures, err := NewSignatureVerifier(config.Signing)
+	if err != nil {
+		return err
+	}
+
+	// share the nonces of signed requests between the nodes, so a request can't be replayed against another node
+	if signatures != nil {
+		if signatures.Nonces, err = newNonceLocker(Org, config.LockProvider, signatures.MaxSkew); err != nil {
+			return err
+		}
+	}
+
+	handler := handlers.RecoveryHandler()(handlers.LoggingHandler(os.Stdout, TokenMiddleware([]byte(config.Token), publicURLs, signatures, s.router)))
 	srv := &http.Server{
 		Handler:      handler,
 		Addr:         config.ListenAddress,
@@ -226,11 +408,51 @@ func NewServer(config common.Config) error {
 		ReadTimeout:  15 * time.Second,
 	}
 
-	log.Infof("Starting listener on %s", config.ListenAddress)
-	if err := srv.ListenAndServe(); err != nil {
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Infof("Starting listener on %s", config.ListenAddress)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			serveErr <- err
+		}
+	}()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(sigs)
+
+	select {
+	case err := <-serveErr:
 		return err
+	case sig := <-sigs:
+		log.Infof("%s: received %s, shutting down", id, sig)
+	}
+
+	return shutdown(cancel, cancelRuns, shutdownTimeout, &e, srv)
+}
+
+// shutdown gracefully stops the server.  the scheduler, loader, requeuer, purger and executer loops are stopped, then
+// the in-flight jobs are given until the timeout to finish before they are interrupted and queued again.  finally
+// the http server is shut down.
+func shutdown(cancel, cancelRuns context.CancelFunc, timeout time.Duration, e *executer, srv *http.Server) error {
+	reportEvent(fmt.Sprintf("Shutting down minion (id: %s, org: %s)", e.id, Org), report.INFO)
+
+	// stop scheduling, loading, recovering and fetching jobs
+	cancel()
+
+	if !e.drain(timeout, cancelRuns) {
+		msg := fmt.Sprintf("%s: in-flight jobs didn't finish within %s, interrupted jobs were queued again", e.id, timeout)
+		log.Warn(msg)
+		reportEvent(msg, report.ERROR)
+	}
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shut down http server: %s", err)
 	}
 
+	log.Infof("%s: shutdown complete", e.id)
 	return nil
 }
 
@@ -267,85 +489,104 @@ func rollBack(t *[]func() error) {
 func newLocker(org string, lp common.LockProvider) (jobs.Locker, error) {
 	log.Debugf("configuring locker with %+v", lp)
 
-	var host string
-	if hi, ok := lp.Config["host"]; !ok {
-		return nil, errors.New("redis host is required")
-	} else {
-		if h, ok := hi.(string); !ok {
-			return nil, errors.New("redis host is required and must be a string")
-		} else {
-			host = h
-		}
+	address, password, db, err := redisConfig(lp.Config)
+	if err != nil {
+		return nil, err
 	}
 
-	var port string
-	if pi, ok := lp.Config["port"]; !ok {
-		return nil, errors.New("redis port is required")
-	} else {
-		log.Debugf("port interface exists %+v", pi)
+	lockerName := "minion-" + org + "-lock"
+	locker, err := jobs.NewRedisLocker(lockerName, address, password, db, "2m")
+	if err != nil {
+		return nil, err
+	}
+	return locker, nil
+}
 
-		if p, ok := pi.(string); ok {
-			port = p
-		}
+// newNonceLocker configures the locker for the nonces of signed requests.  It's stored alongside the locks and a
+// nonce is kept until no timestamp it could have been signed with is within the max skew anymore.
+func newNonceLocker(org string, lp common.LockProvider, maxSkew time.Duration) (jobs.Locker, error) {
+	log.Debugf("configuring nonce locker with %+v", lp)
 
-		if p, ok := pi.(float64); ok {
-			port = strconv.Itoa(int(p))
-		}
+	address, password, db, err := redisConfig(lp.Config)
+	if err != nil {
+		return nil, err
+	}
 
-		if port == "" {
-			return nil, errors.New("redis port is required")
-		}
+	nonceName := "minion-" + org + "-nonce"
+	locker, err := jobs.NewRedisLocker(nonceName, address, password, db, (2 * maxSkew).String())
+	if err != nil {
+		return nil, err
 	}
+	return locker, nil
+}
 
-	address := host + ":" + port
+// newScheduleTracker configures the tracker for the last scheduled time of jobs.  It's stored alongside the locks.
+func newScheduleTracker(org string, lp common.LockProvider) (jobs.ScheduleTracker, error) {
+	log.Debugf("configuring schedule tracker with %+v", lp)
 
-	var password string
-	if pass, ok := lp.Config["password"]; ok {
-		if p, ok := pass.(string); ok {
-			password = p
-		}
+	address, password, db, err := redisConfig(lp.Config)
+	if err != nil {
+		return nil, err
 	}
 
-	var db int
-	if database, ok := lp.Config["database"]; ok {
-		if d, ok := database.(string); ok {
-			if i, err := strconv.ParseInt(d, 10, 64); err != nil {
-				log.Warnf("database '%s' is not parsable as an integer, ignoring", d)
-			} else {
-				db = int(i)
-			}
-		} else if d, ok := database.(float64); ok {
-			db = int(d)
-		} else {
-			log.Warnf("database '%v' is not a string or integer, ignoring", database)
-		}
+	trackerName := "minion-" + org + "-schedule"
+	tracker, err := jobs.NewRedisScheduleTracker(trackerName, address, password, db)
+	if err != nil {
+		return nil, err
 	}
+	return tracker, nil
+}
 
-	lockerName := "minion-" + org + "-lock"
-	locker, err := jobs.NewRedisLocker(lockerName, address, password, db, "2m")
+// newStateStore configures the store for the state of the runners.  It's stored alongside the locks.
+func newStateStore(org string, lp common.LockProvider) (jobs.StateStore, error) {
+	log.Debugf("configuring state store with %+v", lp)
+
+	address, password, db, err := redisConfig(lp.Config)
 	if err != nil {
 		return nil, err
 	}
-	return locker, nil
+
+	stateName := "minion-" + org + "-state"
+	store, err := jobs.NewRedisStateStore(stateName, address, password, db)
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
 }
 
 func newJobQueue(org string, qp common.QueueProvider) (jobs.Queuer, error) {
 	log.Debugf("configuring queue with %+v", qp)
 
+	address, password, db, err := redisConfig(qp.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	// setup job queue
+	queueName := "minion-" + org + "-queue"
+	queue, err := jobs.NewRedisQueuer(queueName, address, password, db, 10)
+	if err != nil {
+		return nil, err
+	}
+	return queue, nil
+}
+
+// redisConfig parses the address, password and database from a redis provider configuration
+func redisConfig(config map[string]interface{}) (string, string, int, error) {
 	var host string
-	if hi, ok := qp.Config["host"]; !ok {
-		return nil, errors.New("redis host is required")
+	if hi, ok := config["host"]; !ok {
+		return "", "", 0, errors.New("redis host is required")
 	} else {
 		if h, ok := hi.(string); !ok {
-			return nil, errors.New("redis host is required and must be a string")
+			return "", "", 0, errors.New("redis host is required and must be a string")
 		} else {
 			host = h
 		}
 	}
 
 	var port string
-	if pi, ok := qp.Config["port"]; !ok {
-		return nil, errors.New("redis port is required")
+	if pi, ok := config["port"]; !ok {
+		return "", "", 0, errors.New("redis port is required")
 	} else {
 		log.Debugf("port interface exists %+v", pi)
 
@@ -358,21 +599,21 @@ func newJobQueue(org string, qp common.QueueProvider) (jobs.Queuer, error) {
 		}
 
 		if port == "" {
-			return nil, errors.New("redis port is required")
+			return "", "", 0, errors.New("redis port is required")
 		}
 	}
 
 	address := host + ":" + port
 
 	var password string
-	if pass, ok := qp.Config["password"]; ok {
+	if pass, ok := config["password"]; ok {
 		if p, ok := pass.(string); ok {
 			password = p
 		}
 	}
 
 	var db int
-	if database, ok := qp.Config["database"]; ok {
+	if database, ok := config["database"]; ok {
 		if d, ok := database.(string); ok {
 			if i, err := strconv.ParseInt(d, 10, 64); err != nil {
 				log.Warnf("database '%s' is not parsable as an integer, ignoring", d)
@@ -386,13 +627,7 @@ func newJobQueue(org string, qp common.QueueProvider) (jobs.Queuer, error) {
 		}
 	}
 
-	// setup job queue
-	queueName := "minion-" + org + "-queue"
-	queue, err := jobs.NewRedisQueuer(queueName, address, password, db, 10)
-	if err != nil {
-		return nil, err
-	}
-	return queue, nil
+	return address, password, db, nil
 }
 
 func newJobsRepository(org string, repo common.JobsRepository) (jobs.Repository, error) {
@@ -407,60 +642,146 @@ func newJobsRepository(org string, repo common.JobsRepository) (jobs.Repository,
 		}
 		jr.Prefix = jr.Prefix + "/" + org
 		return jr, nil
+	case "file":
+		jr, err := jobs.NewFileRepository(repo.Config)
+		if err != nil {
+			return nil, err
+		}
+		jr.Prefix = jr.Prefix + "/" + org
+		return jr, nil
+	case "sql":
+		jr, err := jobs.NewSQLRepository(repo.Config)
+		if err != nil {
+			return nil, err
+		}
+		jr.Prefix = jr.Prefix + "/" + org
+		return jr, nil
 	}
 
 	return nil, errors.New("failed to determine jobs repository type, or type not supported: " + repo.Type)
 }
 
-func newJobRunners(org string, runners map[string]common.JobRunner) (map[string]jobs.Runner, error) {
-	jobRunners := make(map[string]jobs.Runner)
-	for name, c := range runners {
-		log.Debugf("configuring job runner %s with %+v", name, c)
+func newRunsRepository(org string, repo common.RunsRepository, jobsRepo common.JobsRepository) (jobs.RunsRepository, error) {
+	// default to storing runs alongside the jobs
+	if repo.Type == "" {
+		repo.Type = jobsRepo.Type
+		repo.Config = make(map[string]interface{})
+		for k, v := range jobsRepo.Config {
+			repo.Config[k] = v
+		}
 
-		switch c.Type {
-		case "dummy":
-			r, err := jobs.NewDummyRunner(c.Config)
-			if err != nil {
-				return nil, err
-			}
-			jobRunners[name] = r
+		prefix, _ := repo.Config["prefix"].(string)
+		repo.Config["prefix"] = prefix + "-runs"
+	}
 
-			log.Infof("configured new dummy runner %s", name)
-		case "instance":
-			r, err := jobs.NewInstanceRunner(c.Config)
-			if err != nil {
-				return nil, err
-			}
-			jobRunners[name] = r
+	log.Debugf("Creating new RunsRepository of type %s with configuration %+v (org: %s)", repo.Type, repo.Config, org)
 
-			log.Infof("configured new instance runner %s", name)
-		case "service":
-			r, err := jobs.NewServiceRunner(c.Config)
-			if err != nil {
-				return nil, err
-			}
-			jobRunners[name] = r
+	switch repo.Type {
+	case "s3":
+		rr, err := jobs.NewDefaultRunsRepository(repo.Config)
+		if err != nil {
+			return nil, err
+		}
+		rr.Prefix = rr.Prefix + "/" + org
+		return rr, nil
+	case "file":
+		rr, err := jobs.NewFileRunsRepository(repo.Config)
+		if err != nil {
+			return nil, err
+		}
+		rr.Prefix = rr.Prefix + "/" + org
+		return rr, nil
+	case "sql":
+		rr, err := jobs.NewSQLRunsRepository(repo.Config)
+		if err != nil {
+			return nil, err
+		}
+		rr.Prefix = rr.Prefix + "/" + org
+		return rr, nil
+	}
 
-			log.Infof("configured new service runner %s", name)
-		case "task":
-			r, err := jobs.NewTaskRunner(c.Config)
-			if err != nil {
-				return nil, err
-			}
-			jobRunners[name] = r
+	return nil, errors.New("failed to determine runs repository type, or type not supported: " + repo.Type)
+}
 
-			log.Infof("configured new task runner %s", name)
-		case "database":
-			r, err := jobs.NewDatabaseRunner(c.Config)
-			if err != nil {
-				return nil, err
-			}
-			jobRunners[name] = r
+// newExecuterPool returns the pool limiting the jobs running at once in the executer
+func newExecuterPool(c common.Executer, accounts map[string]common.Account, runners map[string]common.JobRunner) *pool {
+	size := c.Concurrency
+	if size <= 0 {
+		size = 10
+	}
+
+	accountLimits := make(map[string]int)
+	for name, a := range accounts {
+		accountLimits[name] = c.AccountConcurrency
+		if a.Concurrency > 0 {
+			accountLimits[name] = a.Concurrency
+		}
+	}
+
+	runnerLimits := make(map[string]int)
+	for name, r := range runners {
+		runnerLimits[name] = r.Concurrency
+	}
+
+	log.Infof("configured executer pool of size %d with runner limits %+v and account limits %+v", size, runnerLimits, accountLimits)
+
+	return newPool(size, runnerLimits, accountLimits)
+}
+
+// newRetryPolicy returns the retry policy from the configuration, with the unset fields taken from the defaults
+func newRetryPolicy(c *common.Retry, defaults jobs.RetryPolicy) (jobs.RetryPolicy, error) {
+	if c == nil {
+		return defaults, nil
+	}
+
+	policy := jobs.RetryPolicy{
+		MaxAttempts: c.MaxAttempts,
+		Backoff:     c.Backoff,
+		Jitter:      c.Jitter,
+		RetryOn:     c.RetryOn,
+	}
+
+	durations := []struct {
+		name  string
+		value string
+		field *time.Duration
+	}{
+		{"delay", c.Delay, &policy.Delay},
+		{"maxDelay", c.MaxDelay, &policy.MaxDelay},
+	}
+
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return policy, fmt.Errorf("invalid retry %s '%s': %s", d.name, d.value, err)
+		}
+		*d.field = v
+	}
+
+	if err := policy.Validate(); err != nil {
+		return policy, err
+	}
+
+	return policy.WithDefaults(defaults), nil
+}
 
-			log.Infof("configured new database runner %s", name)
-		default:
-			return nil, errors.New("failed to determine jobs runner type, or type not supported: " + c.Type)
+// newJobRunners creates the configured job runners from the registered runner types
+func newJobRunners(org string, runners map[string]common.JobRunner) (map[string]jobs.Runner, error) {
+	jobRunners := make(map[string]jobs.Runner)
+	for name, c := range runners {
+		log.Debugf("configuring job runner %s with %+v", name, c)
+
+		r, err := jobs.NewRunner(c.Type, c.Config)
+		if err != nil {
+			return nil, err
 		}
+		jobRunners[name] = r
+
+		log.Infof("configured new %s runner %s", c.Type, name)
 	}
 
 	return jobRunners, nil
@@ -11,3 +11,15 @@ type JobsResponse struct {
 	Log  *cloudwatchlogs.LogGroup `json:"log"`
 	Next string                   `json:"next"`
 }
+
+// RunnerResponse is a configured job runner and the job details it accepts
+type RunnerResponse struct {
+	Name string `json:"name"`
+	jobs.RunnerDescription
+}
+
+// TrashResponse is a deleted job in the trash and the time it expires and is purged
+type TrashResponse struct {
+	*jobs.TrashedJob
+	Expires string `json:"expires"`
+}
@@ -12,6 +12,7 @@ import (
 type Config struct {
 	Accounts       map[string]Account
 	EventReporters map[string]EventReporterConfig
+	Executer       Executer
 	JobsRepository JobsRepository
 	JobRunners     map[string]JobRunner
 	ListenAddress  string
@@ -20,26 +21,80 @@ type Config struct {
 	Token          string
 	LogLevel       string
 	QueueProvider  QueueProvider
-	Version        Version
-	Org            string
+	Requeuer       Requeuer
+	RunsRepository RunsRepository
+	Scheduler      Scheduler
+	// Signing accepts requests signed with the token instead of sending the hashed token
+	Signing *Signing
+	// ShutdownTimeout is how long (ie. 30s) in-flight jobs have to finish when shutting down
+	ShutdownTimeout string
+	Version         Version
+	Org             string
 }
 
 type EventReporterConfig map[string]string
 
 // Account is the configuration for an individual account
 type Account struct {
-	Runners []string
+	// Concurrency is the number of jobs in the account that can run at once, overriding the executer default
+	Concurrency int
+	Runners     []string
+	// Timezone is the default IANA timezone for jobs in the account that don't set one
+	Timezone string
 }
 
 type JobRunner struct {
-	Type   string
+	Type string
+	// Concurrency is the number of jobs using the runner that can run at once
+	Concurrency int
+	// Retry is the default retry policy for jobs using the runner
+	Retry  *Retry
 	Config map[string]interface{}
 }
 
+// Executer is the configuration for running jobs pulled off of the queue
+type Executer struct {
+	// Concurrency is the number of jobs that can run at once
+	Concurrency int
+	// AccountConcurrency is the default number of jobs in an account that can run at once
+	AccountConcurrency int
+	// Retry is the default retry policy for jobs
+	Retry *Retry
+}
+
+// Retry is the configuration for retrying failed jobs
+type Retry struct {
+	// MaxAttempts is the total number of attempts at running a job
+	MaxAttempts int
+	// Backoff is the backoff strategy between attempts, fixed or exponential
+	Backoff string
+	// Delay is the wait (ie. 30s) before the first retry
+	Delay string
+	// MaxDelay is the longest wait (ie. 1h) between retries with exponential backoff
+	MaxDelay string
+	// Jitter randomizes the wait between retries by up to the given fraction of it (0-1)
+	Jitter float64
+	// RetryOn is the list of runner error codes, http statuses and http status classes (ie. 5xx) that are retried
+	RetryOn []string
+}
+
 type JobsRepository struct {
 	Type            string
 	RefreshInterval string
-	Config          map[string]interface{}
+	// TrashRetention is how long (ie. 720h) deleted jobs are kept in the trash before they are purged
+	TrashRetention string
+	Config         map[string]interface{}
+}
+
+// RunsRepository is the durable storage for the history of job runs.  If it's not configured,
+// the jobs repository configuration is used with the prefix suffixed with '-runs'.
+type RunsRepository struct {
+	Type string
+	// MaxRuns is the number of runs kept per job, 1000 by default and unlimited if it's negative
+	MaxRuns int
+	// MaxAge is how long (ie. 2160h) the runs of a job are kept, unlimited by default
+	MaxAge string
+	Config map[string]interface{}
 }
 
 type LockProvider struct {
@@ -59,6 +114,38 @@ type QueueProvider struct {
 	Config map[string]interface{}
 }
 
+// Requeuer is the configuration for recovering queued jobs that were fetched but never finalized
+type Requeuer struct {
+	// Interval is how often (ie. 1m) to look for expired jobs
+	Interval string
+	// Lease is how long (ie. 15m) a fetched job has to finish before it's recovered
+	Lease string
+	// MaxAge is how long after the lease expired a job is still requeued instead of dead lettered
+	MaxAge string
+	// MaxAttempts is the number of attempts at finishing a run that's never finalized before it's dead lettered,
+	// counted apart from the retries of failed runs
+	MaxAttempts int
+}
+
+// Scheduler is the configuration for the job scheduler.  The misfire settings are the defaults
+// for jobs that don't set their own misfire policy.
+type Scheduler struct {
+	// MisfirePolicy is what to do with missed job runs: skip, run_once or run_all
+	MisfirePolicy string
+	// MisfireGrace is how far back (ie. 30m) to look for missed job runs
+	MisfireGrace string
+	// MisfireLimit is the maximum number of missed runs enqueued by the run_all policy
+	MisfireLimit int
+}
+
+// Signing is the configuration for accepting HMAC signed requests to the api
+type Signing struct {
+	// MaxSkew is how far (ie. 5m) the timestamp of a signed request can be from the server time
+	MaxSkew string
+	// Required rejects requests that send the hashed token instead of a signature
+	Required bool
+}
+
 // Version carries around the API version information
 type Version struct {
 	Version    string
@@ -0,0 +1,275 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultBatchParallelism is the default number of targets of a batch job acted on at once
+const DefaultBatchParallelism = 5
+
+// Batch acts on a list of targets, ie. instances, with bounded parallelism.  The targets are a comma separated
+// list of ids in the job details or are looked up with a selector from the selector endpoint.  A nil Batch acts
+// with the default parallelism and doesn't support selectors.
+type Batch struct {
+	Parallelism              int
+	SelectorEndpointTemplate string
+	SelectorIDField          string
+	Client                   *HTTPClient
+}
+
+// TargetResult is the result of acting on one target of a batch
+type TargetResult struct {
+	Target string
+	Output string
+	Err    error
+}
+
+// newBatch configures a batch from a runner configuration
+func newBatch(config map[string]interface{}) (*Batch, error) {
+	b := &Batch{
+		Parallelism:     DefaultBatchParallelism,
+		SelectorIDField: "id",
+	}
+
+	if v, ok := config["parallelism"]; ok {
+		p, ok := configInt(v)
+		if !ok || p <= 0 {
+			return nil, fmt.Errorf("invalid parallelism %v", v)
+		}
+		b.Parallelism = p
+	}
+
+	if v, ok := config["selectorEndpointTemplate"].(string); ok {
+		b.SelectorEndpointTemplate = v
+	}
+
+	if v, ok := config["selectorIdField"].(string); ok && v != "" {
+		b.SelectorIDField = v
+	}
+
+	return b, nil
+}
+
+// validate records a problem unless exactly one of the id, ids or selector parameters is set
+func (b *Batch) validate(v *validator, idKey, idsKey, selectorKey string) {
+	set := []string{}
+	for _, k := range []string{idKey, idsKey, selectorKey} {
+		if v.params[k] != "" {
+			set = append(set, k)
+		}
+	}
+
+	switch {
+	case len(set) == 0:
+		v.problem("missing %s, %s or %s", idKey, idsKey, selectorKey)
+	case len(set) > 1:
+		v.problem("only one of %s can be set", strings.Join(set, ", "))
+	case set[0] == idsKey && len(splitTargets(v.params[idsKey])) == 0:
+		v.problem("%s must be a comma separated list of ids", idsKey)
+	case set[0] == selectorKey && (b == nil || b.SelectorEndpointTemplate == ""):
+		v.problem("%s is not supported, the runner has no selectorEndpointTemplate", selectorKey)
+	}
+}
+
+// batched returns true if the job acts on a list of targets instead of a single target
+func (b *Batch) batched(ctx context.Context, params map[string]string, idsKey, selectorKey string) bool {
+	return len(Targets(ctx)) > 0 || params[idsKey] != "" || params[selectorKey] != ""
+}
+
+// targets returns the targets of a batch job.  If the run is a retry of the failed targets of an earlier
+// run, those are returned, otherwise the list of ids is split or the selector is looked up.
+func (b *Batch) targets(ctx context.Context, account string, params map[string]string, idsKey, selectorKey string, authorize func(*http.Request) error) ([]string, error) {
+	if targets := Targets(ctx); len(targets) > 0 {
+		log.Debugf("using the targets %v of the earlier run", targets)
+		return targets, nil
+	}
+
+	if ids := params[idsKey]; ids != "" {
+		return splitTargets(ids), nil
+	}
+
+	return b.lookup(ctx, account, params[selectorKey], authorize)
+}
+
+// lookup resolves a selector to a list of targets with the selector endpoint.  The response is a JSON list
+// of ids or of objects with the id in the selector id field.
+func (b *Batch) lookup(ctx context.Context, account, selector string, authorize func(*http.Request) error) ([]string, error) {
+	if b == nil || b.SelectorEndpointTemplate == "" {
+		return nil, NewRunnerError(ErrPreExecFailure, "selectors are not supported, the runner has no selectorEndpointTemplate", nil)
+	}
+
+	input := struct {
+		Account  string
+		Selector string
+	}{account, selector}
+
+	endpoint, err := execEndpointTemplate(b.SelectorEndpointTemplate, &input)
+	if err != nil {
+		return nil, NewRunnerError(ErrPreExecFailure, "failed to set selector endpoint", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, NewRunnerError(ErrPreExecFailure, "building http request failed", err)
+	}
+
+	if authorize != nil {
+		if err := authorize(req); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Infof("looking up targets for selector '%s' in account %s", selector, account)
+
+	res, err := b.Client.Do(req)
+	if err != nil {
+		return nil, NewRunnerError(ErrExecFailure, "selector lookup failed", err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, NewRunnerError(ErrExecFailure, "reading selector lookup response failed", err)
+	}
+
+	if res.StatusCode >= 300 {
+		return nil, NewRunnerStatusError(ErrExecFailure, "unexpected http response", res.StatusCode, errors.New("unexpected response from selector lookup: "+res.Status))
+	}
+
+	var list []interface{}
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, NewRunnerError(ErrExecFailure, "failed to decode selector lookup response", err)
+	}
+
+	targets := make([]string, 0, len(list))
+	for _, l := range list {
+		switch t := l.(type) {
+		case string:
+			targets = append(targets, t)
+		case map[string]interface{}:
+			id, ok := t[b.SelectorIDField].(string)
+			if !ok || id == "" {
+				return nil, NewRunnerError(ErrExecFailure, fmt.Sprintf("selector lookup response is missing %s", b.SelectorIDField), nil)
+			}
+			targets = append(targets, id)
+		default:
+			return nil, NewRunnerError(ErrExecFailure, fmt.Sprintf("unexpected selector lookup response %v", l), nil)
+		}
+	}
+
+	log.Debugf("selector '%s' matched targets %v", selector, targets)
+
+	return targets, nil
+}
+
+// run acts on each of the targets with at most the configured number at once.  If any of the targets fail,
+// a RunnerError listing every result is returned with the failed targets, so a retry can act on just those.
+func (b *Batch) run(ctx context.Context, targets []string, act func(ctx context.Context, target string) (string, error)) (*Result, error) {
+	if len(targets) == 0 {
+		return &Result{Message: "no targets to act on"}, nil
+	}
+
+	parallelism := DefaultBatchParallelism
+	if b != nil && b.Parallelism > 0 {
+		parallelism = b.Parallelism
+	}
+
+	results := make([]TargetResult, len(targets))
+	sem := make(chan struct{}, parallelism)
+
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target string) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			out, err := act(ctx, target)
+			results[i] = TargetResult{Target: target, Output: out, Err: err}
+		}(i, target)
+	}
+	wg.Wait()
+
+	return batchResult(results)
+}
+
+// batchResult reports the result of each target, with the status of each target in the result data.  If any
+// of the targets failed, a RunnerError is returned with the failed targets.  The error code and status code are
+// those of the failed targets if they're all the same.
+func batchResult(results []TargetResult) (*Result, error) {
+	result := &Result{Targets: make([]string, 0, len(results))}
+	lines := make([]string, 0, len(results))
+	var failed []string
+	var code string
+	var statusCode int
+
+	for i, r := range results {
+		result.Targets = append(result.Targets, r.Target)
+
+		if r.Err == nil {
+			lines = append(lines, fmt.Sprintf("%s: succeeded: %s", r.Target, strings.TrimSpace(r.Output)))
+			result.SetData(r.Target, ResultStatusSucceeded)
+			continue
+		}
+
+		lines = append(lines, fmt.Sprintf("%s: failed: %s", r.Target, r.Err))
+		result.SetData(r.Target, ResultStatusFailed)
+
+		c, s := ErrExecFailure, 0
+		var rErr RunnerError
+		if errors.As(r.Err, &rErr) {
+			c, s = rErr.Code, rErr.StatusCode
+		}
+
+		if len(failed) == 0 {
+			code, statusCode = c, s
+		} else {
+			if code != c {
+				code = ErrExecFailure
+			}
+			if statusCode != s {
+				statusCode = 0
+			}
+		}
+
+		failed = append(failed, results[i].Target)
+	}
+
+	result.Message = strings.Join(lines, "\n")
+	if len(failed) == 0 {
+		return result, nil
+	}
+
+	sort.Strings(failed)
+
+	err := NewRunnerStatusError(code, fmt.Sprintf("%d of %d targets failed\n%s", len(failed), len(results), result.Message), statusCode, nil)
+	err.Targets = failed
+
+	result.Message = err.Message
+	result.StatusCode = statusCode
+
+	return result, err
+}
+
+// splitTargets splits a comma separated list of targets, ignoring empty entries
+func splitTargets(list string) []string {
+	var targets []string
+	for _, t := range strings.Split(list, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			targets = append(targets, t)
+		}
+	}
+	return targets
+}
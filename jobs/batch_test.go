@@ -0,0 +1,333 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewBatch(t *testing.T) {
+	out, err := newBatch(map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	expected := &Batch{Parallelism: DefaultBatchParallelism, SelectorIDField: "id"}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("expected %+v, got %+v", expected, out)
+	}
+
+	for _, p := range []interface{}{float64(0), float64(-1), "lots"} {
+		if _, err := newBatch(map[string]interface{}{"parallelism": p}); err == nil {
+			t.Errorf("expected error for parallelism %v, got nil", p)
+		}
+	}
+
+	out, err = newBatch(map[string]interface{}{
+		"parallelism":              float64(2),
+		"selectorEndpointTemplate": "http://example.com/{{.Account}}?tag={{.Selector}}",
+		"selectorIdField":          "InstanceId",
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	expected = &Batch{
+		Parallelism:              2,
+		SelectorEndpointTemplate: "http://example.com/{{.Account}}?tag={{.Selector}}",
+		SelectorIDField:          "InstanceId",
+	}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("expected %+v, got %+v", expected, out)
+	}
+}
+
+func TestBatchValidate(t *testing.T) {
+	tests := []struct {
+		batch    *Batch
+		params   map[string]string
+		problems []string
+	}{
+		{
+			batch:  nil,
+			params: map[string]string{"instance_id": "i-1"},
+		},
+		{
+			batch:  nil,
+			params: map[string]string{"instance_ids": "i-1, i-2"},
+		},
+		{
+			batch:    nil,
+			params:   map[string]string{},
+			problems: []string{"missing instance_id, instance_ids or instance_selector"},
+		},
+		{
+			batch:    nil,
+			params:   map[string]string{"instance_id": "i-1", "instance_ids": "i-2"},
+			problems: []string{"only one of instance_id, instance_ids can be set"},
+		},
+		{
+			batch:    nil,
+			params:   map[string]string{"instance_ids": " , "},
+			problems: []string{"instance_ids must be a comma separated list of ids"},
+		},
+		{
+			batch:    nil,
+			params:   map[string]string{"instance_selector": "env=dev"},
+			problems: []string{"instance_selector is not supported, the runner has no selectorEndpointTemplate"},
+		},
+		{
+			batch:  &Batch{SelectorEndpointTemplate: "http://example.com"},
+			params: map[string]string{"instance_selector": "env=dev"},
+		},
+	}
+
+	for _, test := range tests {
+		v := newValidator(test.params)
+		test.batch.validate(v, "instance_id", "instance_ids", "instance_selector")
+		if !reflect.DeepEqual(v.problems, test.problems) {
+			t.Errorf("expected problems %v for %+v, got %v", test.problems, test.params, v.problems)
+		}
+	}
+}
+
+func TestBatchTargets(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/myaccount/ids", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Auth-Token") != "my-awesome-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if r.URL.Query().Get("tag") != "env=dev" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		fmt.Fprint(w, `["i-1", "i-2"]`)
+	})
+	mux.HandleFunc("/myaccount/objects", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"InstanceId": "i-3"}, {"InstanceId": "i-4"}]`)
+	})
+	mux.HandleFunc("/myaccount/broken", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"id": "i-5"}]`)
+	})
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	authorize := func(req *http.Request) error {
+		req.Header.Set("X-Auth-Token", "my-awesome-token")
+		return nil
+	}
+
+	b := &Batch{
+		Parallelism:              DefaultBatchParallelism,
+		SelectorEndpointTemplate: ts.URL + "/{{.Account}}/ids?tag={{.Selector}}",
+		SelectorIDField:          "InstanceId",
+	}
+
+	tests := []struct {
+		ctx     context.Context
+		params  map[string]string
+		targets []string
+	}{
+		{
+			ctx:     context.TODO(),
+			params:  map[string]string{"instance_ids": "i-1, i-2,,i-3"},
+			targets: []string{"i-1", "i-2", "i-3"},
+		},
+		{
+			ctx:     context.TODO(),
+			params:  map[string]string{"instance_selector": "env=dev"},
+			targets: []string{"i-1", "i-2"},
+		},
+		{
+			ctx:     WithTargets(context.TODO(), []string{"i-2"}),
+			params:  map[string]string{"instance_ids": "i-1, i-2"},
+			targets: []string{"i-2"},
+		},
+	}
+
+	for _, test := range tests {
+		out, err := b.targets(test.ctx, "myaccount", test.params, "instance_ids", "instance_selector", authorize)
+		if err != nil {
+			t.Errorf("expected nil error for %+v, got %s", test.params, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(out, test.targets) {
+			t.Errorf("expected targets %v for %+v, got %v", test.targets, test.params, out)
+		}
+	}
+
+	b.SelectorEndpointTemplate = ts.URL + "/{{.Account}}/objects"
+	out, err := b.lookup(context.TODO(), "myaccount", "env=dev", nil)
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if expected := []string{"i-3", "i-4"}; !reflect.DeepEqual(out, expected) {
+		t.Errorf("expected targets %v, got %v", expected, out)
+	}
+
+	b.SelectorEndpointTemplate = ts.URL + "/{{.Account}}/broken"
+	if _, err := b.lookup(context.TODO(), "myaccount", "env=dev", nil); err == nil {
+		t.Error("expected error for lookup response without ids, got nil")
+	}
+
+	b.SelectorEndpointTemplate = ts.URL + "/{{.Account}}/ids?tag={{.Selector}}"
+	var rErr RunnerError
+	if _, err := b.lookup(context.TODO(), "myaccount", "env=dev", nil); !errors.As(err, &rErr) || rErr.StatusCode != http.StatusForbidden {
+		t.Errorf("expected forbidden error for unauthorized lookup, got %v", err)
+	}
+
+	var nilBatch *Batch
+	if _, err := nilBatch.lookup(context.TODO(), "myaccount", "env=dev", nil); !errors.As(err, &rErr) || rErr.Code != ErrPreExecFailure {
+		t.Errorf("expected %s error without selector endpoint, got %v", ErrPreExecFailure, err)
+	}
+}
+
+func TestBatchRun(t *testing.T) {
+	b := &Batch{Parallelism: 2}
+
+	var mux sync.Mutex
+	running, max := 0, 0
+
+	result, err := b.run(context.TODO(), []string{"i-1", "i-2", "i-3", "i-4", "i-5"}, func(ctx context.Context, target string) (string, error) {
+		mux.Lock()
+		running++
+		if running > max {
+			max = running
+		}
+		mux.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mux.Lock()
+		running--
+		mux.Unlock()
+
+		return "OK", nil
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if max > 2 {
+		t.Errorf("expected at most 2 targets at once, got %d", max)
+	}
+
+	expected := "i-1: succeeded: OK\ni-2: succeeded: OK\ni-3: succeeded: OK\ni-4: succeeded: OK\ni-5: succeeded: OK"
+	if result.Message != expected {
+		t.Errorf("expected output '%s', got '%s'", expected, result.Message)
+	}
+
+	if !reflect.DeepEqual(result.Targets, []string{"i-1", "i-2", "i-3", "i-4", "i-5"}) || result.Data["i-3"] != ResultStatusSucceeded {
+		t.Errorf("expected every target to be reported, got %+v", result)
+	}
+
+	if result, err := b.run(context.TODO(), nil, nil); err != nil || result.Message != "no targets to act on" {
+		t.Errorf("expected no targets output, got %+v, %v", result, err)
+	}
+}
+
+func TestBatchResult(t *testing.T) {
+	tests := []struct {
+		results    []TargetResult
+		code       string
+		statusCode int
+		targets    []string
+	}{
+		{
+			results: []TargetResult{
+				{Target: "i-1", Output: "OK"},
+				{Target: "i-3", Err: NewRunnerStatusError(ErrExecFailure, "unexpected http response", 503, nil)},
+				{Target: "i-2", Err: NewRunnerStatusError(ErrExecFailure, "unexpected http response", 503, nil)},
+			},
+			code:       ErrExecFailure,
+			statusCode: 503,
+			targets:    []string{"i-2", "i-3"},
+		},
+		{
+			results: []TargetResult{
+				{Target: "i-1", Err: NewRunnerError(ErrPreExecFailure, "template execution failed", nil)},
+				{Target: "i-2", Err: NewRunnerStatusError(ErrExecFailure, "unexpected http response", 404, nil)},
+			},
+			code:       ErrExecFailure,
+			statusCode: 0,
+			targets:    []string{"i-1", "i-2"},
+		},
+		{
+			results: []TargetResult{
+				{Target: "i-1", Err: errors.New("boom")},
+			},
+			code:    ErrExecFailure,
+			targets: []string{"i-1"},
+		},
+	}
+
+	for _, test := range tests {
+		result, err := batchResult(test.results)
+
+		var rErr RunnerError
+		if !errors.As(err, &rErr) {
+			t.Errorf("expected RunnerError, got %v", err)
+			continue
+		}
+
+		if rErr.Code != test.code || rErr.StatusCode != test.statusCode {
+			t.Errorf("expected code %s (%d), got %s (%d)", test.code, test.statusCode, rErr.Code, rErr.StatusCode)
+		}
+
+		if !reflect.DeepEqual(rErr.Targets, test.targets) {
+			t.Errorf("expected failed targets %v, got %v", test.targets, rErr.Targets)
+		}
+
+		prefix := fmt.Sprintf("%d of %d targets failed\n", len(test.targets), len(test.results))
+		if !strings.HasPrefix(rErr.Message, prefix) {
+			t.Errorf("expected message to start with '%s', got '%s'", prefix, rErr.Message)
+		}
+
+		for _, r := range test.results {
+			if !strings.Contains(rErr.Message, r.Target+": ") {
+				t.Errorf("expected message to report %s, got '%s'", r.Target, rErr.Message)
+			}
+
+			status := ResultStatusSucceeded
+			if r.Err != nil {
+				status = ResultStatusFailed
+			}
+
+			if result.Data[r.Target] != status {
+				t.Errorf("expected result status %s for %s, got %+v", status, r.Target, result.Data)
+			}
+		}
+
+		if result.Message != rErr.Message || result.StatusCode != test.statusCode {
+			t.Errorf("expected result with the error message and status code, got %+v", result)
+		}
+	}
+}
+
+func TestSplitTargets(t *testing.T) {
+	tests := map[string][]string{
+		"":                 nil,
+		" , ":              nil,
+		"i-1":              {"i-1"},
+		"i-1, i-2 ,, i-3 ": {"i-1", "i-2", "i-3"},
+	}
+
+	for list, expected := range tests {
+		if out := splitTargets(list); !reflect.DeepEqual(out, expected) {
+			t.Errorf("expected %v for '%s', got %v", expected, list, out)
+		}
+	}
+}
@@ -8,8 +8,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
 	"text/template"
-	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -18,6 +18,24 @@ type DatabaseRunner struct {
 	Endpoint         string
 	EndpointTemplate string
 	Token            string
+	Verifier         *StateVerifier
+	Batch            *Batch
+	Client           *HTTPClient
+	Signer           *Signer
+}
+
+func init() {
+	RegisterRunner(RunnerType{
+		RunnerDescription: RunnerDescription{
+			Type:            "database",
+			Description:     "changes the state of a database instance or a batch of database instances",
+			Actions:         []string{"stop", "start"},
+			RequiredDetails: []string{"database_action", "instance_id|instance_ids|instance_selector"},
+		},
+		New: func(config map[string]interface{}) (Runner, error) {
+			return NewDatabaseRunner(config)
+		},
+	})
 }
 
 // NewDatabaseRunner creates and configures a new database runner.  An endpoint or endpoint template is required
@@ -47,56 +65,131 @@ func NewDatabaseRunner(config map[string]interface{}) (*DatabaseRunner, error) {
 		token = v
 	}
 
+	signer, err := newSigner(config, token)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newHTTPClient(config["http"])
+	if err != nil {
+		return nil, err
+	}
+
+	verifier, err := newStateVerifier(config["verify"], map[string]string{
+		"start": "available",
+		"stop":  "stopped",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	batch, err := newBatch(config)
+	if err != nil {
+		return nil, err
+	}
+	batch.Client = client
+
+	if verifier != nil {
+		verifier.Client = client
+	}
+
 	return &DatabaseRunner{
 		Endpoint:         endpoint,
 		EndpointTemplate: endpointTemplate,
 		Token:            token,
+		Verifier:         verifier,
+		Batch:            batch,
+		Client:           client,
+		Signer:           signer,
 	}, nil
 }
 
-// Run executes the DatabaseRunner.  The instance_id and database_action are required.  Allowable actions
-// are 'start' and 'stop'.  If an endpoint is configured on the runner, it will be used, otherwise
-// we assume there is an endpointTemplate and try to execute it.  Database actions are currently only
-// executed with the PUT method and a body containing {"state": "action"}.
+// Validate validates the DatabaseRunner parameters.  The database_action and one of instance_id, instance_ids or
+// instance_selector are required and the database_action must be 'start' or 'stop'.  The optional verify parameter can only be 'true' if verification
+// is configured for the runner.
+func (r *DatabaseRunner) Validate(parameters interface{}) error {
+	v := newValidator(parameters)
+	r.Batch.validate(v, "instance_id", "instance_ids", "instance_selector")
+	v.oneOf("database_action", "stop", "start")
+	if verify, ok := v.boolean("verify"); ok && verify && r.Verifier == nil {
+		v.problem("verify is not configured for the runner")
+	}
+	return v.err()
+}
+
+// Run executes the DatabaseRunner.  The database_action and an instance_id, a comma separated list of instance_ids
+// or an instance_selector are required.  Allowable actions are 'start' and 'stop'.  A list of databases or the
+// databases matching the selector are acted on in parallel and the result of each is reported.  If an endpoint
+// is configured on the runner, it will be used, otherwise we assume there is an endpointTemplate and try to
+// execute it.  Database actions are currently only executed with the PUT method and a body containing
+// {"state": "action"}.
 func (r *DatabaseRunner) Run(ctx context.Context, account string, parameters interface{}) (string, error) {
+	return runResult(r.RunResult(ctx, account, parameters))
+}
+
+// RunResult executes the DatabaseRunner like Run and returns a structured result with the targets, the status code
+// and an excerpt of the response.  Batches report the status of each target in the result data.
+func (r *DatabaseRunner) RunResult(ctx context.Context, account string, parameters interface{}) (*Result, error) {
 	if account == "" {
-		return "", errors.New("account is required")
+		return nil, errors.New("account is required")
 	}
 
 	log.Infof("running database runner %+v in account %s,  with parameters %+v", r, account, parameters)
 
 	params, ok := parameters.(map[string]string)
 	if !ok {
-		return "", NewRunnerError(ErrMissingDetails, "wrong type", errors.New("parameters list is not a map[string]string"))
+		return nil, NewRunnerError(ErrMissingDetails, "wrong type", errors.New("parameters list is not a map[string]string"))
 	}
 
+	batched := r.Batch.batched(ctx, params, "instance_ids", "instance_selector")
+
 	instanceID, ok := params["instance_id"]
-	if !ok {
-		return "", NewRunnerError(ErrMissingDetails, "missing instance_id", nil)
+	if !ok && !batched {
+		return nil, NewRunnerError(ErrMissingDetails, "missing instance_id", nil)
 	}
 
 	action, ok := params["database_action"]
 	if !ok {
-		return "", NewRunnerError(ErrMissingDetails, "missing database_action", nil)
+		return nil, NewRunnerError(ErrMissingDetails, "missing database_action", nil)
 	}
 
+	if batched {
+		targets, err := r.Batch.targets(ctx, account, params, "instance_ids", "instance_selector", r.authorize)
+		if err != nil {
+			return nil, err
+		}
+
+		return r.Batch.run(ctx, targets, func(ctx context.Context, instanceID string) (string, error) {
+			result, err := r.act(ctx, account, instanceID, action, params)
+			return result.Output(), err
+		})
+	}
+
+	return r.act(ctx, account, instanceID, action, params)
+}
+
+// act executes the action on one database and verifies its target state, if verification is enabled
+func (r *DatabaseRunner) act(ctx context.Context, account, instanceID, action string, params map[string]string) (*Result, error) {
+	input := struct {
+		Account    string
+		InstanceID string
+	}{account, instanceID}
+
+	result := &Result{Targets: []string{instanceID}}
+	result.SetData("action", action)
+
 	endpoint := r.Endpoint
 	if endpoint == "" {
 		log.Debugf("endpoint is empty, attempting to use endpoint template '%s'", r.EndpointTemplate)
 
-		input := struct {
-			Account    string
-			InstanceID string
-		}{account, instanceID}
-
 		tmpl, err := template.New("endpoint").Parse(r.EndpointTemplate)
 		if err != nil {
-			return "", NewRunnerError(ErrPreExecFailure, "template parsing failed", err)
+			return result, NewRunnerError(ErrPreExecFailure, "template parsing failed", err)
 		}
 
 		var out bytes.Buffer
 		if err := tmpl.Execute(&out, &input); err != nil {
-			return "", NewRunnerError(ErrPreExecFailure, "template execution failed", err)
+			return result, NewRunnerError(ErrPreExecFailure, "template execution failed", err)
 		}
 
 		endpoint = out.String()
@@ -107,41 +200,63 @@ func (r *DatabaseRunner) Run(ctx context.Context, account string, parameters int
 	case "stop", "start":
 		j, err := json.Marshal(map[string]string{"state": action})
 		if err != nil {
-			return "", err
+			return result, err
 		}
 
-		client := &http.Client{
-			Timeout: time.Second * 30,
-		}
 		req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, bytes.NewReader(j))
 		if err != nil {
-			return "", NewRunnerError(ErrPreExecFailure, "building http request failed", err)
+			return result, NewRunnerError(ErrPreExecFailure, "building http request failed", err)
 		}
 
-		if r.Token != "" {
-			req.Header.Set("X-Auth-Token", r.Token)
-		}
+		r.authorize(req)
 		req.Header.Set("Content-Type", "application/json")
 
-		res, err := client.Do(req)
+		res, err := r.Client.Do(req)
 		if err != nil {
-			return "", NewRunnerError(ErrExecFailure, "http request failed", err)
+			return result, NewRunnerError(ErrExecFailure, "http request failed", err)
 		}
 		defer res.Body.Close()
 
 		body, err := ioutil.ReadAll(res.Body)
 		if err != nil {
-			return "", NewRunnerError(ErrPostExecFailure, "reading response body failed", err)
+			return result, NewRunnerError(ErrPostExecFailure, "reading response body failed", err)
 		}
 
 		log.Debugf("got response %s(%d) for endpoint %s: %s", res.Status, res.StatusCode, endpoint, body)
 
+		result.StatusCode = res.StatusCode
+		result.Response = excerpt(body)
+
 		if res.StatusCode >= 300 {
-			return "", NewRunnerError(ErrExecFailure, "unexpected http response", errors.New("unexpected response from databaseRunner api: "+res.Status))
+			return result, NewRunnerStatusError(ErrExecFailure, "unexpected http response", res.StatusCode, errors.New("unexpected response from databaseRunner api: "+res.Status))
 		}
 
-		return string(body), nil
+		out := string(body)
+		if r.Verifier.enabled(params) {
+			summary, err := r.Verifier.Verify(ctx, &input, instanceID, action, r.authorize)
+			if err != nil {
+				return result, err
+			}
+			out = strings.TrimSpace(out + "\n" + summary)
+			result.SetData("verified", "true")
+		}
+
+		result.Message = out
+		return result, nil
 	default:
-		return "", fmt.Errorf("unexpected action '%s' for database %s", action, instanceID)
+		return result, fmt.Errorf("unexpected action '%s' for database %s", action, instanceID)
+	}
+}
+
+// authorize signs a request to the database api or sets the token header, if a token is configured
+func (r *DatabaseRunner) authorize(req *http.Request) error {
+	if r.Signer != nil {
+		log.Debug("signing request")
+		return r.Signer.Sign(req)
+	}
+
+	if r.Token != "" {
+		req.Header.Set("X-Auth-Token", r.Token)
 	}
+	return nil
 }
@@ -244,3 +244,126 @@ func TestDatabaseRunnerRun(t *testing.T) {
 		t.Error("expected error for bad URI, got nil")
 	}
 }
+
+func TestDatabaseRunnerValidate(t *testing.T) {
+	tests := []struct {
+		params   interface{}
+		problems []string
+	}{
+		{
+			params:   map[string]string{"instance_id": "db-123456", "database_action": "stop"},
+			problems: nil,
+		},
+		{
+			params:   map[string]string{"instance_id": "db-123456", "database_action": "reboot"},
+			problems: []string{"unexpected database_action 'reboot', must be one of 'stop', 'start'"},
+		},
+		{
+			params:   map[string]string{"instance_id": ""},
+			problems: []string{"missing instance_id, instance_ids or instance_selector", "missing database_action"},
+		},
+		{
+			params:   map[string]string{"instance_id": "db-123456", "database_action": "stop", "verify": "true"},
+			problems: []string{"verify is not configured for the runner"},
+		},
+	}
+
+	r := &DatabaseRunner{}
+	for _, test := range tests {
+		err := r.Validate(test.params)
+		if test.problems == nil {
+			if err != nil {
+				t.Errorf("expected nil error for %+v, got %s", test.params, err)
+			}
+			continue
+		}
+
+		verr, ok := err.(ValidationError)
+		if !ok {
+			t.Errorf("expected ValidationError for %+v, got %v", test.params, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(verr.Problems, test.problems) {
+			t.Errorf("expected problems %v, got %v", test.problems, verr.Problems)
+		}
+	}
+}
+
+func TestDatabaseRunnerRunVerify(t *testing.T) {
+	status := &mockStatusServer{states: []string{"starting"}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/power/myaccount/i-123456", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+	mux.Handle("/", status)
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	r, err := NewDatabaseRunner(map[string]interface{}{
+		"token":            "my-awesome-token",
+		"endpointTemplate": ts.URL + "/power/{{.Account}}/{{.InstanceID}}",
+		"verify": map[string]interface{}{
+			"endpointTemplate": ts.URL + "/{{.Account}}/{{.InstanceID}}",
+			"stateField":       "Instance.State",
+			"interval":         "10ms",
+			"timeout":          "100ms",
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	_, err = r.Run(context.TODO(), "myaccount", map[string]string{
+		"instance_id":     "i-123456",
+		"database_action": "start",
+	})
+
+	var rErr RunnerError
+	if !errors.As(err, &rErr) || rErr.Code != ErrExecFailure {
+		t.Fatalf("expected %s error, got %v", ErrExecFailure, err)
+	}
+
+	expected := "i-123456 did not reach state 'available' in 0s: starting (0s)"
+	if rErr.Message != expected {
+		t.Errorf("expected message '%s', got '%s'", expected, rErr.Message)
+	}
+}
+
+func TestDatabaseRunnerRunBatch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/myaccount/db-2" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "OK")
+	}))
+	defer ts.Close()
+
+	r, err := NewDatabaseRunner(map[string]interface{}{
+		"endpointTemplate": ts.URL + "/{{.Account}}/{{.InstanceID}}",
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	_, err = r.Run(context.TODO(), "myaccount", map[string]string{
+		"instance_ids":    "db-1, db-2",
+		"database_action": "start",
+	})
+
+	var rErr RunnerError
+	if !errors.As(err, &rErr) {
+		t.Fatalf("expected RunnerError, got %v", err)
+	}
+
+	if rErr.StatusCode != http.StatusServiceUnavailable || !reflect.DeepEqual(rErr.Targets, []string{"db-2"}) {
+		t.Errorf("expected failed target [db-2] with status 503, got %v (%d)", rErr.Targets, rErr.StatusCode)
+	}
+
+	// selectors aren't supported without a selector endpoint
+	if err := r.Validate(map[string]string{"instance_selector": "env=dev", "database_action": "start"}); err == nil {
+		t.Error("expected validation error for selector without selector endpoint, got nil")
+	}
+}
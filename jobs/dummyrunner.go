@@ -13,6 +13,18 @@ type DummyRunner struct {
 	Template string
 }
 
+func init() {
+	RegisterRunner(RunnerType{
+		RunnerDescription: RunnerDescription{
+			Type:        "dummy",
+			Description: "executes a template with the account name and returns the result",
+		},
+		New: func(config map[string]interface{}) (Runner, error) {
+			return NewDummyRunner(config)
+		},
+	})
+}
+
 // NewDummyRunner creates a new dummy runner that doesn't do anything, but requires a
 // template and returns that executed template when called
 func NewDummyRunner(config map[string]interface{}) (*DummyRunner, error) {
@@ -30,6 +42,11 @@ func NewDummyRunner(config map[string]interface{}) (*DummyRunner, error) {
 	return &DummyRunner{Template: template}, nil
 }
 
+// Validate validates the DummyRunner parameters, they are ignored so they are always valid
+func (r *DummyRunner) Validate(parameters interface{}) error {
+	return nil
+}
+
 // Run executes the DummyRunner, ignoring the parameters
 func (r *DummyRunner) Run(ctx context.Context, account string, parameters interface{}) (string, error) {
 	if account == "" {
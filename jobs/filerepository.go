@@ -0,0 +1,710 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/YaleSpinup/apierror"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultFileLockTimeout is how long to wait for the lock of a file held by another writer
+	DefaultFileLockTimeout = 10 * time.Second
+	// DefaultFileLockStale is the age of a lock file after which it's considered left behind by a crashed writer
+	DefaultFileLockStale = 30 * time.Second
+)
+
+// FileRepository is an implementation of a jobs repository on the local filesystem.  Jobs are stored as JSON
+// files in the path <root>/<prefix>/<account>/<group>/<job id>.  Writes are atomic and a lock file guards each
+// job, so nodes sharing the volume can use the same repository.  Each version of a job is also kept in the
+// revision history under <root>/.revisions/<prefix>/<account>/<group>/<job id>/<version>, and trashed jobs are
+// moved to <root>/.trash/<prefix>/<account>/<group>/<job id>.
+type FileRepository struct {
+	Root   string
+	Prefix string
+}
+
+// NewFileRepository creates a new file repository from the config data.  The root directory is required and
+// is created if it doesn't exist.
+func NewFileRepository(config map[string]interface{}) (*FileRepository, error) {
+	log.Debug("creating new file repository")
+
+	var root, prefix string
+	if v, ok := config["root"].(string); ok {
+		root = v
+	}
+
+	if v, ok := config["prefix"].(string); ok {
+		prefix = v
+	}
+
+	if root == "" {
+		return nil, errors.New("root is required for the file repository")
+	}
+
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create file repository root %s: %s", root, err)
+	}
+
+	return &FileRepository{
+		Root:   root,
+		Prefix: prefix,
+	}, nil
+}
+
+// Create creates a job in the file jobs repository
+func (f *FileRepository) Create(ctx context.Context, account, group string, job *Job) (*Job, error) {
+	if account == "" || group == "" || job == nil {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	// generate a new random ID for the job, a new job doesn't have a version yet
+	job.ID = NewID()
+	job.Version = 0
+
+	return f.Update(ctx, account, group, job.ID, job)
+}
+
+// Delete deletes a job in the file jobs repository.  If the id is empty, all of the jobs in the group are deleted.
+func (f *FileRepository) Delete(ctx context.Context, account, group, id string) error {
+	if account == "" || group == "" {
+		return apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Infof("deleting job from file repository %s/%s/%s", account, group, id)
+
+	path, err := filePath(f.Root, f.Prefix, account, group, id)
+	if err != nil {
+		return err
+	}
+
+	if id == "" {
+		log.Warnf("recursively deleting jobs in %s", path)
+
+		if err := os.RemoveAll(path); err != nil {
+			return apierror.New(apierror.ErrInternalError, "failed to delete jobs in "+path, err)
+		}
+		return nil
+	}
+
+	unlock, err := lockFile(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return apierror.New(apierror.ErrInternalError, "failed to delete job file "+path, err)
+	}
+
+	return nil
+}
+
+// DeleteVersion deletes a job in the file jobs repository if it's still at the given version
+func (f *FileRepository) DeleteVersion(ctx context.Context, account, group, id string, version int64) error {
+	if account == "" || group == "" || id == "" {
+		return apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Infof("deleting job version %d from file repository %s/%s/%s", version, account, group, id)
+
+	path, err := filePath(f.Root, f.Prefix, account, group, id)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current := &Job{}
+	if err := readJSONFile(path, current); err != nil {
+		return err
+	}
+
+	if current.Version != version {
+		return staleVersionError(id, version, current.Version)
+	}
+
+	if err := os.Remove(path); err != nil {
+		return apierror.New(apierror.ErrInternalError, "failed to delete job file "+path, err)
+	}
+
+	return nil
+}
+
+// Get gets a job from the file jobs repository
+func (f *FileRepository) Get(ctx context.Context, account, group, id string) (*Job, error) {
+	if account == "" || group == "" || id == "" {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Infof("getting job %s/%s/%s", account, group, id)
+
+	path, err := filePath(f.Root, f.Prefix, account, group, id)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &Job{}
+	if err := readJSONFile(path, job); err != nil {
+		return nil, err
+	}
+
+	log.Debugf("output from getting file job '%s': %+v", id, job)
+
+	return job, nil
+}
+
+// List lists the jobs in the file jobs repository.  If group is empty, all jobs are returned from the
+// account.  If some of those jobs are in a group, the group is prefixed with the job id in the response.
+func (f *FileRepository) List(ctx context.Context, account, group string) ([]string, error) {
+	if account == "" {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Infof("listing jobs for account '%s', group '%s'", account, group)
+
+	path, err := filePath(f.Root, f.Prefix, account, group)
+	if err != nil {
+		return nil, err
+	}
+
+	return listFiles(path)
+}
+
+// Update updates a job in the file jobs repository
+func (f *FileRepository) Update(ctx context.Context, account, group, id string, job *Job) (*Job, error) {
+	if account == "" || group == "" || id == "" || job == nil || job.ID != id {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	// set the modified at to right now
+	now := time.Now().UTC().Truncate(time.Second)
+	job.ModifiedAt = &now
+
+	log.Infof("updating job %s/%s/%s", account, group, id)
+
+	path, err := filePath(f.Root, f.Prefix, account, group, id)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := lockFile(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// the current version is read under the lock, so no other writer can change it until the job is written
+	current := &Job{}
+	if err := readJSONFile(path, current); err != nil {
+		var aerr apierror.Error
+		if !errors.As(err, &aerr) || aerr.Code != apierror.ErrNotFound {
+			return nil, err
+		}
+	}
+
+	if err := checkVersion(id, job.Version, current.Version); err != nil {
+		return nil, err
+	}
+	job.Version = current.Version + 1
+
+	j, err := json.MarshalIndent(job, "", "\t")
+	if err != nil {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", err)
+	}
+
+	if err := writeFileAtomic(path, j); err != nil {
+		return nil, err
+	}
+
+	dir, err := f.revisionsDir(account, group, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeFileAtomic(filepath.Join(dir, strconv.FormatInt(job.Version, 10)), j); err != nil {
+		log.Errorf("failed to write revision %d of job %s: %s", job.Version, id, err)
+	}
+
+	return job, nil
+}
+
+// Revisions returns the revision history of a job in the file jobs repository, most recent first
+func (f *FileRepository) Revisions(ctx context.Context, account, group, id string) ([]*Revision, error) {
+	if account == "" || group == "" || id == "" {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Infof("listing revisions of job %s/%s/%s", account, group, id)
+
+	dir, err := f.revisionsDir(account, group, id)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := listFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := []*Revision{}
+	for _, file := range files {
+		job := &Job{}
+		if err := readJSONFile(filepath.Join(dir, file), job); err != nil {
+			log.Errorf("error getting revision '%s' of job %s: %s", file, id, err)
+			continue
+		}
+		revisions = append(revisions, newRevision(file, job))
+	}
+
+	sort.Slice(revisions, func(i, j int) bool { return revisions[i].Version > revisions[j].Version })
+
+	return revisions, nil
+}
+
+// Trash moves a job, or all of the jobs in the group if the id is empty, to the trash of the file jobs repository
+func (f *FileRepository) Trash(ctx context.Context, account, group, id string, version int64) error {
+	if account == "" || group == "" || (id == "" && version != 0) {
+		return apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Infof("trashing job in file repository %s/%s/%s", account, group, id)
+
+	if id != "" {
+		return f.trash(ctx, account, group, id, version)
+	}
+
+	path, err := filePath(f.Root, f.Prefix, account, group)
+	if err != nil {
+		return err
+	}
+
+	ids, err := listFiles(path)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := f.trash(ctx, account, group, id, 0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// trash moves a job to the trash under its lock
+func (f *FileRepository) trash(ctx context.Context, account, group, id string, version int64) error {
+	path, err := filePath(f.Root, f.Prefix, account, group, id)
+	if err != nil {
+		return err
+	}
+
+	trashPath, err := filePath(filepath.Join(f.Root, ".trash"), f.Prefix, account, group, id)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	job := &Job{}
+	if err := readJSONFile(path, job); err != nil {
+		return err
+	}
+
+	if version != 0 && job.Version != version {
+		return staleVersionError(id, version, job.Version)
+	}
+
+	j, err := json.MarshalIndent(newTrashedJob(group, job), "", "\t")
+	if err != nil {
+		return apierror.New(apierror.ErrBadRequest, "invalid input", err)
+	}
+
+	if err := writeFileAtomic(trashPath, j); err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil {
+		return apierror.New(apierror.ErrInternalError, "failed to delete job file "+path, err)
+	}
+
+	return nil
+}
+
+// ListTrash lists the trashed jobs in the file jobs repository.  If group is empty, the trashed jobs of the
+// whole account are returned.
+func (f *FileRepository) ListTrash(ctx context.Context, account, group string) ([]*TrashedJob, error) {
+	if account == "" {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Infof("listing trashed jobs for account '%s', group '%s'", account, group)
+
+	dir, err := filePath(filepath.Join(f.Root, ".trash"), f.Prefix, account, group)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := listFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	trashed := []*TrashedJob{}
+	for _, file := range files {
+		t := &TrashedJob{}
+		if err := readJSONFile(filepath.Join(dir, filepath.FromSlash(file)), t); err != nil {
+			log.Errorf("error getting trashed job '%s': %s", file, err)
+			continue
+		}
+		trashed = append(trashed, t)
+	}
+
+	return trashed, nil
+}
+
+// RestoreTrash moves a trashed job, or all of the trashed jobs in the group if the id is empty, back into the
+// file jobs repository
+func (f *FileRepository) RestoreTrash(ctx context.Context, account, group, id string) ([]*Job, error) {
+	if account == "" || group == "" {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Infof("restoring trashed job in file repository %s/%s/%s", account, group, id)
+
+	ids := []string{id}
+	if id == "" {
+		dir, err := filePath(filepath.Join(f.Root, ".trash"), f.Prefix, account, group)
+		if err != nil {
+			return nil, err
+		}
+
+		if ids, err = listFiles(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	restored := []*Job{}
+	for _, id := range ids {
+		job, err := f.restore(ctx, account, group, id)
+		if err != nil {
+			return restored, err
+		}
+		restored = append(restored, job)
+	}
+
+	return restored, nil
+}
+
+// restore moves a trashed job back under the lock of the job
+func (f *FileRepository) restore(ctx context.Context, account, group, id string) (*Job, error) {
+	path, err := filePath(f.Root, f.Prefix, account, group, id)
+	if err != nil {
+		return nil, err
+	}
+
+	trashPath, err := filePath(filepath.Join(f.Root, ".trash"), f.Prefix, account, group, id)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := lockFile(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	t := &TrashedJob{}
+	if err := readJSONFile(trashPath, t); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		return nil, apierror.New(apierror.ErrConflict, "cannot restore job, it already exists "+id, nil)
+	}
+
+	j, err := json.MarshalIndent(t.Job, "", "\t")
+	if err != nil {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", err)
+	}
+
+	if err := writeFileAtomic(path, j); err != nil {
+		return nil, err
+	}
+
+	if err := os.Remove(trashPath); err != nil {
+		log.Errorf("failed to remove restored job %s from the trash: %s", id, err)
+	}
+
+	return t.Job, nil
+}
+
+// PurgeTrash permanently removes a trashed job, or all of the trashed jobs in the group if the id is empty, and
+// their revision history from the file jobs repository
+func (f *FileRepository) PurgeTrash(ctx context.Context, account, group, id string) error {
+	if account == "" || group == "" {
+		return apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Warnf("purging trashed job from file repository %s/%s/%s", account, group, id)
+
+	dir, err := filePath(filepath.Join(f.Root, ".trash"), f.Prefix, account, group)
+	if err != nil {
+		return err
+	}
+
+	ids := []string{id}
+	if id == "" {
+		if ids, err = listFiles(dir); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range ids {
+		trashPath, err := filePath(dir, id)
+		if err != nil {
+			return err
+		}
+
+		// a job that isn't in the trash keeps its revisions, it may not be deleted
+		if err := os.Remove(trashPath); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return apierror.New(apierror.ErrInternalError, "failed to purge trashed job file "+trashPath, err)
+		}
+
+		revisions, err := f.revisionsDir(account, group, id)
+		if err != nil {
+			return err
+		}
+
+		if err := os.RemoveAll(revisions); err != nil {
+			return apierror.New(apierror.ErrInternalError, "failed to purge revisions in "+revisions, err)
+		}
+	}
+
+	return nil
+}
+
+// revisionsDir returns the directory of the revision history of a job
+func (f *FileRepository) revisionsDir(account, group, id string) (string, error) {
+	return filePath(filepath.Join(f.Root, ".revisions"), f.Prefix, account, group, id)
+}
+
+// filePath joins the parts of the path of a file in a file repository under the root.  Parts that would
+// leave the root, ie. '..', are rejected.
+func filePath(root string, parts ...string) (string, error) {
+	clean := []string{root}
+	for _, p := range parts {
+		p = strings.Trim(p, "/")
+		if p == "" {
+			continue
+		}
+
+		for _, e := range strings.Split(p, "/") {
+			if e == "" || e == "." || e == ".." || strings.HasPrefix(e, ".") || strings.ContainsRune(e, '\\') {
+				return "", apierror.New(apierror.ErrBadRequest, "invalid input", fmt.Errorf("invalid path element '%s'", e))
+			}
+		}
+		clean = append(clean, filepath.FromSlash(p))
+	}
+
+	return filepath.Join(clean...), nil
+}
+
+// listFiles returns the paths of the files under the directory relative to it, separated with '/'.  Hidden
+// files, like locks and partial writes, are skipped.  A directory that doesn't exist has no files.
+func listFiles(dir string) ([]string, error) {
+	files := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		if strings.HasPrefix(info.Name(), ".") || info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+
+		return nil
+	})
+	if err != nil {
+		return nil, apierror.New(apierror.ErrInternalError, "failed to list files in "+dir, err)
+	}
+
+	return files, nil
+}
+
+// readJSONFile decodes the JSON file into v
+func readJSONFile(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return apierror.New(apierror.ErrNotFound, "file not found "+path, err)
+		}
+		return apierror.New(apierror.ErrInternalError, "failed to read file "+path, err)
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return apierror.New(apierror.ErrBadRequest, "failed to decode json from file "+path, err)
+	}
+
+	return nil
+}
+
+// writeFileAtomic writes the data to a hidden temporary file next to the path and renames it, so readers
+// never see a partial file
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return apierror.New(apierror.ErrInternalError, "failed to create directory "+dir, err)
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return apierror.New(apierror.ErrInternalError, "failed to create temporary file in "+dir, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return apierror.New(apierror.ErrInternalError, "failed to write temporary file "+tmp.Name(), err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return apierror.New(apierror.ErrInternalError, "failed to sync temporary file "+tmp.Name(), err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return apierror.New(apierror.ErrInternalError, "failed to close temporary file "+tmp.Name(), err)
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return apierror.New(apierror.ErrInternalError, "failed to set mode of temporary file "+tmp.Name(), err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return apierror.New(apierror.ErrInternalError, "failed to rename temporary file to "+path, err)
+	}
+
+	return nil
+}
+
+// lockFile takes the lock of a file in a file repository and returns the function releasing it.  The lock is
+// a hidden file next to the path, created exclusively so it works across nodes sharing a volume, with a token
+// identifying its owner.  A lock older than DefaultFileLockStale was left behind by a writer that crashed and is
+// taken over.
+func lockFile(ctx context.Context, path string) (func(), error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, apierror.New(apierror.ErrInternalError, "failed to create directory "+dir, err)
+	}
+
+	lock := filepath.Join(dir, "."+filepath.Base(path)+".lock")
+	token := NewID()
+
+	ctx, cancel := context.WithTimeout(ctx, DefaultFileLockTimeout)
+	defer cancel()
+
+	for {
+		l, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			host, _ := os.Hostname()
+			_, err := fmt.Fprintf(l, "%s %s %d\n", token, host, os.Getpid())
+			if cErr := l.Close(); err == nil {
+				err = cErr
+			}
+
+			if err != nil {
+				os.Remove(lock)
+				return nil, apierror.New(apierror.ErrInternalError, "failed to write lock "+lock, err)
+			}
+
+			return func() { unlockFile(lock, token) }, nil
+		}
+
+		if !os.IsExist(err) {
+			return nil, apierror.New(apierror.ErrInternalError, "failed to create lock "+lock, err)
+		}
+
+		if info, err := os.Stat(lock); err == nil && time.Since(info.ModTime()) > DefaultFileLockStale {
+			breakLock(lock, token)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, apierror.New(apierror.ErrConflict, "timeout waiting for lock "+lock, ctx.Err())
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// breakLock removes a stale lock.  The lock is first renamed to a name unique to the waiter, so when several
+// waiters find the lock stale, only one of them removes it.  If the renamed lock isn't stale, another waiter
+// already took the lock over and it's put back, unless the lock was taken again in the meantime.
+func breakLock(lock, token string) {
+	stale := lock + "." + token
+	if err := os.Rename(lock, stale); err != nil {
+		// the lock was released or renamed by another waiter
+		return
+	}
+	defer os.Remove(stale)
+
+	if info, err := os.Stat(stale); err == nil && time.Since(info.ModTime()) <= DefaultFileLockStale {
+		if err := os.Link(stale, lock); err != nil {
+			log.Warnf("failed to put back lock %s taken over by another writer: %s", lock, err)
+		}
+		return
+	}
+
+	log.Warnf("removed stale lock %s", lock)
+}
+
+// unlockFile releases a lock, as long as it's still owned by the token.  A lock that was taken over because it
+// went stale belongs to the new owner and is left in place.
+func unlockFile(lock, token string) {
+	data, err := ioutil.ReadFile(lock)
+	if err != nil {
+		log.Errorf("failed to release lock %s: %s", lock, err)
+		return
+	}
+
+	if owner := strings.Fields(string(data)); len(owner) == 0 || owner[0] != token {
+		log.Warnf("lock %s was taken over by another writer, not releasing it", lock)
+		return
+	}
+
+	if err := os.Remove(lock); err != nil {
+		log.Errorf("failed to release lock %s: %s", lock, err)
+	}
+}
@@ -0,0 +1,352 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/YaleSpinup/apierror"
+)
+
+func TestNewFileRepository(t *testing.T) {
+	if _, err := NewFileRepository(map[string]interface{}{}); err == nil {
+		t.Error("expected error without root, got nil")
+	}
+
+	root := filepath.Join(t.TempDir(), "jobs")
+	f, err := NewFileRepository(map[string]interface{}{"root": root, "prefix": "minion"})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if expected := (&FileRepository{Root: root, Prefix: "minion"}); !reflect.DeepEqual(f, expected) {
+		t.Errorf("expected %+v, got %+v", expected, f)
+	}
+
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		t.Errorf("expected root directory to be created, got %v", err)
+	}
+}
+
+func TestFileRepository(t *testing.T) {
+	root := t.TempDir()
+	f := &FileRepository{Root: root, Prefix: "/minion/localdev"}
+
+	job := &Job{Description: "first studio album", Details: map[string]string{"runner": "dummy"}, ScheduleExpression: "00 00 25 07 *"}
+	created, err := f.Create(context.TODO(), "metal", "metallica", job)
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if created.ID == "" || created.ModifiedAt == nil {
+		t.Errorf("expected job with id and modified at, got %+v", created)
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "minion", "localdev", "metal", "metallica", created.ID)); err != nil {
+		t.Errorf("expected job file in the account and group path, got %s", err)
+	}
+
+	out, err := f.Get(context.TODO(), "metal", "metallica", created.ID)
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if !reflect.DeepEqual(out, created) {
+		t.Errorf("expected %+v, got %+v", created, out)
+	}
+
+	created.Description = "updated"
+	if _, err := f.Update(context.TODO(), "metal", "metallica", created.ID, created); err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if out, _ := f.Get(context.TODO(), "metal", "metallica", created.ID); out.Description != "updated" {
+		t.Errorf("expected updated description, got %+v", out)
+	}
+
+	other, err := f.Create(context.TODO(), "metal", "megadeth", &Job{Description: "peace sells", Details: map[string]string{"runner": "dummy"}, ScheduleExpression: "00 00 29 09 *"})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	// leftover temporary and lock files aren't listed
+	if err := ioutil.WriteFile(filepath.Join(root, "minion", "localdev", "metal", "megadeth", ".foo.tmp-123"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := f.List(context.TODO(), "metal", "")
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+	sort.Strings(list)
+
+	expected := []string{"megadeth/" + other.ID, "metallica/" + created.ID}
+	sort.Strings(expected)
+	if !reflect.DeepEqual(list, expected) {
+		t.Errorf("expected list %v, got %v", expected, list)
+	}
+
+	if list, err := f.List(context.TODO(), "metal", "metallica"); err != nil || !reflect.DeepEqual(list, []string{created.ID}) {
+		t.Errorf("expected group list [%s], got %v (%v)", created.ID, list, err)
+	}
+
+	if list, err := f.List(context.TODO(), "punk", ""); err != nil || len(list) != 0 {
+		t.Errorf("expected empty list for an account without jobs, got %v (%v)", list, err)
+	}
+
+	if err := f.Delete(context.TODO(), "metal", "metallica", created.ID); err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	var aerr apierror.Error
+	if _, err := f.Get(context.TODO(), "metal", "metallica", created.ID); !errors.As(err, &aerr) || aerr.Code != apierror.ErrNotFound {
+		t.Errorf("expected not found error for deleted job, got %v", err)
+	}
+
+	if err := f.Delete(context.TODO(), "metal", "megadeth", ""); err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if list, err := f.List(context.TODO(), "metal", ""); err != nil || len(list) != 0 {
+		t.Errorf("expected empty list after deleting the groups, got %v (%v)", list, err)
+	}
+}
+
+func TestFileRepositoryInvalidInput(t *testing.T) {
+	f := &FileRepository{Root: t.TempDir()}
+
+	var aerr apierror.Error
+	for _, path := range [][]string{
+		{"", "group", "id"},
+		{"account", "", "id"},
+		{"account", "group", ""},
+		{"..", "group", "id"},
+		{"account", "../../etc", "id"},
+		{"account", "group", ".hidden"},
+	} {
+		if _, err := f.Get(context.TODO(), path[0], path[1], path[2]); !errors.As(err, &aerr) || aerr.Code != apierror.ErrBadRequest {
+			t.Errorf("expected bad request for %v, got %v", path, err)
+		}
+	}
+
+	if _, err := f.Update(context.TODO(), "account", "group", "id", &Job{ID: "other"}); !errors.As(err, &aerr) || aerr.Code != apierror.ErrBadRequest {
+		t.Errorf("expected bad request for mismatched id, got %v", err)
+	}
+}
+
+func TestFileRepositoryConcurrentUpdates(t *testing.T) {
+	// two repositories on the same root act like nodes sharing a volume
+	root := t.TempDir()
+	repos := []*FileRepository{{Root: root}, {Root: root}}
+
+	job, err := repos[0].Create(context.TODO(), "metal", "metallica", &Job{Details: map[string]string{"runner": "dummy"}, ScheduleExpression: "00 00 25 07 *"})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			j := &Job{ID: job.ID, Description: "update", Details: job.Details, ScheduleExpression: job.ScheduleExpression}
+			if _, err := repos[i%2].Update(context.TODO(), "metal", "metallica", job.ID, j); err != nil {
+				t.Errorf("expected nil error, got %s", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if out, err := repos[1].Get(context.TODO(), "metal", "metallica", job.ID); err != nil || out.Description != "update" {
+		t.Errorf("expected updated job, got %+v (%v)", out, err)
+	}
+
+	files, _ := ioutil.ReadDir(filepath.Join(root, "metal", "metallica"))
+	if len(files) != 1 {
+		t.Errorf("expected only the job file to be left, got %d files", len(files))
+	}
+}
+
+func TestLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job")
+
+	unlock, err := lockFile(context.TODO(), path)
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+	defer cancel()
+
+	var aerr apierror.Error
+	if _, err := lockFile(ctx, path); !errors.As(err, &aerr) || aerr.Code != apierror.ErrConflict {
+		t.Errorf("expected conflict waiting for a held lock, got %v", err)
+	}
+
+	unlock()
+
+	unlock, err = lockFile(context.TODO(), path)
+	if err != nil {
+		t.Fatalf("expected nil error after the lock is released, got %s", err)
+	}
+	defer unlock()
+
+	// a stale lock is taken over
+	lock := filepath.Join(filepath.Dir(path), ".job.lock")
+	old := time.Now().Add(-2 * DefaultFileLockStale)
+	if err := os.Chtimes(lock, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	takeover, err := lockFile(context.TODO(), path)
+	if err != nil {
+		t.Fatalf("expected stale lock to be taken over, got %s", err)
+	}
+
+	// releasing a lock that was taken over leaves the new owner's lock in place
+	unlock()
+	if _, err := os.Stat(lock); err != nil {
+		t.Errorf("expected the lock taken over to be kept, got %s", err)
+	}
+	takeover()
+
+	if _, err := os.Stat(lock); !os.IsNotExist(err) {
+		t.Errorf("expected the lock to be released, got %v", err)
+	}
+
+	// waiters finding the same stale lock don't take it over more than once
+	unlock, err = lockFile(context.TODO(), path)
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+	defer unlock()
+
+	if err := os.Chtimes(lock, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.TODO(), 500*time.Millisecond)
+	defer cancel()
+
+	var held int32
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			release, err := lockFile(ctx, path)
+			if err != nil {
+				return
+			}
+
+			if n := atomic.AddInt32(&held, 1); n > 1 {
+				t.Errorf("expected the lock to be held once, held %d times", n)
+			}
+			time.Sleep(20 * time.Millisecond)
+			atomic.AddInt32(&held, -1)
+			release()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestFileRepositoryVersions(t *testing.T) {
+	f := &FileRepository{Root: t.TempDir()}
+
+	job, err := f.Create(context.TODO(), "metal", "metallica", &Job{Details: map[string]string{"runner": "dummy"}, ScheduleExpression: "00 00 25 07 *", Version: 7})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if job.Version != 1 {
+		t.Errorf("expected new job at version 1, got %d", job.Version)
+	}
+
+	update := *job
+	if out, err := f.Update(context.TODO(), "metal", "metallica", job.ID, &update); err != nil || out.Version != 2 {
+		t.Fatalf("expected update to version 2, got %+v (%v)", out, err)
+	}
+
+	// the first writer's copy is still at version 1
+	var aerr apierror.Error
+	if _, err := f.Update(context.TODO(), "metal", "metallica", job.ID, job); !errors.As(err, &aerr) || aerr.Code != apierror.ErrConflict {
+		t.Errorf("expected conflict for a stale version, got %v", err)
+	}
+
+	if err := f.DeleteVersion(context.TODO(), "metal", "metallica", job.ID, 1); !errors.As(err, &aerr) || aerr.Code != apierror.ErrConflict {
+		t.Errorf("expected conflict deleting a stale version, got %v", err)
+	}
+
+	if err := f.DeleteVersion(context.TODO(), "metal", "metallica", job.ID, 2); err != nil {
+		t.Errorf("expected nil error deleting the current version, got %s", err)
+	}
+
+	if err := f.DeleteVersion(context.TODO(), "metal", "metallica", job.ID, 2); !errors.As(err, &aerr) || aerr.Code != apierror.ErrNotFound {
+		t.Errorf("expected not found deleting a deleted job, got %v", err)
+	}
+}
+
+func TestFileRepositoryRevisions(t *testing.T) {
+	f := &FileRepository{Root: t.TempDir(), Prefix: "minion"}
+
+	job, err := f.Create(context.TODO(), "metal", "metallica", &Job{Description: "first", Details: map[string]string{"runner": "dummy"}, ScheduleExpression: "00 00 25 07 *"})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	job.Description = "second"
+	if _, err := f.Update(context.TODO(), "metal", "metallica", job.ID, job); err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	// the revisions aren't listed as jobs
+	if list, err := f.List(context.TODO(), "metal", ""); err != nil || len(list) != 1 {
+		t.Errorf("expected only the job to be listed, got %v (%v)", list, err)
+	}
+
+	revisions, err := f.Revisions(context.TODO(), "metal", "metallica", job.ID)
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if len(revisions) != 2 || revisions[0].ID != "2" || revisions[0].Job.Description != "second" || revisions[1].ID != "1" || revisions[1].Job.Description != "first" {
+		t.Errorf("expected revisions 2 and 1 most recent first, got %+v", revisions)
+	}
+
+	// the history is kept after the job is deleted
+	if err := f.Delete(context.TODO(), "metal", "metallica", job.ID); err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if revisions, err := f.Revisions(context.TODO(), "metal", "metallica", job.ID); err != nil || len(revisions) != 2 {
+		t.Errorf("expected 2 revisions of the deleted job, got %+v (%v)", revisions, err)
+	}
+
+	if revisions, err := f.Revisions(context.TODO(), "metal", "metallica", "job2"); err != nil || len(revisions) != 0 {
+		t.Errorf("expected no revisions of a missing job, got %+v (%v)", revisions, err)
+	}
+}
+
+func TestFileRepositoryTrash(t *testing.T) {
+	f := &FileRepository{Root: t.TempDir(), Prefix: "minion"}
+
+	testTrash(t, f)
+
+	// the revision history of purged jobs is purged with them
+	if dirs, err := ioutil.ReadDir(filepath.Join(f.Root, ".revisions", "minion", "metal", "metallica")); err != nil || len(dirs) != 0 {
+		t.Errorf("expected no revisions left in the purged group, got %d (%v)", len(dirs), err)
+	}
+
+	if dirs, err := ioutil.ReadDir(filepath.Join(f.Root, ".revisions", "minion", "metal", "megadeth")); err != nil || len(dirs) != 1 {
+		t.Errorf("expected the revisions of the job left in the account, got %d (%v)", len(dirs), err)
+	}
+}
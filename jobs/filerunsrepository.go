@@ -0,0 +1,176 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/YaleSpinup/apierror"
+	log "github.com/sirupsen/logrus"
+)
+
+// FileRunsRepository is an implementation of a runs repository on the local filesystem.  Runs are stored
+// in the path <root>/<prefix>/<account>/<group>/<job id>/<run id>.
+type FileRunsRepository struct {
+	Root   string
+	Prefix string
+}
+
+// NewFileRunsRepository creates a new runs repository from the config data.  The configuration is the same
+// as the file jobs repository.
+func NewFileRunsRepository(config map[string]interface{}) (*FileRunsRepository, error) {
+	log.Debug("creating new file runs repository")
+
+	f, err := NewFileRepository(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileRunsRepository{
+		Root:   f.Root,
+		Prefix: f.Prefix,
+	}, nil
+}
+
+// Get gets a run from the file runs repository
+func (f *FileRunsRepository) Get(ctx context.Context, account, group, jobID, id string) (*Run, error) {
+	if account == "" || group == "" || jobID == "" || id == "" {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	p, err := filePath(f.Root, f.Prefix, account, group, jobID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugf("getting run file (account: %s, group: %s, job id: %s, id: %s, path: '%s')", account, group, jobID, id, p)
+
+	run := &Run{}
+	if err := readJSONFile(p, run); err != nil {
+		return nil, err
+	}
+
+	return run, nil
+}
+
+// List lists the runs of a job in the file runs repository, most recently started first
+func (f *FileRunsRepository) List(ctx context.Context, account, group, jobID string, limit int) ([]*Run, error) {
+	if account == "" || group == "" || jobID == "" {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Infof("listing runs for job %s/%s/%s", account, group, jobID)
+
+	runs, _, err := f.list(account, group, jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit > 0 && len(runs) > limit {
+		runs = runs[:limit]
+	}
+
+	return runs, nil
+}
+
+// Put creates or updates a run in the file runs repository
+func (f *FileRunsRepository) Put(ctx context.Context, run *Run) error {
+	if run == nil || run.Account == "" || run.Group == "" || run.JobID == "" || run.ID == "" {
+		return apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	p, err := filePath(f.Root, f.Prefix, run.Account, run.Group, run.JobID, run.ID)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("putting run %s with %+v", p, run)
+
+	j, err := json.MarshalIndent(run, "", "\t")
+	if err != nil {
+		return apierror.New(apierror.ErrBadRequest, "invalid input", err)
+	}
+
+	// each run is only written by the node running it, the atomic write is enough
+	return writeFileAtomic(p, j)
+}
+
+// Prune removes the runs of a job beyond the newest keep runs and the runs started before the given time from the
+// file runs repository
+func (f *FileRunsRepository) Prune(ctx context.Context, account, group, jobID string, keep int, before time.Time) error {
+	if account == "" || group == "" || jobID == "" {
+		return apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	runs, paths, err := f.list(account, group, jobID)
+	if err != nil {
+		return err
+	}
+
+	for i, run := range runs {
+		if (keep > 0 && i >= keep) || (!before.IsZero() && run.StartedAt != nil && run.StartedAt.Before(before)) {
+			log.Debugf("pruning run %s of job %s/%s/%s", run.ID, account, group, jobID)
+
+			if err := os.Remove(paths[run]); err != nil && !os.IsNotExist(err) {
+				return apierror.New(apierror.ErrInternalError, "failed to prune run "+run.ID, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Delete removes all of the runs of a job from the file runs repository
+func (f *FileRunsRepository) Delete(ctx context.Context, account, group, jobID string) error {
+	if account == "" || group == "" || jobID == "" {
+		return apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	dir, err := filePath(f.Root, f.Prefix, account, group, jobID)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("deleting runs of job %s/%s/%s", account, group, jobID)
+
+	if err := os.RemoveAll(dir); err != nil {
+		return apierror.New(apierror.ErrInternalError, "failed to delete runs of job "+jobID, err)
+	}
+
+	return nil
+}
+
+// list returns the runs of a job, most recently started first, with the path of each run
+func (f *FileRunsRepository) list(account, group, jobID string) ([]*Run, map[*Run]string, error) {
+	dir, err := filePath(f.Root, f.Prefix, account, group, jobID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	files, err := listFiles(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	runs := []*Run{}
+	paths := make(map[*Run]string, len(files))
+	for _, file := range files {
+		p := filepath.Join(dir, filepath.FromSlash(file))
+
+		run := &Run{}
+		if err := readJSONFile(p, run); err != nil {
+			log.Errorf("error getting run '%s': %s", file, err)
+			continue
+		}
+
+		runs = append(runs, run)
+		paths[run] = p
+	}
+
+	sortRuns(runs)
+
+	return runs, paths, nil
+}
@@ -0,0 +1,109 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/YaleSpinup/apierror"
+)
+
+func TestFileRunsRepository(t *testing.T) {
+	f := &FileRunsRepository{Root: t.TempDir(), Prefix: "minion-runs/localdev"}
+
+	first := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	second := time.Now().UTC().Truncate(time.Second)
+
+	runs := []*Run{
+		{ID: "run1", JobID: "job1", Account: "metal", Group: "metallica", StartedAt: &first, Status: RunStatusSucceeded},
+		{ID: "run2", JobID: "job1", Account: "metal", Group: "metallica", StartedAt: &second, Status: RunStatusRunning},
+		{ID: "run3", JobID: "job2", Account: "metal", Group: "metallica", StartedAt: &second, Status: RunStatusRunning},
+	}
+
+	for _, r := range runs {
+		if err := f.Put(context.TODO(), r); err != nil {
+			t.Fatalf("expected nil error, got %s", err)
+		}
+	}
+
+	runs[1].Status = RunStatusFailed
+	if err := f.Put(context.TODO(), runs[1]); err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	out, err := f.Get(context.TODO(), "metal", "metallica", "job1", "run2")
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if out.Status != RunStatusFailed {
+		t.Errorf("expected updated run status %s, got %s", RunStatusFailed, out.Status)
+	}
+
+	list, err := f.List(context.TODO(), "metal", "metallica", "job1", 0)
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if len(list) != 2 || list[0].ID != "run2" || list[1].ID != "run1" {
+		t.Errorf("expected runs run2, run1 most recent first, got %+v", list)
+	}
+
+	if list, err := f.List(context.TODO(), "metal", "metallica", "job1", 1); err != nil || len(list) != 1 || list[0].ID != "run2" {
+		t.Errorf("expected the most recent run run2, got %+v (%v)", list, err)
+	}
+
+	if list, err := f.List(context.TODO(), "metal", "metallica", "job3", 0); err != nil || len(list) != 0 {
+		t.Errorf("expected no runs for a job that never ran, got %+v (%v)", list, err)
+	}
+
+	var aerr apierror.Error
+	if _, err := f.Get(context.TODO(), "metal", "metallica", "job1", "run4"); !errors.As(err, &aerr) || aerr.Code != apierror.ErrNotFound {
+		t.Errorf("expected not found error, got %v", err)
+	}
+
+	if err := f.Put(context.TODO(), &Run{ID: "run5"}); !errors.As(err, &aerr) || aerr.Code != apierror.ErrBadRequest {
+		t.Errorf("expected bad request for a run without a job, got %v", err)
+	}
+
+	// runs started before the given time are pruned
+	if err := f.Prune(context.TODO(), "metal", "metallica", "job1", 0, second); err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if list, err := f.List(context.TODO(), "metal", "metallica", "job1", 0); err != nil || len(list) != 1 || list[0].ID != "run2" {
+		t.Errorf("expected run run2 after pruning by age, got %+v (%v)", list, err)
+	}
+
+	// only the newest runs are kept
+	third := second.Add(time.Minute)
+	if err := f.Put(context.TODO(), &Run{ID: "run6", JobID: "job1", Account: "metal", Group: "metallica", StartedAt: &third}); err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if err := f.Prune(context.TODO(), "metal", "metallica", "job1", 1, time.Time{}); err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if list, err := f.List(context.TODO(), "metal", "metallica", "job1", 0); err != nil || len(list) != 1 || list[0].ID != "run6" {
+		t.Errorf("expected run run6 after pruning by count, got %+v (%v)", list, err)
+	}
+
+	if err := f.Delete(context.TODO(), "metal", "metallica", "job1"); err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if list, err := f.List(context.TODO(), "metal", "metallica", "job1", 0); err != nil || len(list) != 0 {
+		t.Errorf("expected no runs after deleting, got %+v (%v)", list, err)
+	}
+
+	// the runs of other jobs are kept
+	if list, err := f.List(context.TODO(), "metal", "metallica", "job2", 0); err != nil || len(list) != 1 {
+		t.Errorf("expected the runs of job2 to be kept, got %+v (%v)", list, err)
+	}
+
+	if err := f.Delete(context.TODO(), "metal", "metallica", "../job2"); !errors.As(err, &aerr) || aerr.Code != apierror.ErrBadRequest {
+		t.Errorf("expected bad request for deleting the runs of an invalid job, got %v", err)
+	}
+}
@@ -0,0 +1,273 @@
+package jobs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultHTTPTimeout is the default timeout of a request to a spinup api
+	DefaultHTTPTimeout = 30 * time.Second
+	// DefaultHTTPRetryDelay is the default delay before the first retry of a failed request, it doubles with every retry
+	DefaultHTTPRetryDelay = time.Second
+)
+
+// defaultHTTPClient is shared by the runners that don't configure their http client, so they reuse connections
+var defaultHTTPClient = &HTTPClient{
+	Client: &http.Client{
+		Timeout:   DefaultHTTPTimeout,
+		Transport: newHTTPTransport(),
+	},
+	RetryDelay: DefaultHTTPRetryDelay,
+}
+
+// HTTPClient is the http client used by the runners to call the spinup apis.  Idempotent requests that fail
+// with a network error or a 502, 503 or 504 response are retried up to Retries times.
+type HTTPClient struct {
+	Client     *http.Client
+	Retries    int
+	RetryDelay time.Duration
+}
+
+// newHTTPClient configures an http client from the http section of a runner configuration.  If the http section
+// isn't set, the shared default client is returned.
+func newHTTPClient(config interface{}) (*HTTPClient, error) {
+	if config == nil {
+		return defaultHTTPClient, nil
+	}
+
+	c, ok := config.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("http must be a map")
+	}
+
+	transport := newHTTPTransport()
+	client := &HTTPClient{
+		Client: &http.Client{
+			Timeout:   DefaultHTTPTimeout,
+			Transport: transport,
+		},
+		RetryDelay: DefaultHTTPRetryDelay,
+	}
+
+	for _, d := range []struct {
+		key string
+		val *time.Duration
+	}{{"timeout", &client.Client.Timeout}, {"retryDelay", &client.RetryDelay}, {"idleConnTimeout", &transport.IdleConnTimeout}} {
+		s, ok := c[d.key].(string)
+		if !ok || s == "" {
+			continue
+		}
+
+		duration, err := time.ParseDuration(s)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid http %s '%s'", d.key, s)
+		}
+		*d.val = duration
+	}
+
+	for _, n := range []struct {
+		key string
+		val *int
+	}{{"retries", &client.Retries}, {"maxIdleConns", &transport.MaxIdleConns}, {"maxIdleConnsPerHost", &transport.MaxIdleConnsPerHost}} {
+		v, ok := c[n.key]
+		if !ok {
+			continue
+		}
+
+		i, ok := configInt(v)
+		if !ok || i < 0 {
+			return nil, fmt.Errorf("invalid http %s %v", n.key, v)
+		}
+		*n.val = i
+	}
+
+	if v, ok := c["disableKeepAlives"].(bool); ok {
+		transport.DisableKeepAlives = v
+	}
+
+	if v, ok := c["proxy"].(string); ok && v != "" {
+		u, err := url.Parse(v)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid http proxy '%s'", v)
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+
+	tlsConfig, err := newTLSConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	return client, nil
+}
+
+// newHTTPTransport returns a transport with the defaults of the http package default transport
+func newHTTPTransport() *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// newTLSConfig returns the tls configuration with the custom ca bundle and the client certificate from the http
+// section of a runner configuration, or nil if neither is set
+func newTLSConfig(c map[string]interface{}) (*tls.Config, error) {
+	caBundle, _ := c["caBundle"].(string)
+	clientCert, _ := c["clientCert"].(string)
+	clientKey, _ := c["clientKey"].(string)
+
+	if caBundle == "" && clientCert == "" && clientKey == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caBundle != "" {
+		pem, err := ioutil.ReadFile(caBundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read http caBundle: %s", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in http caBundle %s", caBundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if clientCert != "" || clientKey != "" {
+		if clientCert == "" || clientKey == "" {
+			return nil, errors.New("http clientCert and clientKey are both required for client certificate authentication")
+		}
+
+		cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load http client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// Do sends the request.  Idempotent requests are retried with an increasing delay when the request fails or
+// the api is unavailable, until the retries are used up or the request context is done.  Signed requests are
+// signed again for every retry.  A nil client uses the shared default client.
+func (c *HTTPClient) Do(req *http.Request) (*http.Response, error) {
+	if c == nil {
+		c = defaultHTTPClient
+	}
+
+	client := c.Client
+	if client == nil {
+		client = defaultHTTPClient.Client
+	}
+
+	retries := c.Retries
+	if !idempotent(req) {
+		retries = 0
+	}
+
+	delay := c.RetryDelay
+	for attempt := 0; ; attempt++ {
+		res, err := client.Do(req)
+		if attempt >= retries || !retryable(res, err) {
+			return res, err
+		}
+
+		if err != nil {
+			log.Warnf("%s %s failed, retrying in %s: %s", req.Method, req.URL, delay, err)
+		} else {
+			log.Warnf("%s %s returned %s, retrying in %s", req.Method, req.URL, res.Status, delay)
+			res.Body.Close()
+		}
+
+		if err := sleep(req.Context(), delay); err != nil {
+			return nil, err
+		}
+		delay *= 2
+
+		if req, err = rewind(req); err != nil {
+			return nil, err
+		}
+
+		if err := resign(req); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// idempotent returns true if the request can safely be sent again
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	default:
+		return false
+	}
+}
+
+// retryable returns true if the request failed or the response means the api is temporarily unavailable
+func retryable(res *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	switch res.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// rewind returns a copy of the request with a new body to send it again
+func rewind(req *http.Request) (*http.Request, error) {
+	out := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		out.Body = body
+	}
+	return out, nil
+}
+
+// sleep waits for the delay or until the context is done
+func sleep(ctx context.Context, delay time.Duration) error {
+	t := time.NewTimer(delay)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
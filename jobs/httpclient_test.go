@@ -0,0 +1,188 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	out, err := newHTTPClient(nil)
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if out != defaultHTTPClient {
+		t.Errorf("expected the default client without configuration, got %+v", out)
+	}
+
+	for _, config := range []interface{}{
+		"http",
+		map[string]interface{}{"timeout": "soon"},
+		map[string]interface{}{"retryDelay": "-1s"},
+		map[string]interface{}{"retries": float64(-1)},
+		map[string]interface{}{"maxIdleConns": "lots"},
+		map[string]interface{}{"proxy": "not a proxy"},
+		map[string]interface{}{"caBundle": "/does/not/exist.pem"},
+		map[string]interface{}{"clientCert": "/path/to/cert.pem"},
+	} {
+		if _, err := newHTTPClient(config); err == nil {
+			t.Errorf("expected error for http config %v, got nil", config)
+		}
+	}
+
+	out, err = newHTTPClient(map[string]interface{}{
+		"timeout":             "5s",
+		"retries":             float64(3),
+		"retryDelay":          "100ms",
+		"maxIdleConns":        float64(20),
+		"maxIdleConnsPerHost": float64(5),
+		"idleConnTimeout":     "1m",
+		"disableKeepAlives":   true,
+		"proxy":               "http://proxy.example.com:3128",
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if out.Client.Timeout != 5*time.Second || out.Retries != 3 || out.RetryDelay != 100*time.Millisecond {
+		t.Errorf("unexpected client %+v", out)
+	}
+
+	transport := out.Client.Transport.(*http.Transport)
+	if transport.MaxIdleConns != 20 || transport.MaxIdleConnsPerHost != 5 || transport.IdleConnTimeout != time.Minute || !transport.DisableKeepAlives {
+		t.Errorf("unexpected transport %+v", transport)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	if proxy, err := transport.Proxy(req); err != nil || proxy == nil || proxy.Host != "proxy.example.com:3128" {
+		t.Errorf("expected proxy proxy.example.com:3128, got %v (%v)", proxy, err)
+	}
+}
+
+func TestNewHTTPClientCABundle(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	defer ts.Close()
+
+	caBundle := filepath.Join(t.TempDir(), "ca.pem")
+	if err := ioutil.WriteFile(caBundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := newHTTPClient(map[string]interface{}{"caBundle": caBundle})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("expected nil error with the ca bundle, got %s", err)
+	}
+	res.Body.Close()
+
+	req, _ = http.NewRequest(http.MethodGet, ts.URL, nil)
+	if _, err := defaultHTTPClient.Do(req); err == nil {
+		t.Error("expected error without the ca bundle, got nil")
+	}
+}
+
+func TestHTTPClientDo(t *testing.T) {
+	var mux sync.Mutex
+	attempts := 0
+	var bodies []string
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		mux.Lock()
+		defer mux.Unlock()
+
+		attempts++
+		bodies = append(bodies, string(body))
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("OK"))
+	}))
+	defer ts.Close()
+
+	reset := func() {
+		mux.Lock()
+		defer mux.Unlock()
+		attempts = 0
+		bodies = nil
+	}
+
+	client := &HTTPClient{Client: &http.Client{Timeout: time.Second}, Retries: 2, RetryDelay: time.Millisecond}
+
+	tests := []struct {
+		method   string
+		retries  int
+		status   int
+		attempts int
+	}{
+		{method: http.MethodGet, retries: 2, status: http.StatusOK, attempts: 3},
+		{method: http.MethodPut, retries: 2, status: http.StatusOK, attempts: 3},
+		{method: http.MethodPut, retries: 1, status: http.StatusServiceUnavailable, attempts: 2},
+		{method: http.MethodPost, retries: 2, status: http.StatusServiceUnavailable, attempts: 1},
+	}
+
+	for _, test := range tests {
+		reset()
+		client.Retries = test.retries
+
+		req, _ := http.NewRequest(test.method, ts.URL, bytes.NewReader([]byte(`{"state":"start"}`)))
+		res, err := client.Do(req)
+		if err != nil {
+			t.Errorf("expected nil error for %s, got %s", test.method, err)
+			continue
+		}
+		res.Body.Close()
+
+		if res.StatusCode != test.status || attempts != test.attempts {
+			t.Errorf("expected status %d after %d attempts for %s, got %d after %d", test.status, test.attempts, test.method, res.StatusCode, attempts)
+		}
+
+		for _, b := range bodies {
+			if b != `{"state":"start"}` {
+				t.Errorf("expected the body to be sent with every attempt, got '%s'", b)
+			}
+		}
+	}
+
+	// retrying stops when the context is done
+	reset()
+	client.Retries, client.RetryDelay = 2, time.Minute
+	ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+	if _, err := client.Do(req); err == nil {
+		t.Error("expected error when the context is done, got nil")
+	}
+
+	// a nil client uses the default client
+	reset()
+	var nilClient *HTTPClient
+	req, _ = http.NewRequest(http.MethodGet, ts.URL, nil)
+	res, err := nilClient.Do(req)
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected default client not to retry, got %d", res.StatusCode)
+	}
+}
@@ -8,8 +8,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
 	"text/template"
-	"time"
 
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
@@ -21,6 +21,24 @@ type InstanceRunner struct {
 	Token            string
 	Encrypt          bool
 	AuthHeader       string
+	Verifier         *StateVerifier
+	Batch            *Batch
+	Client           *HTTPClient
+	Signer           *Signer
+}
+
+func init() {
+	RegisterRunner(RunnerType{
+		RunnerDescription: RunnerDescription{
+			Type:            "instance",
+			Description:     "changes the power state of an instance or a batch of instances",
+			Actions:         []string{"reboot", "stop", "start"},
+			RequiredDetails: []string{"instance_action", "instance_id|instance_ids|instance_selector"},
+		},
+		New: func(config map[string]interface{}) (Runner, error) {
+			return NewInstanceRunner(config)
+		},
+	})
 }
 
 // NewInstanceRunner creates and configures a new instance runner.  An endpoint or endpoint template is required
@@ -60,58 +78,134 @@ func NewInstanceRunner(config map[string]interface{}) (*InstanceRunner, error) {
 		token = v
 	}
 
+	signer, err := newSigner(config, token)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newHTTPClient(config["http"])
+	if err != nil {
+		return nil, err
+	}
+
+	verifier, err := newStateVerifier(config["verify"], map[string]string{
+		"reboot": "running",
+		"start":  "running",
+		"stop":   "stopped",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	batch, err := newBatch(config)
+	if err != nil {
+		return nil, err
+	}
+	batch.Client = client
+
+	if verifier != nil {
+		verifier.Client = client
+	}
+
 	return &InstanceRunner{
 		Endpoint:         endpoint,
 		EndpointTemplate: endpointTemplate,
 		Token:            token,
 		Encrypt:          encrypt,
 		AuthHeader:       authHeader,
+		Verifier:         verifier,
+		Batch:            batch,
+		Client:           client,
+		Signer:           signer,
 	}, nil
 }
 
-// Run executes the InstanceRunner.  The instance_id and instance_action are required.  Allowable actions
-// are 'start', 'stop' and 'reboot'.  If an endpoint is configured on the runner, it will be used, otherwise
-// we assume there is an endpointTemplate and try to execute it.  Instance actions are currently only
-// executed with the PUT method and a body containing {"state": "action"}.
+// Validate validates the InstanceRunner parameters.  The instance_action and one of instance_id, instance_ids or
+// instance_selector are required and the instance_action must be 'start', 'stop' or 'reboot'.  The optional verify
+// parameter can only be 'true' if verification is configured for the runner.
+func (r *InstanceRunner) Validate(parameters interface{}) error {
+	v := newValidator(parameters)
+	r.Batch.validate(v, "instance_id", "instance_ids", "instance_selector")
+	v.oneOf("instance_action", "reboot", "stop", "start")
+	if verify, ok := v.boolean("verify"); ok && verify && r.Verifier == nil {
+		v.problem("verify is not configured for the runner")
+	}
+	return v.err()
+}
+
+// Run executes the InstanceRunner.  The instance_action and an instance_id, a comma separated list of instance_ids
+// or an instance_selector are required.  Allowable actions are 'start', 'stop' and 'reboot'.  A list of instances
+// or the instances matching the selector are acted on in parallel and the result of each is reported.  If an
+// endpoint is configured on the runner, it will be used, otherwise we assume there is an endpointTemplate and
+// try to execute it.  Instance actions are currently only executed with the PUT method and a body containing
+// {"state": "action"}.
 func (r *InstanceRunner) Run(ctx context.Context, account string, parameters interface{}) (string, error) {
+	return runResult(r.RunResult(ctx, account, parameters))
+}
+
+// RunResult executes the InstanceRunner like Run and returns a structured result with the targets, the status code
+// and an excerpt of the response.  Batches report the status of each target in the result data.
+func (r *InstanceRunner) RunResult(ctx context.Context, account string, parameters interface{}) (*Result, error) {
 	if account == "" {
-		return "", errors.New("account is required")
+		return nil, errors.New("account is required")
 	}
 
 	log.Debugf("initializing instance runner %+v in account %s,  with parameters %+v", r, account, parameters)
 
 	params, ok := parameters.(map[string]string)
 	if !ok {
-		return "", NewRunnerError(ErrMissingDetails, "wrong type", errors.New("parameters list is not a map[string]string"))
+		return nil, NewRunnerError(ErrMissingDetails, "wrong type", errors.New("parameters list is not a map[string]string"))
 	}
 
+	batched := r.Batch.batched(ctx, params, "instance_ids", "instance_selector")
+
 	instanceID, ok := params["instance_id"]
-	if !ok {
-		return "", NewRunnerError(ErrMissingDetails, "missing instance_id", nil)
+	if !ok && !batched {
+		return nil, NewRunnerError(ErrMissingDetails, "missing instance_id", nil)
 	}
 
 	action, ok := params["instance_action"]
 	if !ok {
-		return "", NewRunnerError(ErrMissingDetails, "missing instance_action", nil)
+		return nil, NewRunnerError(ErrMissingDetails, "missing instance_action", nil)
 	}
 
+	if batched {
+		targets, err := r.Batch.targets(ctx, account, params, "instance_ids", "instance_selector", r.authorize)
+		if err != nil {
+			return nil, err
+		}
+
+		return r.Batch.run(ctx, targets, func(ctx context.Context, instanceID string) (string, error) {
+			result, err := r.act(ctx, account, instanceID, action, params)
+			return result.Output(), err
+		})
+	}
+
+	return r.act(ctx, account, instanceID, action, params)
+}
+
+// act executes the action on one instance and verifies its target state, if verification is enabled
+func (r *InstanceRunner) act(ctx context.Context, account, instanceID, action string, params map[string]string) (*Result, error) {
+	input := struct {
+		Account    string
+		InstanceID string
+	}{account, instanceID}
+
+	result := &Result{Targets: []string{instanceID}}
+	result.SetData("action", action)
+
 	endpoint := r.Endpoint
 	if endpoint == "" {
 		log.Debugf("endpoint is empty, attempting to use endpoint template '%s'", r.EndpointTemplate)
 
-		input := struct {
-			Account    string
-			InstanceID string
-		}{account, instanceID}
-
 		tmpl, err := template.New("endpoint").Parse(r.EndpointTemplate)
 		if err != nil {
-			return "", NewRunnerError(ErrPreExecFailure, "template parsing failed", err)
+			return result, NewRunnerError(ErrPreExecFailure, "template parsing failed", err)
 		}
 
 		var out bytes.Buffer
 		if err := tmpl.Execute(&out, &input); err != nil {
-			return "", NewRunnerError(ErrPreExecFailure, "template execution failed", err)
+			return result, NewRunnerError(ErrPreExecFailure, "template execution failed", err)
 		}
 
 		endpoint = out.String()
@@ -122,54 +216,81 @@ func (r *InstanceRunner) Run(ctx context.Context, account string, parameters int
 	case "reboot", "stop", "start":
 		j, err := json.Marshal(map[string]string{"state": action})
 		if err != nil {
-			return "", err
+			return result, err
 		}
 
-		client := &http.Client{
-			Timeout: time.Second * 30,
-		}
 		req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, bytes.NewReader(j))
 		if err != nil {
-			return "", NewRunnerError(ErrPreExecFailure, "building http request failed", err)
+			return result, NewRunnerError(ErrPreExecFailure, "building http request failed", err)
 		}
 
-		if r.Token != "" {
-			log.Debugf("setting token header %s for %s %s", r.AuthHeader, action, instanceID)
-			if r.Encrypt {
-				e, err := bcrypt.GenerateFromPassword([]byte(r.Token), 6)
-				if err != nil {
-					return "", NewRunnerError(ErrExecFailure, "unable to hash token", err)
-				}
-
-				log.Debug("token is encrypted")
-
-				req.Header.Set(r.AuthHeader, string(e))
-			} else {
-				req.Header.Set(r.AuthHeader, r.Token)
-			}
+		if err := r.authorize(req); err != nil {
+			return result, err
 		}
 
 		log.Infof("instance runner executing '%s' on %s", action, instanceID)
 
-		res, err := client.Do(req)
+		res, err := r.Client.Do(req)
 		if err != nil {
-			return "", NewRunnerError(ErrExecFailure, "http request failed", err)
+			return result, NewRunnerError(ErrExecFailure, "http request failed", err)
 		}
 		defer res.Body.Close()
 
 		body, err := ioutil.ReadAll(res.Body)
 		if err != nil {
-			return "", NewRunnerError(ErrPostExecFailure, "reading response body failed", err)
+			return result, NewRunnerError(ErrPostExecFailure, "reading response body failed", err)
 		}
 
 		log.Debugf("got response %s(%d) for endpoint %s: %s", res.Status, res.StatusCode, endpoint, body)
 
+		result.StatusCode = res.StatusCode
+		result.Response = excerpt(body)
+
 		if res.StatusCode >= 300 {
-			return "", NewRunnerError(ErrExecFailure, "unexpected http response", errors.New("unexpected response from instanceRunner api: "+res.Status))
+			return result, NewRunnerStatusError(ErrExecFailure, "unexpected http response", res.StatusCode, errors.New("unexpected response from instanceRunner api: "+res.Status))
+		}
+
+		out := string(body)
+		if r.Verifier.enabled(params) {
+			summary, err := r.Verifier.Verify(ctx, &input, instanceID, action, r.authorize)
+			if err != nil {
+				return result, err
+			}
+			out = strings.TrimSpace(out + "\n" + summary)
+			result.SetData("verified", "true")
 		}
 
-		return string(body), nil
+		result.Message = out
+		return result, nil
 	default:
-		return "", fmt.Errorf("unexpected action '%s' for instance %s", action, instanceID)
+		return result, fmt.Errorf("unexpected action '%s' for instance %s", action, instanceID)
 	}
 }
+
+// authorize signs a request to the instance api or sets the token header, if a token is configured
+func (r *InstanceRunner) authorize(req *http.Request) error {
+	if r.Signer != nil {
+		log.Debug("signing request")
+		return r.Signer.Sign(req)
+	}
+
+	if r.Token == "" {
+		return nil
+	}
+
+	log.Debugf("setting token header %s", r.AuthHeader)
+	if r.Encrypt {
+		e, err := bcrypt.GenerateFromPassword([]byte(r.Token), 6)
+		if err != nil {
+			return NewRunnerError(ErrExecFailure, "unable to hash token", err)
+		}
+
+		log.Debug("token is encrypted")
+
+		req.Header.Set(r.AuthHeader, string(e))
+	} else {
+		req.Header.Set(r.AuthHeader, r.Token)
+	}
+
+	return nil
+}
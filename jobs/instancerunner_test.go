@@ -244,3 +244,186 @@ func TestInstanceRunnerRun(t *testing.T) {
 		t.Error("expected error for bad URI, got nil")
 	}
 }
+
+func TestInstanceRunnerValidate(t *testing.T) {
+	tests := []struct {
+		params   interface{}
+		problems []string
+	}{
+		{
+			params:   map[string]string{"instance_id": "i-123456", "instance_action": "reboot"},
+			problems: nil,
+		},
+		{
+			params:   map[string]string{"instance_id": "i-123456", "instance_action": "start"},
+			problems: nil,
+		},
+		{
+			params:   map[string]string{"instance_id": "i-123456", "instance_action": "terminate"},
+			problems: []string{"unexpected instance_action 'terminate', must be one of 'reboot', 'stop', 'start'"},
+		},
+		{
+			params:   map[string]string{},
+			problems: []string{"missing instance_id, instance_ids or instance_selector", "missing instance_action"},
+		},
+		{
+			params:   map[string]string{"instance_id": "i-123456", "instance_action": "stop", "verify": "false"},
+			problems: nil,
+		},
+		{
+			params:   map[string]string{"instance_id": "i-123456", "instance_action": "stop", "verify": "true"},
+			problems: []string{"verify is not configured for the runner"},
+		},
+		{
+			params:   map[string]string{"instance_id": "i-123456", "instance_action": "stop", "verify": "yes"},
+			problems: []string{"verify must be 'true' or 'false': 'yes'"},
+		},
+		{
+			params:   "instance_id=i-123456",
+			problems: []string{"parameters list is not a map[string]string", "missing instance_id, instance_ids or instance_selector", "missing instance_action"},
+		},
+	}
+
+	r := &InstanceRunner{}
+	for _, test := range tests {
+		err := r.Validate(test.params)
+		if test.problems == nil {
+			if err != nil {
+				t.Errorf("expected nil error for %+v, got %s", test.params, err)
+			}
+			continue
+		}
+
+		verr, ok := err.(ValidationError)
+		if !ok {
+			t.Errorf("expected ValidationError for %+v, got %v", test.params, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(verr.Problems, test.problems) {
+			t.Errorf("expected problems %v, got %v", test.problems, verr.Problems)
+		}
+	}
+}
+
+func TestInstanceRunnerRunVerify(t *testing.T) {
+	status := &mockStatusServer{states: []string{"stopping", "stopped"}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/power/myaccount/i-123456", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprint(w, "OK")
+	})
+	mux.Handle("/", status)
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	r, err := NewInstanceRunner(map[string]interface{}{
+		"token":            "my-awesome-token",
+		"endpointTemplate": ts.URL + "/power/{{.Account}}/{{.InstanceID}}",
+		"verify": map[string]interface{}{
+			"endpointTemplate": ts.URL + "/{{.Account}}/{{.InstanceID}}",
+			"stateField":       "Instance.State",
+			"interval":         "10ms",
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	out, err := r.Run(context.TODO(), "myaccount", map[string]string{
+		"instance_id":     "i-123456",
+		"instance_action": "stop",
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	expected := "OK\nverified i-123456 reached state 'stopped' in 0s: stopping (0s) -> stopped (0s)"
+	if out != expected {
+		t.Errorf("expected output '%s', got '%s'", expected, out)
+	}
+
+	// verification can be turned off for a job
+	calls := status.calls
+	out, err = r.Run(context.TODO(), "myaccount", map[string]string{
+		"instance_id":     "i-123456",
+		"instance_action": "stop",
+		"verify":          "false",
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if out != "OK" || status.calls != calls {
+		t.Errorf("expected output 'OK' without verifying, got '%s' after %d status calls", out, status.calls-calls)
+	}
+}
+
+func TestInstanceRunnerRunBatch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/myaccount/instances":
+			fmt.Fprint(w, `[{"id": "i-1"}, {"id": "i-2"}]`)
+		case "/power/myaccount/i-1", "/power/myaccount/i-2":
+			fmt.Fprint(w, "OK")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	r, err := NewInstanceRunner(map[string]interface{}{
+		"endpointTemplate":         ts.URL + "/power/{{.Account}}/{{.InstanceID}}",
+		"selectorEndpointTemplate": ts.URL + "/{{.Account}}/instances?tag={{.Selector}}",
+		"parallelism":              float64(2),
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	out, err := r.Run(context.TODO(), "myaccount", map[string]string{
+		"instance_selector": "env=dev",
+		"instance_action":   "stop",
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if expected := "i-1: succeeded: OK\ni-2: succeeded: OK"; out != expected {
+		t.Errorf("expected output '%s', got '%s'", expected, out)
+	}
+
+	// a partial failure reports every instance and returns the failed ones
+	_, err = r.Run(context.TODO(), "myaccount", map[string]string{
+		"instance_ids":    "i-1,i-3,i-2,i-4",
+		"instance_action": "stop",
+	})
+
+	var rErr RunnerError
+	if !errors.As(err, &rErr) {
+		t.Fatalf("expected RunnerError, got %v", err)
+	}
+
+	if rErr.StatusCode != http.StatusNotFound || !reflect.DeepEqual(rErr.Targets, []string{"i-3", "i-4"}) {
+		t.Errorf("expected failed targets [i-3 i-4] with status 404, got %v (%d)", rErr.Targets, rErr.StatusCode)
+	}
+
+	expected := "2 of 4 targets failed\ni-1: succeeded: OK\ni-3: failed: "
+	if len(rErr.Message) < len(expected) || rErr.Message[:len(expected)] != expected {
+		t.Errorf("expected message to start with '%s', got '%s'", expected, rErr.Message)
+	}
+
+	// a retry only acts on the failed instances
+	out, err = r.Run(WithTargets(context.TODO(), []string{"i-2"}), "myaccount", map[string]string{
+		"instance_ids":    "i-1,i-3,i-2,i-4",
+		"instance_action": "stop",
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if expected := "i-2: succeeded: OK"; out != expected {
+		t.Errorf("expected output '%s', got '%s'", expected, out)
+	}
+}
@@ -0,0 +1,84 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Invalidation is a change to a job in the repository that invalidates the cached copies of it.  If the
+// ID is empty, every job in the group is invalidated.  The Origin is the id of the node that made the change.
+type Invalidation struct {
+	Account string `json:"account"`
+	Group   string `json:"group"`
+	ID      string `json:"id,omitempty"`
+	Origin  string `json:"origin"`
+}
+
+// Invalidator broadcasts changes to jobs to all of the minion nodes
+type Invalidator interface {
+	Invalidate(inv *Invalidation) error
+	Invalidations(ctx context.Context) (<-chan *Invalidation, error)
+}
+
+// Invalidate publishes a job invalidation on the invalidation channel
+func (q *RedisQueuer) Invalidate(inv *Invalidation) error {
+	out, err := json.Marshal(inv)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode invalidation")
+	}
+
+	log.Debugf("publishing invalidation %s", out)
+
+	if err := q.client.Publish(q.InvalidationChannel, string(out)).Err(); err != nil {
+		return errors.Wrap(err, "failed publishing invalidation")
+	}
+
+	return nil
+}
+
+// Invalidations subscribes to the invalidation channel and returns the received invalidations until the
+// context is cancelled.  Invalidations published while the subscription is disconnected are lost.
+func (q *RedisQueuer) Invalidations(ctx context.Context) (<-chan *Invalidation, error) {
+	pubsub := q.client.Subscribe(q.InvalidationChannel)
+
+	// wait for the subscription to be confirmed
+	if _, err := pubsub.Receive(); err != nil {
+		pubsub.Close()
+		return nil, errors.Wrap(err, "failed subscribing to invalidations")
+	}
+
+	out := make(chan *Invalidation)
+	go func() {
+		defer close(out)
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+
+				inv := &Invalidation{}
+				if err := json.Unmarshal([]byte(msg.Payload), inv); err != nil {
+					log.Warnf("failed decoding invalidation %s: %s", msg.Payload, err)
+					continue
+				}
+
+				select {
+				case out <- inv:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return out, nil
+}
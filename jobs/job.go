@@ -11,6 +11,15 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	// MisfireSkip skips any missed runs of a job
+	MisfireSkip = "skip"
+	// MisfireRunOnce runs a job once if one or more runs were missed
+	MisfireRunOnce = "run_once"
+	// MisfireRunAll runs each missed run of a job, up to the misfire limit
+	MisfireRunAll = "run_all"
+)
+
 // Tag is a key value struct for holding
 // tag information
 type Tag struct {
@@ -25,11 +34,17 @@ type Job struct {
 	Details            map[string]string
 	Enabled            bool
 	ID                 string
+	MisfireGrace       *time.Duration
+	MisfireLimit       int
+	MisfirePolicy      string
 	ModifiedBy         string
 	ModifiedAt         *time.Time
 	Name               string
 	Group              string
+	Retry              *RetryPolicy
 	ScheduleExpression string
+	Timezone           string
+	Version            int64
 }
 
 // NewID returns a new ID for a job.  Currently this is just a UUID string
@@ -108,6 +123,48 @@ func (m *Job) UnmarshalJSON(j []byte) error {
 		m.ID = s
 	}
 
+	if misfireGrace, ok := rawStrings["misfire_grace"]; ok {
+		s, ok := misfireGrace.(string)
+		if !ok {
+			msg := fmt.Sprintf("misfire_grace is not a string: %+v", rawStrings["misfire_grace"])
+			return errors.New(msg)
+		}
+
+		if s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil || d < 0 {
+				msg := fmt.Sprintf("misfire_grace is not a valid duration: '%s'", s)
+				return errors.New(msg)
+			}
+			m.MisfireGrace = &d
+		}
+	}
+
+	if misfireLimit, ok := rawStrings["misfire_limit"]; ok {
+		f, ok := misfireLimit.(float64)
+		if !ok || f < 0 || f != float64(int(f)) {
+			msg := fmt.Sprintf("misfire_limit is not a positive integer: %+v", rawStrings["misfire_limit"])
+			return errors.New(msg)
+		}
+		m.MisfireLimit = int(f)
+	}
+
+	if misfirePolicy, ok := rawStrings["misfire_policy"]; ok {
+		s, ok := misfirePolicy.(string)
+		if !ok {
+			msg := fmt.Sprintf("misfire_policy is not a string: %+v", rawStrings["misfire_policy"])
+			return errors.New(msg)
+		}
+
+		switch s {
+		case "", MisfireSkip, MisfireRunOnce, MisfireRunAll:
+			m.MisfirePolicy = s
+		default:
+			msg := fmt.Sprintf("misfire_policy must be one of '%s', '%s' or '%s': '%s'", MisfireSkip, MisfireRunOnce, MisfireRunAll, s)
+			return errors.New(msg)
+		}
+	}
+
 	if modifiedAt, ok := rawStrings["modified_at"]; ok {
 		ma, ok := modifiedAt.(string)
 		if !ok {
@@ -145,6 +202,24 @@ func (m *Job) UnmarshalJSON(j []byte) error {
 		m.Name = s
 	}
 
+	if retry, ok := rawStrings["retry"]; ok && retry != nil {
+		if _, ok := retry.(map[string]interface{}); !ok {
+			msg := fmt.Sprintf("retry is not an object: %+v", rawStrings["retry"])
+			return errors.New(msg)
+		}
+
+		r, err := json.Marshal(retry)
+		if err != nil {
+			return err
+		}
+
+		policy := &RetryPolicy{}
+		if err := json.Unmarshal(r, policy); err != nil {
+			return err
+		}
+		m.Retry = policy
+	}
+
 	if scheduleExpression, ok := rawStrings["schedule_expression"]; ok {
 		s, ok := scheduleExpression.(string)
 		if !ok {
@@ -162,6 +237,35 @@ func (m *Job) UnmarshalJSON(j []byte) error {
 		m.ScheduleExpression = s
 	}
 
+	if timezone, ok := rawStrings["timezone"]; ok {
+		s, ok := timezone.(string)
+		if !ok {
+			msg := fmt.Sprintf("timezone is not a string: %+v", rawStrings["timezone"])
+			return errors.New(msg)
+		}
+
+		// the local timezone depends on the host running minion, so only allow explicit IANA names
+		if s == "Local" {
+			return errors.New("timezone must be an IANA time zone name, not 'Local'")
+		}
+
+		if _, err := time.LoadLocation(s); err != nil {
+			msg := fmt.Sprintf("timezone is not a valid IANA time zone: '%s': %s", s, err)
+			return errors.New(msg)
+		}
+
+		m.Timezone = s
+	}
+
+	if version, ok := rawStrings["version"]; ok {
+		f, ok := version.(float64)
+		if !ok || f < 0 || f != float64(int64(f)) {
+			msg := fmt.Sprintf("version is not a positive integer: %+v", rawStrings["version"])
+			return errors.New(msg)
+		}
+		m.Version = int64(f)
+	}
+
 	if enabled, ok := rawStrings["enabled"]; ok {
 		s, ok := enabled.(bool)
 		if !ok {
@@ -181,6 +285,11 @@ func (m Job) MarshalJSON() ([]byte, error) {
 		modifiedAt = m.ModifiedAt.UTC().Truncate(time.Second).Format(time.RFC3339)
 	}
 
+	misfireGrace := ""
+	if m.MisfireGrace != nil {
+		misfireGrace = m.MisfireGrace.String()
+	}
+
 	job := struct {
 		Account            string            `json:"account"`
 		Description        string            `json:"description"`
@@ -191,8 +300,14 @@ func (m Job) MarshalJSON() ([]byte, error) {
 		ModifiedBy         string            `json:"modified_by"`
 		Name               string            `json:"name"`
 		ScheduleExpression string            `json:"schedule_expression"`
+		Timezone           string            `json:"timezone,omitempty"`
+		MisfirePolicy      string            `json:"misfire_policy,omitempty"`
+		MisfireLimit       int               `json:"misfire_limit,omitempty"`
+		MisfireGrace       string            `json:"misfire_grace,omitempty"`
+		Retry              *RetryPolicy      `json:"retry,omitempty"`
 		Enabled            bool              `json:"enabled"`
-	}{m.Account, m.Description, m.Details, m.Group, m.ID, modifiedAt, m.ModifiedBy, m.Name, m.ScheduleExpression, m.Enabled}
+		Version            int64             `json:"version,omitempty"`
+	}{m.Account, m.Description, m.Details, m.Group, m.ID, modifiedAt, m.ModifiedBy, m.Name, m.ScheduleExpression, m.Timezone, m.MisfirePolicy, m.MisfireLimit, misfireGrace, m.Retry, m.Enabled, m.Version}
 
 	return json.Marshal(job)
 }
@@ -205,7 +320,21 @@ func (m *Job) UnmarshalBinary(data []byte) error {
 	return m.UnmarshalJSON(data)
 }
 
-// NextRun returns the next invocation of the schedule expression
+// Location returns the location the job's schedule expression is evaluated in.  If the job
+// doesn't have a timezone set, UTC is returned.
+func (j *Job) Location() (*time.Location, error) {
+	if j.Timezone == "" {
+		return time.UTC, nil
+	}
+
+	return time.LoadLocation(j.Timezone)
+}
+
+// NextRun returns the next invocation of the schedule expression after t.  The schedule expression is
+// evaluated against the wall clock in the job's timezone and the returned time is in that location.
+// Around daylight saving time transitions, a wall clock time that is skipped when the clocks go forward
+// fires once at the end of the gap, and a wall clock time that occurs twice when the clocks go back only
+// fires on its first occurrence.
 func (j *Job) NextRun(t time.Time) (*time.Time, error) {
 	parser := cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
 
@@ -214,7 +343,64 @@ func (j *Job) NextRun(t time.Time) (*time.Time, error) {
 		return nil, err
 	}
 
-	next := c.Next(t)
+	loc, err := j.Location()
+	if err != nil {
+		return nil, err
+	}
 
-	return &next, nil
+	// the schedule is evaluated against a copy of the wall clock in UTC so the cron
+	// library never has to deal with daylight saving time transitions
+	wall := wallClock(t.In(loc))
+
+	// each iteration skips a wall clock time that already fired during a repeated hour,
+	// so this is bounded by the number of minutes in the largest possible overlap
+	for i := 0; i < 24*60; i++ {
+		wall = c.Next(wall)
+		if wall.IsZero() {
+			return &wall, nil
+		}
+
+		next := fromWallClock(wall, loc)
+		if next.After(t) {
+			return &next, nil
+		}
+	}
+
+	return nil, fmt.Errorf("failed to determine next run for schedule expression '%s' in %s", j.ScheduleExpression, loc)
+}
+
+// wallClock returns the wall clock time of t as a time in UTC
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// fromWallClock returns the instant in loc for the wall clock time represented in UTC by wall.  If the wall clock
+// time occurs twice in loc, the earlier instant is returned.  If the wall clock time doesn't exist in loc, the first
+// instant after the gap is returned.
+func fromWallClock(wall time.Time, loc *time.Location) time.Time {
+	t := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), wall.Nanosecond(), loc)
+
+	start, end := t.ZoneBounds()
+	if wallClock(t).Equal(wall) {
+		if start.IsZero() {
+			return t
+		}
+
+		// if the previous zone has a larger offset, the same wall clock time may exist before the transition
+		_, offset := t.Zone()
+		_, prevOffset := start.Add(-time.Nanosecond).Zone()
+		earlier := t.Add(time.Duration(offset-prevOffset) * time.Second)
+		if earlier.Before(start) && wallClock(earlier).Equal(wall) {
+			return earlier
+		}
+
+		return t
+	}
+
+	// the wall clock time was skipped, time.Date normalizes to one side of the
+	// transition so use the zone boundary on that side
+	if wallClock(t).After(wall) {
+		return start
+	}
+	return end
 }
@@ -117,6 +117,119 @@ func TestJobUnmarshalJSON(t *testing.T) {
 	if err := out.UnmarshalJSON([]byte(`{"enabled":"false"}`)); err == nil {
 		t.Error("expected error for bad enabled, got nil")
 	}
+
+	// timezone type
+	if err := out.UnmarshalJSON([]byte(`{"timezone":false}`)); err == nil {
+		t.Error("expected error for bad timezone type, got nil")
+	}
+
+	// timezone invalid
+	if err := out.UnmarshalJSON([]byte(`{"timezone":"America/Gotham"}`)); err == nil {
+		t.Error("expected error for bad timezone, got nil")
+	}
+
+	// timezone local
+	if err := out.UnmarshalJSON([]byte(`{"timezone":"Local"}`)); err == nil {
+		t.Error("expected error for local timezone, got nil")
+	}
+
+	// misfire_policy type
+	if err := out.UnmarshalJSON([]byte(`{"misfire_policy":false}`)); err == nil {
+		t.Error("expected error for bad misfire_policy type, got nil")
+	}
+
+	// misfire_policy invalid
+	if err := out.UnmarshalJSON([]byte(`{"misfire_policy":"sometimes"}`)); err == nil {
+		t.Error("expected error for bad misfire_policy, got nil")
+	}
+
+	// misfire_limit type
+	if err := out.UnmarshalJSON([]byte(`{"misfire_limit":"5"}`)); err == nil {
+		t.Error("expected error for bad misfire_limit type, got nil")
+	}
+
+	// misfire_limit negative
+	if err := out.UnmarshalJSON([]byte(`{"misfire_limit":-1}`)); err == nil {
+		t.Error("expected error for negative misfire_limit, got nil")
+	}
+
+	// misfire_grace type
+	if err := out.UnmarshalJSON([]byte(`{"misfire_grace":30}`)); err == nil {
+		t.Error("expected error for bad misfire_grace type, got nil")
+	}
+
+	// misfire_grace invalid
+	if err := out.UnmarshalJSON([]byte(`{"misfire_grace":"a while"}`)); err == nil {
+		t.Error("expected error for bad misfire_grace, got nil")
+	}
+
+	// misfire valid
+	out = &Job{}
+	if err := out.UnmarshalJSON([]byte(`{"misfire_policy":"run_all","misfire_limit":5,"misfire_grace":"30m"}`)); err != nil {
+		t.Errorf("expected nil error for valid misfire, got %s", err)
+	} else if out.MisfirePolicy != MisfireRunAll || out.MisfireLimit != 5 || out.MisfireGrace == nil || *out.MisfireGrace != 30*time.Minute {
+		t.Errorf("expected misfire to be run_all, 5, 30m, got %s, %d, %v", out.MisfirePolicy, out.MisfireLimit, out.MisfireGrace)
+	}
+
+	// misfire_grace explicitly 0
+	out = &Job{}
+	if err := out.UnmarshalJSON([]byte(`{"misfire_grace":"0s"}`)); err != nil {
+		t.Errorf("expected nil error for zero misfire_grace, got %s", err)
+	} else if out.MisfireGrace == nil || *out.MisfireGrace != 0 {
+		t.Errorf("expected misfire_grace to be set to 0, got %v", out.MisfireGrace)
+	}
+
+	// misfire_grace not set
+	out = &Job{}
+	if err := out.UnmarshalJSON([]byte(`{"misfire_grace":""}`)); err != nil {
+		t.Errorf("expected nil error for empty misfire_grace, got %s", err)
+	} else if out.MisfireGrace != nil {
+		t.Errorf("expected misfire_grace not to be set, got %s", *out.MisfireGrace)
+	}
+
+	// retry type
+	if err := out.UnmarshalJSON([]byte(`{"retry":"always"}`)); err == nil {
+		t.Error("expected error for bad retry type, got nil")
+	}
+
+	// retry invalid
+	if err := out.UnmarshalJSON([]byte(`{"retry":{"backoff":"linear"}}`)); err == nil {
+		t.Error("expected error for bad retry backoff, got nil")
+	}
+
+	// retry valid
+	out = &Job{}
+	if err := out.UnmarshalJSON([]byte(`{"retry":{"max_attempts":5,"backoff":"exponential","delay":"30s","retry_on":["5xx"]}}`)); err != nil {
+		t.Errorf("expected nil error for valid retry, got %s", err)
+	} else if out.Retry == nil || out.Retry.MaxAttempts != 5 || out.Retry.Backoff != BackoffExponential || out.Retry.Delay != 30*time.Second || len(out.Retry.RetryOn) != 1 {
+		t.Errorf("expected retry to be 5, exponential, 30s, [5xx], got %+v", out.Retry)
+	}
+
+	// version type
+	if err := out.UnmarshalJSON([]byte(`{"version":"3"}`)); err == nil {
+		t.Error("expected error for bad version type, got nil")
+	}
+
+	// version negative
+	if err := out.UnmarshalJSON([]byte(`{"version":-1}`)); err == nil {
+		t.Error("expected error for negative version, got nil")
+	}
+
+	// version valid
+	out = &Job{}
+	if err := out.UnmarshalJSON([]byte(`{"version":3}`)); err != nil {
+		t.Errorf("expected nil error for valid version, got %s", err)
+	} else if out.Version != 3 {
+		t.Errorf("expected version to be 3, got %d", out.Version)
+	}
+
+	// timezone valid
+	out = &Job{}
+	if err := out.UnmarshalJSON([]byte(`{"timezone":"America/New_York"}`)); err != nil {
+		t.Errorf("expected nil error for valid timezone, got %s", err)
+	} else if out.Timezone != "America/New_York" {
+		t.Errorf("expected timezone to be America/New_York, got %s", out.Timezone)
+	}
 }
 
 func TestMetadataMarshalJSON(t *testing.T) {
@@ -127,6 +240,7 @@ func TestMetadataMarshalJSON(t *testing.T) {
 	}
 
 	modifiedAt, _ := time.Parse(time.RFC3339, "2015-11-21T04:19:01.123Z")
+	misfireGrace, noMisfireGrace := 30*time.Minute, time.Duration(0)
 	tests := []test{
 		{
 			Job{},
@@ -151,6 +265,39 @@ func TestMetadataMarshalJSON(t *testing.T) {
 			[]byte(`{"account":"foocct","description":"Alien sightings","details":{"instance_id":"i-derpderpderp"},"group":"folder1","id":"08d754ba-8540-4fdc-92f3-47950c1cdb1c","modified_at":"2015-11-21T04:19:01Z","modified_by":"kkroker","name":"alien-sightings-dataset","schedule_expression":"cron()","enabled":true}`),
 			nil,
 		},
+		{
+			Job{
+				ID:                 "08d754ba-8540-4fdc-92f3-47950c1cdb1c",
+				ScheduleExpression: "0 8 * * *",
+				Timezone:           "America/New_York",
+				MisfirePolicy:      MisfireRunAll,
+				MisfireLimit:       5,
+				MisfireGrace:       &misfireGrace,
+			},
+			[]byte(`{"account":"","description":"","details":null,"group":"","id":"08d754ba-8540-4fdc-92f3-47950c1cdb1c","modified_at":"","modified_by":"","name":"","schedule_expression":"0 8 * * *","timezone":"America/New_York","misfire_policy":"run_all","misfire_limit":5,"misfire_grace":"30m0s","enabled":false}`),
+			nil,
+		},
+		{
+			Job{
+				ID:           "08d754ba-8540-4fdc-92f3-47950c1cdb1c",
+				MisfireGrace: &noMisfireGrace,
+			},
+			[]byte(`{"account":"","description":"","details":null,"group":"","id":"08d754ba-8540-4fdc-92f3-47950c1cdb1c","modified_at":"","modified_by":"","name":"","schedule_expression":"","misfire_grace":"0s","enabled":false}`),
+			nil,
+		},
+		{
+			Job{
+				ID: "08d754ba-8540-4fdc-92f3-47950c1cdb1c",
+				Retry: &RetryPolicy{
+					MaxAttempts: 5,
+					Backoff:     BackoffExponential,
+					Delay:       30 * time.Second,
+					RetryOn:     []string{"5xx", ErrExecFailure},
+				},
+			},
+			[]byte(`{"account":"","description":"","details":null,"group":"","id":"08d754ba-8540-4fdc-92f3-47950c1cdb1c","modified_at":"","modified_by":"","name":"","schedule_expression":"","retry":{"max_attempts":5,"backoff":"exponential","delay":"30s","retry_on":["5xx","ExecutionFailure"]},"enabled":false}`),
+			nil,
+		},
 	}
 
 	for _, tst := range tests {
@@ -172,6 +319,21 @@ func TestJob_NextRun(t *testing.T) {
 	hourlyTime, _ := time.Parse(time.RFC3339, "2015-11-21T05:00:00.000Z")
 	allTheStars, _ := time.Parse(time.RFC3339, "2015-11-21T04:20:00.000Z")
 	everyFive, _ := time.Parse(time.RFC3339, "2015-11-21T04:20:00.000Z")
+	eightAmEST, _ := time.Parse(time.RFC3339, "2015-11-21T13:00:00.000Z")
+	summerTime, _ := time.Parse(time.RFC3339, "2015-07-21T04:19:01.123Z")
+	eightAmEDT, _ := time.Parse(time.RFC3339, "2015-07-21T12:00:00.000Z")
+
+	// clocks went forward from 02:00 EST to 03:00 EDT on 2020-03-08 (07:00 UTC)
+	springForward, _ := time.Parse(time.RFC3339, "2020-03-08T06:00:00.000Z")
+	springForwardRun, _ := time.Parse(time.RFC3339, "2020-03-08T07:00:00.000Z")
+	springForwardAfter, _ := time.Parse(time.RFC3339, "2020-03-08T07:15:00.000Z")
+
+	// clocks went back from 02:00 EDT to 01:00 EST on 2020-11-01 (06:00 UTC)
+	fallBack, _ := time.Parse(time.RFC3339, "2020-11-01T04:00:00.000Z")
+	fallBackRun, _ := time.Parse(time.RFC3339, "2020-11-01T05:30:00.000Z")
+	fallBackNextDay, _ := time.Parse(time.RFC3339, "2020-11-02T06:30:00.000Z")
+	fallBackRepeated, _ := time.Parse(time.RFC3339, "2020-11-01T05:59:00.000Z")
+	fallBackAfterRepeated, _ := time.Parse(time.RFC3339, "2020-11-01T07:00:00.000Z")
 	type fields struct {
 		Account            string
 		Description        string
@@ -183,6 +345,7 @@ func TestJob_NextRun(t *testing.T) {
 		Name               string
 		Group              string
 		ScheduleExpression string
+		Timezone           string
 	}
 	type args struct {
 		t time.Time
@@ -242,6 +405,94 @@ func TestJob_NextRun(t *testing.T) {
 			},
 			want: &everyFive,
 		},
+		{
+			name: "bad timezone",
+			fields: fields{
+				ScheduleExpression: "@hourly",
+				Timezone:           "America/Gotham",
+			},
+			args: args{
+				t: testTime,
+			},
+			wantErr: true,
+		},
+		{
+			name: "eight am new york standard time",
+			fields: fields{
+				ScheduleExpression: "0 8 * * *",
+				Timezone:           "America/New_York",
+			},
+			args: args{
+				t: testTime,
+			},
+			want: &eightAmEST,
+		},
+		{
+			name: "eight am new york daylight time",
+			fields: fields{
+				ScheduleExpression: "0 8 * * *",
+				Timezone:           "America/New_York",
+			},
+			args: args{
+				t: summerTime,
+			},
+			want: &eightAmEDT,
+		},
+		{
+			name: "skipped by spring forward",
+			fields: fields{
+				ScheduleExpression: "30 2 * * *",
+				Timezone:           "America/New_York",
+			},
+			args: args{
+				t: springForward,
+			},
+			want: &springForwardRun,
+		},
+		{
+			name: "every fifteen during spring forward",
+			fields: fields{
+				ScheduleExpression: "*/15 * * * *",
+				Timezone:           "America/New_York",
+			},
+			args: args{
+				t: springForwardRun,
+			},
+			want: &springForwardAfter,
+		},
+		{
+			name: "first occurrence during fall back",
+			fields: fields{
+				ScheduleExpression: "30 1 * * *",
+				Timezone:           "America/New_York",
+			},
+			args: args{
+				t: fallBack,
+			},
+			want: &fallBackRun,
+		},
+		{
+			name: "no second occurrence during fall back",
+			fields: fields{
+				ScheduleExpression: "30 1 * * *",
+				Timezone:           "America/New_York",
+			},
+			args: args{
+				t: fallBackRun,
+			},
+			want: &fallBackNextDay,
+		},
+		{
+			name: "every minute during the repeated hour",
+			fields: fields{
+				ScheduleExpression: "* * * * *",
+				Timezone:           "America/New_York",
+			},
+			args: args{
+				t: fallBackRepeated,
+			},
+			want: &fallBackAfterRepeated,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -256,13 +507,20 @@ func TestJob_NextRun(t *testing.T) {
 				Name:               tt.fields.Name,
 				Group:              tt.fields.Group,
 				ScheduleExpression: tt.fields.ScheduleExpression,
+				Timezone:           tt.fields.Timezone,
 			}
 			got, err := j.NextRun(tt.args.t)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Job.NextRun() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
+			if tt.want == nil {
+				if got != nil {
+					t.Errorf("Job.NextRun() = %v, want nil", got)
+				}
+				return
+			}
+			if got == nil || !got.Equal(*tt.want) {
 				t.Errorf("Job.NextRun() = %v, want %v", got, tt.want)
 			}
 		})
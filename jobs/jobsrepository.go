@@ -1,7 +1,16 @@
 package jobs
 
-import "context"
+import (
+	"context"
+	"fmt"
 
+	"github.com/YaleSpinup/apierror"
+)
+
+// Repository is the durable storage of jobs.  Every write increments the version of the job.  If the job passed
+// to Update has a version, the update is rejected with a conflict error unless the stored job is still at that
+// version, so concurrent writers don't overwrite each other's changes.  A job without a version is written
+// unconditionally.
 type Repository interface {
 	Create(ctx context.Context, account, group string, job *Job) (*Job, error)
 	Delete(ctx context.Context, account, group, id string) error
@@ -9,3 +18,31 @@ type Repository interface {
 	List(ctx context.Context, account, group string) ([]string, error)
 	Update(ctx context.Context, account, group, id string, job *Job) (*Job, error)
 }
+
+// BulkLister is implemented by the repositories that can return the jobs of an account with their details
+// in a single call, instead of a List followed by a Get for each job.  The jobs are keyed like the response
+// of List for an empty group, <group>/<id>.  If enabled is true, only the enabled jobs are returned.
+type BulkLister interface {
+	ListJobs(ctx context.Context, account string, enabled bool) (map[string]*Job, error)
+}
+
+// VersionedDeleter is implemented by the repositories that can delete a job only if it's still at the given
+// version.  A stale delete is rejected with a conflict error.
+type VersionedDeleter interface {
+	DeleteVersion(ctx context.Context, account, group, id string, version int64) error
+}
+
+// checkVersion returns a conflict error if the expected version of a job is set and isn't the current version
+func checkVersion(id string, expected, current int64) error {
+	if expected == 0 || expected == current {
+		return nil
+	}
+
+	return staleVersionError(id, expected, current)
+}
+
+// staleVersionError returns the conflict error of a write to a job that is no longer at the expected version
+func staleVersionError(id string, expected, current int64) error {
+	msg := fmt.Sprintf("job %s was modified, expected version %d but it is at version %d", id, expected, current)
+	return apierror.New(apierror.ErrConflict, msg, nil)
+}
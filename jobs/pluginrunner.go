@@ -0,0 +1,381 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// PluginProtocolVersion is the version of the plugin request and response
+	PluginProtocolVersion = 1
+	// PluginSocketEnv is the environment variable with the path of the socket a plugin connects to
+	PluginSocketEnv = "MINION_PLUGIN_SOCKET"
+	// PluginTransportStdio exchanges the request and response over the plugin's stdin and stdout
+	PluginTransportStdio = "stdio"
+	// PluginTransportSocket exchanges the request and response over a local unix socket
+	PluginTransportSocket = "socket"
+	// DefaultPluginMaxOutput is the default maximum size in bytes of a plugin response
+	DefaultPluginMaxOutput = 64 * 1024
+)
+
+type PluginRunner struct {
+	Command         string
+	Args            []string
+	Env             []string
+	Transport       string
+	RequiredDetails []string
+	MaxOutput       int
+	Timeout         time.Duration
+}
+
+// PluginRequest is the request sent to a plugin for each run
+type PluginRequest struct {
+	Version  int               `json:"version"`
+	RunID    string            `json:"run_id"`
+	Account  string            `json:"account"`
+	Details  map[string]string `json:"details"`
+	Deadline time.Time         `json:"deadline"`
+}
+
+// PluginResponse is the response returned by a plugin.  If the run failed, the error is set.
+type PluginResponse struct {
+	Version int          `json:"version"`
+	Output  string       `json:"output,omitempty"`
+	Error   *PluginError `json:"error,omitempty"`
+}
+
+// PluginError is a failed run reported by a plugin.  The code is one of the RunnerError codes, unknown
+// codes are treated as execution failures.
+type PluginError struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	StatusCode int    `json:"status_code,omitempty"`
+}
+
+func init() {
+	RegisterRunner(RunnerType{
+		RunnerDescription: RunnerDescription{
+			Type:        "plugin",
+			Description: "runs an external plugin executable",
+		},
+		New: func(config map[string]interface{}) (Runner, error) {
+			return NewPluginRunner(config)
+		},
+	})
+}
+
+// NewPluginRunner creates and configures a new plugin runner.  The command is required and is started with the args
+// and env for each run.  The request and response are exchanged over stdin and stdout unless the transport is
+// 'socket', then the plugin connects to the unix socket in the MINION_PLUGIN_SOCKET environment variable.
+func NewPluginRunner(config map[string]interface{}) (*PluginRunner, error) {
+	log.Debug("creating new plugin job runner")
+
+	r := &PluginRunner{
+		Transport: PluginTransportStdio,
+		MaxOutput: DefaultPluginMaxOutput,
+		Timeout:   time.Minute,
+	}
+
+	if v, ok := config["command"].(string); ok {
+		r.Command = v
+	}
+
+	if r.Command == "" {
+		return nil, errors.New("command is required")
+	}
+
+	args, err := configStrings(config["args"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid args: %s", err)
+	}
+	r.Args = args
+
+	if env, ok := config["env"].(map[string]interface{}); ok {
+		for k, v := range env {
+			e, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("env %s must be a string", k)
+			}
+			r.Env = append(r.Env, k+"="+e)
+		}
+	}
+
+	if v, ok := config["transport"].(string); ok && v != "" {
+		if v != PluginTransportStdio && v != PluginTransportSocket {
+			return nil, fmt.Errorf("invalid transport '%s', must be '%s' or '%s'", v, PluginTransportStdio, PluginTransportSocket)
+		}
+		r.Transport = v
+	}
+
+	details, err := configStrings(config["required_details"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid required_details: %s", err)
+	}
+	r.RequiredDetails = details
+
+	if v, ok := config["max_output"]; ok {
+		max, ok := configInt(v)
+		if !ok || max <= 0 {
+			return nil, fmt.Errorf("invalid max_output %v", v)
+		}
+		r.MaxOutput = max
+	}
+
+	if v, ok := config["timeout"].(string); ok && v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout '%s': %s", v, err)
+		}
+		r.Timeout = timeout
+	}
+
+	return r, nil
+}
+
+// Describe describes the PluginRunner, the required details depend on its configuration
+func (r *PluginRunner) Describe() RunnerDescription {
+	return RunnerDescription{
+		Type:            "plugin",
+		Description:     "runs the plugin " + r.Command,
+		RequiredDetails: r.RequiredDetails,
+	}
+}
+
+// Validate validates the PluginRunner parameters.  Each of the configured required details must be set.
+func (r *PluginRunner) Validate(parameters interface{}) error {
+	v := newValidator(parameters)
+	for _, d := range r.RequiredDetails {
+		v.required(d)
+	}
+	return v.err()
+}
+
+// Run executes the PluginRunner.  The plugin is started and sent a request with the account, job details,
+// run id and deadline.  The output of the response is returned as the output of the run and errors reported
+// by the plugin are returned as RunnerErrors.  The plugin is killed if it doesn't respond before the timeout.
+func (r *PluginRunner) Run(ctx context.Context, account string, parameters interface{}) (string, error) {
+	if account == "" {
+		return "", errors.New("account is required")
+	}
+
+	log.Debugf("initializing plugin runner %+v in account %s, with parameters %+v", r, account, parameters)
+
+	params, ok := parameters.(map[string]string)
+	if !ok {
+		return "", NewRunnerError(ErrMissingDetails, "wrong type parameters list is not a map[string]string", nil)
+	}
+
+	if err := r.Validate(params); err != nil {
+		return "", NewRunnerError(ErrMissingDetails, "invalid parameters", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	deadline, _ := ctx.Deadline()
+	in, err := json.Marshal(PluginRequest{
+		Version:  PluginProtocolVersion,
+		RunID:    RunID(ctx),
+		Account:  account,
+		Details:  params,
+		Deadline: deadline.UTC(),
+	})
+	if err != nil {
+		return "", NewRunnerError(ErrPreExecFailure, "failed to encode plugin request", err)
+	}
+
+	log.Infof("plugin runner running %s for account %s", r.Command, account)
+
+	var out []byte
+	if r.Transport == PluginTransportSocket {
+		out, err = r.runSocket(ctx, in)
+	} else {
+		out, err = r.runStdio(ctx, in)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return r.response(out)
+}
+
+// command returns the plugin command, it's killed when the context is done
+func (r *PluginRunner) command(ctx context.Context, stdout, stderr io.Writer) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, r.Command, r.Args...)
+	cmd.Env = append(os.Environ(), r.Env...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = time.Second
+	return cmd
+}
+
+// runStdio runs the plugin, writing the request to its stdin and returning its stdout
+func (r *PluginRunner) runStdio(ctx context.Context, in []byte) ([]byte, error) {
+	stdout := &limitedBuffer{max: r.MaxOutput}
+	stderr := &limitedBuffer{max: 1024}
+
+	cmd := r.command(ctx, stdout, stderr)
+	cmd.Stdin = bytes.NewReader(in)
+
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return nil, NewRunnerError(ErrExecFailure, "plugin did not finish before the deadline", ctx.Err())
+	}
+
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, NewRunnerError(ErrPreExecFailure, "failed to start plugin", err)
+	}
+
+	if stdout.overflow {
+		return nil, NewRunnerError(ErrPostExecFailure, fmt.Sprintf("plugin response is larger than %d bytes", r.MaxOutput), nil)
+	}
+
+	// a plugin may report an error and exit with a non-zero status
+	if err != nil && stdout.Len() == 0 {
+		return nil, NewRunnerError(ErrExecFailure, "plugin failed: "+strings.TrimSpace(stderr.String()), err)
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// runSocket runs the plugin and waits for it to connect to a unix socket, then writes the request to the
+// connection and returns the response read from it
+func (r *PluginRunner) runSocket(ctx context.Context, in []byte) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "minion-plugin")
+	if err != nil {
+		return nil, NewRunnerError(ErrPreExecFailure, "failed to create plugin socket directory", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "plugin.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, NewRunnerError(ErrPreExecFailure, "failed to listen on plugin socket", err)
+	}
+	defer listener.Close()
+
+	stderr := &limitedBuffer{max: 1024}
+	cmd := r.command(ctx, ioutil.Discard, stderr)
+	cmd.Env = append(cmd.Env, PluginSocketEnv+"="+path)
+
+	if err := cmd.Start(); err != nil {
+		return nil, NewRunnerError(ErrPreExecFailure, "failed to start plugin", err)
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+
+	var conn net.Conn
+	select {
+	case conn = <-accepted:
+	case err := <-exited:
+		return nil, NewRunnerError(ErrExecFailure, "plugin exited before connecting: "+strings.TrimSpace(stderr.String()), err)
+	case <-ctx.Done():
+		return nil, NewRunnerError(ErrExecFailure, "plugin did not connect before the deadline", ctx.Err())
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write(in); err != nil {
+		return nil, NewRunnerError(ErrExecFailure, "failed to write plugin request", err)
+	}
+
+	if c, ok := conn.(*net.UnixConn); ok {
+		c.CloseWrite()
+	}
+
+	out, err := ioutil.ReadAll(io.LimitReader(conn, int64(r.MaxOutput)+1))
+	if ctx.Err() != nil {
+		return nil, NewRunnerError(ErrExecFailure, "plugin did not finish before the deadline", ctx.Err())
+	} else if err != nil {
+		return nil, NewRunnerError(ErrExecFailure, "failed to read plugin response", err)
+	}
+
+	if len(out) > r.MaxOutput {
+		return nil, NewRunnerError(ErrPostExecFailure, fmt.Sprintf("plugin response is larger than %d bytes", r.MaxOutput), nil)
+	}
+
+	// wait for the plugin to exit, a plugin may report an error and exit with a non-zero status
+	select {
+	case err := <-exited:
+		if err != nil && len(out) == 0 {
+			return nil, NewRunnerError(ErrExecFailure, "plugin failed: "+strings.TrimSpace(stderr.String()), err)
+		}
+	case <-ctx.Done():
+		return nil, NewRunnerError(ErrExecFailure, "plugin did not exit before the deadline", ctx.Err())
+	}
+
+	return out, nil
+}
+
+// response decodes the plugin response and returns its output, or its error as a RunnerError
+func (r *PluginRunner) response(out []byte) (string, error) {
+	log.Debugf("got plugin response from %s: %s", r.Command, out)
+
+	resp := PluginResponse{}
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return "", NewRunnerError(ErrPostExecFailure, "failed to decode plugin response", err)
+	}
+
+	if resp.Version != PluginProtocolVersion {
+		msg := fmt.Sprintf("unsupported plugin response version %d, expected %d", resp.Version, PluginProtocolVersion)
+		return "", NewRunnerError(ErrPostExecFailure, msg, nil)
+	}
+
+	if resp.Error != nil {
+		code := resp.Error.Code
+		switch code {
+		case ErrMissingDetails, ErrPreExecFailure, ErrExecFailure, ErrPostExecFailure:
+		default:
+			code = ErrExecFailure
+		}
+
+		return "", NewRunnerStatusError(code, resp.Error.Message, resp.Error.StatusCode, errors.New("plugin reported an error"))
+	}
+
+	return resp.Output, nil
+}
+
+// limitedBuffer is a buffer that keeps the first max bytes written to it and discards the rest
+type limitedBuffer struct {
+	bytes.Buffer
+	max      int
+	overflow bool
+}
+
+// Write writes to the buffer until it's full, it never fails so the writer isn't interrupted
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := b.max - b.Len(); n > room {
+		b.overflow = true
+		p = p[:room]
+	}
+
+	b.Buffer.Write(p)
+	return n, nil
+}
@@ -0,0 +1,238 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPluginHelperProcess isn't a real test, it's the plugin started by the plugin runner tests
+func TestPluginHelperProcess(t *testing.T) {
+	mode := os.Getenv("MINION_TEST_PLUGIN")
+	if mode == "" {
+		return
+	}
+	defer os.Exit(0)
+
+	var in io.Reader = os.Stdin
+	var out io.Writer = os.Stdout
+	if path := os.Getenv(PluginSocketEnv); path != "" {
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			fmt.Fprint(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer conn.Close()
+		in, out = conn, conn
+	}
+
+	req := PluginRequest{}
+	if err := json.NewDecoder(in).Decode(&req); err != nil {
+		fmt.Fprint(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	switch mode {
+	case "ok":
+		json.NewEncoder(out).Encode(PluginResponse{
+			Version: PluginProtocolVersion,
+			Output:  fmt.Sprintf("%d %s %s %s %t", req.Version, req.Account, req.RunID, req.Details["message"], req.Deadline.After(time.Now())),
+		})
+	case "error":
+		json.NewEncoder(out).Encode(PluginResponse{
+			Version: PluginProtocolVersion,
+			Error:   &PluginError{Code: ErrMissingDetails, Message: "boom", StatusCode: 503},
+		})
+		os.Exit(2)
+	case "unknown":
+		json.NewEncoder(out).Encode(PluginResponse{
+			Version: PluginProtocolVersion,
+			Error:   &PluginError{Code: "SomethingElse", Message: "boom"},
+		})
+	case "version":
+		json.NewEncoder(out).Encode(PluginResponse{Version: PluginProtocolVersion + 1, Output: "hi"})
+	case "garbage":
+		fmt.Fprint(out, "not json")
+	case "large":
+		fmt.Fprint(out, strings.Repeat("x", 1000))
+	case "sleep":
+		time.Sleep(10 * time.Second)
+	case "fail":
+		fmt.Fprint(os.Stderr, "bad things happened")
+		os.Exit(3)
+	}
+}
+
+func newTestPluginRunner(mode, transport string) *PluginRunner {
+	return &PluginRunner{
+		Command:   os.Args[0],
+		Args:      []string{"-test.run=TestPluginHelperProcess"},
+		Env:       []string{"MINION_TEST_PLUGIN=" + mode},
+		Transport: transport,
+		MaxOutput: 256,
+		Timeout:   5 * time.Second,
+	}
+}
+
+func TestNewPluginRunner(t *testing.T) {
+	tests := []struct {
+		config map[string]interface{}
+		err    bool
+	}{
+		{
+			config: map[string]interface{}{},
+			err:    true,
+		},
+		{
+			config: map[string]interface{}{"command": "/bin/plugin", "args": "-v"},
+			err:    true,
+		},
+		{
+			config: map[string]interface{}{"command": "/bin/plugin", "env": map[string]interface{}{"FOO": 1}},
+			err:    true,
+		},
+		{
+			config: map[string]interface{}{"command": "/bin/plugin", "transport": "carrier-pigeon"},
+			err:    true,
+		},
+		{
+			config: map[string]interface{}{"command": "/bin/plugin", "max_output": float64(0)},
+			err:    true,
+		},
+		{
+			config: map[string]interface{}{"command": "/bin/plugin", "timeout": "later"},
+			err:    true,
+		},
+		{
+			config: map[string]interface{}{"command": "/bin/plugin"},
+		},
+	}
+
+	for _, test := range tests {
+		_, err := NewPluginRunner(test.config)
+		if test.err && err == nil {
+			t.Errorf("expected error for config %+v, got nil", test.config)
+		} else if !test.err && err != nil {
+			t.Errorf("expected nil error for config %+v, got %s", test.config, err)
+		}
+	}
+
+	out, err := NewPluginRunner(map[string]interface{}{
+		"command":          "/bin/plugin",
+		"args":             []interface{}{"-v", "run"},
+		"env":              map[string]interface{}{"FOO": "bar"},
+		"transport":        "socket",
+		"required_details": []interface{}{"message"},
+		"max_output":       float64(1024),
+		"timeout":          "10s",
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	expected := &PluginRunner{
+		Command:         "/bin/plugin",
+		Args:            []string{"-v", "run"},
+		Env:             []string{"FOO=bar"},
+		Transport:       PluginTransportSocket,
+		RequiredDetails: []string{"message"},
+		MaxOutput:       1024,
+		Timeout:         10 * time.Second,
+	}
+
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("expected %+v, got %+v", expected, out)
+	}
+}
+
+func TestPluginRunnerRun(t *testing.T) {
+	tests := []struct {
+		mode       string
+		output     string
+		code       string
+		statusCode int
+	}{
+		{mode: "ok", output: "1 myaccount run-123 hello true"},
+		{mode: "error", code: ErrMissingDetails, statusCode: 503},
+		{mode: "unknown", code: ErrExecFailure},
+		{mode: "version", code: ErrPostExecFailure},
+		{mode: "garbage", code: ErrPostExecFailure},
+		{mode: "large", code: ErrPostExecFailure},
+		{mode: "fail", code: ErrExecFailure},
+	}
+
+	for _, transport := range []string{PluginTransportStdio, PluginTransportSocket} {
+		for _, test := range tests {
+			r := newTestPluginRunner(test.mode, transport)
+			ctx := WithRunID(context.TODO(), "run-123")
+
+			out, err := r.Run(ctx, "myaccount", map[string]string{"message": "hello"})
+			if test.code == "" {
+				if err != nil {
+					t.Errorf("%s/%s: expected nil error, got %s", transport, test.mode, err)
+				}
+
+				if out != test.output {
+					t.Errorf("%s/%s: expected output '%s', got '%s'", transport, test.mode, test.output, out)
+				}
+				continue
+			}
+
+			var rErr RunnerError
+			if !errors.As(err, &rErr) {
+				t.Errorf("%s/%s: expected RunnerError, got %v", transport, test.mode, err)
+				continue
+			}
+
+			if rErr.Code != test.code || rErr.StatusCode != test.statusCode {
+				t.Errorf("%s/%s: expected code %s (%d), got %s (%d)", transport, test.mode, test.code, test.statusCode, rErr.Code, rErr.StatusCode)
+			}
+		}
+	}
+}
+
+func TestPluginRunnerRunTimeout(t *testing.T) {
+	for _, transport := range []string{PluginTransportStdio, PluginTransportSocket} {
+		r := newTestPluginRunner("sleep", transport)
+		r.Timeout = 200 * time.Millisecond
+
+		start := time.Now()
+		_, err := r.Run(context.TODO(), "myaccount", map[string]string{})
+
+		var rErr RunnerError
+		if !errors.As(err, &rErr) || rErr.Code != ErrExecFailure {
+			t.Errorf("%s: expected %s error, got %v", transport, ErrExecFailure, err)
+		}
+
+		if d := time.Since(start); d > 5*time.Second {
+			t.Errorf("%s: expected plugin to be killed after the timeout, took %s", transport, d)
+		}
+	}
+}
+
+func TestPluginRunnerRunValidation(t *testing.T) {
+	r := newTestPluginRunner("ok", PluginTransportStdio)
+	r.RequiredDetails = []string{"message"}
+
+	if _, err := r.Run(context.TODO(), "", map[string]string{"message": "hello"}); err == nil {
+		t.Error("expected error for empty account, got nil")
+	}
+
+	var rErr RunnerError
+	if _, err := r.Run(context.TODO(), "myaccount", map[string]string{}); !errors.As(err, &rErr) || rErr.Code != ErrMissingDetails {
+		t.Errorf("expected %s error for missing details, got %v", ErrMissingDetails, err)
+	}
+
+	r.Command = "/does/not/exist"
+	if _, err := r.Run(context.TODO(), "myaccount", map[string]string{"message": "hello"}); !errors.As(err, &rErr) || rErr.Code != ErrPreExecFailure {
+		t.Errorf("expected %s error for missing command, got %v", ErrPreExecFailure, err)
+	}
+}
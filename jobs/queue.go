@@ -1,7 +1,10 @@
 package jobs
 
 import (
+	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis"
@@ -13,26 +16,120 @@ type Queuer interface {
 	Close() error
 	Enqueue(queued *QueuedJob) error
 	Fetch(queued *QueuedJob) error
-	Finalize(id string) error
+	Finalize(queued *QueuedJob) error
 }
 
+// Recoverer recovers queued jobs that were fetched from the queue but never finalized
+type Recoverer interface {
+	DeadLetter(queued *QueuedJob) error
+	Expired(lease time.Duration) ([]*QueuedJob, error)
+	Requeue(queued *QueuedJob) error
+}
+
+// Renewer renews the lease on a fetched job, so a job that's still running isn't recovered
+type Renewer interface {
+	Renew(queued *QueuedJob) error
+}
+
+// QueuedJob is a single run of a job in the queue.  The ID is the cached job id (<group>/<id>), the
+// RunID uniquely identifies the run so the same job can be queued more than once and the Score is the
+// requested execution time as a unix timestamp.  Attempt is the number of failed attempts the run is
+// retrying and Recovered is the number of times the run was recovered after it was fetched and never
+// finalized.  Targets limits a retry to the targets that failed.
 type QueuedJob struct {
-	ID    string
-	Score float64
+	ID        string   `json:"id"`
+	RunID     string   `json:"run_id,omitempty"`
+	Attempt   int      `json:"attempt,omitempty"`
+	Recovered int      `json:"recovered,omitempty"`
+	Targets   []string `json:"targets,omitempty"`
+	Score     float64  `json:"-"`
+
+	// member is the raw queue member the job was fetched as
+	member string
+}
+
+// Member returns the queue member for the queued job
+func (q *QueuedJob) Member() (string, error) {
+	if q.member != "" {
+		return q.member, nil
+	}
+
+	// jobs without a run id are queued by their id alone
+	if q.RunID == "" {
+		return q.ID, nil
+	}
+
+	out, err := json.Marshal(q)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode queued job "+q.ID)
+	}
+
+	return string(out), nil
+}
+
+// parseMember parses a queue member into the queued job.  Members are either the JSON encoded
+// queued job or the plain job id.
+func (q *QueuedJob) parseMember(member string) error {
+	if strings.HasPrefix(member, "{") {
+		if err := json.Unmarshal([]byte(member), q); err != nil {
+			return errors.Wrap(err, "failed to decode queued job "+member)
+		}
+	} else {
+		q.ID = member
+	}
+
+	q.member = member
+	return nil
 }
 
+// moveScript atomically moves a member from the first key to all of the remaining keys, as long
+// as it still exists in the first key.  ARGV is the member to move, the new score and the new member,
+// optionally followed by the score the member must still have in the first key.
+var moveScript = redis.NewScript(`
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not score or (ARGV[4] and tonumber(score) ~= tonumber(ARGV[4])) then
+	return 0
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+for i = 2, #KEYS do
+	redis.call('ZADD', KEYS[i], ARGV[2], ARGV[3])
+end
+return 1
+`)
+
+// fetchScript atomically pops the first member of the queue (KEYS[1]) and starts its lease by setting
+// its score in the backup set (KEYS[2]) to the current time.  ARGV is the current time and the window.
+// The member is left in the queue if its score is more than the window after the current time.  The
+// member, its score and whether it was fetched are returned, or nil if the queue is empty.
+var fetchScript = redis.NewScript(`
+local out = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+if #out == 0 then
+	return false
+end
+if tonumber(out[2]) - tonumber(ARGV[1]) > tonumber(ARGV[2]) then
+	return {out[1], out[2], 0}
+end
+redis.call('ZREM', KEYS[1], out[1])
+redis.call('ZADD', KEYS[2], 'XX', ARGV[1], out[1])
+return {out[1], out[2], 1}
+`)
+
 type RedisQueuer struct {
-	BackupName string
-	client     *redis.Client
-	Name       string
-	Window     int64
+	BackupName          string
+	client              *redis.Client
+	DeadLetterName      string
+	InvalidationChannel string
+	Name                string
+	Window              int64
 }
 
 func NewRedisQueuer(name, address, password string, db int, window int64) (*RedisQueuer, error) {
 	return &RedisQueuer{
-		Name:       name,
-		BackupName: name + "-backup",
-		Window:     window,
+		Name:                name,
+		BackupName:          name + "-backup",
+		DeadLetterName:      name + "-deadletter",
+		InvalidationChannel: name + "-invalidations",
+		Window:              window,
 		client: redis.NewClient(&redis.Options{
 			Addr:       address,
 			PoolSize:   5,
@@ -44,8 +141,11 @@ func NewRedisQueuer(name, address, password string, db int, window int64) (*Redi
 
 }
 
+// Fetch pulls the next job off of the queue.  The job is popped and its lease is started in one
+// atomic step, so the requeuer never sees a fetched job in the backup set with the score it was
+// queued with.  A job that isn't due within the window is left in the queue.
 func (q *RedisQueuer) Fetch(queued *QueuedJob) error {
-	val, err := q.client.BZPopMin(2*time.Second, q.Name).Result()
+	out, err := fetchScript.Run(q.client, []string{q.Name, q.BackupName}, currentTime(), q.Window).Result()
 	if err != nil {
 		if err == redis.Nil {
 			return NewQueueError(ErrQueueIsEmpty, "redis queue is empty", err)
@@ -55,26 +155,38 @@ func (q *RedisQueuer) Fetch(queued *QueuedJob) error {
 		return err
 	}
 
-	log.Debugf("got value from bzpop: %+v", val)
+	log.Debugf("got value from fetch: %+v", out)
+
+	val, ok := out.([]interface{})
+	if !ok || len(val) != 3 {
+		return fmt.Errorf("unexpected value for fetched job: %+v", out)
+	}
+
+	member, ok := val[0].(string)
+	if !ok {
+		return fmt.Errorf("unexpected member value for queued job, not a string: %+v", val[0])
+	}
 
-	id, ok := val.Member.(string)
+	score, ok := val[1].(string)
 	if !ok {
-		return fmt.Errorf("unexpected member value for queued job, not a string: %+v", val.Member)
+		return fmt.Errorf("unexpected score value for queued job, not a string: %+v", val[1])
+	}
+
+	if err := queued.parseMember(member); err != nil {
+		return err
 	}
 
-	log.Debugf("current time: %d, time of job: %f, job id: %s", currentTime(), val.Score, id)
+	if queued.Score, err = strconv.ParseFloat(score, 64); err != nil {
+		return errors.Wrap(err, "failed parsing score of queued job "+member)
+	}
 
-	queued.ID = id
-	queued.Score = val.Score
+	log.Debugf("current time: %d, time of job: %f, job member: %s", currentTime(), queued.Score, member)
 
 	// if the queued score (requested execution) minus the current time is greater than the allowed window,
-	// the job is supposed to execute too far in the future, so reschedule and return an error.
-	if int64(val.Score)-currentTime() > q.Window {
-		log.Debugf("job '%s' is not within the window, rescheduling", id)
-		if err := q.enqueue(q.Name, queued.Score, queued.ID); err != nil {
-			log.Errorf("failed to re-enqueue job: %s", err)
-		}
-		return fmt.Errorf("rescheduled job, not within window")
+	// the job is supposed to execute too far in the future and it was left in the queue.
+	if fetched, _ := val[2].(int64); fetched == 0 {
+		log.Debugf("job '%s' is not within the window, leaving it queued", member)
+		return NewQueueError(ErrQueuedJobNotDue, "job not within window", nil)
 	}
 
 	return nil
@@ -84,11 +196,16 @@ func (q *RedisQueuer) Fetch(queued *QueuedJob) error {
 func (q *RedisQueuer) Enqueue(queued *QueuedJob) error {
 	log.Debugf("enqueuing job %s", queued.ID)
 
-	if err := q.enqueue(q.Name, queued.Score, queued.ID); err != nil {
+	member, err := queued.Member()
+	if err != nil {
+		return err
+	}
+
+	if err := q.enqueue(q.Name, queued.Score, member); err != nil {
 		return err
 	}
 
-	if err := q.enqueue(q.BackupName, queued.Score, queued.ID); err != nil {
+	if err := q.enqueue(q.BackupName, queued.Score, member); err != nil {
 		return err
 	}
 
@@ -97,12 +214,142 @@ func (q *RedisQueuer) Enqueue(queued *QueuedJob) error {
 
 // Finalize does the final steps once a job is completed successfully, currently this
 // is just dequeuing the backup job created when the job was queued.
-func (q *RedisQueuer) Finalize(id string) error {
-	log.Debugf("finalizing job %s", id)
+func (q *RedisQueuer) Finalize(queued *QueuedJob) error {
+	log.Debugf("finalizing job %s", queued.ID)
+
+	member, err := queued.Member()
+	if err != nil {
+		return err
+	}
+
+	if err := q.dequeue(q.BackupName, member); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Expired returns the jobs in the backup set that were fetched more than lease ago and are no longer
+// in the queue.  The score of the returned jobs is the time the lease started.
+func (q *RedisQueuer) Expired(lease time.Duration) ([]*QueuedJob, error) {
+	expiredAt := currentTime() - int64(lease/time.Second)
+
+	out, err := q.client.ZRangeByScoreWithScores(q.BackupName, redis.ZRangeBy{
+		Min: "-inf",
+		Max: fmt.Sprintf("%d", expiredAt),
+	}).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed listing expired jobs")
+	}
+
+	expired := []*QueuedJob{}
+	for _, z := range out {
+		member, ok := z.Member.(string)
+		if !ok {
+			log.Warnf("unexpected member value for backup job, not a string: %+v", z.Member)
+			continue
+		}
+
+		// jobs that are still in the queue haven't been fetched yet
+		if err := q.client.ZScore(q.Name, member).Err(); err == nil {
+			continue
+		} else if err != redis.Nil {
+			return nil, errors.Wrap(err, "failed checking queue for job "+member)
+		}
+
+		queued := &QueuedJob{}
+		if err := queued.parseMember(member); err != nil {
+			log.Warnf("failed parsing backup job: %s", err)
+			continue
+		}
+		queued.Score = z.Score
+
+		expired = append(expired, queued)
+	}
+
+	return expired, nil
+}
+
+// Renew restarts the lease on a fetched job.  It only updates jobs that are still in the backup set, so
+// a job that was finalized or recovered in the meantime isn't added back.
+func (q *RedisQueuer) Renew(queued *QueuedJob) error {
+	member, err := queued.Member()
+	if err != nil {
+		return err
+	}
+
+	if err := q.client.ZAddXX(q.BackupName, redis.Z{
+		Score:  float64(currentTime()),
+		Member: member,
+	}).Err(); err != nil {
+		return errors.Wrap(err, "failed renewing lease on job "+member)
+	}
+
+	return nil
+}
+
+// Requeue atomically removes a job from the backup set and enqueues it to run now, counting the recovery.  If the
+// job is no longer in the backup set, it was finalized or recovered by someone else and a QueueError is returned.
+// The same goes for a job whose lease was started or renewed since it was returned by Expired.
+func (q *RedisQueuer) Requeue(queued *QueuedJob) error {
+	member, err := queued.Member()
+	if err != nil {
+		return err
+	}
+
+	next := &QueuedJob{
+		ID:        queued.ID,
+		RunID:     queued.RunID,
+		Attempt:   queued.Attempt,
+		Recovered: queued.Recovered + 1,
+		Targets:   queued.Targets,
+		Score:     float64(currentTime()),
+	}
+
+	// make sure each attempt has a unique queue member
+	if next.RunID == "" {
+		next.RunID = NewRunID()
+	}
+
+	nextMember, err := next.Member()
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("requeuing job %s as %s", member, nextMember)
+
+	return q.move(member, next.Score, nextMember, queued.Score, q.BackupName, q.Name, q.BackupName)
+}
 
-	if err := q.dequeue(q.BackupName, id); err != nil {
+// DeadLetter atomically moves a job from the backup set to the dead letter set.  Like Requeue, a job whose lease
+// was renewed since it was returned by Expired isn't moved.
+func (q *RedisQueuer) DeadLetter(queued *QueuedJob) error {
+	member, err := queued.Member()
+	if err != nil {
 		return err
 	}
+
+	log.Debugf("moving job %s to the dead letter set", member)
+
+	return q.move(member, float64(currentTime()), member, queued.Score, q.BackupName, q.DeadLetterName)
+}
+
+// move runs the move script.  if the lease is set, the member is only moved if its score in the first key is
+// still the lease returned by Expired, ie. it wasn't fetched again or renewed in the meantime.
+func (q *RedisQueuer) move(member string, score float64, newMember string, lease float64, keys ...string) error {
+	args := []interface{}{member, score, newMember}
+	if lease != 0 {
+		args = append(args, lease)
+	}
+
+	out, err := moveScript.Run(q.client, keys, args...).Int()
+	if err != nil {
+		return errors.Wrap(err, "failed moving job "+member)
+	}
+
+	if out == 0 {
+		return NewQueueError(ErrQueuedJobNotFound, "job not found in "+keys[0], nil)
+	}
+
 	return nil
 }
 
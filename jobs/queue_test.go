@@ -1,8 +1,8 @@
 package jobs
 
-import(
-	"testing"
+import (
 	"reflect"
+	"testing"
 )
 
 func TestNewRedisQueuer(t *testing.T) {
@@ -14,4 +14,56 @@ func TestNewRedisQueuer(t *testing.T) {
 	if to := reflect.TypeOf(r).String(); to != "*jobs.RedisQueuer" {
 		t.Errorf("expected type to be '*jobs.RedisQueuer, got %s", to)
 	}
-}
\ No newline at end of file
+
+	if r.BackupName != "foo-backup" {
+		t.Errorf("expected backup name to be 'foo-backup', got %s", r.BackupName)
+	}
+
+	if r.DeadLetterName != "foo-deadletter" {
+		t.Errorf("expected dead letter name to be 'foo-deadletter', got %s", r.DeadLetterName)
+	}
+
+	if r.InvalidationChannel != "foo-invalidations" {
+		t.Errorf("expected invalidation channel to be 'foo-invalidations', got %s", r.InvalidationChannel)
+	}
+}
+func TestQueuedJobMember(t *testing.T) {
+	q := &QueuedJob{ID: "group/id", Score: 12345}
+	if m, err := q.Member(); err != nil {
+		t.Errorf("expected nil error, got %s", err)
+	} else if m != "group/id" {
+		t.Errorf("expected member to be 'group/id', got %s", m)
+	}
+
+	q = &QueuedJob{ID: "group/id", RunID: "run1", Score: 12345}
+	m, err := q.Member()
+	if err != nil {
+		t.Errorf("expected nil error, got %s", err)
+	} else if m != `{"id":"group/id","run_id":"run1"}` {
+		t.Errorf("expected member to be json encoded, got %s", m)
+	}
+
+	out := &QueuedJob{}
+	if err := out.parseMember(m); err != nil {
+		t.Errorf("expected nil error, got %s", err)
+	}
+
+	if out.ID != "group/id" || out.RunID != "run1" {
+		t.Errorf("expected parsed member to match, got %+v", out)
+	}
+
+	if om, _ := out.Member(); om != m {
+		t.Errorf("expected member of parsed job to be %s, got %s", m, om)
+	}
+
+	out = &QueuedJob{}
+	if err := out.parseMember("group/id"); err != nil {
+		t.Errorf("expected nil error, got %s", err)
+	} else if out.ID != "group/id" || out.RunID != "" {
+		t.Errorf("expected parsed plain member to match, got %+v", out)
+	}
+
+	if err := out.parseMember("{"); err == nil {
+		t.Error("expected error for bad member, got nil")
+	}
+}
@@ -5,6 +5,8 @@ import (
 )
 
 const ErrQueueIsEmpty = "QueueIsEmpty"
+const ErrQueuedJobNotFound = "QueuedJobNotFound"
+const ErrQueuedJobNotDue = "QueuedJobNotDue"
 
 // Error wraps lower level errors with code, message and an original error
 type QueueError struct {
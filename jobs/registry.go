@@ -0,0 +1,94 @@
+package jobs
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// RunnerDescription describes a type of runner and the job details it accepts
+type RunnerDescription struct {
+	Type            string   `json:"type"`
+	Description     string   `json:"description,omitempty"`
+	Actions         []string `json:"actions,omitempty"`
+	RequiredDetails []string `json:"required_details,omitempty"`
+}
+
+// RunnerType is a type of runner that can be configured in the jobRunners configuration.  New creates
+// a runner of the type from its configuration.
+type RunnerType struct {
+	RunnerDescription
+	New func(config map[string]interface{}) (Runner, error)
+}
+
+// Describer is implemented by runners whose accepted job details depend on their configuration
+type Describer interface {
+	Describe() RunnerDescription
+}
+
+var (
+	registryMux sync.RWMutex
+	registry    = map[string]RunnerType{}
+)
+
+// RegisterRunner makes a type of runner available to the jobRunners configuration.  It's meant to be called
+// from an init function and panics if the type is incomplete or already registered.
+func RegisterRunner(t RunnerType) {
+	registryMux.Lock()
+	defer registryMux.Unlock()
+
+	if t.Type == "" || t.New == nil {
+		panic("jobs: runner type and constructor are required")
+	}
+
+	if _, ok := registry[t.Type]; ok {
+		panic("jobs: runner type registered twice: " + t.Type)
+	}
+
+	registry[t.Type] = t
+}
+
+// RunnerTypes returns the registered runner types sorted by type
+func RunnerTypes() []RunnerType {
+	registryMux.RLock()
+	defer registryMux.RUnlock()
+
+	types := make([]RunnerType, 0, len(registry))
+	for _, t := range registry {
+		types = append(types, t)
+	}
+
+	sort.Slice(types, func(i, j int) bool { return types[i].Type < types[j].Type })
+
+	return types
+}
+
+// NewRunner creates a runner of the registered type from its configuration
+func NewRunner(typ string, config map[string]interface{}) (Runner, error) {
+	registryMux.RLock()
+	t, ok := registry[typ]
+	registryMux.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("failed to determine jobs runner type, or type not supported: %s", typ)
+	}
+
+	return t.New(config)
+}
+
+// Describe returns the description of a runner of the registered type.  Runners that implement Describer
+// describe themselves.
+func Describe(typ string, r Runner) RunnerDescription {
+	if d, ok := r.(Describer); ok {
+		return d.Describe()
+	}
+
+	registryMux.RLock()
+	defer registryMux.RUnlock()
+
+	if t, ok := registry[typ]; ok {
+		return t.RunnerDescription
+	}
+
+	return RunnerDescription{Type: typ}
+}
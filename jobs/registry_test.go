@@ -0,0 +1,100 @@
+package jobs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRunnerTypes(t *testing.T) {
+	expected := []string{"database", "dummy", "instance", "plugin", "service", "task", "webhook"}
+
+	var types []string
+	for _, rt := range RunnerTypes() {
+		types = append(types, rt.Type)
+	}
+
+	if !reflect.DeepEqual(types, expected) {
+		t.Errorf("expected registered runner types %v, got %v", expected, types)
+	}
+}
+
+func TestRegisterRunner(t *testing.T) {
+	tests := []struct {
+		name string
+		typ  RunnerType
+	}{
+		{
+			name: "missing type",
+			typ:  RunnerType{New: func(map[string]interface{}) (Runner, error) { return &DummyRunner{}, nil }},
+		},
+		{
+			name: "missing constructor",
+			typ:  RunnerType{RunnerDescription: RunnerDescription{Type: "foo"}},
+		},
+		{
+			name: "duplicate type",
+			typ: RunnerType{
+				RunnerDescription: RunnerDescription{Type: "dummy"},
+				New:               func(map[string]interface{}) (Runner, error) { return &DummyRunner{}, nil },
+			},
+		},
+	}
+
+	for _, test := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected panic registering runner with %s", test.name)
+				}
+			}()
+			RegisterRunner(test.typ)
+		}()
+	}
+}
+
+func TestNewRunner(t *testing.T) {
+	if _, err := NewRunner("foobar", map[string]interface{}{}); err == nil {
+		t.Error("expected error for unknown runner type, got nil")
+	}
+
+	if _, err := NewRunner("dummy", map[string]interface{}{}); err == nil {
+		t.Error("expected error for invalid dummy runner config, got nil")
+	}
+
+	r, err := NewRunner("dummy", map[string]interface{}{"template": "hi"})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if _, ok := r.(*DummyRunner); !ok {
+		t.Errorf("expected *jobs.DummyRunner, got %T", r)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	out := Describe("instance", &InstanceRunner{})
+	expected := RunnerDescription{
+		Type:            "instance",
+		Description:     "changes the power state of an instance or a batch of instances",
+		Actions:         []string{"reboot", "stop", "start"},
+		RequiredDetails: []string{"instance_action", "instance_id|instance_ids|instance_selector"},
+	}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("expected %+v, got %+v", expected, out)
+	}
+
+	out = Describe("webhook", &WebhookRunner{Method: "PUT", URLTemplate: "http://example.com", RequiredDetails: []string{"id"}})
+	expected = RunnerDescription{
+		Type:            "webhook",
+		Description:     "sends a PUT request to http://example.com",
+		RequiredDetails: []string{"id"},
+	}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("expected %+v, got %+v", expected, out)
+	}
+
+	out = Describe("foobar", &DummyRunner{})
+	if !reflect.DeepEqual(out, RunnerDescription{Type: "foobar"}) {
+		t.Errorf("expected description with only the type, got %+v", out)
+	}
+}
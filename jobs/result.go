@@ -0,0 +1,132 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultResultExcerpt is the maximum number of bytes of a response kept in a result
+const DefaultResultExcerpt = 512
+
+const (
+	// ResultStatusSucceeded is the status of a result of a successful run
+	ResultStatusSucceeded = "succeeded"
+	// ResultStatusFailed is the status of a result of a failed run
+	ResultStatusFailed = "failed"
+)
+
+// Result is the structured result of running a job.  Targets identify what the runner acted on, ie. instance
+// ids, StatusCode and Response are the status code and an excerpt of the response of the last api request and
+// Data is any other information the runner reports.  The timings are set for every run.
+type Result struct {
+	Status     string            `json:"status"`
+	Message    string            `json:"message,omitempty"`
+	Targets    []string          `json:"targets,omitempty"`
+	StatusCode int               `json:"status_code,omitempty"`
+	Response   string            `json:"response,omitempty"`
+	StartedAt  *time.Time        `json:"started_at,omitempty"`
+	EndedAt    *time.Time        `json:"ended_at,omitempty"`
+	DurationMS int64             `json:"duration_ms"`
+	Data       map[string]string `json:"data,omitempty"`
+}
+
+// ResultRunner is implemented by runners that return a structured result.  The result is returned with
+// whatever is known about the run when it fails.
+type ResultRunner interface {
+	RunResult(ctx context.Context, account string, parameters interface{}) (*Result, error)
+}
+
+// RunWithResult runs the runner and returns its result.  Runners that don't return a structured result get one
+// with their output as the message.  The status, timings and, when the run fails, the error details are set on
+// the result.
+func RunWithResult(ctx context.Context, runner Runner, account string, parameters interface{}) (*Result, error) {
+	start := time.Now().UTC()
+
+	var result *Result
+	var err error
+	if rr, ok := runner.(ResultRunner); ok {
+		result, err = rr.RunResult(ctx, account, parameters)
+	} else {
+		var out string
+		out, err = runner.Run(ctx, account, parameters)
+		result = &Result{Message: out}
+	}
+
+	if result == nil {
+		result = &Result{}
+	}
+
+	end := time.Now().UTC()
+	result.StartedAt = &start
+	result.EndedAt = &end
+	result.DurationMS = end.Sub(start).Milliseconds()
+
+	if err == nil {
+		result.Status = ResultStatusSucceeded
+		return result, nil
+	}
+
+	result.Status = ResultStatusFailed
+	if result.Message == "" {
+		result.Message = err.Error()
+	}
+
+	var rErr RunnerError
+	if errors.As(err, &rErr) {
+		if result.StatusCode == 0 {
+			result.StatusCode = rErr.StatusCode
+		}
+
+		if len(result.Targets) == 0 {
+			result.Targets = rErr.Targets
+		}
+	}
+
+	return result, err
+}
+
+// Output returns the result message, it's the output of runners that don't return a structured result
+func (r *Result) Output() string {
+	if r == nil {
+		return ""
+	}
+	return r.Message
+}
+
+// JSON returns the result encoded as JSON
+func (r *Result) JSON() string {
+	out, err := json.Marshal(r)
+	if err != nil {
+		log.Errorf("failed to encode result %+v: %s", r, err)
+		return r.Output()
+	}
+	return string(out)
+}
+
+// SetData sets a key of the result data
+func (r *Result) SetData(key, value string) {
+	if r.Data == nil {
+		r.Data = make(map[string]string)
+	}
+	r.Data[key] = value
+}
+
+// excerpt returns the beginning of a response to keep in a result
+func excerpt(body []byte) string {
+	if len(body) <= DefaultResultExcerpt {
+		return string(body)
+	}
+	return string(body[:DefaultResultExcerpt]) + "... (truncated)"
+}
+
+// runResult adapts a ResultRunner to the Run method of the Runner interface
+func runResult(result *Result, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	return result.Output(), nil
+}
@@ -0,0 +1,143 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type mockPlainRunner struct {
+	out string
+	err error
+}
+
+func (m *mockPlainRunner) Validate(parameters interface{}) error {
+	return nil
+}
+
+func (m *mockPlainRunner) Run(ctx context.Context, account string, parameters interface{}) (string, error) {
+	return m.out, m.err
+}
+
+type mockResultRunner struct {
+	mockPlainRunner
+	result *Result
+}
+
+func (m *mockResultRunner) RunResult(ctx context.Context, account string, parameters interface{}) (*Result, error) {
+	return m.result, m.err
+}
+
+func TestRunWithResult(t *testing.T) {
+	tests := []struct {
+		name   string
+		runner Runner
+		err    bool
+		result Result
+	}{
+		{
+			name:   "plain runner",
+			runner: &mockPlainRunner{out: "OK"},
+			result: Result{Status: ResultStatusSucceeded, Message: "OK"},
+		},
+		{
+			name:   "plain runner failure",
+			runner: &mockPlainRunner{err: NewRunnerStatusError(ErrExecFailure, "unexpected http response", 503, nil)},
+			err:    true,
+			result: Result{Status: ResultStatusFailed, Message: ErrExecFailure + ": unexpected http response", StatusCode: 503},
+		},
+		{
+			name: "result runner",
+			runner: &mockResultRunner{result: &Result{
+				Message:    "started",
+				Targets:    []string{"i-1"},
+				StatusCode: 200,
+				Data:       map[string]string{"action": "start"},
+			}},
+			result: Result{
+				Status:     ResultStatusSucceeded,
+				Message:    "started",
+				Targets:    []string{"i-1"},
+				StatusCode: 200,
+				Data:       map[string]string{"action": "start"},
+			},
+		},
+		{
+			name: "result runner failure",
+			runner: &mockResultRunner{
+				mockPlainRunner: mockPlainRunner{err: RunnerError{Code: ErrExecFailure, Message: "1 of 2 targets failed", Targets: []string{"i-2"}}},
+				result:          &Result{Targets: []string{"i-1", "i-2"}, StatusCode: 200},
+			},
+			err: true,
+			result: Result{
+				Status:     ResultStatusFailed,
+				Message:    ErrExecFailure + ": 1 of 2 targets failed",
+				Targets:    []string{"i-1", "i-2"},
+				StatusCode: 200,
+			},
+		},
+		{
+			name:   "result runner failure without result",
+			runner: &mockResultRunner{mockPlainRunner: mockPlainRunner{err: RunnerError{Code: ErrExecFailure, Message: "boom", Targets: []string{"i-2"}}}},
+			err:    true,
+			result: Result{Status: ResultStatusFailed, Message: ErrExecFailure + ": boom", Targets: []string{"i-2"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out, err := RunWithResult(context.TODO(), test.runner, "myaccount", nil)
+			if test.err && err == nil {
+				t.Error("expected error, got nil")
+			} else if !test.err && err != nil {
+				t.Errorf("expected nil error, got %s", err)
+			}
+
+			if out == nil {
+				t.Fatal("expected result, got nil")
+			}
+
+			if out.StartedAt == nil || out.EndedAt == nil || out.EndedAt.Before(*out.StartedAt) {
+				t.Errorf("expected result timings, got %v - %v", out.StartedAt, out.EndedAt)
+			}
+
+			out.StartedAt, out.EndedAt, out.DurationMS = nil, nil, 0
+			if !reflect.DeepEqual(*out, test.result) {
+				t.Errorf("expected result %+v, got %+v", test.result, *out)
+			}
+		})
+	}
+}
+
+func TestResultJSON(t *testing.T) {
+	r := &Result{Status: ResultStatusSucceeded, Message: "OK", Targets: []string{"i-1"}}
+	r.SetData("action", "start")
+
+	out := Result{}
+	if err := json.Unmarshal([]byte(r.JSON()), &out); err != nil {
+		t.Fatalf("expected nil error decoding result, got %s", err)
+	}
+
+	if !reflect.DeepEqual(&out, r) {
+		t.Errorf("expected %+v, got %+v", r, out)
+	}
+
+	var nilResult *Result
+	if out := nilResult.Output(); out != "" {
+		t.Errorf("expected empty output for nil result, got '%s'", out)
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	if out := excerpt([]byte("short")); out != "short" {
+		t.Errorf("expected 'short', got '%s'", out)
+	}
+
+	long := strings.Repeat("x", DefaultResultExcerpt+10)
+	out := excerpt([]byte(long))
+	if !strings.HasPrefix(out, long[:DefaultResultExcerpt]) || !strings.HasSuffix(out, "... (truncated)") || len(out) != DefaultResultExcerpt+len("... (truncated)") {
+		t.Errorf("expected truncated excerpt, got '%s'", out)
+	}
+}
@@ -0,0 +1,232 @@
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// BackoffFixed waits the same delay before each retry
+	BackoffFixed = "fixed"
+	// BackoffExponential doubles the delay before each retry, up to the max delay
+	BackoffExponential = "exponential"
+)
+
+// RetryPolicy determines if and when a failed job is retried.  MaxAttempts is the total number of attempts at
+// running the job, so 1 disables retries.  Delay is the wait before the first retry, with exponential backoff it
+// doubles for each retry after that up to MaxDelay.  Jitter randomizes each delay by up to the given fraction of
+// it (0-1).  RetryOn is the list of RunnerError codes (ie. ExecutionFailure) and HTTP statuses (ie. 429) or status
+// classes (ie. 5xx) that are retried.  If RetryOn is empty, every error is retried except the ones that can never
+// succeed, missing details and pre-execution failures, and post-execution failures since retrying them would
+// execute the action again.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     string
+	Delay       time.Duration
+	MaxDelay    time.Duration
+	Jitter      float64
+	RetryOn     []string
+}
+
+// DefaultRetryPolicy is used for jobs when neither the job or the runner set a retry policy
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	Backoff:     BackoffFixed,
+	Delay:       5 * time.Second,
+	MaxDelay:    1 * time.Hour,
+}
+
+// Validate returns an error if the retry policy is invalid
+func (p *RetryPolicy) Validate() error {
+	if p.MaxAttempts < 0 {
+		return errors.New("retry max_attempts cannot be negative")
+	}
+
+	switch p.Backoff {
+	case "", BackoffFixed, BackoffExponential:
+	default:
+		return fmt.Errorf("retry backoff must be one of '%s' or '%s': '%s'", BackoffFixed, BackoffExponential, p.Backoff)
+	}
+
+	if p.Delay < 0 || p.MaxDelay < 0 {
+		return errors.New("retry delay and max_delay cannot be negative")
+	}
+
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return fmt.Errorf("retry jitter must be between 0 and 1: %v", p.Jitter)
+	}
+
+	for _, r := range p.RetryOn {
+		if r == "" {
+			return errors.New("retry retry_on cannot contain an empty value")
+		}
+	}
+
+	return nil
+}
+
+// WithDefaults returns a copy of the retry policy with the unset fields taken from the defaults
+func (p RetryPolicy) WithDefaults(defaults RetryPolicy) RetryPolicy {
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = defaults.MaxAttempts
+	}
+
+	if p.Backoff == "" {
+		p.Backoff = defaults.Backoff
+	}
+
+	if p.Delay == 0 {
+		p.Delay = defaults.Delay
+	}
+
+	if p.MaxDelay == 0 {
+		p.MaxDelay = defaults.MaxDelay
+	}
+
+	if p.Jitter == 0 {
+		p.Jitter = defaults.Jitter
+	}
+
+	if len(p.RetryOn) == 0 {
+		p.RetryOn = defaults.RetryOn
+	}
+
+	return p
+}
+
+// Retryable returns true if the error from the given attempt (starting at 1) should be retried
+func (p *RetryPolicy) Retryable(attempt int, err error) bool {
+	if err == nil || attempt >= p.MaxAttempts {
+		return false
+	}
+
+	rErr := RunnerError{}
+	isRunnerError := errors.As(err, &rErr)
+
+	if len(p.RetryOn) == 0 {
+		return !isRunnerError || (rErr.Code != ErrMissingDetails && rErr.Code != ErrPreExecFailure && rErr.Code != ErrPostExecFailure)
+	}
+
+	if !isRunnerError {
+		return false
+	}
+
+	status := strconv.Itoa(rErr.StatusCode)
+	for _, r := range p.RetryOn {
+		if r == rErr.Code {
+			return true
+		}
+
+		if rErr.StatusCode == 0 {
+			continue
+		}
+
+		// match an exact status (ie. 429) or a status class (ie. 5xx)
+		if r == status || (len(r) == 3 && strings.HasSuffix(strings.ToLower(r), "xx") && r[0] == status[0]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NextDelay returns the delay before retrying after the given attempt (starting at 1)
+func (p *RetryPolicy) NextDelay(attempt int) time.Duration {
+	d := float64(p.Delay)
+	if p.Backoff == BackoffExponential && attempt > 1 {
+		d = d * math.Pow(2, float64(attempt-1))
+	}
+
+	if p.MaxDelay > 0 && d > float64(p.MaxDelay) {
+		d = float64(p.MaxDelay)
+	} else if d > math.MaxInt64 {
+		d = math.MaxInt64
+	}
+	delay := time.Duration(d)
+
+	if p.Jitter > 0 {
+		delay += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(delay))
+	}
+
+	if delay < 0 {
+		return 0
+	}
+
+	return delay
+}
+
+// UnmarshalJSON is a custom JSON unmarshaller for a retry policy
+func (p *RetryPolicy) UnmarshalJSON(j []byte) error {
+	raw := struct {
+		MaxAttempts int      `json:"max_attempts"`
+		Backoff     string   `json:"backoff"`
+		Delay       string   `json:"delay"`
+		MaxDelay    string   `json:"max_delay"`
+		Jitter      float64  `json:"jitter"`
+		RetryOn     []string `json:"retry_on"`
+	}{}
+
+	if err := json.Unmarshal(j, &raw); err != nil {
+		return fmt.Errorf("retry is not a valid retry policy: %s", err)
+	}
+
+	policy := RetryPolicy{
+		MaxAttempts: raw.MaxAttempts,
+		Backoff:     raw.Backoff,
+		Jitter:      raw.Jitter,
+		RetryOn:     raw.RetryOn,
+	}
+
+	durations := []struct {
+		name  string
+		value string
+		field *time.Duration
+	}{
+		{"delay", raw.Delay, &policy.Delay},
+		{"max_delay", raw.MaxDelay, &policy.MaxDelay},
+	}
+
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return fmt.Errorf("retry %s is not a valid duration: '%s'", d.name, d.value)
+		}
+		*d.field = v
+	}
+
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	*p = policy
+	return nil
+}
+
+// MarshalJSON is a custom JSON marshaller for a retry policy
+func (p RetryPolicy) MarshalJSON() ([]byte, error) {
+	duration := func(d time.Duration) string {
+		if d == 0 {
+			return ""
+		}
+		return d.String()
+	}
+
+	return json.Marshal(struct {
+		MaxAttempts int      `json:"max_attempts,omitempty"`
+		Backoff     string   `json:"backoff,omitempty"`
+		Delay       string   `json:"delay,omitempty"`
+		MaxDelay    string   `json:"max_delay,omitempty"`
+		Jitter      float64  `json:"jitter,omitempty"`
+		RetryOn     []string `json:"retry_on,omitempty"`
+	}{p.MaxAttempts, p.Backoff, duration(p.Delay), duration(p.MaxDelay), p.Jitter, p.RetryOn})
+}
@@ -0,0 +1,133 @@
+package jobs
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyValidate(t *testing.T) {
+	type test struct {
+		name    string
+		policy  RetryPolicy
+		wantErr bool
+	}
+
+	tests := []test{
+		{name: "empty", policy: RetryPolicy{}},
+		{name: "default", policy: DefaultRetryPolicy},
+		{name: "negative attempts", policy: RetryPolicy{MaxAttempts: -1}, wantErr: true},
+		{name: "bad backoff", policy: RetryPolicy{Backoff: "linear"}, wantErr: true},
+		{name: "negative delay", policy: RetryPolicy{Delay: -1 * time.Second}, wantErr: true},
+		{name: "bad jitter", policy: RetryPolicy{Jitter: 1.5}, wantErr: true},
+		{name: "empty retry_on", policy: RetryPolicy{RetryOn: []string{""}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.policy.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("expected error %t, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyRetryable(t *testing.T) {
+	type test struct {
+		name    string
+		policy  RetryPolicy
+		attempt int
+		err     error
+		want    bool
+	}
+
+	tests := []test{
+		{name: "nil error", policy: DefaultRetryPolicy, attempt: 1, err: nil, want: false},
+		{name: "plain error", policy: DefaultRetryPolicy, attempt: 1, err: errors.New("boom"), want: true},
+		{name: "attempts exhausted", policy: DefaultRetryPolicy, attempt: 3, err: errors.New("boom"), want: false},
+		{name: "missing details", policy: DefaultRetryPolicy, attempt: 1, err: NewRunnerError(ErrMissingDetails, "missing instance_id", nil), want: false},
+		{name: "pre exec failure", policy: DefaultRetryPolicy, attempt: 1, err: NewRunnerError(ErrPreExecFailure, "template parsing failed", nil), want: false},
+		{name: "post exec failure", policy: DefaultRetryPolicy, attempt: 1, err: NewRunnerError(ErrPostExecFailure, "1 of 2 tasks failed", nil), want: false},
+		{name: "exec failure", policy: DefaultRetryPolicy, attempt: 1, err: NewRunnerError(ErrExecFailure, "http request failed", nil), want: true},
+		{
+			name:    "matching code",
+			policy:  RetryPolicy{MaxAttempts: 3, RetryOn: []string{ErrExecFailure}},
+			attempt: 1,
+			err:     NewRunnerError(ErrExecFailure, "http request failed", nil),
+			want:    true,
+		},
+		{
+			name:    "plain error with retry_on",
+			policy:  RetryPolicy{MaxAttempts: 3, RetryOn: []string{ErrExecFailure}},
+			attempt: 1,
+			err:     errors.New("boom"),
+			want:    false,
+		},
+		{
+			name:    "matching status class",
+			policy:  RetryPolicy{MaxAttempts: 3, RetryOn: []string{"5xx"}},
+			attempt: 1,
+			err:     NewRunnerStatusError(ErrExecFailure, "unexpected http response", 502, nil),
+			want:    true,
+		},
+		{
+			name:    "matching status",
+			policy:  RetryPolicy{MaxAttempts: 3, RetryOn: []string{"429"}},
+			attempt: 1,
+			err:     NewRunnerStatusError(ErrExecFailure, "unexpected http response", 429, nil),
+			want:    true,
+		},
+		{
+			name:    "non matching status",
+			policy:  RetryPolicy{MaxAttempts: 3, RetryOn: []string{"5xx", "429"}},
+			attempt: 1,
+			err:     NewRunnerStatusError(ErrExecFailure, "unexpected http response", 404, nil),
+			want:    false,
+		},
+		{
+			name:    "status class without status",
+			policy:  RetryPolicy{MaxAttempts: 3, RetryOn: []string{"5xx"}},
+			attempt: 1,
+			err:     NewRunnerError(ErrExecFailure, "http request failed", nil),
+			want:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Retryable(tt.attempt, tt.err); got != tt.want {
+				t.Errorf("expected %t, got %t", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyNextDelay(t *testing.T) {
+	fixed := RetryPolicy{Backoff: BackoffFixed, Delay: 10 * time.Second}
+	for attempt := 1; attempt <= 3; attempt++ {
+		if got := fixed.NextDelay(attempt); got != 10*time.Second {
+			t.Errorf("expected fixed delay of 10s for attempt %d, got %s", attempt, got)
+		}
+	}
+
+	exponential := RetryPolicy{Backoff: BackoffExponential, Delay: 10 * time.Second, MaxDelay: 1 * time.Minute}
+	for attempt, want := range map[int]time.Duration{1: 10 * time.Second, 2: 20 * time.Second, 3: 40 * time.Second, 4: 1 * time.Minute, 100: 1 * time.Minute} {
+		if got := exponential.NextDelay(attempt); got != want {
+			t.Errorf("expected exponential delay of %s for attempt %d, got %s", want, attempt, got)
+		}
+	}
+
+	jitter := RetryPolicy{Backoff: BackoffFixed, Delay: 10 * time.Second, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		if got := jitter.NextDelay(1); got < 5*time.Second || got > 15*time.Second {
+			t.Errorf("expected delay with jitter between 5s and 15s, got %s", got)
+		}
+	}
+}
+
+func TestRetryPolicyWithDefaults(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5, RetryOn: []string{"5xx"}}.WithDefaults(DefaultRetryPolicy)
+	if p.MaxAttempts != 5 || p.Backoff != BackoffFixed || p.Delay != DefaultRetryPolicy.Delay || p.MaxDelay != DefaultRetryPolicy.MaxDelay || len(p.RetryOn) != 1 {
+		t.Errorf("unexpected retry policy with defaults %+v", p)
+	}
+}
@@ -0,0 +1,122 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Revision is a version of a job kept in the revision history of the jobs repository.  The ID identifies the
+// revision in the repository, ie. the S3 object version id or the job version.
+type Revision struct {
+	ID         string     `json:"id"`
+	Version    int64      `json:"version"`
+	ModifiedAt *time.Time `json:"modified_at,omitempty"`
+	ModifiedBy string     `json:"modified_by,omitempty"`
+	Job        *Job       `json:"job"`
+	Diff       []*Change  `json:"diff,omitempty"`
+}
+
+// Change is the change of a single field of a job between two revisions.  The fields of the details and
+// the retry policy are prefixed with their parent, ie. details.runner.
+type Change struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from,omitempty"`
+	To    interface{} `json:"to,omitempty"`
+}
+
+// RevisionLister is implemented by the repositories that keep the revision history of the jobs.  The revisions
+// are returned most recent first.
+type RevisionLister interface {
+	Revisions(ctx context.Context, account, group, id string) ([]*Revision, error)
+}
+
+// RevisionGetter is implemented by the repositories that get a single revision of a job without the rest of its
+// history.  The revision before it is returned too, to diff the changes, or nil if it's the first revision.
+type RevisionGetter interface {
+	Revision(ctx context.Context, account, group, id, rev string) (*Revision, *Revision, error)
+}
+
+// newRevision returns the revision of a job with the given id
+func newRevision(id string, job *Job) *Revision {
+	return &Revision{
+		ID:         id,
+		Version:    job.Version,
+		ModifiedAt: job.ModifiedAt,
+		ModifiedBy: job.ModifiedBy,
+		Job:        job,
+	}
+}
+
+// Diff returns the changes of the fields of a job from one revision to the next, sorted by field.  If from is
+// nil, every field of the job is a change.  The version and the modification time and author are part of each
+// revision and aren't compared.
+func Diff(from, to *Job) []*Change {
+	before, after := diffFields(from), diffFields(to)
+
+	changes := []*Change{}
+	for k, v := range after {
+		if b, ok := before[k]; !ok || !reflect.DeepEqual(b, v) {
+			changes = append(changes, &Change{Field: k, From: before[k], To: v})
+		}
+	}
+
+	for k, v := range before {
+		if _, ok := after[k]; !ok {
+			changes = append(changes, &Change{Field: k, From: v})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+
+	return changes
+}
+
+// diffFields flattens the JSON representation of a job into its fields
+func diffFields(job *Job) map[string]interface{} {
+	fields := make(map[string]interface{})
+	if job == nil {
+		return fields
+	}
+
+	j, err := json.Marshal(job)
+	if err != nil {
+		return fields
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(j, &m); err != nil {
+		return fields
+	}
+
+	for _, k := range []string{"modified_at", "modified_by", "version"} {
+		delete(m, k)
+	}
+
+	flatten("", m, fields)
+
+	return fields
+}
+
+// flatten adds the values of the nested map m to fields, with their keys joined with dots
+func flatten(prefix string, m map[string]interface{}, fields map[string]interface{}) {
+	for k, v := range m {
+		if prefix != "" {
+			k = prefix + "." + k
+		}
+
+		if nested, ok := v.(map[string]interface{}); ok {
+			flatten(k, nested, fields)
+			continue
+		}
+
+		// empty values are the same as unset fields
+		if v == nil || v == "" {
+			continue
+		}
+
+		fields[k] = v
+	}
+}
@@ -0,0 +1,58 @@
+package jobs
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	modified := time.Now().UTC().Truncate(time.Second)
+	from := &Job{
+		ID:                 "job1",
+		Description:        "first studio album",
+		Details:            map[string]string{"runner": "dummy", "instance_id": "i-123"},
+		ModifiedBy:         "hetfield",
+		ScheduleExpression: "00 00 25 07 *",
+		Version:            1,
+	}
+
+	to := &Job{
+		ID:                 "job1",
+		Description:        "first studio album",
+		Details:            map[string]string{"runner": "dummy", "instance_action": "stop"},
+		Enabled:            true,
+		ModifiedAt:         &modified,
+		ModifiedBy:         "ulrich",
+		ScheduleExpression: "00 00 27 07 *",
+		Version:            2,
+	}
+
+	expected := []*Change{
+		{Field: "details.instance_action", To: "stop"},
+		{Field: "details.instance_id", From: "i-123"},
+		{Field: "enabled", From: false, To: true},
+		{Field: "schedule_expression", From: "00 00 25 07 *", To: "00 00 27 07 *"},
+	}
+
+	if out := Diff(from, to); !reflect.DeepEqual(out, expected) {
+		t.Errorf("expected %+v, got %+v", expected, out)
+	}
+
+	if out := Diff(to, to); len(out) != 0 {
+		t.Errorf("expected no changes between the same revisions, got %+v", out)
+	}
+
+	out := Diff(nil, from)
+	fields := []string{}
+	for _, c := range out {
+		if c.From != nil {
+			t.Errorf("expected no previous value for the first revision, got %+v", c)
+		}
+		fields = append(fields, c.Field)
+	}
+
+	if expected := []string{"description", "details.instance_id", "details.runner", "enabled", "id", "schedule_expression"}; !reflect.DeepEqual(fields, expected) {
+		t.Errorf("expected fields %v for the first revision, got %v", expected, fields)
+	}
+}
@@ -0,0 +1,82 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// RunStatusRunning is the status of a run that has started
+	RunStatusRunning = "running"
+	// RunStatusSucceeded is the status of a run that finished successfully
+	RunStatusSucceeded = "succeeded"
+	// RunStatusFailed is the status of a run that finished with an error
+	RunStatusFailed = "failed"
+	// RunStatusInterrupted is the status of a run that was stopped before it finished, ie. during shutdown
+	RunStatusInterrupted = "interrupted"
+)
+
+// Run is the record of a single execution of a job
+type Run struct {
+	ID          string     `json:"id"`
+	JobID       string     `json:"job_id"`
+	Account     string     `json:"account"`
+	Group       string     `json:"group"`
+	ScheduledAt *time.Time `json:"scheduled_at,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	EndedAt     *time.Time `json:"ended_at,omitempty"`
+	Attempt     int        `json:"attempt"`
+	NodeID      string     `json:"node_id"`
+	Runner      string     `json:"runner"`
+	Status      string     `json:"status"`
+	Output      string     `json:"output,omitempty"`
+	Result      *Result    `json:"result,omitempty"`
+	ErrorCode   string     `json:"error_code,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// RunsRepository is the durable storage for the history of job runs.  List returns the most recent runs of a job
+// first, up to the limit (all of them if the limit is 0).  Prune removes the runs of a job beyond the newest keep
+// runs and the runs from before the given time, a keep of 0 or a zero time doesn't limit the runs.  Delete removes
+// all of the runs of a job.
+type RunsRepository interface {
+	Get(ctx context.Context, account, group, jobID, id string) (*Run, error)
+	List(ctx context.Context, account, group, jobID string, limit int) ([]*Run, error)
+	Put(ctx context.Context, run *Run) error
+	Prune(ctx context.Context, account, group, jobID string, keep int, before time.Time) error
+	Delete(ctx context.Context, account, group, jobID string) error
+}
+
+// NewRunID returns a new id for a run of a job.  It's a version 7 UUID string, which starts with the time
+// it was created in milliseconds so the ids of runs sort in the order they were created.
+func NewRunID() string {
+	var id uuid.UUID
+	if _, err := rand.Read(id[6:]); err != nil {
+		return NewID()
+	}
+
+	ms := uint64(time.Now().UnixMilli())
+	for i := 5; i >= 0; i-- {
+		id[i] = byte(ms)
+		ms >>= 8
+	}
+
+	id[6] = id[6]&0x0f | 0x70
+	id[8] = id[8]&0x3f | 0x80
+
+	return id.String()
+}
+
+// sortRuns sorts runs by the time they started, most recent first.  runs that never started sort last.
+func sortRuns(runs []*Run) {
+	sort.SliceStable(runs, func(i, j int) bool {
+		if runs[i].StartedAt == nil || runs[j].StartedAt == nil {
+			return runs[j].StartedAt == nil && runs[i].StartedAt != nil
+		}
+		return runs[i].StartedAt.After(*runs[j].StartedAt)
+	})
+}
@@ -0,0 +1,32 @@
+package jobs
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestNewRunID(t *testing.T) {
+	ids := []string{}
+	for i := 0; i < 3; i++ {
+		id := NewRunID()
+
+		u, err := uuid.Parse(id)
+		if err != nil {
+			t.Fatalf("expected run id to be a uuid, got %s (%s)", id, err)
+		}
+
+		if u.Version() != 7 || u.Variant() != uuid.RFC4122 {
+			t.Errorf("expected a version 7 RFC4122 uuid, got version %d, variant %s", u.Version(), u.Variant())
+		}
+
+		ids = append(ids, id)
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	if !sort.StringsAreSorted(ids) {
+		t.Errorf("expected run ids to sort in the order they were created, got %v", ids)
+	}
+}
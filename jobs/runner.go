@@ -3,6 +3,8 @@ package jobs
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"strings"
 )
 
 const ErrMissingDetails = "MissingDetails"
@@ -10,15 +12,48 @@ const ErrPreExecFailure = "PreExecutionFailure"
 const ErrExecFailure = "ExecutionFailure"
 const ErrPostExecFailure = "PostExecutionFailure"
 
-// Runner has a Run method and runs a job
+type runIDKey struct{}
+
+// WithRunID returns a copy of the context carrying the id of the run, for runners that pass it on
+func WithRunID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, runIDKey{}, id)
+}
+
+// RunID returns the id of the run carried by the context, or an empty string
+func RunID(ctx context.Context) string {
+	id, _ := ctx.Value(runIDKey{}).(string)
+	return id
+}
+
+type targetsKey struct{}
+
+// WithTargets returns a copy of the context carrying the targets a run is limited to, ie. the failed targets of
+// the run being retried
+func WithTargets(ctx context.Context, targets []string) context.Context {
+	return context.WithValue(ctx, targetsKey{}, targets)
+}
+
+// Targets returns the targets carried by the context, or nil
+func Targets(ctx context.Context) []string {
+	targets, _ := ctx.Value(targetsKey{}).([]string)
+	return targets
+}
+
+// Runner has a Run method and runs a job.  Validate checks the job parameters before the job is saved
+// and returns a ValidationError listing every problem with them.
 type Runner interface {
 	Run(ctx context.Context, account string, parameters interface{}) (string, error)
+	Validate(parameters interface{}) error
 }
 
 type RunnerError struct {
 	Code    string
 	Message string
 	OrigErr error
+	// StatusCode is the http status code of the response that caused the error, if there was one
+	StatusCode int
+	// Targets are the targets that failed for runners that act on more than one, a retry only acts on them
+	Targets []string
 }
 
 // New constructs a RunnerError and returns it as an error
@@ -30,6 +65,16 @@ func NewRunnerError(code, message string, err error) RunnerError {
 	}
 }
 
+// NewRunnerStatusError constructs a RunnerError for an unexpected http response status and returns it as an error
+func NewRunnerStatusError(code, message string, statusCode int, err error) RunnerError {
+	return RunnerError{
+		Code:       code,
+		Message:    message,
+		OrigErr:    err,
+		StatusCode: statusCode,
+	}
+}
+
 // Error Satisfies the Error interface
 func (e RunnerError) Error() string {
 	return e.String()
@@ -47,3 +92,166 @@ func (e RunnerError) String() string {
 func (e RunnerError) Unwrap() error {
 	return e.OrigErr
 }
+
+// ValidationError is returned when validating job parameters, it carries every problem found
+type ValidationError struct {
+	Problems []string
+}
+
+// Error Satisfies the Error interface
+func (e ValidationError) Error() string {
+	return "invalid job details: " + strings.Join(e.Problems, ", ")
+}
+
+// validator collects the problems found while validating job parameters
+type validator struct {
+	params   map[string]string
+	problems []string
+}
+
+// newValidator returns a validator for the job parameters, a problem is recorded if they are the wrong type
+func newValidator(parameters interface{}) *validator {
+	v := &validator{}
+
+	params, ok := parameters.(map[string]string)
+	if !ok {
+		v.problem("parameters list is not a map[string]string")
+		params = map[string]string{}
+	}
+	v.params = params
+
+	return v
+}
+
+// problem records a problem with the parameters
+func (v *validator) problem(format string, a ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, a...))
+}
+
+// required returns the value of a required parameter, recording a problem if it's missing or empty
+func (v *validator) required(key string) (string, bool) {
+	value, ok := v.params[key]
+	if !ok || value == "" {
+		v.problem("missing %s", key)
+		return "", false
+	}
+	return value, true
+}
+
+// oneOf records a problem if a required parameter isn't one of the allowed values
+func (v *validator) oneOf(key string, allowed ...string) (string, bool) {
+	value, ok := v.required(key)
+	if !ok {
+		return "", false
+	}
+
+	for _, a := range allowed {
+		if value == a {
+			return value, true
+		}
+	}
+
+	v.problem("unexpected %s '%s', must be one of '%s'", key, value, strings.Join(allowed, "', '"))
+	return value, false
+}
+
+// integer records a problem if a required parameter isn't an integer of at least min
+func (v *validator) integer(key string, min int) (int, bool) {
+	value, ok := v.required(key)
+	if !ok {
+		return 0, false
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		v.problem("%s must be an integer: '%s'", key, value)
+		return 0, false
+	}
+
+	if i < min {
+		v.problem("%s must be at least %d: %d", key, min, i)
+		return i, false
+	}
+
+	return i, true
+}
+
+// boolean records a problem if an optional parameter is set and isn't 'true' or 'false'.  It returns the value
+// and whether the parameter is set.
+func (v *validator) boolean(key string) (bool, bool) {
+	value, ok := v.params[key]
+	if !ok {
+		return false, false
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil || (value != "true" && value != "false") {
+		v.problem("%s must be 'true' or 'false': '%s'", key, value)
+		return false, false
+	}
+
+	return b, true
+}
+
+// err returns a ValidationError with the problems found, or nil if there weren't any
+func (v *validator) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return ValidationError{Problems: v.problems}
+}
+
+// configInt returns the integer value of a number from the runner configuration
+func configInt(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case float64:
+		if n != float64(int(n)) {
+			return 0, false
+		}
+		return int(n), true
+	default:
+		return 0, false
+	}
+}
+
+// configStrings returns the values of a list of strings from the runner configuration
+func configStrings(v interface{}) ([]string, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a list of strings, got %v", v)
+	}
+
+	var out []string
+	for _, l := range list {
+		s, ok := l.(string)
+		if !ok || s == "" {
+			return nil, fmt.Errorf("invalid value %v", l)
+		}
+		out = append(out, s)
+	}
+
+	return out, nil
+}
+
+// jsonField returns the value of a field of a decoded JSON document.  Nested fields are separated by '.', ie.
+// 'Instance.State'.
+func jsonField(doc interface{}, field string) (interface{}, error) {
+	for _, f := range strings.Split(field, ".") {
+		m, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("field %s not found in response", field)
+		}
+
+		if doc, ok = m[f]; !ok {
+			return nil, fmt.Errorf("field %s not found in response", field)
+		}
+	}
+
+	return doc, nil
+}
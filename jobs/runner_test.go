@@ -49,3 +49,34 @@ func TestRunnerErrorUnwrap(t *testing.T) {
 		t.Errorf("expect original error tp be %s, got %s", err, orig)
 	}
 }
+
+func TestValidationError(t *testing.T) {
+	out := ValidationError{Problems: []string{"missing instance_id", "missing instance_action"}}
+	expect := "invalid job details: missing instance_id, missing instance_action"
+	if out.Error() != expect {
+		t.Errorf("expected '%s', got '%s'", expect, out)
+	}
+}
+
+func TestValidatorInteger(t *testing.T) {
+	tests := []struct {
+		value    string
+		min      int
+		problems []string
+	}{
+		{"1", 0, nil},
+		{"0", 0, nil},
+		{"0", 1, []string{"count must be at least 1: 0"}},
+		{"-1", 0, []string{"count must be at least 0: -1"}},
+		{"one", 0, []string{"count must be an integer: 'one'"}},
+		{"", 0, []string{"missing count"}},
+	}
+
+	for _, test := range tests {
+		v := newValidator(map[string]string{"count": test.value})
+		v.integer("count", test.min)
+		if !reflect.DeepEqual(v.problems, test.problems) {
+			t.Errorf("expected problems %v for '%s', got %v", test.problems, test.value, v.problems)
+		}
+	}
+}
@@ -5,12 +5,15 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"strings"
 	"time"
 
 	"github.com/YaleSpinup/apierror"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
@@ -159,8 +162,9 @@ func (s *S3Repository) Create(ctx context.Context, account, group string, job *J
 		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
 	}
 
-	// generate a new random ID for the job
+	// generate a new random ID for the job, a new job doesn't have a version yet
 	job.ID = NewID()
+	job.Version = 0
 
 	return s.Update(ctx, account, group, job.ID, job)
 }
@@ -173,21 +177,38 @@ func (s *S3Repository) Delete(ctx context.Context, account, group, id string) er
 
 	log.Infof("deleting job from s3 %s/%s/%s", account, group, id)
 
-	key := s.Prefix + "/" + account
-	if !strings.HasSuffix(account, "/") && !strings.HasPrefix(group, "/") {
-		key = key + "/"
+	key := s.jobKey(account, group, id)
+	if id == "" {
+		return s.deletePath(ctx, strings.TrimSuffix(key, "/"))
 	}
-	key = key + group
 
-	if id == "" {
-		return s.deletePath(ctx, key)
+	return s.deleteObject(ctx, key)
+}
+
+// DeleteVersion deletes a job in the s3 jobs repository if it's still at the given version.  S3 doesn't support
+// conditional deletes, so a write between checking the version and deleting the object isn't detected.
+func (s *S3Repository) DeleteVersion(ctx context.Context, account, group, id string, version int64) error {
+	if account == "" || group == "" || id == "" {
+		return apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
 	}
 
-	if !strings.HasSuffix(group, "/") && !strings.HasPrefix(id, "/") {
-		key = key + "/"
+	log.Infof("deleting job version %d from s3 %s/%s/%s", version, account, group, id)
+
+	key := s.jobKey(account, group, id)
+
+	current, etag, err := s.version(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	if etag == "" {
+		return apierror.New(apierror.ErrNotFound, "job not found "+id, nil)
+	}
+
+	if current != version {
+		return staleVersionError(id, version, current)
 	}
 
-	key = key + id
 	return s.deleteObject(ctx, key)
 }
 
@@ -201,6 +222,10 @@ func (s *S3Repository) deletePath(ctx context.Context, prefix string) error {
 
 	log.Debugf("got list of objects with prefix %s: %+v", prefix, jobs)
 
+	if len(jobs) == 0 {
+		return nil
+	}
+
 	// TODO: handle the case of deleting more than 1000 objects?
 	if len(jobs) >= 1000 {
 		return errors.New("cannot delete more than 1000 jobs at one time")
@@ -246,17 +271,7 @@ func (s *S3Repository) Get(ctx context.Context, account, group, id string) (*Job
 
 	log.Infof("getting job %s/%s/%s", account, group, id)
 
-	key := s.Prefix + "/" + account
-	if !strings.HasSuffix(account, "/") && !strings.HasPrefix(group, "/") {
-		key = key + "/"
-	}
-	key = key + group
-
-	if !strings.HasSuffix(group, "/") && !strings.HasPrefix(id, "/") {
-		key = key + "/"
-	}
-
-	key = key + id
+	key := s.jobKey(account, group, id)
 
 	log.Debugf("getting object (account: %s, group: %s, id: %s, key: '%s')", account, group, id, key)
 
@@ -347,6 +362,430 @@ func (s *S3Repository) Update(ctx context.Context, account, group, id string, jo
 
 	log.Infof("updating job %s/%s/%s", account, group, id)
 
+	key := s.jobKey(account, group, id)
+
+	current, etag, err := s.version(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkVersion(id, job.Version, current); err != nil {
+		return nil, err
+	}
+	job.Version = current + 1
+
+	log.Debugf("updating %s with job %+v", key, job)
+
+	j, err := json.MarshalIndent(job, "", "\t")
+	if err != nil {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", err)
+	}
+
+	// the put is conditional on the object not changing (or being created) since its version was read
+	condition := map[string]string{"If-None-Match": "*"}
+	if etag != "" {
+		condition = map[string]string{"If-Match": etag}
+	}
+
+	out, err := s.S3.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Body:        bytes.NewReader(j),
+		Bucket:      aws.String(s.Bucket),
+		ContentType: aws.String("application/json"),
+		Key:         aws.String(key),
+	}, request.WithSetRequestHeaders(condition))
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == "PreconditionFailed" || aerr.Code() == "ConditionalRequestConflict") {
+			msg := fmt.Sprintf("job %s was modified by another writer while it was updated", id)
+			return nil, apierror.New(apierror.ErrConflict, msg, aerr)
+		}
+		return nil, ErrCode("failed to put s3 object", err)
+	}
+
+	log.Debugf("output from s3 job put: %+v", out)
+
+	return job, nil
+}
+
+// Revisions returns the revision history of a job in the s3 jobs repository, most recent first.  The revisions
+// are the versions of the job object, so versioning must be enabled on the bucket to keep more than the current
+// revision.  The id of a revision is the version id of the object.
+func (s *S3Repository) Revisions(ctx context.Context, account, group, id string) ([]*Revision, error) {
+	if account == "" || group == "" || id == "" {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Infof("listing revisions of job %s/%s/%s", account, group, id)
+
+	key := s.jobKey(account, group, id)
+
+	objects, _, err := s.listVersions(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []string{}
+	for _, o := range objects {
+		versions = append(versions, aws.StringValue(o.VersionId))
+	}
+
+	revisions := []*Revision{}
+	for _, v := range versions {
+		out, err := s.S3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket:    aws.String(s.Bucket),
+			Key:       aws.String(key),
+			VersionId: aws.String(v),
+		})
+		if err != nil {
+			return nil, ErrCode("failed to get job object version from s3 "+v, err)
+		}
+
+		job := &Job{}
+		err = json.NewDecoder(out.Body).Decode(job)
+		out.Body.Close()
+		if err != nil {
+			log.Errorf("error decoding version %s of job %s: %s", v, id, err)
+			continue
+		}
+
+		revisions = append(revisions, newRevision(v, job))
+	}
+
+	return revisions, nil
+}
+
+// Trash moves a job, or all of the jobs in the group if the id is empty, to the trash of the s3 jobs repository.
+// Trashed jobs are stored under the .trash/ prefix of the bucket.  Like DeleteVersion, a write between checking
+// the version and moving the object isn't detected.
+func (s *S3Repository) Trash(ctx context.Context, account, group, id string, version int64) error {
+	if account == "" || group == "" || (id == "" && version != 0) {
+		return apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Infof("trashing job in s3 %s/%s/%s", account, group, id)
+
+	ids := []string{id}
+	if id == "" {
+		list, err := s.listObjects(ctx, s.jobKey(account, group, ""))
+		if err != nil {
+			return err
+		}
+		ids = list
+	}
+
+	for _, id := range ids {
+		key := s.jobKey(account, group, id)
+
+		job, err := s.Get(ctx, account, group, id)
+		if err != nil {
+			return err
+		}
+
+		if version != 0 && job.Version != version {
+			return staleVersionError(id, version, job.Version)
+		}
+
+		if err := s.putJSON(ctx, trashKey(key), newTrashedJob(group, job), nil); err != nil {
+			return err
+		}
+
+		if err := s.deleteObject(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ListTrash lists the trashed jobs in the s3 jobs repository.  If group is empty, the trashed jobs of the
+// whole account are returned.
+func (s *S3Repository) ListTrash(ctx context.Context, account, group string) ([]*TrashedJob, error) {
+	if account == "" {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Infof("listing trashed jobs for account '%s', group '%s'", account, group)
+
+	prefix := trashKey(s.Prefix + "/" + account)
+	if group != "" {
+		prefix = trashKey(s.jobKey(account, group, ""))
+	}
+
+	list, err := s.listObjects(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	trashed := []*TrashedJob{}
+	for _, k := range list {
+		t := &TrashedJob{}
+		if err := s.getJSON(ctx, strings.TrimSuffix(prefix, "/")+"/"+k, t); err != nil {
+			log.Errorf("error getting trashed job '%s': %s", k, err)
+			continue
+		}
+		trashed = append(trashed, t)
+	}
+
+	return trashed, nil
+}
+
+// RestoreTrash moves a trashed job, or all of the trashed jobs in the group if the id is empty, back into the
+// s3 jobs repository.  The put of the job is conditional on the job not existing.
+func (s *S3Repository) RestoreTrash(ctx context.Context, account, group, id string) ([]*Job, error) {
+	if account == "" || group == "" {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Infof("restoring trashed job in s3 %s/%s/%s", account, group, id)
+
+	ids := []string{id}
+	if id == "" {
+		list, err := s.listObjects(ctx, trashKey(s.jobKey(account, group, "")))
+		if err != nil {
+			return nil, err
+		}
+		ids = list
+	}
+
+	restored := []*Job{}
+	for _, id := range ids {
+		key := s.jobKey(account, group, id)
+
+		t := &TrashedJob{}
+		if err := s.getJSON(ctx, trashKey(key), t); err != nil {
+			return restored, err
+		}
+
+		if err := s.putJSON(ctx, key, t.Job, map[string]string{"If-None-Match": "*"}); err != nil {
+			var aerr apierror.Error
+			if errors.As(err, &aerr) && aerr.Code == apierror.ErrConflict {
+				return restored, apierror.New(apierror.ErrConflict, "cannot restore job, it already exists "+id, err)
+			}
+			return restored, err
+		}
+
+		if err := s.deleteObject(ctx, trashKey(key)); err != nil {
+			log.Errorf("failed to remove restored job %s from the trash: %s", id, err)
+		}
+
+		restored = append(restored, t.Job)
+	}
+
+	return restored, nil
+}
+
+// Revision gets a revision of a job and the revision before it from the s3 jobs repository.  Only the two versions
+// of the job object are fetched, the version before the revision is the next one listed after it.
+func (s *S3Repository) Revision(ctx context.Context, account, group, id, rev string) (*Revision, *Revision, error) {
+	if account == "" || group == "" || id == "" || rev == "" {
+		return nil, nil, apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Infof("getting revision %s of job %s/%s/%s", rev, account, group, id)
+
+	key := s.jobKey(account, group, id)
+
+	revision, err := s.getVersion(ctx, key, rev)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	input := s3.ListObjectVersionsInput{
+		Bucket:          aws.String(s.Bucket),
+		KeyMarker:       aws.String(key),
+		Prefix:          aws.String(key),
+		VersionIdMarker: aws.String(rev),
+	}
+
+	for {
+		output, err := s.S3.ListObjectVersionsWithContext(ctx, &input)
+		if err != nil {
+			return nil, nil, ErrCode("failed to list job object versions from s3 "+key, err)
+		}
+
+		// the versions are listed by key, so a version of another job starting with the id follows the first revision
+		for _, v := range output.Versions {
+			if aws.StringValue(v.Key) != key {
+				return revision, nil, nil
+			}
+
+			previous, err := s.getVersion(ctx, key, aws.StringValue(v.VersionId))
+			if err != nil {
+				return nil, nil, err
+			}
+
+			return revision, previous, nil
+		}
+
+		if !aws.BoolValue(output.IsTruncated) {
+			return revision, nil, nil
+		}
+		input.KeyMarker = output.NextKeyMarker
+		input.VersionIdMarker = output.NextVersionIdMarker
+	}
+}
+
+// getVersion returns the revision of a version of a job object
+func (s *S3Repository) getVersion(ctx context.Context, key, version string) (*Revision, error) {
+	out, err := s.S3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket:    aws.String(s.Bucket),
+		Key:       aws.String(key),
+		VersionId: aws.String(version),
+	})
+	if err != nil {
+		return nil, ErrCode("failed to get job object version from s3 "+version, err)
+	}
+	defer out.Body.Close()
+
+	job := &Job{}
+	if err := json.NewDecoder(out.Body).Decode(job); err != nil {
+		return nil, apierror.New(apierror.ErrBadRequest, "failed to decode json from s3", err)
+	}
+
+	return newRevision(version, job), nil
+}
+
+// PurgeTrash permanently removes a trashed job, or all of the trashed jobs in the group if the id is empty, from
+// the s3 jobs repository.  All of the versions of the job object are deleted with the trashed object, unless a job
+// with the same id was created since it was trashed.
+func (s *S3Repository) PurgeTrash(ctx context.Context, account, group, id string) error {
+	if account == "" || group == "" {
+		return apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Warnf("purging trashed job from s3 %s/%s/%s", account, group, id)
+
+	ids := []string{id}
+	if id == "" {
+		list, err := s.listObjects(ctx, trashKey(s.jobKey(account, group, "")))
+		if err != nil {
+			return err
+		}
+		ids = list
+	}
+
+	for _, id := range ids {
+		key := s.jobKey(account, group, id)
+
+		t := &TrashedJob{}
+		if err := s.getJSON(ctx, trashKey(key), t); err != nil {
+			var aerr apierror.Error
+			if errors.As(err, &aerr) && aerr.Code == apierror.ErrNotFound {
+				log.Debugf("job %s isn't in the trash, not purging it", id)
+				continue
+			}
+			return err
+		}
+
+		// the history is deleted before the trashed object, so a failed purge is retried
+		versions, markers, err := s.listVersions(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		if len(versions) > 0 && aws.BoolValue(versions[0].IsLatest) {
+			log.Warnf("job %s was recreated since it was trashed, keeping its history", id)
+		} else if err := s.deleteVersions(ctx, key, versions, markers); err != nil {
+			return err
+		}
+
+		trashed, trashedMarkers, err := s.listVersions(ctx, trashKey(key))
+		if err != nil {
+			return err
+		}
+
+		if err := s.deleteVersions(ctx, trashKey(key), trashed, trashedMarkers); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// listVersions returns the versions of the object with the key, most recent first, and its delete markers
+func (s *S3Repository) listVersions(ctx context.Context, key string) ([]*s3.ObjectVersion, []*s3.DeleteMarkerEntry, error) {
+	input := s3.ListObjectVersionsInput{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(key),
+	}
+
+	versions := []*s3.ObjectVersion{}
+	markers := []*s3.DeleteMarkerEntry{}
+	truncated := true
+	for truncated {
+		output, err := s.S3.ListObjectVersionsWithContext(ctx, &input)
+		if err != nil {
+			return nil, nil, ErrCode("failed to list job object versions from s3 "+key, err)
+		}
+
+		// the prefix also matches the keys of other jobs starting with the id
+		for _, v := range output.Versions {
+			if aws.StringValue(v.Key) == key {
+				versions = append(versions, v)
+			}
+		}
+
+		for _, m := range output.DeleteMarkers {
+			if aws.StringValue(m.Key) == key {
+				markers = append(markers, m)
+			}
+		}
+
+		truncated = aws.BoolValue(output.IsTruncated)
+		input.KeyMarker = output.NextKeyMarker
+		input.VersionIdMarker = output.NextVersionIdMarker
+	}
+
+	return versions, markers, nil
+}
+
+// deleteVersions permanently deletes the versions and the delete markers of the object with the key, up to 1000
+// per request
+func (s *S3Repository) deleteVersions(ctx context.Context, key string, versions []*s3.ObjectVersion, markers []*s3.DeleteMarkerEntry) error {
+	ids := []*string{}
+	for _, v := range versions {
+		ids = append(ids, v.VersionId)
+	}
+	for _, m := range markers {
+		ids = append(ids, m.VersionId)
+	}
+
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > 1000 {
+			n = 1000
+		}
+
+		objs := make([]*s3.ObjectIdentifier, n)
+		for i, id := range ids[:n] {
+			objs[i] = &s3.ObjectIdentifier{Key: aws.String(key), VersionId: id}
+		}
+
+		log.Warnf("deleting %d versions of object %s from bucket %s", n, key, s.Bucket)
+
+		out, err := s.S3.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(s.Bucket),
+			Delete: &s3.Delete{
+				Objects: objs,
+				Quiet:   aws.Bool(true),
+			},
+		})
+		if err != nil {
+			return ErrCode("failed to delete versions of object "+key, err)
+		}
+
+		if len(out.Errors) > 0 {
+			msg := fmt.Sprintf("failed to delete %d versions of object %s: %s", len(out.Errors), key, aws.StringValue(out.Errors[0].Message))
+			return apierror.New(apierror.ErrInternalError, msg, nil)
+		}
+
+		ids = ids[n:]
+	}
+
+	return nil
+}
+
+// jobKey returns the key of the object of a job, or the prefix of the objects in the group if the id is empty
+func (s *S3Repository) jobKey(account, group, id string) string {
 	key := s.Prefix + "/" + account
 	if !strings.HasSuffix(account, "/") && !strings.HasPrefix(group, "/") {
 		key = key + "/"
@@ -357,26 +796,74 @@ func (s *S3Repository) Update(ctx context.Context, account, group, id string, jo
 		key = key + "/"
 	}
 
-	key = key + id
+	return key + id
+}
+
+// trashKey returns the key of the trashed object of a job key
+func trashKey(key string) string {
+	return ".trash/" + strings.TrimPrefix(key, "/")
+}
+
+// getJSON decodes the object with the key into v
+func (s *S3Repository) getJSON(ctx context.Context, key string, v interface{}) error {
+	out, err := s.S3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return ErrCode("failed to get object from s3 "+key, err)
+	}
+	defer out.Body.Close()
+
+	if err := json.NewDecoder(out.Body).Decode(v); err != nil {
+		return apierror.New(apierror.ErrBadRequest, "failed to decode json from s3", err)
+	}
 
-	log.Debugf("updating %s with job %+v", key, job)
+	return nil
+}
 
-	j, err := json.MarshalIndent(job, "", "\t")
+// putJSON writes v as JSON to the object with the key, with the optional conditional request headers.  A failed
+// condition is a conflict error.
+func (s *S3Repository) putJSON(ctx context.Context, key string, v interface{}, condition map[string]string) error {
+	j, err := json.MarshalIndent(v, "", "\t")
 	if err != nil {
-		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", err)
+		return apierror.New(apierror.ErrBadRequest, "invalid input", err)
 	}
 
-	out, err := s.S3.PutObjectWithContext(ctx, &s3.PutObjectInput{
+	if _, err := s.S3.PutObjectWithContext(ctx, &s3.PutObjectInput{
 		Body:        bytes.NewReader(j),
 		Bucket:      aws.String(s.Bucket),
 		ContentType: aws.String("application/json"),
 		Key:         aws.String(key),
+	}, request.WithSetRequestHeaders(condition)); err != nil {
+		if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == "PreconditionFailed" || aerr.Code() == "ConditionalRequestConflict") {
+			return apierror.New(apierror.ErrConflict, "object was modified by another writer "+key, aerr)
+		}
+		return ErrCode("failed to put s3 object", err)
+	}
+
+	return nil
+}
+
+// version returns the version of the job stored in the object and the etag of the object.  If the object doesn't
+// exist, the etag is empty.
+func (s *S3Repository) version(ctx context.Context, key string) (int64, string, error) {
+	out, err := s.S3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
 	})
 	if err != nil {
-		return nil, ErrCode("failed to put s3 object", err)
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return 0, "", nil
+		}
+		return 0, "", ErrCode("failed to get job object from s3 "+key, err)
 	}
+	defer out.Body.Close()
 
-	log.Debugf("output from s3 job put: %+v", out)
+	job := &Job{}
+	if err := json.NewDecoder(out.Body).Decode(job); err != nil {
+		return 0, "", apierror.New(apierror.ErrBadRequest, "failed to decode json from s3", err)
+	}
 
-	return job, nil
+	return job.Version, aws.StringValue(out.ETag), nil
 }
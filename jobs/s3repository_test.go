@@ -3,14 +3,20 @@ package jobs
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"net/http"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/YaleSpinup/apierror"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/request"
@@ -172,7 +178,7 @@ func (m *mockS3Client) GetObjectWithContext(ctx aws.Context, input *s3.GetObject
 			if err != nil {
 				return nil, awserr.New("Internal Server Error", "failed marshalling json", err)
 			}
-			return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(out))}, nil
+			return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(out)), ETag: aws.String(`"` + k + `"`)}, nil
 		}
 	}
 
@@ -277,6 +283,7 @@ func TestCreate(t *testing.T) {
 				Name:               input.Name,
 				Group:              input.Group,
 				ScheduleExpression: input.ScheduleExpression,
+				Version:            1,
 			}
 		}
 
@@ -403,6 +410,7 @@ func TestUpdate(t *testing.T) {
 		},
 	}
 	for _, v := range testJobs {
+		v := v
 		tests = append(tests, updateTest{
 			job:   &v,
 			id:    v.ID,
@@ -433,6 +441,7 @@ func TestUpdate(t *testing.T) {
 				Name:               input.Name,
 				Group:              input.Group,
 				ScheduleExpression: input.ScheduleExpression,
+				Version:            1,
 			}
 		}
 
@@ -478,6 +487,370 @@ func TestUpdate(t *testing.T) {
 
 }
 
+func TestUpdateStaleVersion(t *testing.T) {
+	s := S3Repository{
+		S3: newMockS3Client(t, nil),
+	}
+
+	job := testJobs["a6d1b5a6-3a76-4d52-8856-b752afea563a"]
+	job.Version = 5
+
+	var aerr apierror.Error
+	if _, err := s.Update(context.TODO(), "test", job.Group, job.ID, &job); !errors.As(err, &aerr) || aerr.Code != apierror.ErrConflict {
+		t.Errorf("expected conflict for a stale version, got %v", err)
+	}
+
+	if err := s.DeleteVersion(context.TODO(), "test", job.Group, job.ID, 5); !errors.As(err, &aerr) || aerr.Code != apierror.ErrConflict {
+		t.Errorf("expected conflict deleting a stale version, got %v", err)
+	}
+
+	if err := s.DeleteVersion(context.TODO(), "test", job.Group, job.ID, 0); err != nil {
+		t.Errorf("expected nil error deleting the current version, got %s", err)
+	}
+
+	if err := s.DeleteVersion(context.TODO(), "test", job.Group, "some-other-job", 0); !errors.As(err, &aerr) || aerr.Code != apierror.ErrNotFound {
+		t.Errorf("expected not found deleting a missing job, got %v", err)
+	}
+}
+
+// mockS3VersionsClient is a fake S3 client for a bucket with versioning, the versions are listed most recent first
+type mockS3VersionsClient struct {
+	s3iface.S3API
+	key      string
+	versions []*Job
+}
+
+func (m *mockS3VersionsClient) ListObjectVersionsWithContext(ctx aws.Context, input *s3.ListObjectVersionsInput, opts ...request.Option) (*s3.ListObjectVersionsOutput, error) {
+	// return one version per page starting after the version id marker, followed by a version of another job
+	// starting with the same id once all of the versions are listed
+	start := 0
+	if input.VersionIdMarker != nil {
+		marker, _ := strconv.Atoi(aws.StringValue(input.VersionIdMarker))
+		start = marker + 1
+	}
+
+	out := &s3.ListObjectVersionsOutput{}
+	if start < len(m.versions) {
+		out.Versions = append(out.Versions, &s3.ObjectVersion{Key: aws.String(m.key), VersionId: aws.String(strconv.Itoa(start))})
+	}
+
+	if start+1 < len(m.versions) {
+		out.IsTruncated = aws.Bool(true)
+		out.NextKeyMarker = aws.String(m.key)
+		out.NextVersionIdMarker = aws.String(strconv.Itoa(start))
+	} else {
+		out.Versions = append(out.Versions, &s3.ObjectVersion{Key: aws.String(m.key + "-other"), VersionId: aws.String("other")})
+	}
+
+	return out, nil
+}
+
+func (m *mockS3VersionsClient) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	i, err := strconv.Atoi(aws.StringValue(input.VersionId))
+	if aws.StringValue(input.Key) != m.key || err != nil || i >= len(m.versions) {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "Not Found", nil)
+	}
+
+	j, err := json.Marshal(m.versions[i])
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(j))}, nil
+}
+
+func TestRevisions(t *testing.T) {
+	second := testJobs["a6d1b5a6-3a76-4d52-8856-b752afea563a"]
+	second.Version = 2
+	first := second
+	first.Description = "first demo"
+	first.Version = 1
+
+	s := S3Repository{
+		S3: &mockS3VersionsClient{
+			key:      "minion/metal/metallica/" + second.ID,
+			versions: []*Job{&second, &first},
+		},
+		Prefix: "minion",
+	}
+
+	if _, err := s.Revisions(context.TODO(), "metal", "metallica", ""); err == nil {
+		t.Error("expected error for empty input, got nil")
+	}
+
+	revisions, err := s.Revisions(context.TODO(), "metal", "metallica", second.ID)
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if len(revisions) != 2 {
+		t.Fatalf("expected 2 revisions, got %d", len(revisions))
+	}
+
+	if revisions[0].ID != "0" || revisions[0].Version != 2 || revisions[0].Job.Description != second.Description {
+		t.Errorf("expected the most recent revision first, got %+v", revisions[0])
+	}
+
+	if revisions[1].ID != "1" || revisions[1].Version != 1 || revisions[1].Job.Description != "first demo" {
+		t.Errorf("expected the first revision last, got %+v", revisions[1])
+	}
+}
+
+func TestRevision(t *testing.T) {
+	second := testJobs["a6d1b5a6-3a76-4d52-8856-b752afea563a"]
+	second.Version = 2
+	first := second
+	first.Description = "first demo"
+	first.Version = 1
+
+	s := S3Repository{
+		S3: &mockS3VersionsClient{
+			key:      "minion/metal/metallica/" + second.ID,
+			versions: []*Job{&second, &first},
+		},
+		Prefix: "minion",
+	}
+
+	if _, _, err := s.Revision(context.TODO(), "metal", "metallica", second.ID, ""); err == nil {
+		t.Error("expected error for empty input, got nil")
+	}
+
+	revision, previous, err := s.Revision(context.TODO(), "metal", "metallica", second.ID, "0")
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if revision.ID != "0" || revision.Version != 2 || previous == nil || previous.ID != "1" || previous.Version != 1 {
+		t.Errorf("expected revision 0 and the revision 1 before it, got %+v and %+v", revision, previous)
+	}
+
+	// the first revision is followed by the version of another job
+	revision, previous, err = s.Revision(context.TODO(), "metal", "metallica", second.ID, "1")
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if revision.ID != "1" || revision.Job.Description != "first demo" || previous != nil {
+		t.Errorf("expected the first revision without a revision before it, got %+v and %+v", revision, previous)
+	}
+
+	var aerr apierror.Error
+	if _, _, err := s.Revision(context.TODO(), "metal", "metallica", second.ID, "2"); !errors.As(err, &aerr) || aerr.Code != apierror.ErrNotFound {
+		t.Errorf("expected not found getting a missing revision, got %v", err)
+	}
+}
+
+// mockS3Bucket is a fake S3 client keeping the objects of a bucket in memory.  Like in a bucket with versioning,
+// the version ids of an object are kept when it's deleted, until the versions are deleted.
+type mockS3Bucket struct {
+	s3iface.S3API
+	objects  map[string][]byte
+	versions map[string][]string
+}
+
+func (m *mockS3Bucket) PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	// apply the options to a request to get the conditional headers
+	req := &request.Request{HTTPRequest: &http.Request{Header: http.Header{}}}
+	req.ApplyOptions(opts...)
+
+	key := aws.StringValue(input.Key)
+	if _, ok := m.objects[key]; ok && req.HTTPRequest.Header.Get("If-None-Match") == "*" {
+		return nil, awserr.New("PreconditionFailed", "At least one of the pre-conditions you specified did not hold", nil)
+	}
+
+	body, err := ioutil.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.objects[key] = body
+
+	if m.versions == nil {
+		m.versions = map[string][]string{}
+	}
+	m.versions[key] = append(m.versions[key], strconv.Itoa(len(m.versions[key])))
+
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (m *mockS3Bucket) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	body, ok := m.objects[aws.StringValue(input.Key)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, aws.StringValue(input.Key)+" not found", nil)
+	}
+
+	etag := fmt.Sprintf(`"%x"`, md5.Sum(body))
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(body)), ETag: aws.String(etag)}, nil
+}
+
+func (m *mockS3Bucket) DeleteObjectWithContext(ctx aws.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error) {
+	delete(m.objects, aws.StringValue(input.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (m *mockS3Bucket) DeleteObjectsWithContext(ctx aws.Context, input *s3.DeleteObjectsInput, opts ...request.Option) (*s3.DeleteObjectsOutput, error) {
+	if len(input.Delete.Objects) == 0 {
+		return nil, awserr.New("MalformedXML", "The XML you provided was not well-formed", nil)
+	}
+
+	for _, o := range input.Delete.Objects {
+		key := aws.StringValue(o.Key)
+		if o.VersionId == nil {
+			delete(m.objects, key)
+			continue
+		}
+
+		versions := []string{}
+		for _, v := range m.versions[key] {
+			if v != aws.StringValue(o.VersionId) {
+				versions = append(versions, v)
+			}
+		}
+
+		m.versions[key] = versions
+		if len(versions) == 0 {
+			delete(m.versions, key)
+			delete(m.objects, key)
+		}
+	}
+	return &s3.DeleteObjectsOutput{}, nil
+}
+
+func (m *mockS3Bucket) ListObjectVersionsWithContext(ctx aws.Context, input *s3.ListObjectVersionsInput, opts ...request.Option) (*s3.ListObjectVersionsOutput, error) {
+	keys := []string{}
+	for k := range m.versions {
+		if strings.HasPrefix(k, aws.StringValue(input.Prefix)) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	out := &s3.ListObjectVersionsOutput{}
+	for _, k := range keys {
+		_, exists := m.objects[k]
+		versions := m.versions[k]
+		for i := len(versions) - 1; i >= 0; i-- {
+			out.Versions = append(out.Versions, &s3.ObjectVersion{
+				IsLatest:  aws.Bool(exists && i == len(versions)-1),
+				Key:       aws.String(k),
+				VersionId: aws.String(versions[i]),
+			})
+		}
+	}
+	return out, nil
+}
+
+func (m *mockS3Bucket) ListObjectsV2WithContext(ctx aws.Context, input *s3.ListObjectsV2Input, opts ...request.Option) (*s3.ListObjectsV2Output, error) {
+	keys := []string{}
+	for k := range m.objects {
+		if strings.HasPrefix(k, aws.StringValue(input.Prefix)) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	out := &s3.ListObjectsV2Output{}
+	for _, k := range keys {
+		out.Contents = append(out.Contents, &s3.Object{Key: aws.String(k)})
+	}
+	return out, nil
+}
+
+func TestTrash(t *testing.T) {
+	bucket := &mockS3Bucket{objects: map[string][]byte{}}
+	s := &S3Repository{S3: bucket, Prefix: "minion"}
+
+	testTrash(t, s)
+
+	for k := range bucket.objects {
+		if strings.HasPrefix(k, ".trash/") {
+			t.Errorf("expected the trash to be empty, got %s", k)
+		}
+	}
+
+	// an empty trash is purged without deleting any objects
+	if err := s.PurgeTrash(context.TODO(), "metal", "metallica", ""); err != nil {
+		t.Errorf("expected nil error purging an empty trash, got %s", err)
+	}
+
+	// an account without a prefix isn't trashed under a double slash
+	s.Prefix = ""
+	job, err := s.Create(context.TODO(), "metal", "slayer", &Job{Details: map[string]string{"runner": "dummy"}, ScheduleExpression: "00 00 25 07 *"})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if err := s.Trash(context.TODO(), "metal", "slayer", job.ID, 0); err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if _, ok := bucket.objects[".trash/metal/slayer/"+job.ID]; !ok {
+		t.Errorf("expected trashed job at .trash/metal/slayer/%s, got %v", job.ID, bucket.objects)
+	}
+}
+
+func TestPurgeTrashVersions(t *testing.T) {
+	bucket := &mockS3Bucket{objects: map[string][]byte{}}
+	s := &S3Repository{S3: bucket, Prefix: "minion"}
+	ctx := context.TODO()
+
+	newJob := func(group string) *Job {
+		job, err := s.Create(ctx, "metal", group, &Job{Details: map[string]string{"runner": "dummy"}, ScheduleExpression: "00 00 25 07 *"})
+		if err != nil {
+			t.Fatalf("expected nil error, got %s", err)
+		}
+
+		job.Description = "updated"
+		if job, err = s.Update(ctx, "metal", group, job.ID, job); err != nil {
+			t.Fatalf("expected nil error, got %s", err)
+		}
+		return job
+	}
+
+	versions := func(group, id string) int {
+		return len(bucket.versions["minion/metal/"+group+"/"+id])
+	}
+
+	job1, job2, job3 := newJob("metallica"), newJob("metallica"), newJob("megadeth")
+	for group, job := range map[string]*Job{"metallica": job1, "megadeth": job3} {
+		if err := s.Trash(ctx, "metal", group, job.ID, 0); err != nil {
+			t.Fatalf("expected nil error, got %s", err)
+		}
+	}
+
+	if err := s.Trash(ctx, "metal", "metallica", job2.ID, 0); err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	// a job recreated since it was trashed keeps its history
+	recreated := *job2
+	recreated.Version = 0
+	if _, err := s.Update(ctx, "metal", "metallica", job2.ID, &recreated); err != nil {
+		t.Fatalf("expected nil error recreating job, got %s", err)
+	}
+
+	if err := s.PurgeTrash(ctx, "metal", "metallica", ""); err != nil {
+		t.Fatalf("expected nil error purging group, got %s", err)
+	}
+
+	if n := versions("metallica", job1.ID); n != 0 {
+		t.Errorf("expected the versions of the purged job to be deleted, got %d", n)
+	}
+
+	if n := versions("metallica", job2.ID); n != 3 {
+		t.Errorf("expected the 3 versions of the recreated job to be kept, got %d", n)
+	}
+
+	if n := versions("megadeth", job3.ID); n != 2 {
+		t.Errorf("expected the 2 versions of the job trashed in another group to be kept, got %d", n)
+	}
+
+	for k := range bucket.versions {
+		if strings.HasPrefix(k, ".trash/minion/metal/metallica/") {
+			t.Errorf("expected the trashed objects of the group to be deleted, got %s", k)
+		}
+	}
+}
+
 func TestGet(t *testing.T) {
 	s := S3Repository{
 		S3: newMockS3Client(t, nil),
@@ -0,0 +1,322 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/YaleSpinup/apierror"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// S3RunsRepository is an implementation of a runs repository in S3.  Runs with a time ordered id (see NewRunID)
+// are stored in the path /<account>/<group>/<job id>/sorted/<inverted run id>, where each hex digit of the id is
+// inverted so the objects are listed most recent run first.  Runs with other ids, ie. recorded before run ids were
+// time ordered, are stored in the path /<account>/<group>/<job id>/<run id>.
+type S3RunsRepository struct {
+	S3     s3iface.S3API
+	Bucket string
+	Prefix string
+}
+
+// sortedRuns is the path of the runs with time ordered ids under the path of a job
+const sortedRuns = "sorted/"
+
+// NewDefaultRunsRepository creates a new runs repository from the default config data.  The
+// configuration is the same as the s3 jobs repository.
+func NewDefaultRunsRepository(config map[string]interface{}) (*S3RunsRepository, error) {
+	log.Debug("creating new default runs repository")
+
+	s, err := NewDefaultRepository(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3RunsRepository{
+		S3:     s.S3,
+		Bucket: s.Bucket,
+		Prefix: s.Prefix,
+	}, nil
+}
+
+// Get gets a run from the s3 runs repository
+func (s *S3RunsRepository) Get(ctx context.Context, account, group, jobID, id string) (*Run, error) {
+	if account == "" || group == "" || jobID == "" || id == "" {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	key := s.key(account, group, jobID, id)
+
+	log.Debugf("getting run object (account: %s, group: %s, job id: %s, id: %s, key: '%s')", account, group, jobID, id, key)
+
+	return s.getObject(ctx, key)
+}
+
+// List lists the runs of a job in the s3 runs repository, most recent first.  The runs with time ordered ids are
+// listed in the order they were created and only fetched up to the limit.  The older runs follow them, those are
+// all fetched and sorted by the time they started.
+func (s *S3RunsRepository) List(ctx context.Context, account, group, jobID string, limit int) ([]*Run, error) {
+	if account == "" || group == "" || jobID == "" {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Infof("listing runs for job %s/%s/%s", account, group, jobID)
+
+	prefix := s.key(account, group, jobID, "")
+
+	runs := []*Run{}
+	if err := s.listObjects(ctx, prefix+sortedRuns, "", limit, func(object *s3.Object) bool {
+		if run, err := s.getObject(ctx, aws.StringValue(object.Key)); err != nil {
+			log.Errorf("error getting run '%s': %s", aws.StringValue(object.Key), err)
+		} else {
+			runs = append(runs, run)
+		}
+		return limit == 0 || len(runs) < limit
+	}); err != nil {
+		return nil, err
+	}
+	sortRuns(runs)
+
+	if limit > 0 && len(runs) >= limit {
+		return runs, nil
+	}
+
+	older := []*Run{}
+	if err := s.listObjects(ctx, prefix, "/", 0, func(object *s3.Object) bool {
+		if run, err := s.getObject(ctx, aws.StringValue(object.Key)); err != nil {
+			log.Errorf("error getting run '%s': %s", aws.StringValue(object.Key), err)
+		} else {
+			older = append(older, run)
+		}
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	sortRuns(older)
+
+	runs = append(runs, older...)
+	if limit > 0 && len(runs) > limit {
+		runs = runs[:limit]
+	}
+
+	return runs, nil
+}
+
+// Put creates or updates a run in the s3 runs repository
+func (s *S3RunsRepository) Put(ctx context.Context, run *Run) error {
+	if run == nil || run.Account == "" || run.Group == "" || run.JobID == "" || run.ID == "" {
+		return apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	key := s.key(run.Account, run.Group, run.JobID, run.ID)
+
+	log.Debugf("putting run %s with %+v", key, run)
+
+	j, err := json.MarshalIndent(run, "", "\t")
+	if err != nil {
+		return apierror.New(apierror.ErrBadRequest, "invalid input", err)
+	}
+
+	if _, err := s.S3.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Body:        bytes.NewReader(j),
+		Bucket:      aws.String(s.Bucket),
+		ContentType: aws.String("application/json"),
+		Key:         aws.String(key),
+	}); err != nil {
+		return ErrCode("failed to put s3 run object", err)
+	}
+
+	return nil
+}
+
+// Prune removes the runs of a job beyond the newest keep runs and the runs last written before the given time from
+// the s3 runs repository.  Only the objects are listed, the runs aren't fetched.
+func (s *S3RunsRepository) Prune(ctx context.Context, account, group, jobID string, keep int, before time.Time) error {
+	if account == "" || group == "" || jobID == "" {
+		return apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	prefix := s.key(account, group, jobID, "")
+
+	objects := []*s3.Object{}
+	if err := s.listObjects(ctx, prefix+sortedRuns, "", 0, func(object *s3.Object) bool {
+		objects = append(objects, object)
+		return true
+	}); err != nil {
+		return err
+	}
+
+	older := []*s3.Object{}
+	if err := s.listObjects(ctx, prefix, "/", 0, func(object *s3.Object) bool {
+		older = append(older, object)
+		return true
+	}); err != nil {
+		return err
+	}
+
+	sort.SliceStable(older, func(i, j int) bool {
+		return aws.TimeValue(older[i].LastModified).After(aws.TimeValue(older[j].LastModified))
+	})
+	objects = append(objects, older...)
+
+	keys := []string{}
+	for i, object := range objects {
+		if (keep > 0 && i >= keep) || (!before.IsZero() && aws.TimeValue(object.LastModified).Before(before)) {
+			keys = append(keys, aws.StringValue(object.Key))
+		}
+	}
+
+	if len(keys) > 0 {
+		log.Infof("pruning %d runs of job %s/%s/%s", len(keys), account, group, jobID)
+	}
+
+	return s.deleteObjects(ctx, keys)
+}
+
+// Delete removes all of the runs of a job from the s3 runs repository
+func (s *S3RunsRepository) Delete(ctx context.Context, account, group, jobID string) error {
+	if account == "" || group == "" || jobID == "" {
+		return apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Infof("deleting runs of job %s/%s/%s", account, group, jobID)
+
+	keys := []string{}
+	if err := s.listObjects(ctx, s.key(account, group, jobID, ""), "", 0, func(object *s3.Object) bool {
+		keys = append(keys, aws.StringValue(object.Key))
+		return true
+	}); err != nil {
+		return err
+	}
+
+	return s.deleteObjects(ctx, keys)
+}
+
+func (s *S3RunsRepository) getObject(ctx context.Context, key string) (*Run, error) {
+	out, err := s.S3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, ErrCode("failed to get run object from s3 "+key, err)
+	}
+	defer out.Body.Close()
+
+	run := &Run{}
+	if err := json.NewDecoder(out.Body).Decode(run); err != nil {
+		return nil, apierror.New(apierror.ErrBadRequest, "failed to decode json from s3", err)
+	}
+
+	return run, nil
+}
+
+// listObjects calls fn with the objects under the prefix in key order until it returns false.  with a delimiter,
+// only the objects directly under the prefix are listed.  a page size of 0 lists the default 1000 objects per page.
+func (s *S3RunsRepository) listObjects(ctx context.Context, prefix, delimiter string, pageSize int, fn func(*s3.Object) bool) error {
+	input := s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(prefix),
+	}
+
+	if delimiter != "" {
+		input.Delimiter = aws.String(delimiter)
+	}
+
+	if pageSize > 0 && pageSize < 1000 {
+		input.MaxKeys = aws.Int64(int64(pageSize))
+	}
+
+	for {
+		output, err := s.S3.ListObjectsV2WithContext(ctx, &input)
+		if err != nil {
+			return ErrCode("failed to list run objects from s3", err)
+		}
+
+		for _, object := range output.Contents {
+			if !fn(object) {
+				return nil
+			}
+		}
+
+		if !aws.BoolValue(output.IsTruncated) {
+			return nil
+		}
+		input.ContinuationToken = output.NextContinuationToken
+	}
+}
+
+// deleteObjects deletes the objects with the keys, up to 1000 per request
+func (s *S3RunsRepository) deleteObjects(ctx context.Context, keys []string) error {
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > 1000 {
+			n = 1000
+		}
+
+		objs := make([]*s3.ObjectIdentifier, n)
+		for i, key := range keys[:n] {
+			objs[i] = &s3.ObjectIdentifier{Key: aws.String(key)}
+		}
+
+		out, err := s.S3.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(s.Bucket),
+			Delete: &s3.Delete{
+				Objects: objs,
+				Quiet:   aws.Bool(true),
+			},
+		})
+		if err != nil {
+			return ErrCode("failed to delete run objects from s3", err)
+		}
+
+		if len(out.Errors) > 0 {
+			msg := fmt.Sprintf("failed to delete %d run objects from s3, %s: %s", len(out.Errors), aws.StringValue(out.Errors[0].Key), aws.StringValue(out.Errors[0].Message))
+			return apierror.New(apierror.ErrInternalError, msg, nil)
+		}
+
+		keys = keys[n:]
+	}
+
+	return nil
+}
+
+// key returns the object key for a run, if the id is empty the prefix for all of the runs of the job is returned
+func (s *S3RunsRepository) key(account, group, jobID, id string) string {
+	parts := []string{}
+	for _, p := range []string{s.Prefix, account, group, jobID} {
+		if p = strings.Trim(p, "/"); p != "" {
+			parts = append(parts, p)
+		}
+	}
+
+	prefix := strings.Join(parts, "/") + "/"
+	if u, err := uuid.Parse(id); err == nil && u.Version() == 7 {
+		return prefix + sortedRuns + invertHex(id)
+	}
+
+	return prefix + id
+}
+
+// invertHex inverts each hex digit of a string (0 becomes f, 1 becomes e, ...), which reverses the order the
+// strings sort in
+func invertHex(s string) string {
+	const digits = "0123456789abcdef"
+
+	out := []byte(strings.ToLower(s))
+	for i, c := range out {
+		if d := strings.IndexByte(digits, c); d >= 0 {
+			out[i] = digits[15-d]
+		}
+	}
+
+	return string(out)
+}
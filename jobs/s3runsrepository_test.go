@@ -0,0 +1,384 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// mockRunsS3Client is a fake S3 client backed by a map of objects
+type mockRunsS3Client struct {
+	s3iface.S3API
+	t        *testing.T
+	err      error
+	gets     int
+	modified map[string]time.Time
+	now      time.Time
+	objects  map[string][]byte
+}
+
+func newMockRunsS3Client(t *testing.T) *mockRunsS3Client {
+	return &mockRunsS3Client{
+		t:        t,
+		modified: map[string]time.Time{},
+		now:      testTime,
+		objects:  map[string][]byte{},
+	}
+}
+
+func (m *mockRunsS3Client) PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	b, err := ioutil.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	m.objects[aws.StringValue(input.Key)] = b
+	m.modified[aws.StringValue(input.Key)] = m.now
+	m.now = m.now.Add(time.Minute)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (m *mockRunsS3Client) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	m.gets++
+
+	b, ok := m.objects[aws.StringValue(input.Key)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "not found", nil)
+	}
+
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(b))}, nil
+}
+
+func (m *mockRunsS3Client) ListObjectsV2WithContext(ctx aws.Context, input *s3.ListObjectsV2Input, opts ...request.Option) (*s3.ListObjectsV2Output, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	prefix := aws.StringValue(input.Prefix)
+
+	keys := []string{}
+	for k := range m.objects {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+
+		if d := aws.StringValue(input.Delimiter); d != "" && strings.Contains(strings.TrimPrefix(k, prefix), d) {
+			continue
+		}
+
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	start := 0
+	if token := aws.StringValue(input.ContinuationToken); token != "" {
+		start, _ = strconv.Atoi(token)
+	}
+	keys = keys[start:]
+
+	out := &s3.ListObjectsV2Output{Contents: []*s3.Object{}}
+	if max := int(aws.Int64Value(input.MaxKeys)); max > 0 && len(keys) > max {
+		keys = keys[:max]
+		out.IsTruncated = aws.Bool(true)
+		out.NextContinuationToken = aws.String(strconv.Itoa(start + max))
+	}
+
+	for _, k := range keys {
+		out.Contents = append(out.Contents, &s3.Object{Key: aws.String(k), LastModified: aws.Time(m.modified[k])})
+	}
+
+	return out, nil
+}
+
+func (m *mockRunsS3Client) DeleteObjectsWithContext(ctx aws.Context, input *s3.DeleteObjectsInput, opts ...request.Option) (*s3.DeleteObjectsOutput, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	for _, o := range input.Delete.Objects {
+		delete(m.objects, aws.StringValue(o.Key))
+	}
+
+	return &s3.DeleteObjectsOutput{}, nil
+}
+
+// testRunID returns a time ordered run id created n minutes after the test time
+func testRunID(n int) string {
+	ms := testTime.Add(time.Duration(n) * time.Minute).UnixMilli()
+	return fmt.Sprintf("%08x-%04x-7000-8000-%012x", ms>>16, ms&0xffff, n)
+}
+
+func TestS3RunsRepositoryPutGet(t *testing.T) {
+	client := newMockRunsS3Client(t)
+	s := S3RunsRepository{S3: client, Bucket: "bucket", Prefix: "/prefix/org"}
+
+	started := testTime
+	run := &Run{
+		ID:        "run1",
+		JobID:     "job1",
+		Account:   "metal",
+		Group:     "metallica",
+		StartedAt: &started,
+		Attempt:   1,
+		NodeID:    "node1",
+		Runner:    "dummy",
+		Status:    RunStatusSucceeded,
+		Output:    "OK",
+	}
+
+	if err := s.Put(context.TODO(), run); err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if _, ok := client.objects["prefix/org/metal/metallica/job1/run1"]; !ok {
+		t.Errorf("expected run object to be stored at prefix/org/metal/metallica/job1/run1, got %+v", client.objects)
+	}
+
+	out, err := s.Get(context.TODO(), "metal", "metallica", "job1", "run1")
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if !reflect.DeepEqual(run, out) {
+		t.Errorf("expected %+v, got %+v", run, out)
+	}
+
+	if _, err := s.Get(context.TODO(), "metal", "metallica", "job1", "missing"); err == nil {
+		t.Error("expected error for missing run, got nil")
+	}
+
+	if _, err := s.Get(context.TODO(), "metal", "metallica", "job1", ""); err == nil {
+		t.Error("expected error for empty id, got nil")
+	}
+
+	if err := s.Put(context.TODO(), &Run{ID: "run2"}); err == nil {
+		t.Error("expected error for empty input, got nil")
+	}
+
+	client.err = errors.New("boom")
+	if err := s.Put(context.TODO(), run); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+func TestS3RunsRepositoryList(t *testing.T) {
+	client := newMockRunsS3Client(t)
+	s := S3RunsRepository{S3: client, Bucket: "bucket", Prefix: "prefix"}
+
+	for i, id := range []string{"a", "b", "c"} {
+		started := testTime.Add(time.Duration(i) * time.Minute)
+		if err := s.Put(context.TODO(), &Run{ID: id, JobID: "job1", Account: "metal", Group: "metallica", StartedAt: &started}); err != nil {
+			t.Fatalf("expected nil error, got %s", err)
+		}
+	}
+
+	// a run of another job with a similar id shouldn't be listed
+	if err := s.Put(context.TODO(), &Run{ID: "d", JobID: "job10", Account: "metal", Group: "metallica"}); err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	out, err := s.List(context.TODO(), "metal", "metallica", "job1", 0)
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	ids := []string{}
+	for _, r := range out {
+		ids = append(ids, r.ID)
+	}
+
+	if expected := []string{"c", "b", "a"}; !reflect.DeepEqual(expected, ids) {
+		t.Errorf("expected %v, got %v", expected, ids)
+	}
+
+	if _, err := s.List(context.TODO(), "metal", "", "job1", 0); err == nil {
+		t.Error("expected error for empty group, got nil")
+	}
+
+	client.err = errors.New("boom")
+	if _, err := s.List(context.TODO(), "metal", "metallica", "job1", 0); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+func TestS3RunsRepositoryListSorted(t *testing.T) {
+	client := newMockRunsS3Client(t)
+	s := S3RunsRepository{S3: client, Bucket: "bucket", Prefix: "prefix"}
+
+	// runs recorded before the run ids were time ordered
+	for i, id := range []string{"a", "b"} {
+		started := testTime.Add(time.Duration(i) * time.Minute)
+		if err := s.Put(context.TODO(), &Run{ID: id, JobID: "job1", Account: "metal", Group: "metallica", StartedAt: &started}); err != nil {
+			t.Fatalf("expected nil error, got %s", err)
+		}
+	}
+
+	sorted := []string{}
+	for i := 10; i < 15; i++ {
+		id := testRunID(i)
+		started := testTime.Add(time.Duration(i) * time.Minute)
+		if err := s.Put(context.TODO(), &Run{ID: id, JobID: "job1", Account: "metal", Group: "metallica", StartedAt: &started}); err != nil {
+			t.Fatalf("expected nil error, got %s", err)
+		}
+		sorted = append([]string{id}, sorted...)
+	}
+
+	key := "prefix/metal/metallica/job1/sorted/" + invertHex(testRunID(10))
+	if _, ok := client.objects[key]; !ok {
+		t.Errorf("expected run object to be stored at %s, got %+v", key, client.objects)
+	}
+
+	if out, err := s.Get(context.TODO(), "metal", "metallica", "job1", testRunID(10)); err != nil {
+		t.Errorf("expected nil error, got %s", err)
+	} else if out.ID != testRunID(10) {
+		t.Errorf("expected run %s, got %s", testRunID(10), out.ID)
+	}
+
+	type test struct {
+		limit  int
+		expect []string
+		gets   int
+	}
+
+	tests := []test{
+		{limit: 0, expect: append(append([]string{}, sorted...), "b", "a"), gets: 7},
+		{limit: 2, expect: sorted[:2], gets: 2},
+		{limit: 5, expect: sorted, gets: 5},
+		{limit: 6, expect: append(append([]string{}, sorted...), "b"), gets: 7},
+	}
+
+	for _, tst := range tests {
+		client.gets = 0
+
+		out, err := s.List(context.TODO(), "metal", "metallica", "job1", tst.limit)
+		if err != nil {
+			t.Fatalf("expected nil error, got %s", err)
+		}
+
+		ids := []string{}
+		for _, r := range out {
+			ids = append(ids, r.ID)
+		}
+
+		if !reflect.DeepEqual(tst.expect, ids) {
+			t.Errorf("expected %v with limit %d, got %v", tst.expect, tst.limit, ids)
+		}
+
+		if client.gets != tst.gets {
+			t.Errorf("expected %d runs to be fetched with limit %d, got %d", tst.gets, tst.limit, client.gets)
+		}
+	}
+}
+
+func TestS3RunsRepositoryPruneDelete(t *testing.T) {
+	client := newMockRunsS3Client(t)
+	s := S3RunsRepository{S3: client, Bucket: "bucket", Prefix: "prefix"}
+
+	// each put is a minute after the last one, a and b are the oldest
+	ids := []string{"a", "b"}
+	for i := 0; i < 4; i++ {
+		ids = append(ids, testRunID(i))
+	}
+
+	for _, id := range ids {
+		if err := s.Put(context.TODO(), &Run{ID: id, JobID: "job1", Account: "metal", Group: "metallica"}); err != nil {
+			t.Fatalf("expected nil error, got %s", err)
+		}
+	}
+
+	if err := s.Put(context.TODO(), &Run{ID: testRunID(0), JobID: "job2", Account: "metal", Group: "metallica"}); err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	listed := func() []string {
+		out, err := s.List(context.TODO(), "metal", "metallica", "job1", 0)
+		if err != nil {
+			t.Fatalf("expected nil error, got %s", err)
+		}
+
+		ids := []string{}
+		for _, r := range out {
+			ids = append(ids, r.ID)
+		}
+		return ids
+	}
+
+	// nothing is pruned without a limit
+	if err := s.Prune(context.TODO(), "metal", "metallica", "job1", 0, time.Time{}); err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if out := listed(); len(out) != 6 {
+		t.Errorf("expected 6 runs, got %v", out)
+	}
+
+	// the runs last written before the third put are pruned
+	if err := s.Prune(context.TODO(), "metal", "metallica", "job1", 0, testTime.Add(2*time.Minute)); err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if out, expected := listed(), []string{testRunID(3), testRunID(2), testRunID(1), testRunID(0)}; !reflect.DeepEqual(expected, out) {
+		t.Errorf("expected %v, got %v", expected, out)
+	}
+
+	// only the newest runs are kept
+	if err := s.Prune(context.TODO(), "metal", "metallica", "job1", 2, time.Time{}); err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if out, expected := listed(), []string{testRunID(3), testRunID(2)}; !reflect.DeepEqual(expected, out) {
+		t.Errorf("expected %v, got %v", expected, out)
+	}
+
+	if err := s.Delete(context.TODO(), "metal", "metallica", "job1"); err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if out := listed(); len(out) != 0 {
+		t.Errorf("expected no runs, got %v", out)
+	}
+
+	// the runs of other jobs are kept
+	if _, err := s.Get(context.TODO(), "metal", "metallica", "job2", testRunID(0)); err != nil {
+		t.Errorf("expected nil error, got %s", err)
+	}
+
+	if err := s.Prune(context.TODO(), "metal", "", "job1", 1, time.Time{}); err == nil {
+		t.Error("expected error for empty group, got nil")
+	}
+
+	if err := s.Delete(context.TODO(), "metal", "metallica", ""); err == nil {
+		t.Error("expected error for empty job id, got nil")
+	}
+
+	client.err = errors.New("boom")
+	if err := s.Prune(context.TODO(), "metal", "metallica", "job1", 1, time.Time{}); err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	if err := s.Delete(context.TODO(), "metal", "metallica", "job1"); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
@@ -6,11 +6,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"strings"
 	"text/template"
-	"time"
 
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
@@ -22,6 +23,10 @@ type ServiceRunner struct {
 	Token            string
 	Encrypt          bool
 	AuthHeader       string
+	CountField       string
+	State            StateStore
+	Client           *HTTPClient
+	Signer           *Signer
 }
 
 type ServiceRunnerScaleInput struct {
@@ -29,12 +34,30 @@ type ServiceRunnerScaleInput struct {
 	Cluster      string
 	Name         string
 	desiredCount int
+	relative     bool
+	minCount     int
+	maxCount     int
 	endpoint     string
 }
 
+func init() {
+	RegisterRunner(RunnerType{
+		RunnerDescription: RunnerDescription{
+			Type:            "service",
+			Description:     "scales, restores or redeploys a container service",
+			Actions:         []string{"scale", "restore", "redeploy"},
+			RequiredDetails: []string{"service_action", "service_cluster", "service_name"},
+		},
+		New: func(config map[string]interface{}) (Runner, error) {
+			return NewServiceRunner(config)
+		},
+	})
+}
+
 // NewServiceRunner creates and configures a new service runner.  An endpoint or endpoint template is required
 // the endpoint and endpoint template are not currently validated but this can/should be done in the future.  If a
-// a token is passed, it will be configured but is not required.
+// a token is passed, it will be configured but is not required.  The countField is the field of the service
+// returned by the endpoint with the current desired count, it's used for relative scaling and saving the count.
 func NewServiceRunner(config map[string]interface{}) (*ServiceRunner, error) {
 	log.Debug("creating new service job runner")
 
@@ -69,126 +92,468 @@ func NewServiceRunner(config map[string]interface{}) (*ServiceRunner, error) {
 		authHeader = h
 	}
 
+	countField := "DesiredCount"
+	if f, ok := config["countField"].(string); ok && f != "" {
+		countField = f
+	}
+
+	signer, err := newSigner(config, token)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newHTTPClient(config["http"])
+	if err != nil {
+		return nil, err
+	}
+
 	return &ServiceRunner{
 		Endpoint:         endpoint,
 		EndpointTemplate: endpointTemplate,
 		Token:            token,
 		Encrypt:          encrypt,
 		AuthHeader:       authHeader,
+		CountField:       countField,
+		Client:           client,
+		Signer:           signer,
 	}, nil
 }
 
+// SetStateStore sets the store for the desired counts saved when scaling down and used when restoring
+func (r *ServiceRunner) SetStateStore(store StateStore) {
+	r.State = store
+}
+
+// Validate validates the ServiceRunner parameters.  The service_action must be 'scale', 'restore' or 'redeploy'
+// and the service_cluster and service_name are required.  Scaling requires the desired_count, an integer of at
+// least 0 or a relative count like '+2' or '-1'.  The optional min_count and max_count bound the new count and
+// save_count saves the current count to be restored later.  Restoring optionally takes a desired_count to use
+// when no count was saved.
+func (r *ServiceRunner) Validate(parameters interface{}) error {
+	v := newValidator(parameters)
+	v.oneOf("service_action", "scale", "restore", "redeploy")
+	v.required("service_cluster")
+	v.required("service_name")
+
+	switch v.params["service_action"] {
+	case "scale":
+		if c := v.params["desired_count"]; strings.HasPrefix(c, "+") || strings.HasPrefix(c, "-") {
+			if _, err := strconv.Atoi(c); err != nil {
+				v.problem("desired_count must be an integer: '%s'", c)
+			}
+		} else {
+			v.integer("desired_count", 0)
+		}
+
+		min, minOk := 0, false
+		if _, ok := v.params["min_count"]; ok {
+			min, minOk = v.integer("min_count", 0)
+		}
+
+		if _, ok := v.params["max_count"]; ok {
+			if max, ok := v.integer("max_count", 1); ok && minOk && min > max {
+				v.problem("min_count must not be greater than max_count: %d > %d", min, max)
+			}
+		}
+
+		if save, ok := v.boolean("save_count"); ok && save && r.State == nil {
+			v.problem("save_count is not supported, the runner has no state store")
+		}
+	case "restore":
+		if _, ok := v.params["desired_count"]; ok {
+			v.integer("desired_count", 0)
+		}
+
+		if r.State == nil {
+			v.problem("restore is not supported, the runner has no state store")
+		}
+	}
+
+	return v.err()
+}
+
 // Run executes the ServiceRunner.  The service_cluster, service_name and service_action are required.  Allowable
-// actions are 'scale'.  If an endpoint is configured on the runner, it will be used, otherwise
-// we assume there is an endpointTemplate and try to execute it.
+// actions are 'scale', 'restore' and 'redeploy'.  If an endpoint is configured on the runner, it will be used,
+// otherwise we assume there is an endpointTemplate and try to execute it.
 func (r *ServiceRunner) Run(ctx context.Context, account string, parameters interface{}) (string, error) {
+	return runResult(r.RunResult(ctx, account, parameters))
+}
+
+// RunResult executes the ServiceRunner like Run and returns a structured result with the service, the status code
+// and an excerpt of the response.  The desired counts are reported in the result data.
+func (r *ServiceRunner) RunResult(ctx context.Context, account string, parameters interface{}) (*Result, error) {
 	if account == "" {
-		return "", errors.New("account is required")
+		return nil, errors.New("account is required")
 	}
 
 	log.Debugf("initializing service runner %+v in account %s,  with parameters %+v", r, account, parameters)
 
 	params, ok := parameters.(map[string]string)
 	if !ok {
-		return "", NewRunnerError(ErrMissingDetails, "wrong type parameters list is not a map[string]string", nil)
+		return nil, NewRunnerError(ErrMissingDetails, "wrong type parameters list is not a map[string]string", nil)
 	}
 
 	action, ok := params["service_action"]
 	if !ok {
-		return "", NewRunnerError(ErrMissingDetails, "missing service_action", nil)
+		return nil, NewRunnerError(ErrMissingDetails, "missing service_action", nil)
 	}
 
+	s := &ServiceRunnerScaleInput{
+		Account:  account,
+		endpoint: r.Endpoint,
+	}
+
+	result := &Result{}
+	result.SetData("action", action)
+
 	switch action {
 	case "scale":
-		s := &ServiceRunnerScaleInput{
-			Account:  account,
-			endpoint: r.Endpoint,
+		if err := s.prep(params); err != nil {
+			return nil, NewRunnerError(ErrPreExecFailure, "failed to define input from parameters", err)
 		}
+		result.Targets = []string{s.Cluster + "/" + s.Name}
 
-		if err := s.prep(params); err != nil {
-			return "", NewRunnerError(ErrPreExecFailure, "failed to define input from parameters", err)
+		if err := r.setEndpoint(s); err != nil {
+			return result, err
 		}
 
-		if s.endpoint == "" {
-			e, err := execEndpointTemplate(r.EndpointTemplate, s)
+		// the current count is only needed for relative scaling and to save it for restoring
+		save := params["save_count"] == "true"
+		current := -1
+		if s.relative || save {
+			c, err := r.currentCount(ctx, s)
 			if err != nil {
-				return "", NewRunnerError(ErrPreExecFailure, "failed set endpoint", err)
+				return result, err
 			}
-			s.endpoint = e
+			current = c
+			result.SetData("previous_count", strconv.Itoa(current))
 		}
 
-		inputPayload := struct {
-			Service map[string]int
-		}{
-			map[string]int{
-				"DesiredCount": s.desiredCount,
-			},
+		saved := -1
+		if save {
+			c, err := r.saveCount(s, current)
+			if err != nil {
+				return result, err
+			}
+			saved = c
+
+			if saved >= 0 {
+				result.SetData("saved_count", strconv.Itoa(saved))
+			}
 		}
 
-		j, err := json.Marshal(inputPayload)
-		if err != nil {
-			return "", err
+		count := s.desiredCount
+		if s.relative {
+			count = current + s.desiredCount
 		}
+		count = s.bound(count)
+		result.SetData("desired_count", strconv.Itoa(count))
 
-		log.Debugf("service runner scaling %s/%s with input %s", s.Cluster, s.Name, string(j))
+		if err := r.scale(ctx, s, count, result); err != nil {
+			return result, err
+		}
 
-		client := &http.Client{
-			Timeout: time.Second * 30,
+		msg := fmt.Sprintf("successfully set desired count for %s/%s to %d", s.Cluster, s.Name, count)
+		if current >= 0 {
+			msg = fmt.Sprintf("%s (was %d)", msg, current)
 		}
-		req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.endpoint, bytes.NewReader(j))
-		if err != nil {
-			return "", NewRunnerError(ErrPreExecFailure, "building http request failed", err)
+		if saved >= 0 {
+			msg = fmt.Sprintf("%s, saved desired count %d for restore", msg, saved)
 		}
 
-		if r.Token != "" {
-			log.Debugf("setting token header %s for %s/%s", r.AuthHeader, s.Cluster, s.Name)
-			if r.Encrypt {
-				e, err := bcrypt.GenerateFromPassword([]byte(r.Token), 6)
-				if err != nil {
-					return "", NewRunnerError(ErrExecFailure, "unable to hash token", err)
-				}
+		result.Message = msg
+		return result, nil
+	case "restore":
+		if err := s.prepService(params); err != nil {
+			return nil, NewRunnerError(ErrPreExecFailure, "failed to define input from parameters", err)
+		}
+		result.Targets = []string{s.Cluster + "/" + s.Name}
 
-				log.Debug("token is encrypted")
+		if err := r.setEndpoint(s); err != nil {
+			return result, err
+		}
 
-				req.Header.Set(r.AuthHeader, string(e))
-			} else {
-				req.Header.Set(r.AuthHeader, r.Token)
-			}
+		count, fromState, err := r.savedCount(s, params)
+		if err != nil {
+			return result, err
 		}
+		result.SetData("desired_count", strconv.Itoa(count))
 
-		log.Infof("service runner scaling  %s/%s to %d", s.Cluster, s.Name, s.desiredCount)
+		if err := r.scale(ctx, s, count, result); err != nil {
+			return result, err
+		}
 
-		res, err := client.Do(req)
-		if err != nil {
-			return "", NewRunnerError(ErrExecFailure, "http request failed", err)
+		// the saved count is used up, the next scale down saves the count again
+		if fromState {
+			if err := r.State.DeleteState(s.stateKey()); err != nil {
+				return result, NewRunnerError(ErrPostExecFailure, "failed to clear saved desired count", err)
+			}
 		}
-		defer res.Body.Close()
 
-		body, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			return "", NewRunnerError(ErrPostExecFailure, "reading response body failed", err)
+		result.Message = fmt.Sprintf("successfully restored desired count for %s/%s to %d", s.Cluster, s.Name, count)
+		return result, nil
+	case "redeploy":
+		if err := s.prepService(params); err != nil {
+			return nil, NewRunnerError(ErrPreExecFailure, "failed to define input from parameters", err)
 		}
+		result.Targets = []string{s.Cluster + "/" + s.Name}
 
-		log.Debugf("got response %s(%d) for endpoint %s: %s", res.Status, res.StatusCode, s.endpoint, body)
+		if err := r.setEndpoint(s); err != nil {
+			return result, err
+		}
+
+		log.Infof("service runner redeploying %s/%s", s.Cluster, s.Name)
 
-		if res.StatusCode >= 300 {
-			return "", NewRunnerError(ErrExecFailure, "unexpected http response", errors.New("unexpected response from serviceRunner api: "+res.Status))
+		if _, err := r.request(ctx, http.MethodPut, s.endpoint, map[string]bool{"ForceNewDeployment": true}, result); err != nil {
+			return result, err
 		}
 
-		msg := fmt.Sprintf("successfully set desired count for %s/%s to %d", s.Cluster, s.Name, s.desiredCount)
-		return msg, nil
+		result.Message = fmt.Sprintf("successfully started a new deployment for %s/%s", s.Cluster, s.Name)
+		return result, nil
 	default:
 		msg := fmt.Sprintf("unexpected service action '%s'", action)
-		return "", NewRunnerError(ErrMissingDetails, msg, nil)
+		return nil, NewRunnerError(ErrMissingDetails, msg, nil)
+	}
+}
+
+// setEndpoint executes the endpoint template with the input, unless an endpoint is configured
+func (r *ServiceRunner) setEndpoint(s *ServiceRunnerScaleInput) error {
+	if s.endpoint != "" {
+		return nil
+	}
+
+	e, err := execEndpointTemplate(r.EndpointTemplate, s)
+	if err != nil {
+		return NewRunnerError(ErrPreExecFailure, "failed set endpoint", err)
+	}
+	s.endpoint = e
+
+	return nil
+}
+
+// scale sets the desired count of the service
+func (r *ServiceRunner) scale(ctx context.Context, s *ServiceRunnerScaleInput, count int, result *Result) error {
+	inputPayload := struct {
+		Service map[string]int
+	}{
+		map[string]int{
+			"DesiredCount": count,
+		},
+	}
+
+	log.Infof("service runner scaling  %s/%s to %d", s.Cluster, s.Name, count)
+
+	_, err := r.request(ctx, http.MethodPut, s.endpoint, inputPayload, result)
+	return err
+}
+
+// currentCount gets the current desired count of the service from the count field of the service
+func (r *ServiceRunner) currentCount(ctx context.Context, s *ServiceRunnerScaleInput) (int, error) {
+	body, err := r.request(ctx, http.MethodGet, s.endpoint, nil, nil)
+	if err != nil {
+		return 0, err
 	}
+
+	var service interface{}
+	if err := json.Unmarshal(body, &service); err != nil {
+		return 0, NewRunnerError(ErrPreExecFailure, "failed to decode service", err)
+	}
+
+	field, err := jsonField(service, r.CountField)
+	if err != nil {
+		return 0, NewRunnerError(ErrPreExecFailure, "failed to get current desired count", err)
+	}
+
+	count, ok := configInt(field)
+	if !ok || count < 0 {
+		return 0, NewRunnerError(ErrPreExecFailure, fmt.Sprintf("unexpected desired count %v", field), nil)
+	}
+
+	log.Debugf("current desired count for %s/%s is %d", s.Cluster, s.Name, count)
+
+	return count, nil
+}
+
+// savedCount returns the desired count saved when the service was scaled down and true.  If no count was saved,
+// the desired_count parameter is used if it's set.
+func (r *ServiceRunner) savedCount(s *ServiceRunnerScaleInput, params map[string]string) (int, bool, error) {
+	if r.State == nil {
+		return 0, false, NewRunnerError(ErrPreExecFailure, "restore is not supported, the runner has no state store", nil)
+	}
+
+	saved, ok, err := r.State.GetState(s.stateKey())
+	if err != nil {
+		return 0, false, NewRunnerError(ErrPreExecFailure, "failed to get saved desired count", err)
+	}
+
+	if !ok {
+		c, ok := params["desired_count"]
+		if !ok {
+			return 0, false, NewRunnerError(ErrPreExecFailure, fmt.Sprintf("no saved desired count for %s/%s", s.Cluster, s.Name), nil)
+		}
+		saved = c
+	}
+
+	count, err := strconv.Atoi(saved)
+	if err != nil || count < 0 {
+		return 0, false, NewRunnerError(ErrPreExecFailure, fmt.Sprintf("invalid saved desired count '%s'", saved), err)
+	}
+
+	return count, ok, nil
+}
+
+// saveCount saves the current desired count of the service to restore it later and returns the saved count, or -1 if
+// it wasn't saved.  A count that's still waiting to be restored isn't overwritten, and neither is a zero count, so
+// scaling down again (ie. a duplicate or a manual run) doesn't lose the count to restore.
+func (r *ServiceRunner) saveCount(s *ServiceRunnerScaleInput, current int) (int, error) {
+	if r.State == nil {
+		return -1, NewRunnerError(ErrPreExecFailure, "save_count is not supported, the runner has no state store", nil)
+	}
+
+	pending, ok, err := r.State.GetState(s.stateKey())
+	if err != nil {
+		return -1, NewRunnerError(ErrPreExecFailure, "failed to get saved desired count", err)
+	}
+
+	if ok {
+		log.Infof("service runner keeping saved desired count %s for %s/%s, it wasn't restored yet", pending, s.Cluster, s.Name)
+		return -1, nil
+	}
+
+	if current == 0 {
+		log.Infof("service runner not saving desired count 0 for %s/%s", s.Cluster, s.Name)
+		return -1, nil
+	}
+
+	if err := r.State.SetState(s.stateKey(), strconv.Itoa(current)); err != nil {
+		return -1, NewRunnerError(ErrPreExecFailure, "failed to save desired count", err)
+	}
+
+	log.Infof("service runner saved desired count %d for %s/%s", current, s.Cluster, s.Name)
+	return current, nil
+}
+
+// request sends a request with the optional JSON body to the service api and returns the response body.  If a
+// result is passed, the status code and an excerpt of the response are set on it.
+func (r *ServiceRunner) request(ctx context.Context, method, endpoint string, input interface{}, result *Result) ([]byte, error) {
+	var reader io.Reader
+	if input != nil {
+		j, err := json.Marshal(input)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Debugf("service runner sending %s %s with input %s", method, endpoint, string(j))
+		reader = bytes.NewReader(j)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return nil, NewRunnerError(ErrPreExecFailure, "building http request failed", err)
+	}
+
+	if err := r.authorize(req); err != nil {
+		return nil, err
+	}
+
+	res, err := r.Client.Do(req)
+	if err != nil {
+		return nil, NewRunnerError(ErrExecFailure, "http request failed", err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, NewRunnerError(ErrPostExecFailure, "reading response body failed", err)
+	}
+
+	log.Debugf("got response %s(%d) for endpoint %s: %s", res.Status, res.StatusCode, endpoint, body)
+
+	if result != nil {
+		result.StatusCode = res.StatusCode
+		result.Response = excerpt(body)
+	}
+
+	if res.StatusCode >= 300 {
+		return nil, NewRunnerStatusError(ErrExecFailure, "unexpected http response", res.StatusCode, errors.New("unexpected response from serviceRunner api: "+res.Status))
+	}
+
+	return body, nil
+}
+
+// authorize signs a request to the service api or sets the token header, if a token is configured
+func (r *ServiceRunner) authorize(req *http.Request) error {
+	if r.Signer != nil {
+		log.Debug("signing request")
+		return r.Signer.Sign(req)
+	}
+
+	if r.Token == "" {
+		return nil
+	}
+
+	log.Debugf("setting token header %s", r.AuthHeader)
+	if r.Encrypt {
+		e, err := bcrypt.GenerateFromPassword([]byte(r.Token), 6)
+		if err != nil {
+			return NewRunnerError(ErrExecFailure, "unable to hash token", err)
+		}
+
+		log.Debug("token is encrypted")
+
+		req.Header.Set(r.AuthHeader, string(e))
+	} else {
+		req.Header.Set(r.AuthHeader, r.Token)
+	}
+
+	return nil
 }
 
 func (s *ServiceRunnerScaleInput) prep(parameters map[string]string) error {
+	if err := s.prepService(parameters); err != nil {
+		return err
+	}
+
+	countString, ok := parameters["desired_count"]
+	if !ok {
+		return NewRunnerError(ErrMissingDetails, "missing desired_count", nil)
+	}
+
+	desiredCount, err := strconv.Atoi(countString)
+	if err != nil {
+		return NewRunnerError(ErrPreExecFailure, "desired count cannot be converted to integer", err)
+	}
+	s.desiredCount = desiredCount
+
+	// a count with a sign is relative to the current count, ie. '+2' or '-1'
+	s.relative = strings.HasPrefix(countString, "+") || strings.HasPrefix(countString, "-")
+
+	for _, b := range []struct {
+		key string
+		val *int
+	}{{"min_count", &s.minCount}, {"max_count", &s.maxCount}} {
+		c, ok := parameters[b.key]
+		if !ok {
+			continue
+		}
+
+		n, err := strconv.Atoi(c)
+		if err != nil {
+			return NewRunnerError(ErrPreExecFailure, b.key+" cannot be converted to integer", err)
+		}
+		*b.val = n
+	}
+
+	return nil
+}
+
+// prepService sets the cluster and name of the service from the parameters
+func (s *ServiceRunnerScaleInput) prepService(parameters map[string]string) error {
 	if parameters == nil {
 		return NewRunnerError(ErrMissingDetails, "parameters cannot be nil", nil)
 	}
 
-	log.Debugf("prepping service scale input with params: %+v", parameters)
+	log.Debugf("prepping service input with params: %+v", parameters)
 
 	serviceCluster, ok := parameters["service_cluster"]
 	if !ok {
@@ -202,18 +567,25 @@ func (s *ServiceRunnerScaleInput) prep(parameters map[string]string) error {
 	}
 	s.Name = serviceName
 
-	countString, ok := parameters["desired_count"]
-	if !ok {
-		return NewRunnerError(ErrMissingDetails, "missing desired_count", nil)
+	return nil
+}
+
+// bound limits the count to the min_count and max_count, a max_count of 0 means there's no maximum
+func (s *ServiceRunnerScaleInput) bound(count int) int {
+	if count < s.minCount {
+		count = s.minCount
 	}
 
-	desiredCount, err := strconv.Atoi(countString)
-	if err != nil {
-		return NewRunnerError(ErrPreExecFailure, "desired count cannot be converted to integer", err)
+	if s.maxCount > 0 && count > s.maxCount {
+		count = s.maxCount
 	}
-	s.desiredCount = desiredCount
 
-	return nil
+	return count
+}
+
+// stateKey is the key of the desired count saved for the service
+func (s *ServiceRunnerScaleInput) stateKey() string {
+	return fmt.Sprintf("service/%s/%s/%s/desired_count", s.Account, s.Cluster, s.Name)
 }
 
 // execEndpointTemplate parses the passed template and then executes it with the given data.
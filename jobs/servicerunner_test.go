@@ -8,6 +8,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strconv"
+	"sync"
 	"testing"
 
 	"golang.org/x/crypto/bcrypt"
@@ -366,3 +368,275 @@ func TestExecEndpointTemplate(t *testing.T) {
 		}
 	}
 }
+
+func TestServiceRunnerValidate(t *testing.T) {
+	tests := []struct {
+		params   interface{}
+		problems []string
+	}{
+		{
+			params:   map[string]string{"service_action": "scale", "service_cluster": "c1", "service_name": "s1", "desired_count": "0"},
+			problems: nil,
+		},
+		{
+			params:   map[string]string{"service_action": "scale", "service_cluster": "c1", "service_name": "s1", "desired_count": "-1", "min_count": "1", "max_count": "5"},
+			problems: nil,
+		},
+		{
+			params:   map[string]string{"service_action": "scale", "service_cluster": "c1", "service_name": "s1", "desired_count": "+two"},
+			problems: []string{"desired_count must be an integer: '+two'"},
+		},
+		{
+			params:   map[string]string{"service_action": "scale", "service_cluster": "c1", "service_name": "s1", "desired_count": "lots"},
+			problems: []string{"desired_count must be an integer: 'lots'"},
+		},
+		{
+			params:   map[string]string{"service_action": "scale", "service_cluster": "c1", "service_name": "s1", "desired_count": "2", "min_count": "3", "max_count": "2"},
+			problems: []string{"min_count must not be greater than max_count: 3 > 2"},
+		},
+		{
+			params:   map[string]string{"service_action": "scale", "service_cluster": "c1", "service_name": "s1", "desired_count": "2", "min_count": "-1", "max_count": "0"},
+			problems: []string{"min_count must be at least 0: -1", "max_count must be at least 1: 0"},
+		},
+		{
+			params:   map[string]string{"service_action": "scale", "service_cluster": "c1", "service_name": "s1", "desired_count": "0", "save_count": "true"},
+			problems: []string{"save_count is not supported, the runner has no state store"},
+		},
+		{
+			params:   map[string]string{"service_action": "restore", "service_cluster": "c1", "service_name": "s1"},
+			problems: []string{"restore is not supported, the runner has no state store"},
+		},
+		{
+			params:   map[string]string{"service_action": "redeploy", "service_cluster": "c1", "service_name": "s1"},
+			problems: nil,
+		},
+		{
+			params:   map[string]string{"service_action": "restart"},
+			problems: []string{"unexpected service_action 'restart', must be one of 'scale', 'restore', 'redeploy'", "missing service_cluster", "missing service_name"},
+		},
+	}
+
+	r := &ServiceRunner{}
+	for _, test := range tests {
+		err := r.Validate(test.params)
+		if test.problems == nil {
+			if err != nil {
+				t.Errorf("expected nil error for %+v, got %s", test.params, err)
+			}
+			continue
+		}
+
+		verr, ok := err.(ValidationError)
+		if !ok {
+			t.Errorf("expected ValidationError for %+v, got %v", test.params, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(verr.Problems, test.problems) {
+			t.Errorf("expected problems %v, got %v", test.problems, verr.Problems)
+		}
+	}
+}
+
+// mockServiceServer is a service api that keeps the desired count of a service
+type mockServiceServer struct {
+	mux          sync.Mutex
+	desiredCount int
+	redeployed   bool
+}
+
+func (m *mockServiceServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if r.Header.Get("X-Auth-Token") != "my-awesome-token" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if r.URL.Path != "/v1/ecs/myaccount/clusters/fooclu/services/foosvc" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		fmt.Fprintf(w, `{"Service": {"DesiredCount": %d}}`, m.desiredCount)
+	case http.MethodPut:
+		input := struct {
+			Service            map[string]int
+			ForceNewDeployment bool
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, "cannot decode body into input", http.StatusBadRequest)
+			return
+		}
+
+		if input.ForceNewDeployment {
+			m.redeployed = true
+		} else if c, ok := input.Service["DesiredCount"]; ok {
+			m.desiredCount = c
+		} else {
+			http.Error(w, "bad input payload", http.StatusBadRequest)
+			return
+		}
+
+		w.Write([]byte("OK"))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestServiceRunnerRunActions(t *testing.T) {
+	service := &mockServiceServer{desiredCount: 3}
+	ts := httptest.NewServer(service)
+	defer ts.Close()
+
+	r, err := NewServiceRunner(map[string]interface{}{
+		"token":            "my-awesome-token",
+		"endpointTemplate": ts.URL + "/v1/ecs/{{.Account}}/clusters/{{.Cluster}}/services/{{.Name}}",
+		"countField":       "Service.DesiredCount",
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	state := &mockStateStore{}
+	r.SetStateStore(state)
+
+	tests := []struct {
+		params map[string]string
+		output string
+		count  int
+		err    bool
+	}{
+		{
+			params: map[string]string{"service_action": "scale", "desired_count": "+2"},
+			output: "successfully set desired count for fooclu/foosvc to 5 (was 3)",
+			count:  5,
+		},
+		{
+			params: map[string]string{"service_action": "scale", "desired_count": "+2", "max_count": "6"},
+			output: "successfully set desired count for fooclu/foosvc to 6 (was 5)",
+			count:  6,
+		},
+		{
+			params: map[string]string{"service_action": "scale", "desired_count": "-10", "min_count": "1"},
+			output: "successfully set desired count for fooclu/foosvc to 1 (was 6)",
+			count:  1,
+		},
+		{
+			params: map[string]string{"service_action": "scale", "desired_count": "4"},
+			output: "successfully set desired count for fooclu/foosvc to 4",
+			count:  4,
+		},
+		{
+			params: map[string]string{"service_action": "scale", "desired_count": "0", "save_count": "true"},
+			output: "successfully set desired count for fooclu/foosvc to 0 (was 4), saved desired count 4 for restore",
+			count:  0,
+		},
+		{
+			params: map[string]string{"service_action": "scale", "desired_count": "0", "save_count": "true"},
+			output: "successfully set desired count for fooclu/foosvc to 0 (was 0)",
+			count:  0,
+		},
+		{
+			params: map[string]string{"service_action": "restore"},
+			output: "successfully restored desired count for fooclu/foosvc to 4",
+			count:  4,
+		},
+		{
+			params: map[string]string{"service_action": "redeploy"},
+			output: "successfully started a new deployment for fooclu/foosvc",
+			count:  4,
+		},
+	}
+
+	for _, test := range tests {
+		test.params["service_cluster"] = "fooclu"
+		test.params["service_name"] = "foosvc"
+
+		out, err := r.Run(context.TODO(), "myaccount", test.params)
+		if err != nil {
+			t.Errorf("expected nil error for %+v, got %s", test.params, err)
+			continue
+		}
+
+		if out != test.output {
+			t.Errorf("expected output '%s', got '%s'", test.output, out)
+		}
+
+		if service.desiredCount != test.count {
+			t.Errorf("expected desired count %d for %+v, got %d", test.count, test.params, service.desiredCount)
+		}
+	}
+
+	if !service.redeployed {
+		t.Error("expected service to be redeployed")
+	}
+
+	if len(state.state) != 0 {
+		t.Errorf("expected the saved count to be cleared after restoring, got %+v", state.state)
+	}
+
+	// a scale down while a count is waiting to be restored keeps the saved count
+	scaleDown := map[string]string{"service_action": "scale", "service_cluster": "fooclu", "service_name": "foosvc", "desired_count": "2", "save_count": "true"}
+	if _, err := r.Run(context.TODO(), "myaccount", scaleDown); err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	scaleDown["desired_count"] = "1"
+	if out, err := r.Run(context.TODO(), "myaccount", scaleDown); err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	} else if out != "successfully set desired count for fooclu/foosvc to 1 (was 2)" {
+		t.Errorf("expected the saved count not to be overwritten, got '%s'", out)
+	}
+
+	restore := map[string]string{"service_action": "restore", "service_cluster": "fooclu", "service_name": "foosvc"}
+	if _, err := r.Run(context.TODO(), "myaccount", restore); err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	} else if service.desiredCount != 4 {
+		t.Errorf("expected desired count 4 to be restored after scaling down twice, got %d", service.desiredCount)
+	}
+
+	result, err := r.RunResult(context.TODO(), "myaccount", map[string]string{
+		"service_action":  "scale",
+		"service_cluster": "fooclu",
+		"service_name":    "foosvc",
+		"desired_count":   "+1",
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if !reflect.DeepEqual(result.Targets, []string{"fooclu/foosvc"}) || result.StatusCode != http.StatusOK {
+		t.Errorf("expected result for fooclu/foosvc with status 200, got %+v", result)
+	}
+
+	if result.Data["previous_count"] != strconv.Itoa(service.desiredCount-1) || result.Data["desired_count"] != strconv.Itoa(service.desiredCount) {
+		t.Errorf("expected previous and desired counts in the result data, got %+v", result.Data)
+	}
+
+	if err := r.Validate(map[string]string{"service_action": "restore", "service_cluster": "fooclu", "service_name": "foosvc"}); err != nil {
+		t.Errorf("expected nil error validating restore with a state store, got %s", err)
+	}
+
+	// restoring another service without a saved count uses the desired_count or fails
+	params := map[string]string{"service_action": "restore", "service_cluster": "fooclu", "service_name": "barsvc"}
+	var rErr RunnerError
+	if _, err := r.Run(context.TODO(), "myaccount", params); !errors.As(err, &rErr) || rErr.Code != ErrPreExecFailure {
+		t.Errorf("expected %s error restoring without a saved count, got %v", ErrPreExecFailure, err)
+	}
+
+	params["desired_count"] = "2"
+	if _, err := r.Run(context.TODO(), "myaccount", params); !errors.As(err, &rErr) || rErr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected the desired_count to be restored to the unknown service, got %v", err)
+	}
+
+	// relative scaling fails if the current count can't be read
+	r.CountField = "Service.Count"
+	params = map[string]string{"service_action": "scale", "service_cluster": "fooclu", "service_name": "foosvc", "desired_count": "+1"}
+	if _, err := r.Run(context.TODO(), "myaccount", params); !errors.As(err, &rErr) || rErr.Code != ErrPreExecFailure {
+		t.Errorf("expected %s error for missing count field, got %v", ErrPreExecFailure, err)
+	}
+}
@@ -0,0 +1,167 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SignatureHeader is the header with the HMAC signature of a signed request
+	SignatureHeader = "X-Minion-Signature"
+	// SignatureTimestampHeader is the header with the unix time a signed request was signed at
+	SignatureTimestampHeader = "X-Minion-Timestamp"
+	// SignatureNonceHeader is the header with the random nonce of a signed request
+	SignatureNonceHeader = "X-Minion-Nonce"
+	// SignatureVersion prefixes the signature, it changes if the signed content changes
+	SignatureVersion = "v1"
+)
+
+// Signer signs requests with an HMAC-SHA256 of the method, path, timestamp, nonce and body digest using the shared
+// secret.  Unlike sending the token, a signature can't be reused for another request and expires with its timestamp.
+type Signer struct {
+	Secret []byte
+}
+
+// newSigner returns a signer with the runner token as the secret if the runner configuration sets sign_requests,
+// otherwise nil
+func newSigner(config map[string]interface{}, token string) (*Signer, error) {
+	sign, ok := config["sign_requests"].(bool)
+	if !ok || !sign {
+		return nil, nil
+	}
+
+	if token == "" {
+		return nil, errors.New("token is required to sign requests")
+	}
+
+	return &Signer{Secret: []byte(token)}, nil
+}
+
+// signerKey is the request context key of the signer that signed the request
+type signerKey struct{}
+
+// Sign sets the signature, timestamp and nonce headers of the request.  The signer is kept in the request context,
+// so the http client signs every retry of the request again with a new timestamp and nonce.
+func (s *Signer) Sign(req *http.Request) error {
+	body, err := requestBody(req)
+	if err != nil {
+		return NewRunnerError(ErrPreExecFailure, "reading request body to sign failed", err)
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return NewRunnerError(ErrPreExecFailure, "generating request nonce failed", err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	n := hex.EncodeToString(nonce)
+
+	req.Header.Set(SignatureTimestampHeader, timestamp)
+	req.Header.Set(SignatureNonceHeader, n)
+	req.Header.Set(SignatureHeader, RequestSignature(s.Secret, req.Method, req.URL.RequestURI(), timestamp, n, body))
+
+	if signer, _ := req.Context().Value(signerKey{}).(*Signer); signer != s {
+		*req = *req.WithContext(context.WithValue(req.Context(), signerKey{}, s))
+	}
+
+	return nil
+}
+
+// resign signs the request again if it was signed by a signer, so a retry isn't rejected as a replay
+func resign(req *http.Request) error {
+	if s, ok := req.Context().Value(signerKey{}).(*Signer); ok {
+		return s.Sign(req)
+	}
+	return nil
+}
+
+// RequestSignature returns the versioned HMAC-SHA256 signature of a request.  The uri is the escaped path and
+// query of the request.
+func RequestSignature(secret []byte, method, uri, timestamp, nonce string, body []byte) string {
+	digest := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.Join([]string{
+		strings.ToUpper(method),
+		uri,
+		timestamp,
+		nonce,
+		hex.EncodeToString(digest[:]),
+	}, "\n")))
+
+	return SignatureVersion + "=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyRequestSignature checks the signature of a request and that it was signed within maxSkew of now.  The
+// request body is read and replaced so it can be read again.
+func VerifyRequestSignature(secret []byte, req *http.Request, maxSkew time.Duration) error {
+	signature := req.Header.Get(SignatureHeader)
+	timestamp := req.Header.Get(SignatureTimestampHeader)
+	nonce := req.Header.Get(SignatureNonceHeader)
+
+	if signature == "" || timestamp == "" || nonce == "" {
+		return errors.New("missing signature headers")
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid signature timestamp '%s'", timestamp)
+	}
+
+	if skew := time.Since(time.Unix(ts, 0)); skew > maxSkew || skew < -maxSkew {
+		return fmt.Errorf("signature timestamp %s is outside of the allowed skew of %s", timestamp, maxSkew)
+	}
+
+	body, err := requestBody(req)
+	if err != nil {
+		return fmt.Errorf("failed to read request body: %s", err)
+	}
+
+	expected := RequestSignature(secret, req.Method, req.URL.RequestURI(), timestamp, nonce, body)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return errors.New("signature doesn't match")
+	}
+
+	return nil
+}
+
+// requestBody returns the body of the request and replaces it, so the request can still be sent or handled
+func requestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+
+	if req.GetBody != nil {
+		rc, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return ioutil.ReadAll(rc)
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body.Close()
+
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	return body, nil
+}
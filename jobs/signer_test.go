@@ -0,0 +1,174 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewSigner(t *testing.T) {
+	if s, err := newSigner(map[string]interface{}{}, "sekret"); err != nil || s != nil {
+		t.Errorf("expected nil signer without sign_requests, got %+v, %v", s, err)
+	}
+
+	if _, err := newSigner(map[string]interface{}{"sign_requests": true}, ""); err == nil {
+		t.Error("expected error signing requests without a token, got nil")
+	}
+
+	s, err := newSigner(map[string]interface{}{"sign_requests": true}, "sekret")
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if string(s.Secret) != "sekret" {
+		t.Errorf("expected the token as the secret, got '%s'", s.Secret)
+	}
+}
+
+func TestSignerSign(t *testing.T) {
+	secret := []byte("sekret")
+	s := &Signer{Secret: secret}
+
+	newRequest := func() *http.Request {
+		req, _ := http.NewRequest(http.MethodPut, "http://example.com/v1/ec2/myaccount/instances/i-1/power?x=1", bytes.NewReader([]byte(`{"state":"start"}`)))
+		if err := s.Sign(req); err != nil {
+			t.Fatalf("expected nil error signing, got %s", err)
+		}
+		return req
+	}
+
+	req := newRequest()
+	if err := VerifyRequestSignature(secret, req, time.Minute); err != nil {
+		t.Errorf("expected nil error verifying signed request, got %s", err)
+	}
+
+	// the body can still be sent after signing
+	if body, _ := ioutil.ReadAll(req.Body); string(body) != `{"state":"start"}` {
+		t.Errorf("expected the request body to be kept, got '%s'", body)
+	}
+
+	if other := newRequest(); other.Header.Get(SignatureNonceHeader) == req.Header.Get(SignatureNonceHeader) {
+		t.Error("expected a new nonce for every request")
+	}
+
+	tests := map[string]func(r *http.Request){
+		"wrong secret": func(r *http.Request) {
+			r.Header.Set(SignatureHeader, RequestSignature([]byte("wrong"), r.Method, r.URL.RequestURI(), r.Header.Get(SignatureTimestampHeader), r.Header.Get(SignatureNonceHeader), []byte(`{"state":"start"}`)))
+		},
+		"changed method": func(r *http.Request) { r.Method = http.MethodDelete },
+		"changed path":   func(r *http.Request) { r.URL.Path = "/v1/ec2/myaccount/instances/i-2/power" },
+		"changed query":  func(r *http.Request) { r.URL.RawQuery = "x=2" },
+		"changed body": func(r *http.Request) {
+			r.Body = ioutil.NopCloser(bytes.NewReader([]byte(`{"state":"stop"}`)))
+			r.GetBody = nil
+		},
+		"changed nonce":     func(r *http.Request) { r.Header.Set(SignatureNonceHeader, "1234") },
+		"missing signature": func(r *http.Request) { r.Header.Del(SignatureHeader) },
+		"old timestamp": func(r *http.Request) {
+			r.Header.Set(SignatureTimestampHeader, strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10))
+		},
+		"invalid timestamp": func(r *http.Request) { r.Header.Set(SignatureTimestampHeader, "yesterday") },
+	}
+
+	for name, change := range tests {
+		req := newRequest()
+		change(req)
+		if err := VerifyRequestSignature(secret, req, time.Minute); err == nil {
+			t.Errorf("expected error verifying request with %s, got nil", name)
+		}
+	}
+}
+
+func TestInstanceRunnerSignsRequests(t *testing.T) {
+	secret := []byte("sekret")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Auth-Token") != "" {
+			t.Error("expected no token header on a signed request")
+		}
+
+		if err := VerifyRequestSignature(secret, r, time.Minute); err != nil {
+			t.Errorf("expected valid signature, got %s", err)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		w.Write([]byte("OK"))
+	}))
+	defer ts.Close()
+
+	r, err := NewInstanceRunner(map[string]interface{}{
+		"endpoint":      ts.URL + "/power",
+		"token":         string(secret),
+		"encrypt_token": true,
+		"sign_requests": true,
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	out, err := r.Run(context.TODO(), "myaccount", map[string]string{"instance_action": "start", "instance_id": "i-1"})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if out != "OK" {
+		t.Errorf("expected output 'OK', got '%s'", out)
+	}
+}
+
+func TestHTTPClientResignsRetries(t *testing.T) {
+	secret := []byte("sekret")
+
+	var mux sync.Mutex
+	nonces := map[string]bool{}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := VerifyRequestSignature(secret, r, time.Minute); err != nil {
+			t.Errorf("expected valid signature, got %s", err)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		mux.Lock()
+		defer mux.Unlock()
+
+		nonce := r.Header.Get(SignatureNonceHeader)
+		if nonces[nonce] {
+			t.Errorf("expected a new nonce for every attempt, got %s again", nonce)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		nonces[nonce] = true
+
+		if len(nonces) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("OK"))
+	}))
+	defer ts.Close()
+
+	client := &HTTPClient{Client: &http.Client{Timeout: time.Second}, Retries: 2, RetryDelay: time.Millisecond}
+
+	req, _ := http.NewRequest(http.MethodPut, ts.URL+"/power", bytes.NewReader([]byte(`{"state":"start"}`)))
+	if err := (&Signer{Secret: secret}).Sign(req); err != nil {
+		t.Fatalf("expected nil error signing, got %s", err)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK || len(nonces) != 3 {
+		t.Errorf("expected status 200 after 3 signed attempts, got %d after %d", res.StatusCode, len(nonces))
+	}
+}
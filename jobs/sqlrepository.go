@@ -0,0 +1,882 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/YaleSpinup/apierror"
+	log "github.com/sirupsen/logrus"
+
+	// register the database/sql drivers for the supported dialects
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	// SQLDialectPostgres is the dialect of a postgres database
+	SQLDialectPostgres = "postgres"
+	// SQLDialectSQLite is the dialect of a sqlite database
+	SQLDialectSQLite = "sqlite"
+)
+
+// sqlMigrations are the schema changes of the sql repositories, in order.  A migration is applied once and
+// recorded with its version (its index + 1) in the minion_schema_migrations table.  Migrations must not be
+// changed once released, add a new one instead.
+var sqlMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS minion_jobs (
+		prefix    TEXT NOT NULL,
+		account   TEXT NOT NULL,
+		job_group TEXT NOT NULL,
+		id        TEXT NOT NULL,
+		enabled   BOOLEAN NOT NULL,
+		job       TEXT NOT NULL,
+		PRIMARY KEY (prefix, account, job_group, id)
+	);
+	CREATE INDEX IF NOT EXISTS minion_jobs_account_enabled ON minion_jobs (prefix, account, enabled);
+	CREATE INDEX IF NOT EXISTS minion_jobs_enabled ON minion_jobs (prefix, enabled);`,
+	`CREATE TABLE IF NOT EXISTS minion_runs (
+		prefix     TEXT NOT NULL,
+		account    TEXT NOT NULL,
+		job_group  TEXT NOT NULL,
+		job_id     TEXT NOT NULL,
+		id         TEXT NOT NULL,
+		started_at TIMESTAMP,
+		run        TEXT NOT NULL,
+		PRIMARY KEY (prefix, account, job_group, job_id, id)
+	);
+	CREATE INDEX IF NOT EXISTS minion_runs_started_at ON minion_runs (prefix, account, job_group, job_id, started_at);`,
+	`ALTER TABLE minion_jobs ADD COLUMN version INTEGER NOT NULL DEFAULT 0;`,
+	`CREATE TABLE IF NOT EXISTS minion_job_revisions (
+		prefix    TEXT NOT NULL,
+		account   TEXT NOT NULL,
+		job_group TEXT NOT NULL,
+		id        TEXT NOT NULL,
+		version   INTEGER NOT NULL,
+		job       TEXT NOT NULL,
+		PRIMARY KEY (prefix, account, job_group, id, version)
+	);`,
+	`CREATE TABLE IF NOT EXISTS minion_job_trash (
+		prefix     TEXT NOT NULL,
+		account    TEXT NOT NULL,
+		job_group  TEXT NOT NULL,
+		id         TEXT NOT NULL,
+		deleted_at TIMESTAMP NOT NULL,
+		job        TEXT NOT NULL,
+		PRIMARY KEY (prefix, account, job_group, id)
+	);`,
+}
+
+// sqlUpdateAttempts is the number of times an unconditional update is retried when another writer changes the
+// job between reading its version and writing it
+const sqlUpdateAttempts = 5
+
+// SQLRepository is an implementation of a jobs repository in a sql database (postgres or sqlite).  Jobs are
+// stored as JSON documents in the minion_jobs table, keyed by the prefix, account, group and id.  The primary
+// key doubles as the index for listing an account or a group.  Each version of a job is also kept in the
+// minion_job_revisions table, and trashed jobs are moved to the minion_job_trash table.
+type SQLRepository struct {
+	DB      *sql.DB
+	Dialect string
+	Prefix  string
+}
+
+// NewSQLRepository creates a new sql repository from the config data and migrates the schema.  The driver
+// (postgres or sqlite) and the dsn are required.
+func NewSQLRepository(config map[string]interface{}) (*SQLRepository, error) {
+	log.Debug("creating new sql repository")
+
+	db, dialect, err := openSQL(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var prefix string
+	if v, ok := config["prefix"].(string); ok {
+		prefix = v
+	}
+
+	return &SQLRepository{
+		DB:      db,
+		Dialect: dialect,
+		Prefix:  prefix,
+	}, nil
+}
+
+// openSQL opens the database from the config data and migrates the schema
+func openSQL(config map[string]interface{}) (*sql.DB, string, error) {
+	var driver, dsn string
+	if v, ok := config["driver"].(string); ok {
+		driver = v
+	}
+
+	if v, ok := config["dsn"].(string); ok {
+		dsn = v
+	}
+
+	if driver != SQLDialectPostgres && driver != SQLDialectSQLite {
+		return nil, "", fmt.Errorf("driver must be '%s' or '%s', got '%s'", SQLDialectPostgres, SQLDialectSQLite, driver)
+	}
+
+	if dsn == "" {
+		return nil, "", errors.New("dsn is required for the sql repository")
+	}
+
+	// the jobs and runs repositories open their own pools, wait for the other's writes instead of failing
+	if driver == SQLDialectSQLite && !strings.Contains(dsn, "busy_timeout") {
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		dsn = dsn + sep + "_pragma=busy_timeout(10000)"
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open %s database: %s", driver, err)
+	}
+
+	if driver == SQLDialectSQLite {
+		// sqlite allows one writer at a time, sharing a single connection avoids 'database is locked' errors
+		db.SetMaxOpenConns(1)
+	} else if v, ok := config["maxOpenConns"]; ok {
+		n, ok := configInt(v)
+		if !ok || n < 0 {
+			db.Close()
+			return nil, "", fmt.Errorf("invalid maxOpenConns %v", v)
+		}
+		db.SetMaxOpenConns(n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := migrateSQL(ctx, db, driver); err != nil {
+		db.Close()
+		return nil, "", err
+	}
+
+	return db, driver, nil
+}
+
+// sqlMigrationLock is the postgres advisory lock key serializing the schema migrations between nodes
+const sqlMigrationLock = 7238146021
+
+// migrateSQL applies the migrations that weren't applied to the database yet.  Each migration is applied in its own
+// transaction, holding a lock so nodes starting at the same time don't apply the same migration twice: an advisory
+// lock on postgres and the database write lock, taken when the transaction begins, on sqlite.  The schema version is
+// read under the lock, so a node waiting for the lock skips the migrations applied by the node holding it.
+func migrateSQL(ctx context.Context, db *sql.DB, dialect string) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection for schema migrations: %s", err)
+	}
+	defer conn.Close()
+
+	for {
+		done, err := migrateSQLNext(ctx, conn, dialect)
+		if err != nil {
+			return err
+		}
+
+		if done {
+			return nil
+		}
+	}
+}
+
+// migrateSQLNext applies the next migration that wasn't applied to the database yet in a locked transaction.  It
+// returns true if the schema is up to date.
+func migrateSQLNext(ctx context.Context, conn *sql.Conn, dialect string) (bool, error) {
+	begin := "BEGIN"
+	if dialect == SQLDialectSQLite {
+		begin = "BEGIN IMMEDIATE"
+	}
+
+	if _, err := conn.ExecContext(ctx, begin); err != nil {
+		return false, fmt.Errorf("failed to begin schema migration: %s", err)
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			conn.ExecContext(context.Background(), "ROLLBACK")
+		}
+	}()
+
+	if dialect == SQLDialectPostgres {
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("SELECT pg_advisory_xact_lock(%d)", sqlMigrationLock)); err != nil {
+			return false, fmt.Errorf("failed to lock schema migrations: %s", err)
+		}
+	}
+
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS minion_schema_migrations (
+		version    INTEGER NOT NULL PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL
+	)`); err != nil {
+		return false, fmt.Errorf("failed to create schema migrations table: %s", err)
+	}
+
+	var current int
+	if err := conn.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM minion_schema_migrations").Scan(&current); err != nil {
+		return false, fmt.Errorf("failed to get schema version: %s", err)
+	}
+
+	done := current >= len(sqlMigrations)
+	if !done {
+		version := current + 1
+		log.Infof("applying sql repository schema migration %d", version)
+
+		for _, stmt := range strings.Split(sqlMigrations[current], ";") {
+			if strings.TrimSpace(stmt) == "" {
+				continue
+			}
+
+			if _, err := conn.ExecContext(ctx, stmt); err != nil {
+				return false, fmt.Errorf("failed to apply schema migration %d: %s", version, err)
+			}
+		}
+
+		q := rebind(dialect, "INSERT INTO minion_schema_migrations (version, applied_at) VALUES (?, ?)")
+		if _, err := conn.ExecContext(ctx, q, version, time.Now().UTC()); err != nil {
+			return false, fmt.Errorf("failed to record schema migration %d: %s", version, err)
+		}
+	}
+
+	if _, err := conn.ExecContext(ctx, "COMMIT"); err != nil {
+		return false, fmt.Errorf("failed to commit schema migration: %s", err)
+	}
+	committed = true
+
+	return done, nil
+}
+
+// rebind replaces the '?' placeholders of a query with the placeholders of the dialect
+func rebind(dialect, query string) string {
+	if dialect != SQLDialectPostgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+
+	return b.String()
+}
+
+// Create creates a job in the sql jobs repository
+func (s *SQLRepository) Create(ctx context.Context, account, group string, job *Job) (*Job, error) {
+	if account == "" || group == "" || job == nil {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	// generate a new random ID for the job, a new job doesn't have a version yet
+	job.ID = NewID()
+	job.Version = 0
+
+	return s.Update(ctx, account, group, job.ID, job)
+}
+
+// Delete deletes a job in the sql jobs repository.  If the id is empty, all of the jobs in the group are deleted.
+func (s *SQLRepository) Delete(ctx context.Context, account, group, id string) error {
+	if account == "" || group == "" {
+		return apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Infof("deleting job from sql repository %s/%s/%s", account, group, id)
+
+	q := "DELETE FROM minion_jobs WHERE prefix = ? AND account = ? AND job_group = ?"
+	args := []interface{}{s.Prefix, account, group}
+	if id != "" {
+		q = q + " AND id = ?"
+		args = append(args, id)
+	}
+
+	if _, err := s.DB.ExecContext(ctx, rebind(s.Dialect, q), args...); err != nil {
+		return apierror.New(apierror.ErrInternalError, "failed to delete jobs", err)
+	}
+
+	return nil
+}
+
+// Get gets a job from the sql jobs repository
+func (s *SQLRepository) Get(ctx context.Context, account, group, id string) (*Job, error) {
+	if account == "" || group == "" || id == "" {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Infof("getting job %s/%s/%s", account, group, id)
+
+	q := rebind(s.Dialect, "SELECT job FROM minion_jobs WHERE prefix = ? AND account = ? AND job_group = ? AND id = ?")
+
+	var doc string
+	if err := s.DB.QueryRowContext(ctx, q, s.Prefix, account, group, id).Scan(&doc); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, apierror.New(apierror.ErrNotFound, "job not found "+id, err)
+		}
+		return nil, apierror.New(apierror.ErrInternalError, "failed to get job "+id, err)
+	}
+
+	job := &Job{}
+	if err := json.Unmarshal([]byte(doc), job); err != nil {
+		return nil, apierror.New(apierror.ErrBadRequest, "failed to decode json from sql", err)
+	}
+
+	return job, nil
+}
+
+// List lists the jobs in the sql jobs repository.  If group is empty, all jobs are returned from the
+// account.  If some of those jobs are in a group, the group is prefixed with the job id in the response.
+func (s *SQLRepository) List(ctx context.Context, account, group string) ([]string, error) {
+	if account == "" {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Infof("listing jobs for account '%s', group '%s'", account, group)
+
+	q := "SELECT job_group, id FROM minion_jobs WHERE prefix = ? AND account = ?"
+	args := []interface{}{s.Prefix, account}
+	if group != "" {
+		q = q + " AND job_group = ?"
+		args = append(args, group)
+	}
+	q = q + " ORDER BY job_group, id"
+
+	rows, err := s.DB.QueryContext(ctx, rebind(s.Dialect, q), args...)
+	if err != nil {
+		return nil, apierror.New(apierror.ErrInternalError, "failed to list jobs", err)
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var g, id string
+		if err := rows.Scan(&g, &id); err != nil {
+			return nil, apierror.New(apierror.ErrInternalError, "failed to list jobs", err)
+		}
+
+		if group == "" {
+			id = g + "/" + id
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, apierror.New(apierror.ErrInternalError, "failed to list jobs", err)
+	}
+
+	return ids, nil
+}
+
+// ListJobs returns the jobs of the account keyed by their group prefixed id, like List, with a single query.
+// If enabled is true, only the enabled jobs are returned.
+func (s *SQLRepository) ListJobs(ctx context.Context, account string, enabled bool) (map[string]*Job, error) {
+	if account == "" {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Infof("listing jobs with details for account '%s' (enabled only: %t)", account, enabled)
+
+	q := "SELECT job_group, id, job FROM minion_jobs WHERE prefix = ? AND account = ?"
+	args := []interface{}{s.Prefix, account}
+	if enabled {
+		q = q + " AND enabled = ?"
+		args = append(args, true)
+	}
+
+	rows, err := s.DB.QueryContext(ctx, rebind(s.Dialect, q), args...)
+	if err != nil {
+		return nil, apierror.New(apierror.ErrInternalError, "failed to list jobs", err)
+	}
+	defer rows.Close()
+
+	out := make(map[string]*Job)
+	for rows.Next() {
+		var group, id, doc string
+		if err := rows.Scan(&group, &id, &doc); err != nil {
+			return nil, apierror.New(apierror.ErrInternalError, "failed to list jobs", err)
+		}
+
+		job := &Job{}
+		if err := json.Unmarshal([]byte(doc), job); err != nil {
+			log.Errorf("error decoding job '%s/%s': %s", group, id, err)
+			continue
+		}
+		out[group+"/"+id] = job
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, apierror.New(apierror.ErrInternalError, "failed to list jobs", err)
+	}
+
+	return out, nil
+}
+
+// Update updates a job in the sql jobs repository.  The row is only written if its version didn't change since
+// it was read, so concurrent writers can't overwrite each other's changes.
+func (s *SQLRepository) Update(ctx context.Context, account, group, id string, job *Job) (*Job, error) {
+	if account == "" || group == "" || id == "" || job == nil || job.ID != id {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	// set the modified at to right now
+	now := time.Now().UTC().Truncate(time.Second)
+	job.ModifiedAt = &now
+
+	log.Infof("updating job %s/%s/%s", account, group, id)
+
+	expected := job.Version
+	for i := 0; i < sqlUpdateAttempts; i++ {
+		current, exists, err := s.version(ctx, account, group, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := checkVersion(id, expected, current); err != nil {
+			return nil, err
+		}
+		job.Version = current + 1
+
+		j, err := json.Marshal(job)
+		if err != nil {
+			return nil, apierror.New(apierror.ErrBadRequest, "invalid input", err)
+		}
+
+		q := `UPDATE minion_jobs SET enabled = ?, job = ?, version = ?
+			WHERE prefix = ? AND account = ? AND job_group = ? AND id = ? AND version = ?`
+		args := []interface{}{job.Enabled, string(j), job.Version, s.Prefix, account, group, id, current}
+		if !exists {
+			q = `INSERT INTO minion_jobs (prefix, account, job_group, id, enabled, job, version) VALUES (?, ?, ?, ?, ?, ?, ?)
+				ON CONFLICT (prefix, account, job_group, id) DO NOTHING`
+			args = []interface{}{s.Prefix, account, group, id, job.Enabled, string(j), job.Version}
+		}
+
+		written, err := s.write(ctx, account, group, id, job.Version, string(j), q, args)
+		if err != nil {
+			return nil, err
+		}
+
+		if written {
+			return job, nil
+		}
+
+		log.Warnf("job %s/%s/%s was modified while it was updated", account, group, id)
+
+		// a conditional update doesn't retry, the job is no longer at the expected version
+		if expected != 0 {
+			break
+		}
+	}
+
+	job.Version = expected
+	msg := fmt.Sprintf("job %s was modified by another writer while it was updated", id)
+	return nil, apierror.New(apierror.ErrConflict, msg, nil)
+}
+
+// write executes the conditional write of a job and records the revision in the same transaction.  It returns
+// false if the write didn't apply because another writer modified the job.
+func (s *SQLRepository) write(ctx context.Context, account, group, id string, version int64, doc, q string, args []interface{}) (bool, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, apierror.New(apierror.ErrInternalError, "failed to put job "+id, err)
+	}
+
+	res, err := tx.ExecContext(ctx, rebind(s.Dialect, q), args...)
+	if err != nil {
+		tx.Rollback()
+		return false, apierror.New(apierror.ErrInternalError, "failed to put job "+id, err)
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		tx.Rollback()
+		if err != nil {
+			return false, apierror.New(apierror.ErrInternalError, "failed to put job "+id, err)
+		}
+		return false, nil
+	}
+
+	rq := rebind(s.Dialect, `INSERT INTO minion_job_revisions (prefix, account, job_group, id, version, job) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (prefix, account, job_group, id, version) DO UPDATE SET job = excluded.job`)
+	if _, err := tx.ExecContext(ctx, rq, s.Prefix, account, group, id, version, doc); err != nil {
+		tx.Rollback()
+		return false, apierror.New(apierror.ErrInternalError, "failed to put revision of job "+id, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, apierror.New(apierror.ErrInternalError, "failed to put job "+id, err)
+	}
+
+	return true, nil
+}
+
+// Revisions returns the revision history of a job in the sql jobs repository, most recent first
+func (s *SQLRepository) Revisions(ctx context.Context, account, group, id string) ([]*Revision, error) {
+	if account == "" || group == "" || id == "" {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Infof("listing revisions of job %s/%s/%s", account, group, id)
+
+	q := rebind(s.Dialect, `SELECT version, job FROM minion_job_revisions WHERE prefix = ? AND account = ? AND job_group = ? AND id = ?
+		ORDER BY version DESC`)
+
+	rows, err := s.DB.QueryContext(ctx, q, s.Prefix, account, group, id)
+	if err != nil {
+		return nil, apierror.New(apierror.ErrInternalError, "failed to list revisions", err)
+	}
+	defer rows.Close()
+
+	revisions := []*Revision{}
+	for rows.Next() {
+		var version int64
+		var doc string
+		if err := rows.Scan(&version, &doc); err != nil {
+			return nil, apierror.New(apierror.ErrInternalError, "failed to list revisions", err)
+		}
+
+		job := &Job{}
+		if err := json.Unmarshal([]byte(doc), job); err != nil {
+			log.Errorf("error decoding revision %d of job %s: %s", version, id, err)
+			continue
+		}
+		revisions = append(revisions, newRevision(strconv.FormatInt(version, 10), job))
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, apierror.New(apierror.ErrInternalError, "failed to list revisions", err)
+	}
+
+	return revisions, nil
+}
+
+// DeleteVersion deletes a job in the sql jobs repository if it's still at the given version
+func (s *SQLRepository) DeleteVersion(ctx context.Context, account, group, id string, version int64) error {
+	if account == "" || group == "" || id == "" {
+		return apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Infof("deleting job version %d from sql repository %s/%s/%s", version, account, group, id)
+
+	q := rebind(s.Dialect, "DELETE FROM minion_jobs WHERE prefix = ? AND account = ? AND job_group = ? AND id = ? AND version = ?")
+	res, err := s.DB.ExecContext(ctx, q, s.Prefix, account, group, id, version)
+	if err != nil {
+		return apierror.New(apierror.ErrInternalError, "failed to delete job "+id, err)
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return apierror.New(apierror.ErrInternalError, "failed to delete job "+id, err)
+	} else if n == 1 {
+		return nil
+	}
+
+	current, exists, err := s.version(ctx, account, group, id)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return apierror.New(apierror.ErrNotFound, "job not found "+id, nil)
+	}
+
+	return staleVersionError(id, version, current)
+}
+
+// Trash moves a job, or all of the jobs in the group if the id is empty, to the minion_job_trash table in a
+// single transaction.  A job modified while it's trashed fails the whole transaction with a conflict error.
+func (s *SQLRepository) Trash(ctx context.Context, account, group, id string, version int64) error {
+	if account == "" || group == "" || (id == "" && version != 0) {
+		return apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Infof("trashing job in sql repository %s/%s/%s", account, group, id)
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return apierror.New(apierror.ErrInternalError, "failed to trash jobs", err)
+	}
+	defer tx.Rollback()
+
+	q := "SELECT id, version, job FROM minion_jobs WHERE prefix = ? AND account = ? AND job_group = ?"
+	args := []interface{}{s.Prefix, account, group}
+	if id != "" {
+		q = q + " AND id = ?"
+		args = append(args, id)
+	}
+
+	rows, err := tx.QueryContext(ctx, rebind(s.Dialect, q), args...)
+	if err != nil {
+		return apierror.New(apierror.ErrInternalError, "failed to trash jobs", err)
+	}
+
+	type row struct {
+		id      string
+		version int64
+		job     *Job
+	}
+
+	// the rows are read before writing, a sqlite transaction only has a single connection
+	trashed := []row{}
+	for rows.Next() {
+		var r row
+		var doc string
+		if err := rows.Scan(&r.id, &r.version, &doc); err != nil {
+			rows.Close()
+			return apierror.New(apierror.ErrInternalError, "failed to trash jobs", err)
+		}
+
+		r.job = &Job{}
+		if err := json.Unmarshal([]byte(doc), r.job); err != nil {
+			rows.Close()
+			return apierror.New(apierror.ErrBadRequest, "failed to decode json from sql", err)
+		}
+		trashed = append(trashed, r)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return apierror.New(apierror.ErrInternalError, "failed to trash jobs", err)
+	}
+
+	if id != "" {
+		if len(trashed) == 0 {
+			return apierror.New(apierror.ErrNotFound, "job not found "+id, nil)
+		}
+
+		if version != 0 && trashed[0].version != version {
+			return staleVersionError(id, version, trashed[0].version)
+		}
+	}
+
+	tq := rebind(s.Dialect, `INSERT INTO minion_job_trash (prefix, account, job_group, id, deleted_at, job) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (prefix, account, job_group, id) DO UPDATE SET deleted_at = excluded.deleted_at, job = excluded.job`)
+	dq := rebind(s.Dialect, "DELETE FROM minion_jobs WHERE prefix = ? AND account = ? AND job_group = ? AND id = ? AND version = ?")
+
+	for _, r := range trashed {
+		t := newTrashedJob(group, r.job)
+		j, err := json.Marshal(t)
+		if err != nil {
+			return apierror.New(apierror.ErrBadRequest, "invalid input", err)
+		}
+
+		if _, err := tx.ExecContext(ctx, tq, s.Prefix, account, group, r.id, t.DeletedAt, string(j)); err != nil {
+			return apierror.New(apierror.ErrInternalError, "failed to trash job "+r.id, err)
+		}
+
+		res, err := tx.ExecContext(ctx, dq, s.Prefix, account, group, r.id, r.version)
+		if err != nil {
+			return apierror.New(apierror.ErrInternalError, "failed to trash job "+r.id, err)
+		}
+
+		if n, err := res.RowsAffected(); err != nil {
+			return apierror.New(apierror.ErrInternalError, "failed to trash job "+r.id, err)
+		} else if n != 1 {
+			msg := fmt.Sprintf("job %s was modified by another writer while it was trashed", r.id)
+			return apierror.New(apierror.ErrConflict, msg, nil)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return apierror.New(apierror.ErrInternalError, "failed to trash jobs", err)
+	}
+
+	return nil
+}
+
+// ListTrash lists the trashed jobs in the sql jobs repository.  If group is empty, the trashed jobs of the
+// whole account are returned.
+func (s *SQLRepository) ListTrash(ctx context.Context, account, group string) ([]*TrashedJob, error) {
+	if account == "" {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Infof("listing trashed jobs for account '%s', group '%s'", account, group)
+
+	q := "SELECT job_group, id, job FROM minion_job_trash WHERE prefix = ? AND account = ?"
+	args := []interface{}{s.Prefix, account}
+	if group != "" {
+		q = q + " AND job_group = ?"
+		args = append(args, group)
+	}
+	q = q + " ORDER BY job_group, id"
+
+	rows, err := s.DB.QueryContext(ctx, rebind(s.Dialect, q), args...)
+	if err != nil {
+		return nil, apierror.New(apierror.ErrInternalError, "failed to list trashed jobs", err)
+	}
+	defer rows.Close()
+
+	trashed := []*TrashedJob{}
+	for rows.Next() {
+		var g, id, doc string
+		if err := rows.Scan(&g, &id, &doc); err != nil {
+			return nil, apierror.New(apierror.ErrInternalError, "failed to list trashed jobs", err)
+		}
+
+		t := &TrashedJob{}
+		if err := json.Unmarshal([]byte(doc), t); err != nil {
+			log.Errorf("error decoding trashed job '%s/%s': %s", g, id, err)
+			continue
+		}
+		trashed = append(trashed, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, apierror.New(apierror.ErrInternalError, "failed to list trashed jobs", err)
+	}
+
+	return trashed, nil
+}
+
+// RestoreTrash moves a trashed job, or all of the trashed jobs in the group if the id is empty, back into the
+// minion_jobs table in a single transaction
+func (s *SQLRepository) RestoreTrash(ctx context.Context, account, group, id string) ([]*Job, error) {
+	if account == "" || group == "" {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Infof("restoring trashed job in sql repository %s/%s/%s", account, group, id)
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, apierror.New(apierror.ErrInternalError, "failed to restore jobs", err)
+	}
+	defer tx.Rollback()
+
+	q := "SELECT id, job FROM minion_job_trash WHERE prefix = ? AND account = ? AND job_group = ?"
+	args := []interface{}{s.Prefix, account, group}
+	if id != "" {
+		q = q + " AND id = ?"
+		args = append(args, id)
+	}
+	q = q + " ORDER BY id"
+
+	rows, err := tx.QueryContext(ctx, rebind(s.Dialect, q), args...)
+	if err != nil {
+		return nil, apierror.New(apierror.ErrInternalError, "failed to restore jobs", err)
+	}
+
+	ids := []string{}
+	restored := []*Job{}
+	for rows.Next() {
+		var tid, doc string
+		if err := rows.Scan(&tid, &doc); err != nil {
+			rows.Close()
+			return nil, apierror.New(apierror.ErrInternalError, "failed to restore jobs", err)
+		}
+
+		t := &TrashedJob{}
+		if err := json.Unmarshal([]byte(doc), t); err != nil {
+			rows.Close()
+			return nil, apierror.New(apierror.ErrBadRequest, "failed to decode json from sql", err)
+		}
+		ids = append(ids, tid)
+		restored = append(restored, t.Job)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, apierror.New(apierror.ErrInternalError, "failed to restore jobs", err)
+	}
+
+	if id != "" && len(restored) == 0 {
+		return nil, apierror.New(apierror.ErrNotFound, "trashed job not found "+id, nil)
+	}
+
+	iq := rebind(s.Dialect, `INSERT INTO minion_jobs (prefix, account, job_group, id, enabled, job, version) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (prefix, account, job_group, id) DO NOTHING`)
+	dq := rebind(s.Dialect, "DELETE FROM minion_job_trash WHERE prefix = ? AND account = ? AND job_group = ? AND id = ?")
+
+	for i, job := range restored {
+		j, err := json.Marshal(job)
+		if err != nil {
+			return nil, apierror.New(apierror.ErrBadRequest, "invalid input", err)
+		}
+
+		res, err := tx.ExecContext(ctx, iq, s.Prefix, account, group, ids[i], job.Enabled, string(j), job.Version)
+		if err != nil {
+			return nil, apierror.New(apierror.ErrInternalError, "failed to restore job "+ids[i], err)
+		}
+
+		if n, err := res.RowsAffected(); err != nil {
+			return nil, apierror.New(apierror.ErrInternalError, "failed to restore job "+ids[i], err)
+		} else if n != 1 {
+			return nil, apierror.New(apierror.ErrConflict, "cannot restore job, it already exists "+ids[i], nil)
+		}
+
+		if _, err := tx.ExecContext(ctx, dq, s.Prefix, account, group, ids[i]); err != nil {
+			return nil, apierror.New(apierror.ErrInternalError, "failed to restore job "+ids[i], err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, apierror.New(apierror.ErrInternalError, "failed to restore jobs", err)
+	}
+
+	return restored, nil
+}
+
+// PurgeTrash permanently removes a trashed job, or all of the trashed jobs in the group if the id is empty, and
+// their revision history from the sql jobs repository
+func (s *SQLRepository) PurgeTrash(ctx context.Context, account, group, id string) error {
+	if account == "" || group == "" {
+		return apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Warnf("purging trashed job from sql repository %s/%s/%s", account, group, id)
+
+	where := "prefix = ? AND account = ? AND job_group = ?"
+	args := []interface{}{s.Prefix, account, group}
+	if id != "" {
+		where = where + " AND id = ?"
+		args = append(args, id)
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return apierror.New(apierror.ErrInternalError, "failed to purge trashed jobs", err)
+	}
+	defer tx.Rollback()
+
+	// only the revisions of the trashed jobs are purged, not the revisions of the jobs left in the group
+	rq := "DELETE FROM minion_job_revisions WHERE " + where + " AND id IN (SELECT id FROM minion_job_trash WHERE " + where + ")"
+	if _, err := tx.ExecContext(ctx, rebind(s.Dialect, rq), append(args, args...)...); err != nil {
+		return apierror.New(apierror.ErrInternalError, "failed to purge revisions of trashed jobs", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, rebind(s.Dialect, "DELETE FROM minion_job_trash WHERE "+where), args...); err != nil {
+		return apierror.New(apierror.ErrInternalError, "failed to purge trashed jobs", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return apierror.New(apierror.ErrInternalError, "failed to purge trashed jobs", err)
+	}
+
+	return nil
+}
+
+// version returns the stored version of a job and whether the job exists
+func (s *SQLRepository) version(ctx context.Context, account, group, id string) (int64, bool, error) {
+	q := rebind(s.Dialect, "SELECT version FROM minion_jobs WHERE prefix = ? AND account = ? AND job_group = ? AND id = ?")
+
+	var version int64
+	if err := s.DB.QueryRowContext(ctx, q, s.Prefix, account, group, id).Scan(&version); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, false, nil
+		}
+		return 0, false, apierror.New(apierror.ErrInternalError, "failed to get version of job "+id, err)
+	}
+
+	return version, true, nil
+}
@@ -0,0 +1,314 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/YaleSpinup/apierror"
+)
+
+func newTestSQLRepository(t *testing.T) *SQLRepository {
+	s, err := NewSQLRepository(map[string]interface{}{
+		"driver": "sqlite",
+		"dsn":    filepath.Join(t.TempDir(), "minion.db"),
+		"prefix": "minion/localdev",
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+	t.Cleanup(func() { s.DB.Close() })
+
+	return s
+}
+
+func TestNewSQLRepository(t *testing.T) {
+	for _, config := range []map[string]interface{}{
+		{},
+		{"driver": "mysql", "dsn": "foo"},
+		{"driver": "sqlite"},
+	} {
+		if _, err := NewSQLRepository(config); err == nil {
+			t.Errorf("expected error for config %+v, got nil", config)
+		}
+	}
+
+	dsn := filepath.Join(t.TempDir(), "minion.db")
+	for i := 0; i < 2; i++ {
+		s, err := NewSQLRepository(map[string]interface{}{"driver": "sqlite", "dsn": dsn})
+		if err != nil {
+			t.Fatalf("expected nil error, got %s", err)
+		}
+
+		var version int
+		if err := s.DB.QueryRow("SELECT MAX(version) FROM minion_schema_migrations").Scan(&version); err != nil {
+			t.Fatalf("expected nil error, got %s", err)
+		}
+
+		if version != len(sqlMigrations) {
+			t.Errorf("expected schema version %d, got %d", len(sqlMigrations), version)
+		}
+		s.DB.Close()
+	}
+}
+
+func TestMigrateSQLConcurrently(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "minion.db")
+
+	// nodes starting at the same time don't apply the same migration twice
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		go func() {
+			s, err := NewSQLRepository(map[string]interface{}{"driver": "sqlite", "dsn": dsn})
+			if err == nil {
+				s.DB.Close()
+			}
+			errs <- err
+		}()
+	}
+
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("expected nil error migrating concurrently, got %s", err)
+		}
+	}
+
+	s, err := NewSQLRepository(map[string]interface{}{"driver": "sqlite", "dsn": dsn})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+	defer s.DB.Close()
+
+	var count, version int
+	if err := s.DB.QueryRow("SELECT COUNT(*), MAX(version) FROM minion_schema_migrations").Scan(&count, &version); err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if count != len(sqlMigrations) || version != len(sqlMigrations) {
+		t.Errorf("expected %d migrations applied once, got %d up to version %d", len(sqlMigrations), count, version)
+	}
+}
+
+func TestRebind(t *testing.T) {
+	q := "SELECT job FROM minion_jobs WHERE prefix = ? AND account = ?"
+
+	if out := rebind(SQLDialectSQLite, q); out != q {
+		t.Errorf("expected sqlite query to be unchanged, got %s", out)
+	}
+
+	expected := "SELECT job FROM minion_jobs WHERE prefix = $1 AND account = $2"
+	if out := rebind(SQLDialectPostgres, q); out != expected {
+		t.Errorf("expected %s, got %s", expected, out)
+	}
+}
+
+func TestSQLRepository(t *testing.T) {
+	s := newTestSQLRepository(t)
+
+	job := &Job{Description: "first studio album", Details: map[string]string{"runner": "dummy"}, Enabled: true, ScheduleExpression: "00 00 25 07 *"}
+	created, err := s.Create(context.TODO(), "metal", "metallica", job)
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if created.ID == "" || created.ModifiedAt == nil {
+		t.Errorf("expected job with id and modified at, got %+v", created)
+	}
+
+	out, err := s.Get(context.TODO(), "metal", "metallica", created.ID)
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if !reflect.DeepEqual(out, created) {
+		t.Errorf("expected %+v, got %+v", created, out)
+	}
+
+	created.Description = "updated"
+	if _, err := s.Update(context.TODO(), "metal", "metallica", created.ID, created); err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if out, _ := s.Get(context.TODO(), "metal", "metallica", created.ID); out.Description != "updated" {
+		t.Errorf("expected updated description, got %+v", out)
+	}
+
+	other, err := s.Create(context.TODO(), "metal", "megadeth", &Job{Description: "peace sells", Details: map[string]string{"runner": "dummy"}, ScheduleExpression: "00 00 29 09 *"})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	// same ids in another prefix aren't visible
+	foreign := &SQLRepository{DB: s.DB, Dialect: s.Dialect, Prefix: "minion/other"}
+	if _, err := foreign.Create(context.TODO(), "metal", "metallica", &Job{Details: map[string]string{"runner": "dummy"}, ScheduleExpression: "00 00 25 07 *"}); err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	list, err := s.List(context.TODO(), "metal", "")
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	expected := []string{"megadeth/" + other.ID, "metallica/" + created.ID}
+	if !reflect.DeepEqual(list, expected) {
+		t.Errorf("expected list %v, got %v", expected, list)
+	}
+
+	if list, err := s.List(context.TODO(), "metal", "metallica"); err != nil || !reflect.DeepEqual(list, []string{created.ID}) {
+		t.Errorf("expected group list [%s], got %v (%v)", created.ID, list, err)
+	}
+
+	if list, err := s.List(context.TODO(), "punk", ""); err != nil || len(list) != 0 {
+		t.Errorf("expected empty list for an account without jobs, got %v (%v)", list, err)
+	}
+
+	all, err := s.ListJobs(context.TODO(), "metal", false)
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if len(all) != 2 || all["metallica/"+created.ID].Description != "updated" || all["megadeth/"+other.ID] == nil {
+		t.Errorf("expected both jobs with details, got %+v", all)
+	}
+
+	enabled, err := s.ListJobs(context.TODO(), "metal", true)
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if len(enabled) != 1 || enabled["metallica/"+created.ID] == nil {
+		t.Errorf("expected only the enabled job, got %+v", enabled)
+	}
+
+	if err := s.Delete(context.TODO(), "metal", "metallica", created.ID); err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	var aerr apierror.Error
+	if _, err := s.Get(context.TODO(), "metal", "metallica", created.ID); !errors.As(err, &aerr) || aerr.Code != apierror.ErrNotFound {
+		t.Errorf("expected not found error for deleted job, got %v", err)
+	}
+
+	if err := s.Delete(context.TODO(), "metal", "megadeth", ""); err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if list, err := s.List(context.TODO(), "metal", ""); err != nil || len(list) != 0 {
+		t.Errorf("expected empty list after deleting the groups, got %v (%v)", list, err)
+	}
+
+	if list, err := foreign.List(context.TODO(), "metal", ""); err != nil || len(list) != 1 {
+		t.Errorf("expected the job in the other prefix to be kept, got %v (%v)", list, err)
+	}
+
+	if _, err := s.Update(context.TODO(), "metal", "metallica", "id", &Job{ID: "other"}); !errors.As(err, &aerr) || aerr.Code != apierror.ErrBadRequest {
+		t.Errorf("expected bad request for mismatched id, got %v", err)
+	}
+}
+
+func TestSQLRepositoryVersions(t *testing.T) {
+	s := newTestSQLRepository(t)
+
+	job, err := s.Create(context.TODO(), "metal", "metallica", &Job{Details: map[string]string{"runner": "dummy"}, ScheduleExpression: "00 00 25 07 *", Version: 7})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if job.Version != 1 {
+		t.Errorf("expected new job at version 1, got %d", job.Version)
+	}
+
+	update := *job
+	if out, err := s.Update(context.TODO(), "metal", "metallica", job.ID, &update); err != nil || out.Version != 2 {
+		t.Fatalf("expected update to version 2, got %+v (%v)", out, err)
+	}
+
+	if out, err := s.Get(context.TODO(), "metal", "metallica", job.ID); err != nil || out.Version != 2 {
+		t.Errorf("expected stored job at version 2, got %+v (%v)", out, err)
+	}
+
+	// the first writer's copy is still at version 1
+	var aerr apierror.Error
+	if _, err := s.Update(context.TODO(), "metal", "metallica", job.ID, job); !errors.As(err, &aerr) || aerr.Code != apierror.ErrConflict {
+		t.Errorf("expected conflict for a stale version, got %v", err)
+	}
+
+	if job.Version != 1 {
+		t.Errorf("expected the rejected job to keep its version, got %d", job.Version)
+	}
+
+	// an unconditional update always applies
+	job.Version = 0
+	if out, err := s.Update(context.TODO(), "metal", "metallica", job.ID, job); err != nil || out.Version != 3 {
+		t.Errorf("expected unconditional update to version 3, got %+v (%v)", out, err)
+	}
+
+	if err := s.DeleteVersion(context.TODO(), "metal", "metallica", job.ID, 2); !errors.As(err, &aerr) || aerr.Code != apierror.ErrConflict {
+		t.Errorf("expected conflict deleting a stale version, got %v", err)
+	}
+
+	if err := s.DeleteVersion(context.TODO(), "metal", "metallica", job.ID, 3); err != nil {
+		t.Errorf("expected nil error deleting the current version, got %s", err)
+	}
+
+	if err := s.DeleteVersion(context.TODO(), "metal", "metallica", job.ID, 3); !errors.As(err, &aerr) || aerr.Code != apierror.ErrNotFound {
+		t.Errorf("expected not found deleting a deleted job, got %v", err)
+	}
+}
+
+func TestSQLRepositoryRevisions(t *testing.T) {
+	s := newTestSQLRepository(t)
+
+	job, err := s.Create(context.TODO(), "metal", "metallica", &Job{Description: "first", Details: map[string]string{"runner": "dummy"}, ScheduleExpression: "00 00 25 07 *"})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	job.Description = "second"
+	if _, err := s.Update(context.TODO(), "metal", "metallica", job.ID, job); err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	// a rejected update isn't a revision
+	stale := *job
+	stale.Version = 1
+	if _, err := s.Update(context.TODO(), "metal", "metallica", job.ID, &stale); err == nil {
+		t.Fatal("expected conflict for a stale version, got nil")
+	}
+
+	revisions, err := s.Revisions(context.TODO(), "metal", "metallica", job.ID)
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if len(revisions) != 2 || revisions[0].ID != "2" || revisions[0].Job.Description != "second" || revisions[1].ID != "1" || revisions[1].Job.Description != "first" {
+		t.Errorf("expected revisions 2 and 1 most recent first, got %+v", revisions)
+	}
+
+	if revisions, err := s.Revisions(context.TODO(), "metal", "metallica", "job2"); err != nil || len(revisions) != 0 {
+		t.Errorf("expected no revisions of a missing job, got %+v (%v)", revisions, err)
+	}
+}
+
+func TestSQLRepositoryTrash(t *testing.T) {
+	s := newTestSQLRepository(t)
+
+	testTrash(t, s)
+
+	// the revision history of purged jobs is purged with them
+	var purged, left int
+	if err := s.DB.QueryRow("SELECT COUNT(*) FROM minion_job_revisions WHERE job_group = 'metallica'").Scan(&purged); err != nil || purged != 0 {
+		t.Errorf("expected no revisions left in the purged group, got %d (%v)", purged, err)
+	}
+
+	if err := s.DB.QueryRow("SELECT COUNT(*) FROM minion_job_revisions WHERE job_group = 'megadeth'").Scan(&left); err != nil || left != 1 {
+		t.Errorf("expected the revisions of the job left in the account, got %d (%v)", left, err)
+	}
+
+	// trashed jobs aren't loaded
+	if loaded, err := s.ListJobs(context.TODO(), "metal", false); err != nil || len(loaded) != 1 {
+		t.Errorf("expected only the job left in the account to be loaded, got %+v (%v)", loaded, err)
+	}
+}
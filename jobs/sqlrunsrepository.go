@@ -0,0 +1,181 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/YaleSpinup/apierror"
+	log "github.com/sirupsen/logrus"
+)
+
+// SQLRunsRepository is an implementation of a runs repository in a sql database (postgres or sqlite).  Runs
+// are stored as JSON documents in the minion_runs table, indexed by job and start time.
+type SQLRunsRepository struct {
+	DB      *sql.DB
+	Dialect string
+	Prefix  string
+}
+
+// NewSQLRunsRepository creates a new runs repository from the config data.  The configuration is the same
+// as the sql jobs repository.
+func NewSQLRunsRepository(config map[string]interface{}) (*SQLRunsRepository, error) {
+	log.Debug("creating new sql runs repository")
+
+	s, err := NewSQLRepository(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SQLRunsRepository{
+		DB:      s.DB,
+		Dialect: s.Dialect,
+		Prefix:  s.Prefix,
+	}, nil
+}
+
+// Get gets a run from the sql runs repository
+func (s *SQLRunsRepository) Get(ctx context.Context, account, group, jobID, id string) (*Run, error) {
+	if account == "" || group == "" || jobID == "" || id == "" {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Debugf("getting run (account: %s, group: %s, job id: %s, id: %s)", account, group, jobID, id)
+
+	q := rebind(s.Dialect, "SELECT run FROM minion_runs WHERE prefix = ? AND account = ? AND job_group = ? AND job_id = ? AND id = ?")
+
+	var doc string
+	if err := s.DB.QueryRowContext(ctx, q, s.Prefix, account, group, jobID, id).Scan(&doc); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, apierror.New(apierror.ErrNotFound, "run not found "+id, err)
+		}
+		return nil, apierror.New(apierror.ErrInternalError, "failed to get run "+id, err)
+	}
+
+	run := &Run{}
+	if err := json.Unmarshal([]byte(doc), run); err != nil {
+		return nil, apierror.New(apierror.ErrBadRequest, "failed to decode json from sql", err)
+	}
+
+	return run, nil
+}
+
+// List lists the runs of a job in the sql runs repository, most recently started first
+func (s *SQLRunsRepository) List(ctx context.Context, account, group, jobID string, limit int) ([]*Run, error) {
+	if account == "" || group == "" || jobID == "" {
+		return nil, apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Infof("listing runs for job %s/%s/%s", account, group, jobID)
+
+	// runs that never started sort last
+	q := `SELECT id, run FROM minion_runs WHERE prefix = ? AND account = ? AND job_group = ? AND job_id = ?
+		ORDER BY started_at IS NULL, started_at DESC`
+	args := []interface{}{s.Prefix, account, group, jobID}
+	if limit > 0 {
+		q += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := s.DB.QueryContext(ctx, rebind(s.Dialect, q), args...)
+	if err != nil {
+		return nil, apierror.New(apierror.ErrInternalError, "failed to list runs", err)
+	}
+	defer rows.Close()
+
+	runs := []*Run{}
+	for rows.Next() {
+		var id, doc string
+		if err := rows.Scan(&id, &doc); err != nil {
+			return nil, apierror.New(apierror.ErrInternalError, "failed to list runs", err)
+		}
+
+		run := &Run{}
+		if err := json.Unmarshal([]byte(doc), run); err != nil {
+			log.Errorf("error decoding run '%s': %s", id, err)
+			continue
+		}
+		runs = append(runs, run)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, apierror.New(apierror.ErrInternalError, "failed to list runs", err)
+	}
+
+	return runs, nil
+}
+
+// Put creates or updates a run in the sql runs repository
+func (s *SQLRunsRepository) Put(ctx context.Context, run *Run) error {
+	if run == nil || run.Account == "" || run.Group == "" || run.JobID == "" || run.ID == "" {
+		return apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Debugf("putting run %s/%s/%s/%s with %+v", run.Account, run.Group, run.JobID, run.ID, run)
+
+	j, err := json.Marshal(run)
+	if err != nil {
+		return apierror.New(apierror.ErrBadRequest, "invalid input", err)
+	}
+
+	var startedAt interface{}
+	if run.StartedAt != nil {
+		startedAt = run.StartedAt.UTC()
+	}
+
+	q := rebind(s.Dialect, `INSERT INTO minion_runs (prefix, account, job_group, job_id, id, started_at, run) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (prefix, account, job_group, job_id, id) DO UPDATE SET started_at = excluded.started_at, run = excluded.run`)
+
+	if _, err := s.DB.ExecContext(ctx, q, s.Prefix, run.Account, run.Group, run.JobID, run.ID, startedAt, string(j)); err != nil {
+		return apierror.New(apierror.ErrInternalError, "failed to put run "+run.ID, err)
+	}
+
+	return nil
+}
+
+// Prune removes the runs of a job beyond the newest keep runs and the runs started before the given time from the
+// sql runs repository
+func (s *SQLRunsRepository) Prune(ctx context.Context, account, group, jobID string, keep int, before time.Time) error {
+	if account == "" || group == "" || jobID == "" {
+		return apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	if keep > 0 {
+		q := rebind(s.Dialect, `DELETE FROM minion_runs WHERE prefix = ? AND account = ? AND job_group = ? AND job_id = ? AND id NOT IN (
+			SELECT id FROM minion_runs WHERE prefix = ? AND account = ? AND job_group = ? AND job_id = ?
+			ORDER BY started_at IS NULL, started_at DESC LIMIT ?)`)
+
+		if _, err := s.DB.ExecContext(ctx, q, s.Prefix, account, group, jobID, s.Prefix, account, group, jobID, keep); err != nil {
+			return apierror.New(apierror.ErrInternalError, "failed to prune runs of job "+jobID, err)
+		}
+	}
+
+	if !before.IsZero() {
+		q := rebind(s.Dialect, "DELETE FROM minion_runs WHERE prefix = ? AND account = ? AND job_group = ? AND job_id = ? AND started_at < ?")
+
+		if _, err := s.DB.ExecContext(ctx, q, s.Prefix, account, group, jobID, before.UTC()); err != nil {
+			return apierror.New(apierror.ErrInternalError, "failed to prune runs of job "+jobID, err)
+		}
+	}
+
+	return nil
+}
+
+// Delete removes all of the runs of a job from the sql runs repository
+func (s *SQLRunsRepository) Delete(ctx context.Context, account, group, jobID string) error {
+	if account == "" || group == "" || jobID == "" {
+		return apierror.New(apierror.ErrBadRequest, "invalid input", errors.New("empty input"))
+	}
+
+	log.Infof("deleting runs of job %s/%s/%s", account, group, jobID)
+
+	q := rebind(s.Dialect, "DELETE FROM minion_runs WHERE prefix = ? AND account = ? AND job_group = ? AND job_id = ?")
+
+	if _, err := s.DB.ExecContext(ctx, q, s.Prefix, account, group, jobID); err != nil {
+		return apierror.New(apierror.ErrInternalError, "failed to delete runs of job "+jobID, err)
+	}
+
+	return nil
+}
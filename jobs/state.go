@@ -0,0 +1,68 @@
+package jobs
+
+import (
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// StateStore keeps state for runners between runs, ie. the desired count of a service before it was scaled down
+type StateStore interface {
+	GetState(key string) (string, bool, error)
+	SetState(key, value string) error
+	DeleteState(key string) error
+}
+
+// Stateful is implemented by runners that keep state between runs.  The state store is set when the runner is
+// configured.
+type Stateful interface {
+	SetStateStore(store StateStore)
+}
+
+// RedisStateStore is a redis state store.  The state is stored in a redis hash keyed by the runner state key.
+type RedisStateStore struct {
+	client *redis.Client
+	Name   string
+}
+
+// NewRedisStateStore returns a new redis state store
+func NewRedisStateStore(name, address, password string, db int) (*RedisStateStore, error) {
+	return &RedisStateStore{
+		Name: name,
+		client: redis.NewClient(&redis.Options{
+			Addr:       address,
+			PoolSize:   5,
+			MaxRetries: 2,
+			Password:   password,
+			DB:         db,
+		}),
+	}, nil
+}
+
+// GetState returns the state stored for the key and true, or false if there's no state for the key
+func (r *RedisStateStore) GetState(key string) (string, bool, error) {
+	out, err := r.client.HGet(r.Name, key).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return "", false, nil
+		}
+		return "", false, errors.Wrap(err, "failed getting state "+key)
+	}
+
+	return out, true, nil
+}
+
+// SetState stores the state for the key
+func (r *RedisStateStore) SetState(key, value string) error {
+	if err := r.client.HSet(r.Name, key, value).Err(); err != nil {
+		return errors.Wrap(err, "failed setting state "+key)
+	}
+	return nil
+}
+
+// DeleteState removes the state for the key
+func (r *RedisStateStore) DeleteState(key string) error {
+	if err := r.client.HDel(r.Name, key).Err(); err != nil {
+		return errors.Wrap(err, "failed deleting state "+key)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package jobs
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// mockStateStore is an in memory state store
+type mockStateStore struct {
+	mux   sync.Mutex
+	state map[string]string
+	err   error
+}
+
+func (m *mockStateStore) GetState(key string) (string, bool, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if m.err != nil {
+		return "", false, m.err
+	}
+
+	v, ok := m.state[key]
+	return v, ok, nil
+}
+
+func (m *mockStateStore) SetState(key, value string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if m.err != nil {
+		return m.err
+	}
+
+	if m.state == nil {
+		m.state = make(map[string]string)
+	}
+	m.state[key] = value
+	return nil
+}
+
+func (m *mockStateStore) DeleteState(key string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if m.err != nil {
+		return m.err
+	}
+
+	delete(m.state, key)
+	return nil
+}
+
+func TestNewRedisStateStore(t *testing.T) {
+	r, err := NewRedisStateStore("foo", "127.0.0.1:6379", "", 0)
+	if err != nil {
+		t.Errorf("expected nil error, got %s", err)
+	}
+
+	if to := reflect.TypeOf(r).String(); to != "*jobs.RedisStateStore" {
+		t.Errorf("expected type to be '*jobs.RedisStateStore, got %s", to)
+	}
+}
@@ -6,9 +6,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -21,14 +24,61 @@ type TaskRunner struct {
 	Token            string
 	Encrypt          bool
 	AuthHeader       string
+	Waiter           *TaskWaiter
+	Client           *HTTPClient
+	Signer           *Signer
 }
 
 type TaskRunnerRunInput struct {
-	Account  string
-	Cluster  string
-	Name     string
-	count    int
-	endpoint string
+	Account   string
+	Cluster   string
+	Name      string
+	count     int
+	endpoint  string
+	overrides *TaskOverrides
+	wait      bool
+}
+
+// TaskOverrides are the overrides of a container of the task definition for a run
+type TaskOverrides struct {
+	ContainerOverrides []TaskContainerOverride `json:"ContainerOverrides"`
+}
+
+// TaskContainerOverride overrides the command and environment of a container
+type TaskContainerOverride struct {
+	Name        string            `json:"Name"`
+	Command     []string          `json:"Command,omitempty"`
+	Environment []TaskEnvironment `json:"Environment,omitempty"`
+}
+
+// TaskEnvironment is an environment variable of a container override
+type TaskEnvironment struct {
+	Name  string `json:"Name"`
+	Value string `json:"Value"`
+}
+
+// TaskWaiter polls the status endpoint of the tasks started by a run until they stop or the timeout passes
+type TaskWaiter struct {
+	EndpointTemplate string
+	Interval         time.Duration
+	Timeout          time.Duration
+}
+
+// taskEnvPrefix is the prefix of the details set as environment variables of the container override
+const taskEnvPrefix = "env_"
+
+func init() {
+	RegisterRunner(RunnerType{
+		RunnerDescription: RunnerDescription{
+			Type:            "task",
+			Description:     "runs tasks from a container task definition",
+			Actions:         []string{"run"},
+			RequiredDetails: []string{"task_action", "task_cluster", "task_name", "count"},
+		},
+		New: func(config map[string]interface{}) (Runner, error) {
+			return NewTaskRunner(config)
+		},
+	})
 }
 
 // NewTaskRunner creates and configures a new task runner.  An endpoint or endpoint template is required
@@ -70,33 +120,123 @@ func NewTaskRunner(config map[string]interface{}) (*TaskRunner, error) {
 		authHeader = h
 	}
 
+	waiter, err := newTaskWaiter(config["wait"])
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := newSigner(config, token)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newHTTPClient(config["http"])
+	if err != nil {
+		return nil, err
+	}
+
 	return &TaskRunner{
 		Endpoint:         endpoint,
 		EndpointTemplate: endpointTemplate,
 		Token:            token,
 		Encrypt:          encrypt,
 		AuthHeader:       authHeader,
+		Waiter:           waiter,
+		Client:           client,
+		Signer:           signer,
 	}, nil
 }
 
+// newTaskWaiter configures waiting for the tasks to stop from the wait section of the runner configuration.  If
+// the wait section isn't set, nil is returned.
+func newTaskWaiter(config interface{}) (*TaskWaiter, error) {
+	if config == nil {
+		return nil, nil
+	}
+
+	c, ok := config.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("wait must be a map")
+	}
+
+	w := &TaskWaiter{
+		Interval: 10 * time.Second,
+		Timeout:  30 * time.Minute,
+	}
+
+	if t, ok := c["endpointTemplate"].(string); ok {
+		w.EndpointTemplate = t
+	}
+
+	if w.EndpointTemplate == "" {
+		return nil, errors.New("wait endpointTemplate is required")
+	}
+
+	for _, d := range []struct {
+		key string
+		val *time.Duration
+	}{{"interval", &w.Interval}, {"timeout", &w.Timeout}} {
+		s, ok := c[d.key].(string)
+		if !ok || s == "" {
+			continue
+		}
+
+		duration, err := time.ParseDuration(s)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid wait %s '%s'", d.key, s)
+		}
+		*d.val = duration
+	}
+
+	return w, nil
+}
+
+// Validate validates the TaskRunner parameters.  The task_action must be 'run' and the task_cluster, task_name
+// and count are required.  The count must be an integer of at least 1.  The container_name is required to
+// override the command or the environment, set with env_ details.  The optional wait parameter can only be
+// 'true' if waiting is configured for the runner.
+func (r *TaskRunner) Validate(parameters interface{}) error {
+	v := newValidator(parameters)
+	v.oneOf("task_action", "run")
+	v.required("task_cluster")
+	v.required("task_name")
+	v.integer("count", 1)
+
+	if _, err := taskOverrides(v.params); err != nil {
+		v.problem("%s", err)
+	}
+
+	if wait, ok := v.boolean("wait"); ok && wait && r.Waiter == nil {
+		v.problem("wait is not configured for the runner")
+	}
+
+	return v.err()
+}
+
 // Run executes the TaskRunner.  The task_cluster, task_name and task_action are required.  Allowable
 // actions are 'run'.  If an endpoint is configured on the runner, it will be used, otherwise
 // we assume there is an endpointTemplate and try to execute it.
 func (r *TaskRunner) Run(ctx context.Context, account string, parameters interface{}) (string, error) {
+	return runResult(r.RunResult(ctx, account, parameters))
+}
+
+// RunResult executes the TaskRunner like Run and returns a structured result with the task arns, the status code
+// and an excerpt of the response.  When waiting for the tasks, the status of each task is reported in the data.
+func (r *TaskRunner) RunResult(ctx context.Context, account string, parameters interface{}) (*Result, error) {
 	if account == "" {
-		return "", errors.New("account is required")
+		return nil, errors.New("account is required")
 	}
 
 	log.Debugf("initializing task runner %+v in account %s,  with parameters %+v", r, account, parameters)
 
 	params, ok := parameters.(map[string]string)
 	if !ok {
-		return "", NewRunnerError(ErrMissingDetails, "wrong type parameters list is not a map[string]string", nil)
+		return nil, NewRunnerError(ErrMissingDetails, "wrong type parameters list is not a map[string]string", nil)
 	}
 
 	action, ok := params["task_action"]
 	if !ok {
-		return "", NewRunnerError(ErrMissingDetails, "missing task_action", nil)
+		return nil, NewRunnerError(ErrMissingDetails, "missing task_action", nil)
 	}
 
 	switch action {
@@ -107,80 +247,78 @@ func (r *TaskRunner) Run(ctx context.Context, account string, parameters interfa
 		}
 
 		if err := i.prep(params); err != nil {
-			return "", NewRunnerError(ErrPreExecFailure, "failed to prep input from parameters", err)
+			return nil, NewRunnerError(ErrPreExecFailure, "failed to prep input from parameters", err)
 		}
 
 		if i.endpoint == "" {
 			e, err := execEndpointTemplate(r.EndpointTemplate, i)
 			if err != nil {
-				return "", NewRunnerError(ErrPreExecFailure, "failed set endpoint", err)
+				return nil, NewRunnerError(ErrPreExecFailure, "failed set endpoint", err)
 			}
 			i.endpoint = e
 		}
 
+		if i.wait && r.Waiter == nil {
+			return nil, NewRunnerError(ErrPreExecFailure, "wait is not configured for the runner", nil)
+		}
+
 		inputPayload := struct {
 			Count     int
 			StartedBy string
+			Overrides *TaskOverrides `json:",omitempty"`
 		}{
 			Count:     i.count,
 			StartedBy: "minion",
+			Overrides: i.overrides,
 		}
 
 		j, err := json.Marshal(inputPayload)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
 		log.Debugf("task runner run %s/%s with input %s", i.Cluster, i.Name, string(j))
+		log.Infof("task runner running  %s/%s with count %d", i.Cluster, i.Name, i.count)
 
-		client := &http.Client{
-			Timeout: time.Second * 30,
-		}
-		req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.endpoint, bytes.NewReader(j))
+		result := &Result{}
+		result.SetData("action", action)
+		result.SetData("count", strconv.Itoa(i.count))
+
+		body, err := r.request(ctx, http.MethodPost, i.endpoint, bytes.NewReader(j), result)
 		if err != nil {
-			return "", NewRunnerError(ErrPreExecFailure, "building http request failed", err)
+			return result, err
 		}
 
-		if r.Token != "" {
-			log.Debugf("setting token header %s for %s/%s", r.AuthHeader, i.Cluster, i.Name)
-			if r.Encrypt {
-				e, err := bcrypt.GenerateFromPassword([]byte(r.Token), 6)
-				if err != nil {
-					return "", NewRunnerError(ErrExecFailure, "unable to hash token", err)
-				}
-
-				log.Debug("token is encrypted")
-
-				req.Header.Set(r.AuthHeader, string(e))
-			} else {
-				req.Header.Set(r.AuthHeader, r.Token)
-			}
+		arns, err := taskArns(body)
+		if err != nil {
+			return result, err
 		}
+		result.Targets = arns
 
-		log.Infof("task runner running  %s/%s with count %d", i.Cluster, i.Name, i.count)
-
-		res, err := client.Do(req)
-		if err != nil {
-			return "", NewRunnerError(ErrExecFailure, "http request failed", err)
+		msg := fmt.Sprintf("successfully submitted run task %s/%s with count %d", i.Cluster, i.Name, i.count)
+		if len(arns) > 0 {
+			msg = fmt.Sprintf("%s: %s", msg, strings.Join(arns, ", "))
 		}
-		defer res.Body.Close()
 
-		body, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			return "", NewRunnerError(ErrPostExecFailure, "reading response body failed", err)
+		if !i.wait {
+			result.Message = msg
+			return result, nil
 		}
 
-		log.Debugf("got response %s(%d) for endpoint %s: %s", res.Status, res.StatusCode, i.endpoint, body)
+		if len(arns) == 0 {
+			return result, NewRunnerError(ErrPostExecFailure, "no task arns in the response to wait for", nil)
+		}
 
-		if res.StatusCode >= 300 {
-			return "", NewRunnerError(ErrExecFailure, "unexpected http response", errors.New("unexpected response from taskRunner api: "+res.Status))
+		out, err := r.wait(ctx, i, arns, result)
+		if err != nil {
+			return result, err
 		}
 
-		msg := fmt.Sprintf("successfully submitted run task %s/%s with count %d", i.Cluster, i.Name, i.count)
-		return msg, nil
+		result.Message = msg + "\n" + out
+		return result, nil
 	default:
 		msg := fmt.Sprintf("unexpected task action '%s'", action)
-		return "", NewRunnerError(ErrMissingDetails, msg, nil)
+		return nil, NewRunnerError(ErrMissingDetails, msg, nil)
 	}
 }
 
@@ -220,5 +358,296 @@ func (i *TaskRunnerRunInput) prep(parameters map[string]string) error {
 
 	i.count = count
 
+	overrides, err := taskOverrides(parameters)
+	if err != nil {
+		return NewRunnerError(ErrPreExecFailure, "invalid overrides", err)
+	}
+	i.overrides = overrides
+
+	i.wait = parameters["wait"] == "true"
+
 	return nil
 }
+
+// taskOverrides returns the container overrides from the parameters, or nil if the command or environment
+// aren't overridden.  The command is a JSON list or is split on whitespace and every env_ parameter is an
+// environment variable, ie. env_LOG_LEVEL.
+func taskOverrides(parameters map[string]string) (*TaskOverrides, error) {
+	override := TaskContainerOverride{Name: parameters["container_name"]}
+
+	if c, ok := parameters["command"]; ok {
+		if strings.HasPrefix(strings.TrimSpace(c), "[") {
+			if err := json.Unmarshal([]byte(c), &override.Command); err != nil {
+				log.Debugf("failed to decode command %s: %s", c, err)
+				return nil, errors.New("command must be a JSON list of strings")
+			}
+		} else {
+			override.Command = strings.Fields(c)
+		}
+
+		if len(override.Command) == 0 {
+			return nil, errors.New("command cannot be empty")
+		}
+	}
+
+	for k, v := range parameters {
+		if !strings.HasPrefix(k, taskEnvPrefix) || k == taskEnvPrefix {
+			continue
+		}
+		override.Environment = append(override.Environment, TaskEnvironment{Name: strings.TrimPrefix(k, taskEnvPrefix), Value: v})
+	}
+
+	if override.Command == nil && override.Environment == nil {
+		return nil, nil
+	}
+
+	if override.Name == "" {
+		return nil, errors.New("container_name is required to override the command or environment")
+	}
+
+	// sort the environment so the request is the same for every run
+	sort.Slice(override.Environment, func(i, j int) bool {
+		return override.Environment[i].Name < override.Environment[j].Name
+	})
+
+	return &TaskOverrides{ContainerOverrides: []TaskContainerOverride{override}}, nil
+}
+
+// taskArns returns the arns of the tasks started, from the response of the run task api.  Responses that aren't
+// a JSON object are ignored.  If no tasks were started, the failures are returned as an error.
+func taskArns(body []byte) ([]string, error) {
+	output := struct {
+		Tasks []struct {
+			TaskArn string
+		}
+		Failures []struct {
+			Arn    string
+			Reason string
+		}
+	}{}
+
+	if err := json.Unmarshal(body, &output); err != nil {
+		log.Debugf("ignoring task runner response that isn't a run task output: %s", err)
+		return nil, nil
+	}
+
+	arns := []string{}
+	for _, t := range output.Tasks {
+		if t.TaskArn != "" {
+			arns = append(arns, t.TaskArn)
+		}
+	}
+
+	if len(arns) == 0 && len(output.Failures) > 0 {
+		failures := make([]string, 0, len(output.Failures))
+		for _, f := range output.Failures {
+			failures = append(failures, fmt.Sprintf("%s: %s", f.Arn, f.Reason))
+		}
+		return nil, NewRunnerError(ErrExecFailure, "failed to run tasks: "+strings.Join(failures, ", "), nil)
+	}
+
+	return arns, nil
+}
+
+// wait polls the status of the tasks until they all stopped or the timeout passes.  It reports the exit codes
+// of the containers of each task and fails if any of them didn't exit with 0.  The status of each task is set
+// in the result data.  The tasks were already submitted, so it fails with a post-execution failure that isn't
+// retried by default, a retry would submit new tasks while these may still be running.
+func (r *TaskRunner) wait(ctx context.Context, i *TaskRunnerRunInput, arns []string, result *Result) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Waiter.Timeout)
+	defer cancel()
+
+	log.Infof("waiting for tasks %s to stop", strings.Join(arns, ", "))
+
+	start := time.Now()
+	stopped := make(map[string]*taskStatus)
+
+	ticker := time.NewTicker(r.Waiter.Interval)
+	defer ticker.Stop()
+
+	for {
+		for _, arn := range arns {
+			if _, ok := stopped[arn]; ok {
+				continue
+			}
+
+			status, err := r.taskStatus(ctx, i, arn)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Warnf("failed to get status of task %s: %s", arn, err)
+				}
+				continue
+			}
+
+			if strings.EqualFold(status.LastStatus, "STOPPED") {
+				stopped[arn] = status
+			}
+		}
+
+		if len(stopped) == len(arns) {
+			break
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			msg := fmt.Sprintf("%d of %d tasks did not stop in %s", len(arns)-len(stopped), len(arns), time.Since(start).Round(time.Second))
+			return "", NewRunnerError(ErrPostExecFailure, msg, ctx.Err())
+		}
+	}
+
+	lines := make([]string, 0, len(arns))
+	failed := 0
+	for _, arn := range arns {
+		line, ok := stopped[arn].summary(taskID(arn))
+		if !ok {
+			failed++
+			result.SetData(taskID(arn), ResultStatusFailed)
+		} else {
+			result.SetData(taskID(arn), ResultStatusSucceeded)
+		}
+		lines = append(lines, line)
+	}
+
+	out := strings.Join(lines, "\n")
+	if failed > 0 {
+		return "", NewRunnerError(ErrPostExecFailure, fmt.Sprintf("%d of %d tasks failed\n%s", failed, len(arns), out), nil)
+	}
+
+	return out, nil
+}
+
+// taskStatus is the status of a task returned by the status endpoint
+type taskStatus struct {
+	LastStatus    string
+	StoppedReason string
+	Containers    []struct {
+		Name     string
+		ExitCode *int
+		Reason   string
+	}
+}
+
+// summary reports the exit codes of the containers of a stopped task, ie. 'task 123: stopped (Essential container
+// in task exited), exit codes: app=0'.  It returns false if any container exited with another code than 0.
+func (s *taskStatus) summary(id string) (string, bool) {
+	ok := true
+	codes := make([]string, 0, len(s.Containers))
+	for _, c := range s.Containers {
+		if c.ExitCode == nil {
+			ok = false
+			codes = append(codes, fmt.Sprintf("%s=none", c.Name))
+			continue
+		}
+
+		if *c.ExitCode != 0 {
+			ok = false
+		}
+		codes = append(codes, fmt.Sprintf("%s=%d", c.Name, *c.ExitCode))
+	}
+
+	line := fmt.Sprintf("task %s: stopped", id)
+	if s.StoppedReason != "" {
+		line = fmt.Sprintf("%s (%s)", line, s.StoppedReason)
+	}
+
+	return fmt.Sprintf("%s, exit codes: %s", line, strings.Join(codes, ", ")), ok
+}
+
+// taskStatus gets the status of a task from the status endpoint
+func (r *TaskRunner) taskStatus(ctx context.Context, i *TaskRunnerRunInput, arn string) (*taskStatus, error) {
+	input := struct {
+		Account string
+		Cluster string
+		Name    string
+		TaskArn string
+		TaskID  string
+	}{i.Account, i.Cluster, i.Name, arn, taskID(arn)}
+
+	endpoint, err := execEndpointTemplate(r.Waiter.EndpointTemplate, &input)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := r.request(ctx, http.MethodGet, endpoint, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &taskStatus{}
+	if err := json.Unmarshal(body, status); err != nil {
+		return nil, fmt.Errorf("failed to decode task status: %s", err)
+	}
+
+	return status, nil
+}
+
+// request sends a request to the task api and returns the response body.  If a result is passed, the status
+// code and an excerpt of the response are set on it.
+func (r *TaskRunner) request(ctx context.Context, method, endpoint string, body io.Reader, result *Result) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return nil, NewRunnerError(ErrPreExecFailure, "building http request failed", err)
+	}
+
+	if err := r.authorize(req); err != nil {
+		return nil, err
+	}
+
+	res, err := r.Client.Do(req)
+	if err != nil {
+		return nil, NewRunnerError(ErrExecFailure, "http request failed", err)
+	}
+	defer res.Body.Close()
+
+	out, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, NewRunnerError(ErrPostExecFailure, "reading response body failed", err)
+	}
+
+	log.Debugf("got response %s(%d) for endpoint %s: %s", res.Status, res.StatusCode, endpoint, out)
+
+	if result != nil {
+		result.StatusCode = res.StatusCode
+		result.Response = excerpt(out)
+	}
+
+	if res.StatusCode >= 300 {
+		return nil, NewRunnerStatusError(ErrExecFailure, "unexpected http response", res.StatusCode, errors.New("unexpected response from taskRunner api: "+res.Status))
+	}
+
+	return out, nil
+}
+
+// authorize signs a request to the task api or sets the token header, if a token is configured
+func (r *TaskRunner) authorize(req *http.Request) error {
+	if r.Signer != nil {
+		log.Debug("signing request")
+		return r.Signer.Sign(req)
+	}
+
+	if r.Token == "" {
+		return nil
+	}
+
+	log.Debugf("setting token header %s", r.AuthHeader)
+	if r.Encrypt {
+		e, err := bcrypt.GenerateFromPassword([]byte(r.Token), 6)
+		if err != nil {
+			return NewRunnerError(ErrExecFailure, "unable to hash token", err)
+		}
+
+		log.Debug("token is encrypted")
+
+		req.Header.Set(r.AuthHeader, string(e))
+	} else {
+		req.Header.Set(r.AuthHeader, r.Token)
+	}
+
+	return nil
+}
+
+// taskID returns the id of a task from its arn, the part after the last '/'
+func taskID(arn string) string {
+	return arn[strings.LastIndex(arn, "/")+1:]
+}
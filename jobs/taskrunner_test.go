@@ -3,11 +3,15 @@ package jobs
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -61,6 +65,7 @@ func TestNewTaskRunner(t *testing.T) {
 			},
 			want: &TaskRunner{
 				AuthHeader: "X-Auth-Token",
+				Client:     defaultHTTPClient,
 				Encrypt:    true,
 				Endpoint:   "http://127.0.0.1:8080/v1/ecs/acct1/cluster/clu1/taskdefs/td1/tasks",
 			},
@@ -75,6 +80,7 @@ func TestNewTaskRunner(t *testing.T) {
 			},
 			want: &TaskRunner{
 				AuthHeader:       "X-Auth-Token",
+				Client:           defaultHTTPClient,
 				Encrypt:          true,
 				Endpoint:         "http://127.0.0.1:8080/v1/ecs/acct1/cluster/clu1/taskdefs/td1/tasks",
 				EndpointTemplate: "http://127.0.0.1:8080/v1/ecs/{{.Account}}/cluster/{{.Cluster}}/taskdefs/{{ .Name }}/tasks",
@@ -89,6 +95,7 @@ func TestNewTaskRunner(t *testing.T) {
 			},
 			want: &TaskRunner{
 				AuthHeader: "X-Auth-Token",
+				Client:     defaultHTTPClient,
 				Encrypt:    true,
 				Endpoint:   "http://127.0.0.1:8080/v1/ecs/acct1/cluster/clu1/taskdefs/td1/tasks",
 			},
@@ -102,6 +109,7 @@ func TestNewTaskRunner(t *testing.T) {
 			},
 			want: &TaskRunner{
 				AuthHeader:       "X-Auth-Token",
+				Client:           defaultHTTPClient,
 				Encrypt:          true,
 				EndpointTemplate: "http://127.0.0.1:8080/v1/ecs/{{.Account}}/cluster/{{.Cluster}}/taskdefs/{{ .Name }}/tasks",
 			},
@@ -118,6 +126,7 @@ func TestNewTaskRunner(t *testing.T) {
 			},
 			want: &TaskRunner{
 				AuthHeader:       "X-Top-Sekret",
+				Client:           defaultHTTPClient,
 				Encrypt:          true,
 				EndpointTemplate: "http://127.0.0.1:8080/v1/ecs/{{.Account}}/cluster/{{.Cluster}}/taskdefs/{{ .Name }}/tasks",
 				Token:            "123456789890",
@@ -340,3 +349,271 @@ func TestTaskRunner_Run(t *testing.T) {
 		})
 	}
 }
+
+func TestTaskRunnerValidate(t *testing.T) {
+	tests := []struct {
+		params   interface{}
+		problems []string
+	}{
+		{
+			params:   map[string]string{"task_action": "run", "task_cluster": "c1", "task_name": "t1", "count": "1"},
+			problems: nil,
+		},
+		{
+			params:   map[string]string{"task_action": "run", "task_cluster": "c1", "task_name": "t1", "count": "0"},
+			problems: []string{"count must be at least 1: 0"},
+		},
+		{
+			params:   map[string]string{"task_action": "run", "task_cluster": "c1", "task_name": "t1", "count": "1", "container_name": "app", "command": "[\"echo\", \"hi\"]", "env_FOO": "bar", "wait": "false"},
+			problems: nil,
+		},
+		{
+			params:   map[string]string{"task_action": "run", "task_cluster": "c1", "task_name": "t1", "count": "1", "env_FOO": "bar"},
+			problems: []string{"container_name is required to override the command or environment"},
+		},
+		{
+			params:   map[string]string{"task_action": "run", "task_cluster": "c1", "task_name": "t1", "count": "1", "container_name": "app", "command": "[\"echo\", 1]"},
+			problems: []string{"command must be a JSON list of strings"},
+		},
+		{
+			params:   map[string]string{"task_action": "run", "task_cluster": "c1", "task_name": "t1", "count": "1", "wait": "true"},
+			problems: []string{"wait is not configured for the runner"},
+		},
+		{
+			params:   map[string]string{"task_action": "stop"},
+			problems: []string{"unexpected task_action 'stop', must be one of 'run'", "missing task_cluster", "missing task_name", "missing count"},
+		},
+	}
+
+	r := &TaskRunner{}
+	for _, test := range tests {
+		err := r.Validate(test.params)
+		if test.problems == nil {
+			if err != nil {
+				t.Errorf("expected nil error for %+v, got %s", test.params, err)
+			}
+			continue
+		}
+
+		verr, ok := err.(ValidationError)
+		if !ok {
+			t.Errorf("expected ValidationError for %+v, got %v", test.params, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(verr.Problems, test.problems) {
+			t.Errorf("expected problems %v, got %v", test.problems, verr.Problems)
+		}
+	}
+}
+
+func TestNewTaskWaiter(t *testing.T) {
+	out, err := newTaskWaiter(nil)
+	if err != nil || out != nil {
+		t.Errorf("expected nil waiter and nil error without config, got %+v, %v", out, err)
+	}
+
+	tests := []interface{}{
+		"http://example.com",
+		map[string]interface{}{},
+		map[string]interface{}{"endpointTemplate": "http://example.com", "interval": "often"},
+		map[string]interface{}{"endpointTemplate": "http://example.com", "timeout": "0s"},
+	}
+
+	for _, config := range tests {
+		if _, err := newTaskWaiter(config); err == nil {
+			t.Errorf("expected error for config %+v, got nil", config)
+		}
+	}
+
+	out, err = newTaskWaiter(map[string]interface{}{
+		"endpointTemplate": "http://example.com/{{.TaskID}}",
+		"interval":         "5s",
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	expected := &TaskWaiter{EndpointTemplate: "http://example.com/{{.TaskID}}", Interval: 5 * time.Second, Timeout: 30 * time.Minute}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("expected %+v, got %+v", expected, out)
+	}
+}
+
+func TestTaskOverrides(t *testing.T) {
+	tests := []struct {
+		params   map[string]string
+		expected *TaskOverrides
+		err      bool
+	}{
+		{
+			params: map[string]string{"task_name": "t1"},
+		},
+		{
+			params: map[string]string{"container_name": "app", "command": "./migrate --up", "env_B": "2", "env_A": "1", "env_": "ignored"},
+			expected: &TaskOverrides{ContainerOverrides: []TaskContainerOverride{{
+				Name:        "app",
+				Command:     []string{"./migrate", "--up"},
+				Environment: []TaskEnvironment{{Name: "A", Value: "1"}, {Name: "B", Value: "2"}},
+			}}},
+		},
+		{
+			params: map[string]string{"container_name": "app", "command": `["sh", "-c", "echo hello world"]`},
+			expected: &TaskOverrides{ContainerOverrides: []TaskContainerOverride{{
+				Name:    "app",
+				Command: []string{"sh", "-c", "echo hello world"},
+			}}},
+		},
+		{
+			params: map[string]string{"container_name": "app", "command": " "},
+			err:    true,
+		},
+		{
+			params: map[string]string{"command": "./migrate"},
+			err:    true,
+		},
+	}
+
+	for _, test := range tests {
+		out, err := taskOverrides(test.params)
+		if test.err {
+			if err == nil {
+				t.Errorf("expected error for %+v, got nil", test.params)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("expected nil error for %+v, got %s", test.params, err)
+		}
+
+		if !reflect.DeepEqual(out, test.expected) {
+			t.Errorf("expected %+v, got %+v", test.expected, out)
+		}
+	}
+}
+
+// mockTaskServer runs tasks and reports them as running until they've been polled the given number of times
+type mockTaskServer struct {
+	mux      sync.Mutex
+	exitCode int
+	polls    int
+	input    struct{ Overrides *TaskOverrides }
+}
+
+func (m *mockTaskServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/ecs/acct1/clusters/clu1/taskdefs/td1/tasks":
+		if err := json.NewDecoder(r.Body).Decode(&m.input); err != nil {
+			http.Error(w, "cannot decode body into input", http.StatusBadRequest)
+			return
+		}
+
+		fmt.Fprint(w, `{"Tasks": [{"TaskArn": "arn:aws:ecs:us-east-1:012345678901:task/clu1/abc"}, {"TaskArn": "arn:aws:ecs:us-east-1:012345678901:task/clu1/def"}], "Failures": []}`)
+	case r.Method == http.MethodGet && (r.URL.Path == "/v1/ecs/acct1/clusters/clu1/tasks/abc" || r.URL.Path == "/v1/ecs/acct1/clusters/clu1/tasks/def"):
+		m.polls--
+		if m.polls > 0 {
+			fmt.Fprint(w, `{"LastStatus": "RUNNING"}`)
+			return
+		}
+
+		exitCode := 0
+		if strings.HasSuffix(r.URL.Path, "def") {
+			exitCode = m.exitCode
+		}
+		fmt.Fprintf(w, `{"LastStatus": "STOPPED", "StoppedReason": "Essential container in task exited", "Containers": [{"Name": "app", "ExitCode": %d}]}`, exitCode)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestTaskRunnerRunWait(t *testing.T) {
+	tasks := &mockTaskServer{polls: 3}
+	ts := httptest.NewServer(tasks)
+	defer ts.Close()
+
+	r, err := NewTaskRunner(map[string]interface{}{
+		"endpointTemplate": ts.URL + "/v1/ecs/{{.Account}}/clusters/{{.Cluster}}/taskdefs/{{.Name}}/tasks",
+		"wait": map[string]interface{}{
+			"endpointTemplate": ts.URL + "/v1/ecs/{{.Account}}/clusters/{{.Cluster}}/tasks/{{.TaskID}}",
+			"interval":         "10ms",
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	params := map[string]string{
+		"task_action":    "run",
+		"task_cluster":   "clu1",
+		"task_name":      "td1",
+		"count":          "2",
+		"container_name": "app",
+		"command":        "./migrate --up",
+		"env_LOG_LEVEL":  "debug",
+		"wait":           "true",
+	}
+
+	out, err := r.Run(context.TODO(), "acct1", params)
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	expected := `successfully submitted run task clu1/td1 with count 2: arn:aws:ecs:us-east-1:012345678901:task/clu1/abc, arn:aws:ecs:us-east-1:012345678901:task/clu1/def
+task abc: stopped (Essential container in task exited), exit codes: app=0
+task def: stopped (Essential container in task exited), exit codes: app=0`
+	if out != expected {
+		t.Errorf("expected output '%s', got '%s'", expected, out)
+	}
+
+	overrides := &TaskOverrides{ContainerOverrides: []TaskContainerOverride{{
+		Name:        "app",
+		Command:     []string{"./migrate", "--up"},
+		Environment: []TaskEnvironment{{Name: "LOG_LEVEL", Value: "debug"}},
+	}}}
+	if !reflect.DeepEqual(tasks.input.Overrides, overrides) {
+		t.Errorf("expected overrides %+v, got %+v", overrides, tasks.input.Overrides)
+	}
+
+	// a task exiting with another code than 0 fails the run
+	tasks.exitCode = 2
+	_, err = r.Run(context.TODO(), "acct1", params)
+
+	var rErr RunnerError
+	if !errors.As(err, &rErr) || rErr.Code != ErrPostExecFailure {
+		t.Fatalf("expected %s error, got %v", ErrPostExecFailure, err)
+	}
+
+	if !strings.HasPrefix(rErr.Message, "1 of 2 tasks failed") || !strings.Contains(rErr.Message, "task def: stopped (Essential container in task exited), exit codes: app=2") {
+		t.Errorf("expected message with the failed task, got '%s'", rErr.Message)
+	}
+
+	// tasks that don't stop in time fail the run
+	tasks.polls = 1000
+	r.Waiter.Timeout = 100 * time.Millisecond
+	if _, err = r.Run(context.TODO(), "acct1", params); !errors.As(err, &rErr) || rErr.Code != ErrPostExecFailure || !strings.Contains(rErr.Message, "2 of 2 tasks did not stop") {
+		t.Errorf("expected tasks not to stop in time, got %v", err)
+	}
+}
+
+func TestTaskArns(t *testing.T) {
+	out, err := taskArns([]byte("OK"))
+	if err != nil || out != nil {
+		t.Errorf("expected no arns and nil error for response that isn't JSON, got %v, %v", out, err)
+	}
+
+	out, err = taskArns([]byte(`{"Tasks": [{"TaskArn": "arn:1"}], "Failures": [{"Arn": "arn:2", "Reason": "RESOURCE:MEMORY"}]}`))
+	if err != nil || !reflect.DeepEqual(out, []string{"arn:1"}) {
+		t.Errorf("expected [arn:1] and nil error, got %v, %v", out, err)
+	}
+
+	_, err = taskArns([]byte(`{"Tasks": [], "Failures": [{"Arn": "arn:2", "Reason": "RESOURCE:MEMORY"}]}`))
+
+	var rErr RunnerError
+	if !errors.As(err, &rErr) || rErr.Message != "failed to run tasks: arn:2: RESOURCE:MEMORY" {
+		t.Errorf("expected failed to run tasks error, got %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package jobs
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// ScheduleTracker keeps track of the last time each job was scheduled.  The times are read and written for all of
+// the scheduled jobs at once, and forgotten when the jobs are removed.
+type ScheduleTracker interface {
+	LastScheduled(ids ...string) (map[string]time.Time, error)
+	SetLastScheduled(t time.Time, ids ...string) error
+	Forget(ids ...string) error
+}
+
+// RedisScheduleTracker is a redis schedule tracker.  The last scheduled times are stored
+// as unix timestamps in a redis hash keyed by the job id.
+type RedisScheduleTracker struct {
+	client *redis.Client
+	Name   string
+}
+
+// NewRedisScheduleTracker returns a new redis schedule tracker
+func NewRedisScheduleTracker(name, address, password string, db int) (*RedisScheduleTracker, error) {
+	return &RedisScheduleTracker{
+		Name: name,
+		client: redis.NewClient(&redis.Options{
+			Addr:       address,
+			PoolSize:   5,
+			MaxRetries: 2,
+			Password:   password,
+			DB:         db,
+		}),
+	}, nil
+}
+
+// LastScheduled returns the last time each of the jobs was scheduled with a single HMGET.  Jobs that
+// have never been scheduled are left out of the returned map.
+func (r *RedisScheduleTracker) LastScheduled(ids ...string) (map[string]time.Time, error) {
+	last := make(map[string]time.Time, len(ids))
+	if len(ids) == 0 {
+		return last, nil
+	}
+
+	out, err := r.client.HMGet(r.Name, ids...).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed getting last scheduled times")
+	}
+
+	for i, v := range out {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+
+		ts, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed parsing last scheduled time for job "+ids[i])
+		}
+
+		last[ids[i]] = time.Unix(ts, 0).UTC()
+	}
+
+	return last, nil
+}
+
+// SetLastScheduled sets the last time the jobs were scheduled with a single HMSET
+func (r *RedisScheduleTracker) SetLastScheduled(t time.Time, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	fields := make(map[string]interface{}, len(ids))
+	for _, id := range ids {
+		fields[id] = t.Unix()
+	}
+
+	if err := r.client.HMSet(r.Name, fields).Err(); err != nil {
+		return errors.Wrap(err, "failed setting last scheduled times")
+	}
+	return nil
+}
+
+// Forget removes the last scheduled time of removed jobs
+func (r *RedisScheduleTracker) Forget(ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	if err := r.client.HDel(r.Name, ids...).Err(); err != nil {
+		return errors.Wrap(err, "failed removing last scheduled times")
+	}
+	return nil
+}
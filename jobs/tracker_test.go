@@ -0,0 +1,17 @@
+package jobs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewRedisScheduleTracker(t *testing.T) {
+	r, err := NewRedisScheduleTracker("foo", "127.0.0.1:6379", "", 0)
+	if err != nil {
+		t.Errorf("expected nil error, got %s", err)
+	}
+
+	if to := reflect.TypeOf(r).String(); to != "*jobs.RedisScheduleTracker" {
+		t.Errorf("expected type to be '*jobs.RedisScheduleTracker, got %s", to)
+	}
+}
@@ -0,0 +1,36 @@
+package jobs
+
+import (
+	"context"
+	"time"
+)
+
+// TrashedJob is a deleted job kept in the trash of the jobs repository until it's restored or purged
+type TrashedJob struct {
+	Group     string    `json:"group"`
+	DeletedAt time.Time `json:"deleted_at"`
+	Job       *Job      `json:"job"`
+}
+
+// Trasher is implemented by the repositories that move deleted jobs to a trash instead of removing them.  Trashed
+// jobs are no longer listed or loaded, and a restored job is back at the version it was trashed at.  Like Delete,
+// an empty id trashes, restores or purges all of the jobs in the group.
+//
+// Trash moves a job to the trash.  If the version is set, the job is only trashed if it's still at that version.
+// RestoreTrash moves trashed jobs back, it's a conflict if a job with the same id exists.  PurgeTrash removes
+// trashed jobs permanently, with their revision history if the repository keeps it.
+type Trasher interface {
+	Trash(ctx context.Context, account, group, id string, version int64) error
+	ListTrash(ctx context.Context, account, group string) ([]*TrashedJob, error)
+	RestoreTrash(ctx context.Context, account, group, id string) ([]*Job, error)
+	PurgeTrash(ctx context.Context, account, group, id string) error
+}
+
+// newTrashedJob returns a job trashed right now
+func newTrashedJob(group string, job *Job) *TrashedJob {
+	return &TrashedJob{
+		Group:     group,
+		DeletedAt: time.Now().UTC().Truncate(time.Second),
+		Job:       job,
+	}
+}
@@ -0,0 +1,177 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/YaleSpinup/apierror"
+)
+
+// testTrash trashes, restores and purges jobs in a repository keeping a trash
+func testTrash(t *testing.T, r interface {
+	Repository
+	Trasher
+}) {
+	ctx := context.TODO()
+
+	newJob := func(group, description string) *Job {
+		job, err := r.Create(ctx, "metal", group, &Job{Description: description, Details: map[string]string{"runner": "dummy"}, ScheduleExpression: "00 00 25 07 *"})
+		if err != nil {
+			t.Fatalf("expected nil error creating job, got %s", err)
+		}
+		return job
+	}
+
+	expectCode := func(err error, code, msg string) {
+		t.Helper()
+		var aerr apierror.Error
+		if !errors.As(err, &aerr) || aerr.Code != code {
+			t.Errorf("expected %s error %s, got %v", code, msg, err)
+		}
+	}
+
+	list := func(group string) []string {
+		t.Helper()
+		ids, err := r.List(ctx, "metal", group)
+		if err != nil {
+			t.Fatalf("expected nil error listing jobs, got %s", err)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+
+	listTrash := func(group string) []string {
+		t.Helper()
+		trashed, err := r.ListTrash(ctx, "metal", group)
+		if err != nil {
+			t.Fatalf("expected nil error listing the trash, got %s", err)
+		}
+
+		ids := []string{}
+		for _, tj := range trashed {
+			if tj.DeletedAt.IsZero() || time.Since(tj.DeletedAt) > time.Minute {
+				t.Errorf("expected trashed job %s to be deleted just now, got %s", tj.Job.ID, tj.DeletedAt)
+			}
+			ids = append(ids, tj.Group+"/"+tj.Job.ID)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+
+	job1 := newJob("metallica", "first")
+	job2 := newJob("metallica", "second")
+	job3 := newJob("megadeth", "third")
+
+	expectCode(r.Trash(ctx, "metal", "metallica", job1.ID, job1.Version+1), apierror.ErrConflict, "trashing a stale version")
+	expectCode(r.Trash(ctx, "metal", "metallica", "", 1), apierror.ErrBadRequest, "trashing a version of a group")
+	expectCode(r.Trash(ctx, "metal", "metallica", "missing", 0), apierror.ErrNotFound, "trashing a missing job")
+
+	if err := r.Trash(ctx, "metal", "metallica", job1.ID, job1.Version); err != nil {
+		t.Fatalf("expected nil error trashing job, got %s", err)
+	}
+
+	// trashed jobs are no longer listed, so they aren't loaded or scheduled
+	_, err := r.Get(ctx, "metal", "metallica", job1.ID)
+	expectCode(err, apierror.ErrNotFound, "getting a trashed job")
+
+	if ids, expected := list(""), sortedStrings("megadeth/"+job3.ID, "metallica/"+job2.ID); !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected jobs %v, got %v", expected, ids)
+	}
+
+	if ids, expected := listTrash(""), []string{"metallica/" + job1.ID}; !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected trashed jobs %v, got %v", expected, ids)
+	}
+
+	restored, err := r.RestoreTrash(ctx, "metal", "metallica", job1.ID)
+	if err != nil {
+		t.Fatalf("expected nil error restoring job, got %s", err)
+	}
+
+	if len(restored) != 1 || restored[0].ID != job1.ID || restored[0].Version != job1.Version || restored[0].Description != "first" {
+		t.Errorf("expected job %s restored at version %d, got %+v", job1.ID, job1.Version, restored)
+	}
+
+	if job, err := r.Get(ctx, "metal", "metallica", job1.ID); err != nil || job.Version != job1.Version {
+		t.Errorf("expected restored job at version %d, got %+v (%v)", job1.Version, job, err)
+	}
+
+	_, err = r.RestoreTrash(ctx, "metal", "metallica", job1.ID)
+	expectCode(err, apierror.ErrNotFound, "restoring a job that isn't trashed")
+
+	// trash the whole group
+	if err := r.Trash(ctx, "metal", "metallica", "", 0); err != nil {
+		t.Fatalf("expected nil error trashing group, got %s", err)
+	}
+
+	if ids := list("metallica"); len(ids) != 0 {
+		t.Errorf("expected no jobs left in the group, got %v", ids)
+	}
+
+	if ids, expected := listTrash("metallica"), sortedStrings("metallica/"+job1.ID, "metallica/"+job2.ID); !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected trashed jobs %v, got %v", expected, ids)
+	}
+
+	// a job with the same id isn't overwritten by a restore
+	recreated := *job1
+	recreated.Version = 0
+	if _, err := r.Update(ctx, "metal", "metallica", job1.ID, &recreated); err != nil {
+		t.Fatalf("expected nil error recreating job, got %s", err)
+	}
+
+	_, err = r.RestoreTrash(ctx, "metal", "metallica", job1.ID)
+	expectCode(err, apierror.ErrConflict, "restoring over an existing job")
+
+	if err := r.Delete(ctx, "metal", "metallica", job1.ID); err != nil {
+		t.Fatalf("expected nil error deleting job, got %s", err)
+	}
+
+	if restored, err := r.RestoreTrash(ctx, "metal", "metallica", ""); err != nil || len(restored) != 2 {
+		t.Errorf("expected 2 restored jobs, got %+v (%v)", restored, err)
+	}
+
+	if ids, expected := list("metallica"), sortedStrings(job1.ID, job2.ID); !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected restored jobs %v, got %v", expected, ids)
+	}
+
+	// purge a job, then the rest of the group
+	if err := r.Trash(ctx, "metal", "metallica", "", 0); err != nil {
+		t.Fatalf("expected nil error trashing group, got %s", err)
+	}
+
+	// purging a job that isn't trashed doesn't touch it
+	if err := r.PurgeTrash(ctx, "metal", "megadeth", job3.ID); err != nil {
+		t.Fatalf("expected nil error purging a job that isn't trashed, got %s", err)
+	}
+
+	if err := r.PurgeTrash(ctx, "metal", "metallica", job1.ID); err != nil {
+		t.Fatalf("expected nil error purging job, got %s", err)
+	}
+
+	if ids, expected := listTrash(""), []string{"metallica/" + job2.ID}; !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected trashed jobs %v, got %v", expected, ids)
+	}
+
+	if err := r.PurgeTrash(ctx, "metal", "metallica", ""); err != nil {
+		t.Fatalf("expected nil error purging group, got %s", err)
+	}
+
+	if ids := listTrash(""); len(ids) != 0 {
+		t.Errorf("expected an empty trash, got %v", ids)
+	}
+
+	_, err = r.RestoreTrash(ctx, "metal", "metallica", job2.ID)
+	expectCode(err, apierror.ErrNotFound, "restoring a purged job")
+
+	if ids, expected := list(""), []string{"megadeth/" + job3.ID}; !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected jobs %v, got %v", expected, ids)
+	}
+}
+
+func sortedStrings(s ...string) []string {
+	sort.Strings(s)
+	return s
+}
@@ -0,0 +1,219 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// StateVerifier polls a status endpoint after an action until the target reaches the expected state for
+// the action or the timeout passes
+type StateVerifier struct {
+	EndpointTemplate string
+	StateField       string
+	States           map[string]string
+	Interval         time.Duration
+	Timeout          time.Duration
+	Client           *HTTPClient
+}
+
+// StateTransition is a state observed while verifying, after the given time since verifying started
+type StateTransition struct {
+	State string
+	After time.Duration
+}
+
+// newStateVerifier configures a state verifier from the verify section of a runner configuration.  The expected
+// states for the actions default to the given states.  If the verify section isn't set, nil is returned.
+func newStateVerifier(config interface{}, states map[string]string) (*StateVerifier, error) {
+	if config == nil {
+		return nil, nil
+	}
+
+	c, ok := config.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("verify must be a map")
+	}
+
+	v := &StateVerifier{
+		StateField: "state",
+		States:     map[string]string{},
+		Interval:   10 * time.Second,
+		Timeout:    10 * time.Minute,
+	}
+
+	if t, ok := c["endpointTemplate"].(string); ok {
+		v.EndpointTemplate = t
+	}
+
+	if v.EndpointTemplate == "" {
+		return nil, errors.New("verify endpointTemplate is required")
+	}
+
+	if f, ok := c["stateField"].(string); ok && f != "" {
+		v.StateField = f
+	}
+
+	for action, state := range states {
+		v.States[action] = state
+	}
+
+	if s, ok := c["states"].(map[string]interface{}); ok {
+		for action, state := range s {
+			st, ok := state.(string)
+			if !ok || st == "" {
+				return nil, fmt.Errorf("invalid verify state for %s: %v", action, state)
+			}
+			v.States[action] = st
+		}
+	}
+
+	for _, d := range []struct {
+		key string
+		val *time.Duration
+	}{{"interval", &v.Interval}, {"timeout", &v.Timeout}} {
+		s, ok := c[d.key].(string)
+		if !ok || s == "" {
+			continue
+		}
+
+		duration, err := time.ParseDuration(s)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid verify %s '%s'", d.key, s)
+		}
+		*d.val = duration
+	}
+
+	return v, nil
+}
+
+// enabled returns true if the target state of the job should be verified.  Verification is on by default
+// when it's configured and can be turned off for a job with the verify detail.
+func (v *StateVerifier) enabled(params map[string]string) bool {
+	if v == nil {
+		return false
+	}
+	return params["verify"] != "false"
+}
+
+// Verify polls the status endpoint, executed with the data, until the target reaches the expected state
+// for the action.  Errors polling the endpoint are retried until the timeout.  It returns a summary of the
+// observed state transitions and how long it took.
+func (v *StateVerifier) Verify(ctx context.Context, data interface{}, target, action string, authorize func(*http.Request) error) (string, error) {
+	expected, ok := v.States[action]
+	if !ok {
+		return fmt.Sprintf("no state to verify for action '%s' on %s", action, target), nil
+	}
+
+	endpoint, err := execEndpointTemplate(v.EndpointTemplate, data)
+	if err != nil {
+		return "", NewRunnerError(ErrPostExecFailure, "failed to set verify endpoint", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, v.Timeout)
+	defer cancel()
+
+	log.Infof("verifying %s reaches state '%s' after '%s'", target, expected, action)
+
+	start := time.Now()
+	var transitions []StateTransition
+	var lastErr error
+
+	ticker := time.NewTicker(v.Interval)
+	defer ticker.Stop()
+
+	for {
+		state, err := v.state(ctx, endpoint, authorize)
+		if err != nil && ctx.Err() == nil {
+			log.Warnf("failed to get state of %s: %s", target, err)
+			lastErr = err
+		} else if err == nil && (len(transitions) == 0 || transitions[len(transitions)-1].State != state) {
+			log.Debugf("%s is in state '%s'", target, state)
+			transitions = append(transitions, StateTransition{State: state, After: time.Since(start).Round(time.Second)})
+		}
+
+		if err == nil && strings.EqualFold(state, expected) {
+			return fmt.Sprintf("verified %s reached state '%s' in %s: %s", target, expected, time.Since(start).Round(time.Second), formatTransitions(transitions)), nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			msg := fmt.Sprintf("%s did not reach state '%s' in %s: %s", target, expected, time.Since(start).Round(time.Second), formatTransitions(transitions))
+			if lastErr != nil {
+				return "", NewRunnerError(ErrExecFailure, msg, lastErr)
+			}
+			return "", NewRunnerError(ErrExecFailure, msg, ctx.Err())
+		}
+	}
+}
+
+// state gets the current state of the target from the status endpoint
+func (v *StateVerifier) state(ctx context.Context, endpoint string, authorize func(*http.Request) error) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if authorize != nil {
+		if err := authorize(req); err != nil {
+			return "", err
+		}
+	}
+
+	res, err := v.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if res.StatusCode >= 300 {
+		return "", fmt.Errorf("unexpected response from status endpoint: %s", res.Status)
+	}
+
+	var status interface{}
+	if err := json.Unmarshal(body, &status); err != nil {
+		return "", fmt.Errorf("failed to decode status response: %s", err)
+	}
+
+	// the state field can be nested, ie. 'Instance.State'
+	status, err = jsonField(status, v.StateField)
+	if err != nil {
+		return "", fmt.Errorf("state %s", err)
+	}
+
+	switch s := status.(type) {
+	case string:
+		return s, nil
+	case nil:
+		return "", fmt.Errorf("state field %s is empty in status response", v.StateField)
+	default:
+		return fmt.Sprintf("%v", s), nil
+	}
+}
+
+// formatTransitions formats the observed state transitions, ie. 'stopping (0s) -> stopped (42s)'
+func formatTransitions(transitions []StateTransition) string {
+	if len(transitions) == 0 {
+		return "no state observed"
+	}
+
+	out := make([]string, 0, len(transitions))
+	for _, t := range transitions {
+		out = append(out, fmt.Sprintf("%s (%s)", t.State, t.After))
+	}
+
+	return strings.Join(out, " -> ")
+}
@@ -0,0 +1,180 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewStateVerifier(t *testing.T) {
+	defaults := map[string]string{"start": "running", "stop": "stopped"}
+
+	out, err := newStateVerifier(nil, defaults)
+	if err != nil || out != nil {
+		t.Errorf("expected nil verifier and nil error without config, got %+v, %v", out, err)
+	}
+
+	tests := []interface{}{
+		"http://example.com",
+		map[string]interface{}{},
+		map[string]interface{}{"endpointTemplate": "http://example.com", "interval": "often"},
+		map[string]interface{}{"endpointTemplate": "http://example.com", "timeout": "-1m"},
+		map[string]interface{}{"endpointTemplate": "http://example.com", "states": map[string]interface{}{"stop": 1}},
+	}
+
+	for _, config := range tests {
+		if _, err := newStateVerifier(config, defaults); err == nil {
+			t.Errorf("expected error for config %+v, got nil", config)
+		}
+	}
+
+	out, err = newStateVerifier(map[string]interface{}{
+		"endpointTemplate": "http://example.com/{{.InstanceID}}",
+		"stateField":       "Instance.State",
+		"states":           map[string]interface{}{"stop": "halted"},
+		"interval":         "5s",
+		"timeout":          "1m",
+	}, defaults)
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	expected := &StateVerifier{
+		EndpointTemplate: "http://example.com/{{.InstanceID}}",
+		StateField:       "Instance.State",
+		States:           map[string]string{"start": "running", "stop": "halted"},
+		Interval:         5 * time.Second,
+		Timeout:          time.Minute,
+	}
+
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("expected %+v, got %+v", expected, out)
+	}
+
+	if defaults["stop"] != "stopped" {
+		t.Error("expected default states not to be modified")
+	}
+}
+
+func TestStateVerifierEnabled(t *testing.T) {
+	var v *StateVerifier
+	if v.enabled(map[string]string{"verify": "true"}) {
+		t.Error("expected nil verifier not to be enabled")
+	}
+
+	v = &StateVerifier{}
+	if !v.enabled(map[string]string{}) {
+		t.Error("expected configured verifier to be enabled by default")
+	}
+
+	if v.enabled(map[string]string{"verify": "false"}) {
+		t.Error("expected verifier to be disabled by the verify detail")
+	}
+}
+
+// mockStatusServer returns the next state from the list on each request, repeating the last one
+type mockStatusServer struct {
+	mux    sync.Mutex
+	states []string
+	calls  int
+}
+
+func (m *mockStatusServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if r.Header.Get("X-Auth-Token") != "my-awesome-token" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if r.URL.Path != "/myaccount/i-123456" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	state := m.states[len(m.states)-1]
+	if m.calls < len(m.states) {
+		state = m.states[m.calls]
+	}
+	m.calls++
+
+	if state == "" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	fmt.Fprintf(w, `{"Instance": {"State": "%s"}}`, state)
+}
+
+func TestStateVerifierVerify(t *testing.T) {
+	authorize := func(req *http.Request) error {
+		req.Header.Set("X-Auth-Token", "my-awesome-token")
+		return nil
+	}
+
+	input := struct {
+		Account    string
+		InstanceID string
+	}{"myaccount", "i-123456"}
+
+	status := &mockStatusServer{states: []string{"running", "stopping", "", "stopping", "Stopped"}}
+	ts := httptest.NewServer(status)
+	defer ts.Close()
+
+	v := &StateVerifier{
+		EndpointTemplate: ts.URL + "/{{.Account}}/{{.InstanceID}}",
+		StateField:       "Instance.State",
+		States:           map[string]string{"stop": "stopped"},
+		Interval:         10 * time.Millisecond,
+		Timeout:          5 * time.Second,
+	}
+
+	out, err := v.Verify(context.TODO(), &input, "i-123456", "stop", authorize)
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	expected := "verified i-123456 reached state 'stopped' in 0s: running (0s) -> stopping (0s) -> Stopped (0s)"
+	if out != expected {
+		t.Errorf("expected output '%s', got '%s'", expected, out)
+	}
+
+	// actions without an expected state aren't verified
+	if out, err := v.Verify(context.TODO(), &input, "i-123456", "reboot", authorize); err != nil || !strings.HasPrefix(out, "no state to verify") {
+		t.Errorf("expected no verification for reboot, got '%s', %v", out, err)
+	}
+
+	// the target never reaches the expected state
+	status = &mockStatusServer{states: []string{"stopping"}}
+	ts2 := httptest.NewServer(status)
+	defer ts2.Close()
+
+	v.EndpointTemplate = ts2.URL + "/{{.Account}}/{{.InstanceID}}"
+	v.Timeout = 100 * time.Millisecond
+
+	_, err = v.Verify(context.TODO(), &input, "i-123456", "stop", authorize)
+
+	var rErr RunnerError
+	if !errors.As(err, &rErr) || rErr.Code != ErrExecFailure {
+		t.Fatalf("expected %s error, got %v", ErrExecFailure, err)
+	}
+
+	if !strings.Contains(rErr.Message, "did not reach state 'stopped'") || !strings.Contains(rErr.Message, "stopping (0s)") {
+		t.Errorf("expected message with the observed states, got '%s'", rErr.Message)
+	}
+
+	// the state field is missing
+	v.StateField = "State"
+	_, err = v.Verify(context.TODO(), &input, "i-123456", "stop", authorize)
+	if !errors.As(err, &rErr) || !strings.Contains(rErr.Error(), "state field State not found") {
+		t.Errorf("expected missing state field error, got %v", err)
+	}
+}
@@ -0,0 +1,265 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultWebhookMaxOutput is the default number of bytes of the webhook response returned as the run output
+const DefaultWebhookMaxOutput = 4096
+
+type WebhookRunner struct {
+	Method          string
+	URLTemplate     string
+	HeaderTemplates map[string]string
+	BodyTemplate    string
+	ExpectedStatus  []int
+	RequiredDetails []string
+	MaxOutput       int
+	Timeout         time.Duration
+}
+
+// WebhookRunnerInput is the data passed to the webhook runner templates
+type WebhookRunnerInput struct {
+	Account string
+	Details map[string]string
+}
+
+func init() {
+	RegisterRunner(RunnerType{
+		RunnerDescription: RunnerDescription{
+			Type:        "webhook",
+			Description: "sends a templated http request",
+		},
+		New: func(config map[string]interface{}) (Runner, error) {
+			return NewWebhookRunner(config)
+		},
+	})
+}
+
+// NewWebhookRunner creates and configures a new webhook runner.  A url template is required, the method defaults to
+// POST and the header and body templates are optional.  The templates are executed with the account and the job
+// details, ie. '{{.Account}}' or '{{.Details.instance_id}}'.  If no expected status codes are configured, any 2xx
+// response is successful.
+func NewWebhookRunner(config map[string]interface{}) (*WebhookRunner, error) {
+	log.Debug("creating new webhook job runner")
+
+	r := &WebhookRunner{
+		Method:          http.MethodPost,
+		HeaderTemplates: map[string]string{},
+		MaxOutput:       DefaultWebhookMaxOutput,
+		Timeout:         30 * time.Second,
+	}
+
+	if v, ok := config["url"].(string); ok {
+		r.URLTemplate = v
+	}
+
+	if r.URLTemplate == "" {
+		return nil, errors.New("url is required")
+	}
+
+	if v, ok := config["method"].(string); ok && v != "" {
+		r.Method = strings.ToUpper(v)
+	}
+
+	if v, ok := config["body"].(string); ok {
+		r.BodyTemplate = v
+	}
+
+	if headers, ok := config["headers"].(map[string]interface{}); ok {
+		for k, v := range headers {
+			h, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("header %s must be a string", k)
+			}
+			r.HeaderTemplates[k] = h
+		}
+	}
+
+	if codes, ok := config["expected_status"].([]interface{}); ok {
+		for _, c := range codes {
+			code, ok := configInt(c)
+			if !ok || code < 100 || code > 599 {
+				return nil, fmt.Errorf("invalid expected status %v", c)
+			}
+			r.ExpectedStatus = append(r.ExpectedStatus, code)
+		}
+	}
+
+	details, err := configStrings(config["required_details"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid required_details: %s", err)
+	}
+	r.RequiredDetails = details
+
+	if v, ok := config["max_output"]; ok {
+		max, ok := configInt(v)
+		if !ok || max < 0 {
+			return nil, fmt.Errorf("invalid max_output %v", v)
+		}
+		r.MaxOutput = max
+	}
+
+	if v, ok := config["timeout"].(string); ok && v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout '%s': %s", v, err)
+		}
+		r.Timeout = timeout
+	}
+
+	// parse the templates up front so configuration errors are caught at startup
+	templates := map[string]string{"url": r.URLTemplate, "body": r.BodyTemplate}
+	for k, v := range r.HeaderTemplates {
+		templates["header "+k] = v
+	}
+
+	for name, tmpl := range templates {
+		if _, err := template.New(name).Parse(tmpl); err != nil {
+			return nil, fmt.Errorf("failed to parse %s template: %s", name, err)
+		}
+	}
+
+	return r, nil
+}
+
+// Validate validates the WebhookRunner parameters.  Each of the configured required details must be set.
+func (r *WebhookRunner) Validate(parameters interface{}) error {
+	v := newValidator(parameters)
+	for _, d := range r.RequiredDetails {
+		v.required(d)
+	}
+	return v.err()
+}
+
+// Describe describes the WebhookRunner, the required details depend on its configuration
+func (r *WebhookRunner) Describe() RunnerDescription {
+	return RunnerDescription{
+		Type:            "webhook",
+		Description:     fmt.Sprintf("sends a %s request to %s", r.Method, r.URLTemplate),
+		RequiredDetails: r.RequiredDetails,
+	}
+}
+
+// Run executes the WebhookRunner.  The url, header and body templates are executed with the account and
+// job details and the request is sent to the rendered url.  The response body, truncated to the configured
+// max output, is returned as the output of the run.
+func (r *WebhookRunner) Run(ctx context.Context, account string, parameters interface{}) (string, error) {
+	return runResult(r.RunResult(ctx, account, parameters))
+}
+
+// RunResult sends the webhook request like Run and returns a structured result with the method, url, status code
+// and an excerpt of the response.
+func (r *WebhookRunner) RunResult(ctx context.Context, account string, parameters interface{}) (*Result, error) {
+	if account == "" {
+		return nil, errors.New("account is required")
+	}
+
+	log.Debugf("initializing webhook runner %+v in account %s, with parameters %+v", r, account, parameters)
+
+	params, ok := parameters.(map[string]string)
+	if !ok {
+		return nil, NewRunnerError(ErrMissingDetails, "wrong type parameters list is not a map[string]string", nil)
+	}
+
+	if err := r.Validate(params); err != nil {
+		return nil, NewRunnerError(ErrMissingDetails, "invalid parameters", err)
+	}
+
+	input := &WebhookRunnerInput{
+		Account: account,
+		Details: params,
+	}
+
+	url, err := execEndpointTemplate(r.URLTemplate, input)
+	if err != nil {
+		return nil, NewRunnerError(ErrPreExecFailure, "failed to set url", err)
+	}
+
+	var body io.Reader
+	if r.BodyTemplate != "" {
+		b, err := execEndpointTemplate(r.BodyTemplate, input)
+		if err != nil {
+			return nil, NewRunnerError(ErrPreExecFailure, "failed to set body", err)
+		}
+		body = strings.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, r.Method, url, body)
+	if err != nil {
+		return nil, NewRunnerError(ErrPreExecFailure, "building http request failed", err)
+	}
+
+	for k, tmpl := range r.HeaderTemplates {
+		h, err := execEndpointTemplate(tmpl, input)
+		if err != nil {
+			return nil, NewRunnerError(ErrPreExecFailure, "failed to set header "+k, err)
+		}
+		req.Header.Set(k, h)
+	}
+
+	result := &Result{}
+	result.SetData("method", r.Method)
+	result.SetData("url", url)
+
+	log.Infof("webhook runner sending %s request to %s", r.Method, url)
+
+	client := &http.Client{
+		Timeout: r.Timeout,
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return result, NewRunnerError(ErrExecFailure, "http request failed", err)
+	}
+	defer res.Body.Close()
+
+	result.StatusCode = res.StatusCode
+
+	out, err := ioutil.ReadAll(io.LimitReader(res.Body, int64(r.MaxOutput)+1))
+	if err != nil {
+		return result, NewRunnerError(ErrPostExecFailure, "reading response body failed", err)
+	}
+
+	output := string(out)
+	if len(out) > r.MaxOutput {
+		output = string(out[:r.MaxOutput]) + "... (truncated)"
+	}
+
+	log.Debugf("got response %s(%d) for webhook %s: %s", res.Status, res.StatusCode, url, output)
+
+	result.Response = excerpt(out)
+
+	if !r.expected(res.StatusCode) {
+		msg := fmt.Sprintf("unexpected http response: %s", output)
+		return result, NewRunnerStatusError(ErrExecFailure, msg, res.StatusCode, errors.New("unexpected response from webhook: "+res.Status))
+	}
+
+	result.Message = output
+	return result, nil
+}
+
+// expected returns true if the status code is one of the expected status codes or, if none are configured, a 2xx
+func (r *WebhookRunner) expected(code int) bool {
+	if len(r.ExpectedStatus) == 0 {
+		return code >= 200 && code < 300
+	}
+
+	for _, c := range r.ExpectedStatus {
+		if c == code {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,188 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewWebhookRunner(t *testing.T) {
+	tests := []struct {
+		config map[string]interface{}
+		err    bool
+	}{
+		{
+			config: map[string]interface{}{},
+			err:    true,
+		},
+		{
+			config: map[string]interface{}{"url": "http://example.com/{{.Account"},
+			err:    true,
+		},
+		{
+			config: map[string]interface{}{"url": "http://example.com", "headers": map[string]interface{}{"X-Foo": 1}},
+			err:    true,
+		},
+		{
+			config: map[string]interface{}{"url": "http://example.com", "expected_status": []interface{}{float64(700)}},
+			err:    true,
+		},
+		{
+			config: map[string]interface{}{"url": "http://example.com", "max_output": float64(-1)},
+			err:    true,
+		},
+		{
+			config: map[string]interface{}{"url": "http://example.com", "timeout": "soon"},
+			err:    true,
+		},
+		{
+			config: map[string]interface{}{"url": "http://example.com", "body": "{{.Details.foo"},
+			err:    true,
+		},
+		{
+			config: map[string]interface{}{"url": "http://example.com"},
+		},
+	}
+
+	for _, test := range tests {
+		_, err := NewWebhookRunner(test.config)
+		if test.err && err == nil {
+			t.Errorf("expected error for config %+v, got nil", test.config)
+		} else if !test.err && err != nil {
+			t.Errorf("expected nil error for config %+v, got %s", test.config, err)
+		}
+	}
+
+	out, err := NewWebhookRunner(map[string]interface{}{
+		"method":           "put",
+		"url":              "http://example.com/{{.Account}}",
+		"headers":          map[string]interface{}{"X-Auth-Token": "secret"},
+		"body":             `{"id": "{{.Details.id}}"}`,
+		"expected_status":  []interface{}{float64(200), float64(204)},
+		"required_details": []interface{}{"id"},
+		"max_output":       float64(10),
+		"timeout":          "5s",
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	expected := &WebhookRunner{
+		Method:          http.MethodPut,
+		URLTemplate:     "http://example.com/{{.Account}}",
+		HeaderTemplates: map[string]string{"X-Auth-Token": "secret"},
+		BodyTemplate:    `{"id": "{{.Details.id}}"}`,
+		ExpectedStatus:  []int{200, 204},
+		RequiredDetails: []string{"id"},
+		MaxOutput:       10,
+		Timeout:         5 * time.Second,
+	}
+
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("expected %+v, got %+v", expected, out)
+	}
+}
+
+func TestWebhookRunnerValidate(t *testing.T) {
+	r := &WebhookRunner{RequiredDetails: []string{"id", "message"}}
+
+	if err := r.Validate(map[string]string{"id": "123", "message": "hello"}); err != nil {
+		t.Errorf("expected nil error, got %s", err)
+	}
+
+	err := r.Validate(map[string]string{"id": "123"})
+	verr, ok := err.(ValidationError)
+	if !ok {
+		t.Fatalf("expected ValidationError, got %v", err)
+	}
+
+	if !reflect.DeepEqual(verr.Problems, []string{"missing message"}) {
+		t.Errorf("expected problems [missing message], got %v", verr.Problems)
+	}
+}
+
+func TestWebhookRunnerRun(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Auth-Token") != "secret-myaccount" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "bad token")
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+
+		switch r.URL.Path {
+		case "/myaccount/notify":
+			if r.Method != http.MethodPut {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.WriteHeader(http.StatusAccepted)
+			fmt.Fprintf(w, "got %s", body)
+		case "/myaccount/long":
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprint(w, strings.Repeat("x", 100))
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, "unavailable")
+		}
+	}))
+	defer ts.Close()
+
+	r, err := NewWebhookRunner(map[string]interface{}{
+		"method":           "PUT",
+		"url":              ts.URL + "/{{.Account}}/{{.Details.path}}",
+		"headers":          map[string]interface{}{"X-Auth-Token": "secret-{{.Account}}"},
+		"body":             `{"message": "{{.Details.message}}"}`,
+		"required_details": []interface{}{"path"},
+		"max_output":       float64(30),
+	})
+	if err != nil {
+		t.Fatalf("expected nil error, got %s", err)
+	}
+
+	if _, err := r.Run(context.TODO(), "", map[string]string{"path": "notify"}); err == nil {
+		t.Error("expected error for empty account, got nil")
+	}
+
+	var rErr RunnerError
+	if _, err := r.Run(context.TODO(), "myaccount", map[string]string{}); !errors.As(err, &rErr) || rErr.Code != ErrMissingDetails {
+		t.Errorf("expected %s error for missing details, got %v", ErrMissingDetails, err)
+	}
+
+	out, err := r.Run(context.TODO(), "myaccount", map[string]string{"path": "notify", "message": "hi"})
+	if err != nil {
+		t.Errorf("expected nil error, got %s", err)
+	}
+
+	if expected := `got {"message": "hi"}`; out != expected {
+		t.Errorf("expected output '%s', got '%s'", expected, out)
+	}
+
+	out, err = r.Run(context.TODO(), "myaccount", map[string]string{"path": "long"})
+	if err != nil {
+		t.Errorf("expected nil error, got %s", err)
+	}
+
+	if expected := strings.Repeat("x", 30) + "... (truncated)"; out != expected {
+		t.Errorf("expected output '%s', got '%s'", expected, out)
+	}
+
+	_, err = r.Run(context.TODO(), "myaccount", map[string]string{"path": "missing"})
+	if !errors.As(err, &rErr) || rErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected runner error with status %d, got %v", http.StatusServiceUnavailable, err)
+	}
+
+	// only the configured status codes are expected
+	r.ExpectedStatus = []int{http.StatusOK}
+	if _, err = r.Run(context.TODO(), "myaccount", map[string]string{"path": "notify"}); err == nil {
+		t.Error("expected error for unexpected status, got nil")
+	}
+}
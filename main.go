@@ -10,6 +10,9 @@ import (
 	"net/http"
 	"os"
 
+	// embed the timezone database so job timezones work in minimal containers
+	_ "time/tzdata"
+
 	"github.com/YaleSpinup/minion/api"
 	"github.com/YaleSpinup/minion/common"
 